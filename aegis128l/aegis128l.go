@@ -30,7 +30,11 @@ func (aead *Aegis128L) NonceSize() int {
 // New returns a new AEAD that uses the provided key and tag length.
 // The key must be 16 bytes long.
 // The tag length must be 16 or 32.
+// The first call runs the package's known-answer self-test; see common.SelfTestStatus.
 func New(key []byte, tagLen int) (cipher.AEAD, error) {
+	if err := selfTest.Check(); err != nil {
+		return nil, err
+	}
 	if len(key) != KeySize {
 		return nil, common.ErrBadKeyLength
 	}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"testing"
 
+	"github.com/aegis-aead/go-libaegis/aegistest"
 	"github.com/aegis-aead/go-libaegis/common"
 )
 
@@ -162,3 +163,63 @@ func TestBufferOverlapPanic(t *testing.T) {
 		}
 	})
 }
+
+func TestSelfTest(t *testing.T) {
+	if !common.Available {
+		t.Skip("AEGIS-128L not available")
+	}
+
+	if _, err := New(make([]byte, KeySize), 16); err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range common.SelfTestStatus() {
+		if r.Name == "AEGIS-128L" {
+			if r.State != common.SelfTestPassed {
+				t.Fatalf("self-test state: got %v, want passed (%v)", r.State, r.Err)
+			}
+			return
+		}
+	}
+	t.Fatal("AEGIS-128L self-test is not registered")
+}
+
+var stream = aegistest.Stream{
+	NewEncrypter: func(key, nonce, ad []byte, tagLen int) (aegistest.Encrypter, error) {
+		return NewEncrypter(key, nonce, ad, tagLen)
+	},
+	NewDecrypter: func(key, nonce, ad []byte, tagLen int) (aegistest.Decrypter, error) {
+		return NewDecrypter(key, nonce, ad, tagLen)
+	},
+}
+
+func TestVectors(t *testing.T) {
+	if !common.Available {
+		t.Skip("AEGIS-128L not available")
+	}
+
+	t.Run("AEAD", func(t *testing.T) {
+		aegistest.TestAEAD(t, "AEGIS-128L", New)
+	})
+	t.Run("Stream", func(t *testing.T) {
+		aegistest.TestStream(t, "AEGIS-128L", stream)
+	})
+	t.Run("MAC", func(t *testing.T) {
+		aegistest.TestMAC(t, "AEGIS-128L", func(key, nonce []byte, tagLen int) (aegistest.MAC, error) {
+			return NewMAC(key, nonce, tagLen)
+		})
+	})
+}
+
+func FuzzSealOpen(f *testing.F) {
+	if !common.Available {
+		f.Skip("AEGIS-128L not available")
+	}
+	aegistest.FuzzAEAD(f, "AEGIS-128L", New)
+}
+
+func FuzzIncremental(f *testing.F) {
+	if !common.Available {
+		f.Skip("AEGIS-128L not available")
+	}
+	aegistest.FuzzStream(f, "AEGIS-128L", stream, New)
+}
@@ -0,0 +1,52 @@
+//go:build !cgo || !go1.19
+// +build !cgo !go1.19
+
+package aegis128l
+
+import "github.com/aegis-aead/go-libaegis/common"
+
+// MAC computes an AEGIS-128L message authentication code.
+// This is a stub for when CGO is not available.
+type MAC struct{}
+
+// NewMAC creates a new MAC.
+// Panics when CGO is not available.
+func NewMAC(key, nonce []byte, tagLen int) (*MAC, error) {
+	common.NotAvailable()
+	return nil, nil
+}
+
+// Write is not available without CGO.
+func (m *MAC) Write(p []byte) (int, error) {
+	common.NotAvailable()
+	return 0, nil
+}
+
+// Sum is not available without CGO.
+func (m *MAC) Sum(b []byte) []byte {
+	common.NotAvailable()
+	return nil
+}
+
+// Verify is not available without CGO.
+func (m *MAC) Verify(tag []byte) error {
+	common.NotAvailable()
+	return nil
+}
+
+// Reset is not available without CGO.
+func (m *MAC) Reset() {
+	common.NotAvailable()
+}
+
+// Size is not available without CGO.
+func (m *MAC) Size() int {
+	common.NotAvailable()
+	return 0
+}
+
+// BlockSize is not available without CGO.
+func (m *MAC) BlockSize() int {
+	common.NotAvailable()
+	return 0
+}
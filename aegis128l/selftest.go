@@ -0,0 +1,85 @@
+//go:build cgo && go1.19
+// +build cgo,go1.19
+
+package aegis128l
+
+// #include <aegis.h>
+// #cgo CFLAGS: -I../common/libaegis/src/include
+import "C"
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+
+	"github.com/aegis-aead/go-libaegis/common"
+)
+
+var selfTest = common.RegisterSelfTest("AEGIS-128L", runSelfTest)
+
+// Known-answer vectors from draft-irtf-cfrg-aegis-aead.
+var (
+	katKey    = "10010000000000000000000000000000"
+	katNonce  = "10000200000000000000000000000000"
+	katAD     = "0001020304050607"
+	katMsg    = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+	katCt     = "79d94593d8c2119d7e8fd9b8fc77845c5c077a05b2528b6ac54b563aed8efe84"
+	katTag128 = "cc6f3372f6aa1bb82388d695c3962d9a"
+	katTag256 = "022cb796fe7e0ae1197525ff67e309484cfbab6528ddef89f17d74ef8ecd82b3"
+
+	katMACData   = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f202122"
+	katMACTag128 = "d3f09b2842ad301687d6902c921d7818"
+	katMACTag256 = "9490e7c89d420c9f37417fa625eb38e8cad53c5cbec55285e8499ea48377f2a3"
+)
+
+// runSelfTest checks encryption, decryption, forgery rejection and the MAC
+// against the known-answer vectors, for both tag lengths.
+func runSelfTest() error {
+	key, _ := hex.DecodeString(katKey)
+	nonce, _ := hex.DecodeString(katNonce)
+	ad, _ := hex.DecodeString(katAD)
+	msg, _ := hex.DecodeString(katMsg)
+	wantCt, _ := hex.DecodeString(katCt)
+	data, _ := hex.DecodeString(katMACData)
+
+	for _, want := range []string{katTag128, katTag256} {
+		wantTag, _ := hex.DecodeString(want)
+		ct := make([]byte, len(msg))
+		tag := make([]byte, len(wantTag))
+		C.aegis128l_encrypt_detached(slicePointerOrNull(ct), (*C.uchar)(&tag[0]), C.size_t(len(tag)),
+			slicePointerOrNull(msg), C.size_t(len(msg)), slicePointerOrNull(ad), C.size_t(len(ad)),
+			(*C.uchar)(&nonce[0]), (*C.uchar)(&key[0]))
+		if !bytes.Equal(ct, wantCt) || !bytes.Equal(tag, wantTag) {
+			return errors.New("encryption does not match the known answer")
+		}
+
+		pt := make([]byte, len(ct))
+		res := C.aegis128l_decrypt_detached(slicePointerOrNull(pt), slicePointerOrNull(ct), C.size_t(len(ct)),
+			(*C.uchar)(&tag[0]), C.size_t(len(tag)), slicePointerOrNull(ad), C.size_t(len(ad)),
+			(*C.uchar)(&nonce[0]), (*C.uchar)(&key[0]))
+		if res != 0 || !bytes.Equal(pt, msg) {
+			return errors.New("decryption does not match the known answer")
+		}
+
+		tag[0] ^= 1
+		res = C.aegis128l_decrypt_detached(slicePointerOrNull(pt), slicePointerOrNull(ct), C.size_t(len(ct)),
+			(*C.uchar)(&tag[0]), C.size_t(len(tag)), slicePointerOrNull(ad), C.size_t(len(ad)),
+			(*C.uchar)(&nonce[0]), (*C.uchar)(&key[0]))
+		if res == 0 {
+			return errors.New("forged tag was accepted")
+		}
+	}
+
+	for _, want := range []string{katMACTag128, katMACTag256} {
+		wantTag, _ := hex.DecodeString(want)
+		tag := make([]byte, len(wantTag))
+		var st C.aegis128l_mac_state
+		C.aegis128l_mac_init(&st, (*C.uchar)(&key[0]), (*C.uchar)(&nonce[0]))
+		C.aegis128l_mac_update(&st, (*C.uchar)(&data[0]), C.size_t(len(data)))
+		C.aegis128l_mac_final(&st, (*C.uchar)(&tag[0]), C.size_t(len(tag)))
+		if !bytes.Equal(tag, wantTag) {
+			return errors.New("MAC does not match the known answer")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+//go:build cgo && go1.19
+// +build cgo,go1.19
+
+package aegis128x2
+
+// #include <aegis.h>
+// #cgo CFLAGS: -I../common/libaegis/src/include
+import "C"
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+
+	"github.com/aegis-aead/go-libaegis/common"
+)
+
+var selfTest = common.RegisterSelfTest("AEGIS-128X2", runSelfTest)
+
+// Known-answer vectors from draft-irtf-cfrg-aegis-aead. The AEAD vector has
+// an empty message, so the MAC vector is what exercises absorption across lanes.
+var (
+	katKey    = "000102030405060708090a0b0c0d0e0f"
+	katNonce  = "101112131415161718191a1b1c1d1e1f"
+	katAD     = ""
+	katMsg    = ""
+	katCt     = ""
+	katTag128 = "63117dc57756e402819a82e13eca8379"
+	katTag256 = "b92c71fdbd358b8a4de70b27631ace90cffd9b9cfba82028412bac41b4f53759"
+
+	katMACKey    = "10010000000000000000000000000000"
+	katMACNonce  = "10000200000000000000000000000000"
+	katMACData   = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f202122"
+	katMACTag128 = "6873ee34e6b5c59143b6d35c5e4f2c6e"
+	katMACTag256 = "afcba3fc2d63c8d6c7f2d63f3ec8fbbbaf022e15ac120e78ffa7755abccd959c"
+)
+
+// runSelfTest checks encryption, decryption, forgery rejection and the MAC
+// against the known-answer vectors, for both tag lengths.
+func runSelfTest() error {
+	key, _ := hex.DecodeString(katKey)
+	nonce, _ := hex.DecodeString(katNonce)
+	ad, _ := hex.DecodeString(katAD)
+	msg, _ := hex.DecodeString(katMsg)
+	wantCt, _ := hex.DecodeString(katCt)
+	macKey, _ := hex.DecodeString(katMACKey)
+	macNonce, _ := hex.DecodeString(katMACNonce)
+	data, _ := hex.DecodeString(katMACData)
+
+	for _, want := range []string{katTag128, katTag256} {
+		wantTag, _ := hex.DecodeString(want)
+		ct := make([]byte, len(msg))
+		tag := make([]byte, len(wantTag))
+		C.aegis128x2_encrypt_detached(slicePointerOrNull(ct), (*C.uchar)(&tag[0]), C.size_t(len(tag)),
+			slicePointerOrNull(msg), C.size_t(len(msg)), slicePointerOrNull(ad), C.size_t(len(ad)),
+			(*C.uchar)(&nonce[0]), (*C.uchar)(&key[0]))
+		if !bytes.Equal(ct, wantCt) || !bytes.Equal(tag, wantTag) {
+			return errors.New("encryption does not match the known answer")
+		}
+
+		pt := make([]byte, len(ct))
+		res := C.aegis128x2_decrypt_detached(slicePointerOrNull(pt), slicePointerOrNull(ct), C.size_t(len(ct)),
+			(*C.uchar)(&tag[0]), C.size_t(len(tag)), slicePointerOrNull(ad), C.size_t(len(ad)),
+			(*C.uchar)(&nonce[0]), (*C.uchar)(&key[0]))
+		if res != 0 || !bytes.Equal(pt, msg) {
+			return errors.New("decryption does not match the known answer")
+		}
+
+		tag[0] ^= 1
+		res = C.aegis128x2_decrypt_detached(slicePointerOrNull(pt), slicePointerOrNull(ct), C.size_t(len(ct)),
+			(*C.uchar)(&tag[0]), C.size_t(len(tag)), slicePointerOrNull(ad), C.size_t(len(ad)),
+			(*C.uchar)(&nonce[0]), (*C.uchar)(&key[0]))
+		if res == 0 {
+			return errors.New("forged tag was accepted")
+		}
+	}
+
+	for _, want := range []string{katMACTag128, katMACTag256} {
+		wantTag, _ := hex.DecodeString(want)
+		tag := make([]byte, len(wantTag))
+		var st C.aegis128x2_mac_state
+		C.aegis128x2_mac_init(&st, (*C.uchar)(&macKey[0]), (*C.uchar)(&macNonce[0]))
+		C.aegis128x2_mac_update(&st, (*C.uchar)(&data[0]), C.size_t(len(data)))
+		C.aegis128x2_mac_final(&st, (*C.uchar)(&tag[0]), C.size_t(len(tag)))
+		if !bytes.Equal(tag, wantTag) {
+			return errors.New("MAC does not match the known answer")
+		}
+	}
+	return nil
+}
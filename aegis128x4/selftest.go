@@ -0,0 +1,90 @@
+//go:build cgo && go1.19
+// +build cgo,go1.19
+
+package aegis128x4
+
+// #include <aegis.h>
+// #cgo CFLAGS: -I../common/libaegis/src/include
+import "C"
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+
+	"github.com/aegis-aead/go-libaegis/common"
+)
+
+var selfTest = common.RegisterSelfTest("AEGIS-128X4", runSelfTest)
+
+// Known-answer vectors from draft-irtf-cfrg-aegis-aead. The AEAD vector has
+// an empty message, so the MAC vector is what exercises absorption across lanes.
+var (
+	katKey    = "000102030405060708090a0b0c0d0e0f"
+	katNonce  = "101112131415161718191a1b1c1d1e1f"
+	katAD     = ""
+	katMsg    = ""
+	katCt     = ""
+	katTag128 = "5bef762d0947c00455b97bb3af30dfa3"
+	katTag256 = "a4b25437f4be93cfa856a2f27e4416b42cac79fd4698f2cdbe6af25673e10a68"
+
+	katMACKey    = "10010000000000000000000000000000"
+	katMACNonce  = "10000200000000000000000000000000"
+	katMACData   = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f202122"
+	katMACTag128 = "c45a98fd9ab8956ce616eb008cfe4e53"
+	katMACTag256 = "26fdc76f41b1da7aec7779f6e964beae8904e662f05aca8345ae3befb357412a"
+)
+
+// runSelfTest checks encryption, decryption, forgery rejection and the MAC
+// against the known-answer vectors, for both tag lengths.
+func runSelfTest() error {
+	key, _ := hex.DecodeString(katKey)
+	nonce, _ := hex.DecodeString(katNonce)
+	ad, _ := hex.DecodeString(katAD)
+	msg, _ := hex.DecodeString(katMsg)
+	wantCt, _ := hex.DecodeString(katCt)
+	macKey, _ := hex.DecodeString(katMACKey)
+	macNonce, _ := hex.DecodeString(katMACNonce)
+	data, _ := hex.DecodeString(katMACData)
+
+	for _, want := range []string{katTag128, katTag256} {
+		wantTag, _ := hex.DecodeString(want)
+		ct := make([]byte, len(msg))
+		tag := make([]byte, len(wantTag))
+		C.aegis128x4_encrypt_detached(slicePointerOrNull(ct), (*C.uchar)(&tag[0]), C.size_t(len(tag)),
+			slicePointerOrNull(msg), C.size_t(len(msg)), slicePointerOrNull(ad), C.size_t(len(ad)),
+			(*C.uchar)(&nonce[0]), (*C.uchar)(&key[0]))
+		if !bytes.Equal(ct, wantCt) || !bytes.Equal(tag, wantTag) {
+			return errors.New("encryption does not match the known answer")
+		}
+
+		pt := make([]byte, len(ct))
+		res := C.aegis128x4_decrypt_detached(slicePointerOrNull(pt), slicePointerOrNull(ct), C.size_t(len(ct)),
+			(*C.uchar)(&tag[0]), C.size_t(len(tag)), slicePointerOrNull(ad), C.size_t(len(ad)),
+			(*C.uchar)(&nonce[0]), (*C.uchar)(&key[0]))
+		if res != 0 || !bytes.Equal(pt, msg) {
+			return errors.New("decryption does not match the known answer")
+		}
+
+		tag[0] ^= 1
+		res = C.aegis128x4_decrypt_detached(slicePointerOrNull(pt), slicePointerOrNull(ct), C.size_t(len(ct)),
+			(*C.uchar)(&tag[0]), C.size_t(len(tag)), slicePointerOrNull(ad), C.size_t(len(ad)),
+			(*C.uchar)(&nonce[0]), (*C.uchar)(&key[0]))
+		if res == 0 {
+			return errors.New("forged tag was accepted")
+		}
+	}
+
+	for _, want := range []string{katMACTag128, katMACTag256} {
+		wantTag, _ := hex.DecodeString(want)
+		tag := make([]byte, len(wantTag))
+		var st C.aegis128x4_mac_state
+		C.aegis128x4_mac_init(&st, (*C.uchar)(&macKey[0]), (*C.uchar)(&macNonce[0]))
+		C.aegis128x4_mac_update(&st, (*C.uchar)(&data[0]), C.size_t(len(data)))
+		C.aegis128x4_mac_final(&st, (*C.uchar)(&tag[0]), C.size_t(len(tag)))
+		if !bytes.Equal(tag, wantTag) {
+			return errors.New("MAC does not match the known answer")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+//go:build cgo && go1.19
+// +build cgo,go1.19
+
+package aegis256
+
+// #include <aegis.h>
+// #cgo CFLAGS: -I../common/libaegis/src/include
+import "C"
+
+import (
+	"github.com/aegis-aead/go-libaegis/common"
+)
+
+// MAC computes an AEGIS-256 message authentication code.
+// It implements hash.Hash; Sum and Verify do not change the state,
+// so more data can be written afterwards.
+//
+// A MAC must not be copied after first use.
+type MAC struct {
+	state  C.aegis256_mac_state
+	tagLen int
+}
+
+// NewMAC creates a new MAC.
+// The key must be KeySize (32) bytes.
+// The nonce must be at most NonceSize (32) bytes; shorter nonces are padded with zeros.
+// The tagLen must be 16 or 32.
+// The key and nonce pair must not be used for encryption.
+func NewMAC(key, nonce []byte, tagLen int) (*MAC, error) {
+	if err := selfTest.Check(); err != nil {
+		return nil, err
+	}
+	if len(key) != KeySize {
+		return nil, common.ErrBadKeyLength
+	}
+	if len(nonce) > NonceSize {
+		return nil, common.ErrBadNonceLength
+	}
+	if tagLen != 16 && tagLen != 32 {
+		return nil, common.ErrBadTagLength
+	}
+
+	// Pad nonce if needed
+	if len(nonce) < NonceSize {
+		nonce = append(nonce, make([]byte, NonceSize-len(nonce))...)
+	}
+
+	m := &MAC{tagLen: tagLen}
+	C.aegis256_mac_init(&m.state, (*C.uchar)(&key[0]), (*C.uchar)(&nonce[0]))
+	return m, nil
+}
+
+// Write absorbs more data. It never returns an error.
+func (m *MAC) Write(p []byte) (int, error) {
+	if len(p) > 0 {
+		C.aegis256_mac_update(&m.state, (*C.uchar)(&p[0]), C.size_t(len(p)))
+	}
+	return len(p), nil
+}
+
+// Sum appends the tag for the data written so far to b.
+func (m *MAC) Sum(b []byte) []byte {
+	var st C.aegis256_mac_state
+	C.aegis256_mac_state_clone(&st, &m.state)
+	tag := make([]byte, m.tagLen)
+	C.aegis256_mac_final(&st, (*C.uchar)(&tag[0]), C.size_t(m.tagLen))
+	return append(b, tag...)
+}
+
+// Verify checks tag against the data written so far, in constant time.
+// Returns ErrBadTagLength if tag is not Size bytes, or ErrAuth if it does not match.
+func (m *MAC) Verify(tag []byte) error {
+	if len(tag) != m.tagLen {
+		return common.ErrBadTagLength
+	}
+	var st C.aegis256_mac_state
+	C.aegis256_mac_state_clone(&st, &m.state)
+	if C.aegis256_mac_verify(&st, (*C.uchar)(&tag[0]), C.size_t(m.tagLen)) != 0 {
+		return common.ErrAuth
+	}
+	return nil
+}
+
+// Reset restores the state right after NewMAC, keeping the key and nonce.
+func (m *MAC) Reset() {
+	C.aegis256_mac_reset(&m.state)
+}
+
+// Size returns the tag length.
+func (m *MAC) Size() int {
+	return m.tagLen
+}
+
+// BlockSize returns the number of bytes AEGIS-256 absorbs per update.
+func (m *MAC) BlockSize() int {
+	return 16
+}
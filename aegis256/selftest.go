@@ -0,0 +1,85 @@
+//go:build cgo && go1.19
+// +build cgo,go1.19
+
+package aegis256
+
+// #include <aegis.h>
+// #cgo CFLAGS: -I../common/libaegis/src/include
+import "C"
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+
+	"github.com/aegis-aead/go-libaegis/common"
+)
+
+var selfTest = common.RegisterSelfTest("AEGIS-256", runSelfTest)
+
+// Known-answer vectors from draft-irtf-cfrg-aegis-aead.
+var (
+	katKey    = "1001000000000000000000000000000000000000000000000000000000000000"
+	katNonce  = "1000020000000000000000000000000000000000000000000000000000000000"
+	katAD     = ""
+	katMsg    = "00000000000000000000000000000000"
+	katCt     = "754fc3d8c973246dcc6d741412a4b236"
+	katTag128 = "3fe91994768b332ed7f570a19ec5896e"
+	katTag256 = "1181a1d18091082bf0266f66297d167d2e68b845f61a3b0527d31fc7b7b89f13"
+
+	katMACData   = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f202122"
+	katMACTag128 = "c08e20cfc56f27195a46c9cef5c162d4"
+	katMACTag256 = "a5c906ede3d69545c11e20afa360b221f936e946ed2dba3d7c75ad6dc2784126"
+)
+
+// runSelfTest checks encryption, decryption, forgery rejection and the MAC
+// against the known-answer vectors, for both tag lengths.
+func runSelfTest() error {
+	key, _ := hex.DecodeString(katKey)
+	nonce, _ := hex.DecodeString(katNonce)
+	ad, _ := hex.DecodeString(katAD)
+	msg, _ := hex.DecodeString(katMsg)
+	wantCt, _ := hex.DecodeString(katCt)
+	data, _ := hex.DecodeString(katMACData)
+
+	for _, want := range []string{katTag128, katTag256} {
+		wantTag, _ := hex.DecodeString(want)
+		ct := make([]byte, len(msg))
+		tag := make([]byte, len(wantTag))
+		C.aegis256_encrypt_detached(slicePointerOrNull(ct), (*C.uchar)(&tag[0]), C.size_t(len(tag)),
+			slicePointerOrNull(msg), C.size_t(len(msg)), slicePointerOrNull(ad), C.size_t(len(ad)),
+			(*C.uchar)(&nonce[0]), (*C.uchar)(&key[0]))
+		if !bytes.Equal(ct, wantCt) || !bytes.Equal(tag, wantTag) {
+			return errors.New("encryption does not match the known answer")
+		}
+
+		pt := make([]byte, len(ct))
+		res := C.aegis256_decrypt_detached(slicePointerOrNull(pt), slicePointerOrNull(ct), C.size_t(len(ct)),
+			(*C.uchar)(&tag[0]), C.size_t(len(tag)), slicePointerOrNull(ad), C.size_t(len(ad)),
+			(*C.uchar)(&nonce[0]), (*C.uchar)(&key[0]))
+		if res != 0 || !bytes.Equal(pt, msg) {
+			return errors.New("decryption does not match the known answer")
+		}
+
+		tag[0] ^= 1
+		res = C.aegis256_decrypt_detached(slicePointerOrNull(pt), slicePointerOrNull(ct), C.size_t(len(ct)),
+			(*C.uchar)(&tag[0]), C.size_t(len(tag)), slicePointerOrNull(ad), C.size_t(len(ad)),
+			(*C.uchar)(&nonce[0]), (*C.uchar)(&key[0]))
+		if res == 0 {
+			return errors.New("forged tag was accepted")
+		}
+	}
+
+	for _, want := range []string{katMACTag128, katMACTag256} {
+		wantTag, _ := hex.DecodeString(want)
+		tag := make([]byte, len(wantTag))
+		var st C.aegis256_mac_state
+		C.aegis256_mac_init(&st, (*C.uchar)(&key[0]), (*C.uchar)(&nonce[0]))
+		C.aegis256_mac_update(&st, (*C.uchar)(&data[0]), C.size_t(len(data)))
+		C.aegis256_mac_final(&st, (*C.uchar)(&tag[0]), C.size_t(len(tag)))
+		if !bytes.Equal(tag, wantTag) {
+			return errors.New("MAC does not match the known answer")
+		}
+	}
+	return nil
+}
@@ -3,7 +3,9 @@ package aegis256x2
 import (
 	"crypto/rand"
 	"fmt"
+	"testing"
 
+	"github.com/aegis-aead/go-libaegis/aegistest"
 	"github.com/aegis-aead/go-libaegis/common"
 )
 
@@ -31,3 +33,44 @@ func Example() {
 	fmt.Println(string(plaintext))
 	// Output: hello, world!
 }
+
+var stream = aegistest.Stream{
+	NewEncrypter: func(key, nonce, ad []byte, tagLen int) (aegistest.Encrypter, error) {
+		return NewEncrypter(key, nonce, ad, tagLen)
+	},
+	NewDecrypter: func(key, nonce, ad []byte, tagLen int) (aegistest.Decrypter, error) {
+		return NewDecrypter(key, nonce, ad, tagLen)
+	},
+}
+
+func TestVectors(t *testing.T) {
+	if !common.Available {
+		t.Skip("AEGIS-256X2 not available")
+	}
+
+	t.Run("AEAD", func(t *testing.T) {
+		aegistest.TestAEAD(t, "AEGIS-256X2", New)
+	})
+	t.Run("Stream", func(t *testing.T) {
+		aegistest.TestStream(t, "AEGIS-256X2", stream)
+	})
+	t.Run("MAC", func(t *testing.T) {
+		aegistest.TestMAC(t, "AEGIS-256X2", func(key, nonce []byte, tagLen int) (aegistest.MAC, error) {
+			return NewMAC(key, nonce, tagLen)
+		})
+	})
+}
+
+func FuzzSealOpen(f *testing.F) {
+	if !common.Available {
+		f.Skip("AEGIS-256X2 not available")
+	}
+	aegistest.FuzzAEAD(f, "AEGIS-256X2", New)
+}
+
+func FuzzIncremental(f *testing.F) {
+	if !common.Available {
+		f.Skip("AEGIS-256X2 not available")
+	}
+	aegistest.FuzzStream(f, "AEGIS-256X2", stream, New)
+}
@@ -26,6 +26,9 @@ type Encrypter struct {
 // The additionalData is authenticated but not encrypted.
 // The tagLen must be 16 or 32.
 func NewEncrypter(key, nonce, additionalData []byte, tagLen int) (*Encrypter, error) {
+	if err := selfTest.Check(); err != nil {
+		return nil, err
+	}
 	if len(key) != KeySize {
 		return nil, common.ErrBadKeyLength
 	}
@@ -130,6 +133,9 @@ type Decrypter struct {
 // The additionalData must match what was used during encryption.
 // The tagLen must match what was used during encryption (16 or 32).
 func NewDecrypter(key, nonce, additionalData []byte, tagLen int) (*Decrypter, error) {
+	if err := selfTest.Check(); err != nil {
+		return nil, err
+	}
 	if len(key) != KeySize {
 		return nil, common.ErrBadKeyLength
 	}
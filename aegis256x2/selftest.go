@@ -0,0 +1,90 @@
+//go:build cgo && go1.19
+// +build cgo,go1.19
+
+package aegis256x2
+
+// #include <aegis.h>
+// #cgo CFLAGS: -I../common/libaegis/src/include
+import "C"
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+
+	"github.com/aegis-aead/go-libaegis/common"
+)
+
+var selfTest = common.RegisterSelfTest("AEGIS-256X2", runSelfTest)
+
+// Known-answer vectors from draft-irtf-cfrg-aegis-aead. The AEAD vector has
+// an empty message, so the MAC vector is what exercises absorption across lanes.
+var (
+	katKey    = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+	katNonce  = "101112131415161718191a1b1c1d1e1f202122232425262728292a2b2c2d2e2f"
+	katAD     = ""
+	katMsg    = ""
+	katCt     = ""
+	katTag128 = "62cdbab084c83dacdb945bb446f049c8"
+	katTag256 = "25d7e799b49a80354c3f881ac2f1027f471a5d293052bd9997abd3ae84014bb7"
+
+	katMACKey    = "1001000000000000000000000000000000000000000000000000000000000000"
+	katMACNonce  = "1000020000000000000000000000000000000000000000000000000000000000"
+	katMACData   = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f202122"
+	katMACTag128 = "fb319cb6dd728a764606fb14d37f2a5e"
+	katMACTag256 = "0844b20ed5147ceae89c7a160263afd4b1382d6b154ecf560ce8a342cb6a8fd1"
+)
+
+// runSelfTest checks encryption, decryption, forgery rejection and the MAC
+// against the known-answer vectors, for both tag lengths.
+func runSelfTest() error {
+	key, _ := hex.DecodeString(katKey)
+	nonce, _ := hex.DecodeString(katNonce)
+	ad, _ := hex.DecodeString(katAD)
+	msg, _ := hex.DecodeString(katMsg)
+	wantCt, _ := hex.DecodeString(katCt)
+	macKey, _ := hex.DecodeString(katMACKey)
+	macNonce, _ := hex.DecodeString(katMACNonce)
+	data, _ := hex.DecodeString(katMACData)
+
+	for _, want := range []string{katTag128, katTag256} {
+		wantTag, _ := hex.DecodeString(want)
+		ct := make([]byte, len(msg))
+		tag := make([]byte, len(wantTag))
+		C.aegis256x2_encrypt_detached(slicePointerOrNull(ct), (*C.uchar)(&tag[0]), C.size_t(len(tag)),
+			slicePointerOrNull(msg), C.size_t(len(msg)), slicePointerOrNull(ad), C.size_t(len(ad)),
+			(*C.uchar)(&nonce[0]), (*C.uchar)(&key[0]))
+		if !bytes.Equal(ct, wantCt) || !bytes.Equal(tag, wantTag) {
+			return errors.New("encryption does not match the known answer")
+		}
+
+		pt := make([]byte, len(ct))
+		res := C.aegis256x2_decrypt_detached(slicePointerOrNull(pt), slicePointerOrNull(ct), C.size_t(len(ct)),
+			(*C.uchar)(&tag[0]), C.size_t(len(tag)), slicePointerOrNull(ad), C.size_t(len(ad)),
+			(*C.uchar)(&nonce[0]), (*C.uchar)(&key[0]))
+		if res != 0 || !bytes.Equal(pt, msg) {
+			return errors.New("decryption does not match the known answer")
+		}
+
+		tag[0] ^= 1
+		res = C.aegis256x2_decrypt_detached(slicePointerOrNull(pt), slicePointerOrNull(ct), C.size_t(len(ct)),
+			(*C.uchar)(&tag[0]), C.size_t(len(tag)), slicePointerOrNull(ad), C.size_t(len(ad)),
+			(*C.uchar)(&nonce[0]), (*C.uchar)(&key[0]))
+		if res == 0 {
+			return errors.New("forged tag was accepted")
+		}
+	}
+
+	for _, want := range []string{katMACTag128, katMACTag256} {
+		wantTag, _ := hex.DecodeString(want)
+		tag := make([]byte, len(wantTag))
+		var st C.aegis256x2_mac_state
+		C.aegis256x2_mac_init(&st, (*C.uchar)(&macKey[0]), (*C.uchar)(&macNonce[0]))
+		C.aegis256x2_mac_update(&st, (*C.uchar)(&data[0]), C.size_t(len(data)))
+		C.aegis256x2_mac_final(&st, (*C.uchar)(&tag[0]), C.size_t(len(tag)))
+		if !bytes.Equal(tag, wantTag) {
+			return errors.New("MAC does not match the known answer")
+		}
+	}
+	return nil
+}
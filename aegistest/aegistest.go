@@ -0,0 +1,115 @@
+// Package aegistest provides test vectors and conformance runners for
+// AEGIS implementations.
+//
+// Vectors for all six variants ship as JSON files in the vectors directory,
+// one per algorithm. Each AEAD vector gives the ciphertext and tag as
+// separate fields, so the same entry checks one-shot (ct || tag), incremental
+// and detached outputs. Vectors whose source is draft-irtf-cfrg-aegis-aead
+// are copied from the specification; the others were generated with libaegis
+// or derived from a valid vector by a single modification described in the
+// comment. Every valid vector exists with both 16 and 32 byte tags.
+//
+// The runners take constructors rather than concrete types, so wrappers
+// around other AEGIS implementations can be checked against the same vectors:
+//
+//	func TestVectors(t *testing.T) {
+//		aegistest.TestAEAD(t, "AEGIS-128L", mypkg.New)
+//	}
+package aegistest
+
+import (
+	"embed"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+//go:embed vectors/*.json
+var vectorFiles embed.FS
+
+// Result values used in vectors.
+const (
+	Valid   = "valid"   // the vector must verify and match
+	Invalid = "invalid" // verification must fail
+)
+
+// HexBytes is a byte slice encoded as a hex string in JSON.
+type HexBytes []byte
+
+func (h HexBytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(hex.EncodeToString(h))
+}
+
+func (h *HexBytes) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	*h = b
+	return nil
+}
+
+// AEADVector is a single AEAD test case.
+//
+// For invalid vectors, Tag may differ in length from TagLen; decryption
+// with a cipher configured for TagLen must fail in that case too.
+type AEADVector struct {
+	TcID    int      `json:"tc_id"`
+	Comment string   `json:"comment"`
+	Source  string   `json:"source"`
+	Key     HexBytes `json:"key"`
+	Nonce   HexBytes `json:"nonce"`
+	AD      HexBytes `json:"ad"`
+	Msg     HexBytes `json:"msg"`
+	Ct      HexBytes `json:"ct"`
+	Tag     HexBytes `json:"tag"`
+	TagLen  int      `json:"tag_len"`
+	Result  string   `json:"result"`
+}
+
+// MACVector is a single AEGIS-MAC test case.
+type MACVector struct {
+	TcID    int      `json:"tc_id"`
+	Comment string   `json:"comment"`
+	Source  string   `json:"source"`
+	Key     HexBytes `json:"key"`
+	Nonce   HexBytes `json:"nonce"`
+	Data    HexBytes `json:"data"`
+	Tag     HexBytes `json:"tag"`
+	TagLen  int      `json:"tag_len"`
+	Result  string   `json:"result"`
+}
+
+// Suite holds every vector for one algorithm.
+type Suite struct {
+	Algorithm string       `json:"algorithm"`
+	KeySize   int          `json:"key_size"`
+	NonceSize int          `json:"nonce_size"`
+	AEAD      []AEADVector `json:"aead"`
+	MAC       []MACVector  `json:"mac"`
+}
+
+// Algorithms lists the algorithm names that have vectors, in the form
+// accepted by Load.
+func Algorithms() []string {
+	return []string{"AEGIS-128L", "AEGIS-128X2", "AEGIS-128X4", "AEGIS-256", "AEGIS-256X2", "AEGIS-256X4"}
+}
+
+// Load returns the vectors for the named algorithm, such as "AEGIS-128X2".
+func Load(algorithm string) (*Suite, error) {
+	name := "vectors/" + strings.ToLower(strings.ReplaceAll(algorithm, "-", "")) + ".json"
+	data, err := vectorFiles.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("aegistest: no vectors for %q", algorithm)
+	}
+	s := new(Suite)
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("aegistest: %s: %w", name, err)
+	}
+	return s, nil
+}
@@ -0,0 +1,46 @@
+package aegistest
+
+import "testing"
+
+func TestLoad(t *testing.T) {
+	for _, alg := range Algorithms() {
+		s, err := Load(alg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.Algorithm != alg {
+			t.Errorf("%s: file is for %s", alg, s.Algorithm)
+		}
+
+		ids := make(map[int]bool)
+		counts := make(map[string]int)
+		for _, v := range s.AEAD {
+			if ids[v.TcID] {
+				t.Errorf("%s: duplicate AEAD tc_id %d", alg, v.TcID)
+			}
+			ids[v.TcID] = true
+			if len(v.Key) != s.KeySize || len(v.Nonce) != s.NonceSize {
+				t.Errorf("%s: tc%d: bad key or nonce length", alg, v.TcID)
+			}
+			if v.Result == Valid && (len(v.Ct) != len(v.Msg) || len(v.Tag) != v.TagLen) {
+				t.Errorf("%s: tc%d: inconsistent lengths", alg, v.TcID)
+			}
+			counts[v.Result+"/"+string(rune('0'+v.TagLen/16))]++
+		}
+		for _, v := range s.MAC {
+			counts["mac/"+v.Result]++
+		}
+		for _, k := range []string{"valid/1", "valid/2", "invalid/1", "invalid/2", "mac/valid", "mac/invalid"} {
+			if counts[k] == 0 {
+				t.Errorf("%s: no %s vectors", alg, k)
+			}
+		}
+		if counts["valid/1"] != counts["valid/2"] {
+			t.Errorf("%s: %d valid 16-byte tag vectors, %d 32-byte", alg, counts["valid/1"], counts["valid/2"])
+		}
+	}
+
+	if _, err := Load("AEGIS-512"); err == nil {
+		t.Error("Load accepted an unknown algorithm")
+	}
+}
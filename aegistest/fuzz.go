@@ -0,0 +1,194 @@
+package aegistest
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"testing"
+)
+
+// FuzzAEAD fuzzes Seal/Open round trips for newAEAD, seeded from the valid
+// vectors for algorithm. Nonces of any length are tried: longer than the
+// nonce size must panic, shorter must behave as if padded with zeros. Each
+// input is also sealed and opened in place and appended to a prefix.
+// Modified or truncated ciphertexts must fail to open.
+func FuzzAEAD(f *testing.F, algorithm string, newAEAD func(key []byte, tagLen int) (cipher.AEAD, error)) {
+	s, err := Load(algorithm)
+	if err != nil {
+		f.Fatal(err)
+	}
+	for i, v := range s.AEAD {
+		if v.Result == Valid {
+			f.Add([]byte(v.Key), []byte(v.Nonce), []byte(v.AD), []byte(v.Msg), v.TagLen == 32, uint8(i))
+		}
+	}
+	f.Add([]byte{}, make([]byte, s.NonceSize+1), []byte{}, []byte("x"), false, uint8(0))
+
+	f.Fuzz(func(t *testing.T, key, nonce, ad, msg []byte, longTag bool, mode uint8) {
+		tagLen := 16
+		if longTag {
+			tagLen = 32
+		}
+		aead, err := newAEAD(fit(key, s.KeySize), tagLen)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(nonce) > s.NonceSize {
+			if !panics(func() { aead.Seal(nil, nonce, msg, ad) }) {
+				t.Fatalf("Seal accepted a %d-byte nonce", len(nonce))
+			}
+			if !panics(func() { aead.Open(nil, nonce, make([]byte, tagLen), ad) }) {
+				t.Fatalf("Open accepted a %d-byte nonce", len(nonce))
+			}
+			return
+		}
+
+		sealed := aead.Seal(nil, nonce, msg, ad)
+		if len(sealed) != len(msg)+tagLen {
+			t.Fatalf("Seal returned %d bytes, want %d", len(sealed), len(msg)+tagLen)
+		}
+		if padded := aead.Seal(nil, fit(nonce, s.NonceSize), msg, ad); !bytes.Equal(padded, sealed) {
+			t.Fatalf("a %d-byte nonce is not equivalent to its zero-padded form", len(nonce))
+		}
+		pt, err := aead.Open(nil, nonce, sealed, ad)
+		if err != nil || !bytes.Equal(pt, msg) {
+			t.Fatalf("Open: %x, %v", pt, err)
+		}
+
+		switch mode % 2 {
+		case 0:
+			buf := make([]byte, len(msg), len(sealed))
+			copy(buf, msg)
+			out := aead.Seal(buf[:0], nonce, buf, ad)
+			if !bytes.Equal(out, sealed) {
+				t.Fatal("in-place Seal differs")
+			}
+			if pt, err := aead.Open(out[:0], nonce, out, ad); err != nil || !bytes.Equal(pt, msg) {
+				t.Fatalf("in-place Open: %x, %v", pt, err)
+			}
+		case 1:
+			prefix := []byte{mode, mode}
+			out := aead.Seal(prefix, nonce, msg, ad)
+			if !bytes.Equal(out[:2], prefix) || !bytes.Equal(out[2:], sealed) {
+				t.Fatal("Seal does not append to dst")
+			}
+			if pt, err := aead.Open(out[:2], nonce, sealed, ad); err != nil || !bytes.Equal(pt[2:], msg) {
+				t.Fatalf("Open does not append to dst: %x, %v", pt, err)
+			}
+		}
+
+		if _, err := aead.Open(nil, nonce, sealed[:len(sealed)-1], ad); err == nil {
+			t.Fatal("Open accepted a truncated ciphertext")
+		}
+		sealed[int(mode)%len(sealed)] ^= 0x80
+		if _, err := aead.Open(nil, nonce, sealed, ad); err == nil {
+			t.Fatal("Open accepted a modified ciphertext")
+		}
+	})
+}
+
+// FuzzStream fuzzes the incremental API for equivalence with one-shot Seal:
+// the plaintext is fed to the Encrypter in arbitrary chunks, and the result
+// decrypted with a different chunking.
+func FuzzStream(f *testing.F, algorithm string, stream Stream, newAEAD func(key []byte, tagLen int) (cipher.AEAD, error)) {
+	s, err := Load(algorithm)
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, v := range s.AEAD {
+		if v.Result == Valid {
+			f.Add([]byte(v.Key), []byte(v.Nonce), []byte(v.AD), []byte(v.Msg), []byte{1, 0, 31, 64}, v.TagLen == 32)
+		}
+	}
+
+	f.Fuzz(func(t *testing.T, key, nonce, ad, msg, splits []byte, longTag bool) {
+		tagLen := 16
+		if longTag {
+			tagLen = 32
+		}
+		key = fit(key, s.KeySize)
+		if len(nonce) > s.NonceSize {
+			if _, err := stream.NewEncrypter(key, nonce, ad, tagLen); err == nil {
+				t.Fatalf("NewEncrypter accepted a %d-byte nonce", len(nonce))
+			}
+			return
+		}
+		aead, err := newAEAD(key, tagLen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := aead.Seal(nil, nonce, msg, ad)
+
+		enc, err := stream.NewEncrypter(key, nonce, ad, tagLen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ct []byte
+		for _, chunk := range splitBy(msg, splits) {
+			ct = append(ct, enc.Encrypt(chunk)...)
+		}
+		tag := enc.Final()
+		if !bytes.Equal(append(ct, tag...), want) {
+			t.Fatal("incremental encryption differs from Seal")
+		}
+
+		reversed := make([]byte, len(splits))
+		for i, n := range splits {
+			reversed[len(splits)-1-i] = n
+		}
+		dec, err := stream.NewDecrypter(key, nonce, ad, tagLen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var pt []byte
+		for _, chunk := range splitBy(ct, reversed) {
+			pt = append(pt, dec.Decrypt(chunk)...)
+		}
+		if err := dec.Final(tag); err != nil {
+			t.Fatalf("Final: %v", err)
+		}
+		if !bytes.Equal(pt, msg) {
+			t.Fatal("incremental decryption differs from the plaintext")
+		}
+
+		dec, err = stream.NewDecrypter(key, nonce, ad, tagLen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dec.Decrypt(ct)
+		tag[len(tag)-1] ^= 1
+		if err := dec.Final(tag); err == nil {
+			t.Fatal("Final accepted a modified tag")
+		}
+	})
+}
+
+// fit truncates or zero-pads b to n bytes.
+func fit(b []byte, n int) []byte {
+	out := make([]byte, n)
+	copy(out, b)
+	return out
+}
+
+// splitBy cuts b into pieces whose lengths are taken from sizes in turn.
+// Zero sizes produce empty pieces; whatever is left over forms the last one.
+func splitBy(b, sizes []byte) [][]byte {
+	var out [][]byte
+	for i, limit := 0, len(b)+len(sizes); len(b) > 0 && len(sizes) > 0 && i < limit; i++ {
+		n := int(sizes[i%len(sizes)])
+		if n > len(b) {
+			n = len(b)
+		}
+		out = append(out, b[:n])
+		b = b[n:]
+	}
+	return append(out, b)
+}
+
+func panics(fn func()) (panicked bool) {
+	defer func() {
+		panicked = recover() != nil
+	}()
+	fn()
+	return false
+}
@@ -0,0 +1,258 @@
+package aegistest
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"fmt"
+	"io"
+	"testing"
+)
+
+// Encrypter is the incremental encryption interface checked by TestStream.
+type Encrypter interface {
+	Encrypt(plaintext []byte) []byte
+	Final() []byte
+}
+
+// Decrypter is the incremental decryption interface checked by TestStream.
+type Decrypter interface {
+	Decrypt(ciphertext []byte) []byte
+	Final(tag []byte) error
+}
+
+// Stream holds the incremental constructors checked by TestStream.
+type Stream struct {
+	NewEncrypter func(key, nonce, additionalData []byte, tagLen int) (Encrypter, error)
+	NewDecrypter func(key, nonce, additionalData []byte, tagLen int) (Decrypter, error)
+}
+
+// MAC is the interface checked by TestMAC.
+type MAC interface {
+	io.Writer
+	Sum(b []byte) []byte
+	Verify(tag []byte) error
+}
+
+// streamSplits are the chunk sizes used to feed incremental APIs; 0 means
+// the whole input in one call.
+var streamSplits = []int{0, 1, 15, 16, 33, 64}
+
+func mustLoad(t *testing.T, algorithm string) *Suite {
+	t.Helper()
+	s, err := Load(algorithm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func vectorName(id int, tagLen int, result string) string {
+	return fmt.Sprintf("tc%d/tag%d/%s", id, tagLen*8, result)
+}
+
+// TestAEAD runs the AEAD vectors for algorithm against newAEAD. Valid
+// vectors are sealed and opened, including in place; invalid ones must
+// fail to open. It also checks that bad key and tag lengths are rejected.
+func TestAEAD(t *testing.T, algorithm string, newAEAD func(key []byte, tagLen int) (cipher.AEAD, error)) {
+	s := mustLoad(t, algorithm)
+
+	t.Run("params", func(t *testing.T) {
+		key := make([]byte, s.KeySize)
+		for _, tagLen := range []int{0, 8, 24, 64} {
+			if _, err := newAEAD(key, tagLen); err == nil {
+				t.Errorf("tag length %d was accepted", tagLen)
+			}
+		}
+		for _, keyLen := range []int{0, s.KeySize - 1, s.KeySize + 1} {
+			if _, err := newAEAD(make([]byte, keyLen), 16); err == nil {
+				t.Errorf("key length %d was accepted", keyLen)
+			}
+		}
+		for _, tagLen := range []int{16, 32} {
+			aead, err := newAEAD(key, tagLen)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if aead.NonceSize() != s.NonceSize || aead.Overhead() != tagLen {
+				t.Errorf("tag%d: NonceSize %d, Overhead %d; want %d, %d",
+					tagLen*8, aead.NonceSize(), aead.Overhead(), s.NonceSize, tagLen)
+			}
+		}
+	})
+
+	for _, v := range s.AEAD {
+		v := v
+		t.Run(vectorName(v.TcID, v.TagLen, v.Result), func(t *testing.T) {
+			aead, err := newAEAD(v.Key, v.TagLen)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sealed := append(append([]byte{}, v.Ct...), v.Tag...)
+
+			if v.Result == Invalid {
+				if pt, err := aead.Open(nil, v.Nonce, sealed, v.AD); err == nil {
+					t.Fatalf("%s: Open succeeded with %x", v.Comment, pt)
+				}
+				return
+			}
+
+			out := aead.Seal(nil, v.Nonce, v.Msg, v.AD)
+			if len(out) != len(v.Msg)+v.TagLen {
+				t.Fatalf("Seal returned %d bytes, want %d", len(out), len(v.Msg)+v.TagLen)
+			}
+			if ct := out[:len(v.Msg)]; !bytes.Equal(ct, v.Ct) {
+				t.Errorf("ciphertext:\n got %x\nwant %x", ct, v.Ct)
+			}
+			if tag := out[len(v.Msg):]; !bytes.Equal(tag, v.Tag) {
+				t.Errorf("tag:\n got %x\nwant %x", tag, v.Tag)
+			}
+
+			prefix := []byte("prefix")
+			if out := aead.Seal(prefix, v.Nonce, v.Msg, v.AD); !bytes.Equal(out, append(prefix, sealed...)) {
+				t.Errorf("Seal does not append to dst")
+			}
+
+			pt, err := aead.Open(nil, v.Nonce, sealed, v.AD)
+			if err != nil {
+				t.Fatalf("Open: %v", err)
+			}
+			if !bytes.Equal(pt, v.Msg) {
+				t.Errorf("Open:\n got %x\nwant %x", pt, v.Msg)
+			}
+
+			buf := make([]byte, len(v.Msg), len(sealed))
+			copy(buf, v.Msg)
+			if out := aead.Seal(buf[:0], v.Nonce, buf, v.AD); !bytes.Equal(out, sealed) {
+				t.Errorf("in-place Seal:\n got %x\nwant %x", out, sealed)
+			}
+			buf = append([]byte{}, sealed...)
+			if pt, err := aead.Open(buf[:0], v.Nonce, buf, v.AD); err != nil || !bytes.Equal(pt, v.Msg) {
+				t.Errorf("in-place Open: %x, %v", pt, err)
+			}
+		})
+	}
+}
+
+// TestStream runs the AEAD vectors for algorithm against incremental
+// constructors, feeding input in chunks of several sizes and checking the
+// ciphertext and tag separately.
+func TestStream(t *testing.T, algorithm string, stream Stream) {
+	s := mustLoad(t, algorithm)
+
+	for _, v := range s.AEAD {
+		v := v
+		t.Run(vectorName(v.TcID, v.TagLen, v.Result), func(t *testing.T) {
+			for _, split := range streamSplits {
+				if v.Result == Valid {
+					enc, err := stream.NewEncrypter(v.Key, v.Nonce, v.AD, v.TagLen)
+					if err != nil {
+						t.Fatal(err)
+					}
+					var ct []byte
+					for _, chunk := range chunks(v.Msg, split) {
+						ct = append(ct, enc.Encrypt(chunk)...)
+					}
+					if !bytes.Equal(ct, v.Ct) {
+						t.Errorf("split %d: ciphertext:\n got %x\nwant %x", split, ct, v.Ct)
+					}
+					if tag := enc.Final(); !bytes.Equal(tag, v.Tag) {
+						t.Errorf("split %d: tag:\n got %x\nwant %x", split, tag, v.Tag)
+					}
+				}
+
+				dec, err := stream.NewDecrypter(v.Key, v.Nonce, v.AD, v.TagLen)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var pt []byte
+				for _, chunk := range chunks(v.Ct, split) {
+					pt = append(pt, dec.Decrypt(chunk)...)
+				}
+				err = dec.Final(v.Tag)
+				if v.Result == Invalid {
+					if err == nil {
+						t.Fatalf("split %d: %s: Final accepted the tag", split, v.Comment)
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatalf("split %d: Final: %v", split, err)
+				}
+				if !bytes.Equal(pt, v.Msg) {
+					t.Errorf("split %d: plaintext:\n got %x\nwant %x", split, pt, v.Msg)
+				}
+			}
+		})
+	}
+}
+
+// TestMAC runs the MAC vectors for algorithm against newMAC. If the MAC
+// also has a Reset method, it is checked to restore the initial state.
+func TestMAC(t *testing.T, algorithm string, newMAC func(key, nonce []byte, tagLen int) (MAC, error)) {
+	s := mustLoad(t, algorithm)
+
+	t.Run("params", func(t *testing.T) {
+		for _, tagLen := range []int{0, 8, 24, 64} {
+			if _, err := newMAC(make([]byte, s.KeySize), nil, tagLen); err == nil {
+				t.Errorf("tag length %d was accepted", tagLen)
+			}
+		}
+		for _, keyLen := range []int{0, s.KeySize - 1, s.KeySize + 1} {
+			if _, err := newMAC(make([]byte, keyLen), nil, 16); err == nil {
+				t.Errorf("key length %d was accepted", keyLen)
+			}
+		}
+	})
+
+	for _, v := range s.MAC {
+		v := v
+		t.Run(vectorName(v.TcID, v.TagLen, v.Result), func(t *testing.T) {
+			for _, split := range streamSplits {
+				m, err := newMAC(v.Key, v.Nonce, v.TagLen)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, chunk := range chunks(v.Data, split) {
+					m.Write(chunk)
+				}
+				err = m.Verify(v.Tag)
+				if v.Result == Invalid {
+					if err == nil {
+						t.Fatalf("split %d: %s: Verify accepted the tag", split, v.Comment)
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatalf("split %d: Verify: %v", split, err)
+				}
+				tag := m.Sum(nil)
+				if !bytes.Equal(tag, v.Tag) {
+					t.Errorf("split %d: tag:\n got %x\nwant %x", split, tag, v.Tag)
+				}
+				if again := m.Sum(nil); !bytes.Equal(again, tag) {
+					t.Errorf("split %d: Sum changed the state", split)
+				}
+				if r, ok := m.(interface{ Reset() }); ok {
+					r.Reset()
+					m.Write(v.Data)
+					if tag := m.Sum(nil); !bytes.Equal(tag, v.Tag) {
+						t.Errorf("split %d: after Reset:\n got %x\nwant %x", split, tag, v.Tag)
+					}
+				}
+			}
+		})
+	}
+}
+
+// chunks splits b into pieces of size n, or returns b whole if n is 0.
+func chunks(b []byte, n int) [][]byte {
+	if n == 0 || len(b) <= n {
+		return [][]byte{b}
+	}
+	var out [][]byte
+	for len(b) > n {
+		out = append(out, b[:n])
+		b = b[n:]
+	}
+	return append(out, b)
+}
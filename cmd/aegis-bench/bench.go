@@ -0,0 +1,247 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"fmt"
+	"hash"
+	"time"
+
+	"github.com/aegis-aead/go-libaegis/aegis128l"
+	"github.com/aegis-aead/go-libaegis/aegis128x2"
+	"github.com/aegis-aead/go-libaegis/aegis128x4"
+	"github.com/aegis-aead/go-libaegis/aegis256"
+	"github.com/aegis-aead/go-libaegis/aegis256x2"
+	"github.com/aegis-aead/go-libaegis/aegis256x4"
+	"github.com/aegis-aead/go-libaegis/raf"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// operations lists the benchmarks in the order they run.
+var operations = []string{"oneshot", "incremental", "mac", "raf-write", "raf-read"}
+
+// tagLen is used for every AEGIS benchmark, to match the 16-byte tags of
+// AES-GCM and ChaCha20-Poly1305.
+const tagLen = 16
+
+type encrypter interface {
+	EncryptTo(dst, plaintext []byte) []byte
+	Final() []byte
+}
+
+type variant struct {
+	name         string
+	keySize      int
+	newAEAD      func(key []byte, tagLen int) (cipher.AEAD, error)
+	newEncrypter func(key, nonce, ad []byte, tagLen int) (encrypter, error)
+	newMAC       func(key, nonce []byte, tagLen int) (hash.Hash, error)
+	raf          raf.Algorithm
+}
+
+var variants = []variant{
+	{"AEGIS-128L", aegis128l.KeySize, aegis128l.New,
+		func(k, n, ad []byte, t int) (encrypter, error) { return aegis128l.NewEncrypter(k, n, ad, t) },
+		func(k, n []byte, t int) (hash.Hash, error) { return aegis128l.NewMAC(k, n, t) },
+		raf.AEGIS128L},
+	{"AEGIS-128X2", aegis128x2.KeySize, aegis128x2.New,
+		func(k, n, ad []byte, t int) (encrypter, error) { return aegis128x2.NewEncrypter(k, n, ad, t) },
+		func(k, n []byte, t int) (hash.Hash, error) { return aegis128x2.NewMAC(k, n, t) },
+		raf.AEGIS128X2},
+	{"AEGIS-128X4", aegis128x4.KeySize, aegis128x4.New,
+		func(k, n, ad []byte, t int) (encrypter, error) { return aegis128x4.NewEncrypter(k, n, ad, t) },
+		func(k, n []byte, t int) (hash.Hash, error) { return aegis128x4.NewMAC(k, n, t) },
+		raf.AEGIS128X4},
+	{"AEGIS-256", aegis256.KeySize, aegis256.New,
+		func(k, n, ad []byte, t int) (encrypter, error) { return aegis256.NewEncrypter(k, n, ad, t) },
+		func(k, n []byte, t int) (hash.Hash, error) { return aegis256.NewMAC(k, n, t) },
+		raf.AEGIS256},
+	{"AEGIS-256X2", aegis256x2.KeySize, aegis256x2.New,
+		func(k, n, ad []byte, t int) (encrypter, error) { return aegis256x2.NewEncrypter(k, n, ad, t) },
+		func(k, n []byte, t int) (hash.Hash, error) { return aegis256x2.NewMAC(k, n, t) },
+		raf.AEGIS256X2},
+	{"AEGIS-256X4", aegis256x4.KeySize, aegis256x4.New,
+		func(k, n, ad []byte, t int) (encrypter, error) { return aegis256x4.NewEncrypter(k, n, ad, t) },
+		func(k, n []byte, t int) (hash.Hash, error) { return aegis256x4.NewMAC(k, n, t) },
+		raf.AEGIS256X4},
+}
+
+// baselines are the non-AEGIS AEADs measured by the oneshot benchmark.
+var baselines = []struct {
+	name    string
+	keySize int
+	newAEAD func(key []byte) (cipher.AEAD, error)
+}{
+	{"AES-128-GCM", 16, newGCM},
+	{"AES-256-GCM", 32, newGCM},
+	{"ChaCha20-Poly1305", chacha20poly1305.KeySize, chacha20poly1305.New},
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+type bench struct {
+	duration time.Duration
+	sizes    []int
+	algs     []string // empty means all
+
+	rafParallel int
+}
+
+func (b *bench) selected(name string) bool {
+	return len(b.algs) == 0 || contains(b.algs, name)
+}
+
+func (b *bench) run(op string) ([]Measurement, error) {
+	var results []Measurement
+	add := func(alg string, size int, fn func()) {
+		m := b.measure(fn)
+		m.Operation, m.Algorithm, m.Size = op, alg, size
+		if m.NsPerOp > 0 {
+			m.MBPerSec = float64(size) * 1e3 / m.NsPerOp
+		}
+		results = append(results, m)
+	}
+
+	for _, size := range b.sizes {
+		msg := make([]byte, size)
+		switch op {
+		case "oneshot":
+			for _, v := range variants {
+				if !b.selected(v.name) {
+					continue
+				}
+				aead, err := v.newAEAD(make([]byte, v.keySize), tagLen)
+				if err != nil {
+					return nil, err
+				}
+				add(v.name, size, sealer(aead, msg))
+			}
+			for _, v := range baselines {
+				if !b.selected(v.name) {
+					continue
+				}
+				aead, err := v.newAEAD(make([]byte, v.keySize))
+				if err != nil {
+					return nil, err
+				}
+				add(v.name, size, sealer(aead, msg))
+			}
+
+		case "incremental":
+			for _, v := range variants {
+				if !b.selected(v.name) {
+					continue
+				}
+				key, nonce, out := make([]byte, v.keySize), make([]byte, v.keySize), make([]byte, size)
+				var err error
+				add(v.name, size, func() {
+					var enc encrypter
+					if enc, err = v.newEncrypter(key, nonce, nil, tagLen); err == nil {
+						enc.EncryptTo(out, msg)
+						enc.Final()
+					}
+				})
+				if err != nil {
+					return nil, err
+				}
+			}
+
+		case "mac":
+			for _, v := range variants {
+				if !b.selected(v.name) {
+					continue
+				}
+				mac, err := v.newMAC(make([]byte, v.keySize), nil, tagLen)
+				if err != nil {
+					return nil, err
+				}
+				tag := make([]byte, 0, tagLen)
+				add(v.name, size, func() {
+					mac.Reset()
+					mac.Write(msg)
+					mac.Sum(tag)
+				})
+			}
+
+		case "raf-write", "raf-read":
+			for _, v := range variants {
+				if !b.selected(v.name) {
+					continue
+				}
+				// The store is in memory, so that RAF figures exclude disk
+				// I/O.
+				f, err := raf.Create(raf.NewMemStore(), make([]byte, v.keySize), &raf.Options{Algorithm: v.raf, Parallelism: b.rafParallel})
+				if err != nil {
+					return nil, err
+				}
+				if _, err = f.WriteAt(msg, 0); err == nil {
+					if op == "raf-write" {
+						add(v.name, size, func() { f.WriteAt(msg, 0) })
+					} else {
+						add(v.name, size, func() { f.ReadAt(msg, 0) })
+					}
+				}
+				if cerr := f.Close(); err == nil {
+					err = cerr
+				}
+				if err != nil {
+					return nil, fmt.Errorf("%s %s: %w", op, v.name, err)
+				}
+			}
+		}
+	}
+	return results, nil
+}
+
+// sealer returns a function that seals msg with a fixed nonce into a
+// reused buffer.
+func sealer(aead cipher.AEAD, msg []byte) func() {
+	nonce := make([]byte, aead.NonceSize())
+	out := make([]byte, 0, len(msg)+aead.Overhead())
+	return func() {
+		aead.Seal(out, nonce, msg, nil)
+	}
+}
+
+// measure runs fn repeatedly for at least b.duration, growing the iteration
+// count the same way the testing package does.
+func (b *bench) measure(fn func()) Measurement {
+	fn() // warm up
+	n := 1
+	for {
+		start := time.Now()
+		for i := 0; i < n; i++ {
+			fn()
+		}
+		elapsed := time.Since(start)
+		if elapsed >= b.duration || n >= 1e9 {
+			return Measurement{Ops: n, NsPerOp: float64(elapsed.Nanoseconds()) / float64(n)}
+		}
+		next := n * 100
+		if elapsed > 0 {
+			next = int(1.2 * float64(n) * float64(b.duration) / float64(elapsed))
+		}
+		if next > 100*n {
+			next = 100 * n
+		}
+		if next <= n {
+			next = n + 1
+		}
+		n = next
+	}
+}
+
+// cgoCall returns the cost in nanoseconds of an empty cgo call.
+func (b *bench) cgoCall() float64 {
+	m := b.measure(func() {
+		for i := 0; i < 100; i++ {
+			cgoNoop()
+		}
+	})
+	return m.NsPerOp / 100
+}
@@ -0,0 +1,11 @@
+//go:build cgo && go1.19
+// +build cgo,go1.19
+
+package main
+
+// static void noop(void) {}
+import "C"
+
+func cgoNoop() {
+	C.noop()
+}
@@ -0,0 +1,6 @@
+//go:build !cgo || !go1.19
+// +build !cgo !go1.19
+
+package main
+
+func cgoNoop() {}
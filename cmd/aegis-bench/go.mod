@@ -0,0 +1,12 @@
+module github.com/aegis-aead/go-libaegis/cmd/aegis-bench
+
+go 1.19
+
+require (
+	github.com/aegis-aead/go-libaegis v0.0.0
+	golang.org/x/crypto v0.24.0
+)
+
+require golang.org/x/sys v0.21.0 // indirect
+
+replace github.com/aegis-aead/go-libaegis => ../..
@@ -0,0 +1,158 @@
+// Command aegis-bench measures AEGIS throughput on the local host.
+//
+// It benchmarks one-shot, incremental, MAC and RAF operations for all six
+// AEGIS variants across a grid of message sizes, alongside AES-GCM from the
+// standard library and ChaCha20-Poly1305 from golang.org/x/crypto. The cost
+// of an empty cgo call is measured and reported separately, since it is a
+// fixed per-call overhead that dominates small messages.
+//
+// Usage:
+//
+//	aegis-bench [-sizes 64,1024,65536] [-ops oneshot,mac] [-algs AEGIS-128L,AES-128-GCM] [-time 250ms] [-raf-parallel 4] [-format table|json]
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aegis-aead/go-libaegis/common"
+)
+
+// Report is the JSON output format.
+type Report struct {
+	GoVersion string        `json:"go_version"`
+	GOOS      string        `json:"goos"`
+	GOARCH    string        `json:"goarch"`
+	CPUs      int           `json:"cpus"`
+	Time      string        `json:"time_per_measurement"`
+	CgoCallNs float64       `json:"cgo_call_ns"`
+	Parallel  int           `json:"raf_parallelism"`
+	Results   []Measurement `json:"results"`
+}
+
+// Measurement is the result of one benchmark.
+type Measurement struct {
+	Operation string  `json:"operation"`
+	Algorithm string  `json:"algorithm"`
+	Size      int     `json:"size"`
+	Ops       int     `json:"ops"`
+	NsPerOp   float64 `json:"ns_per_op"`
+	MBPerSec  float64 `json:"mb_per_sec"`
+}
+
+var defaultSizes = "16,64,256,1024,8192,65536,1048576"
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "aegis-bench:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, w io.Writer) error {
+	fs := flag.NewFlagSet("aegis-bench", flag.ContinueOnError)
+	sizesFlag := fs.String("sizes", defaultSizes, "comma-separated message sizes in bytes")
+	opsFlag := fs.String("ops", strings.Join(operations, ","), "comma-separated operations to run")
+	algsFlag := fs.String("algs", "", "comma-separated algorithms to run (default all)")
+	duration := fs.Duration("time", 250*time.Millisecond, "minimum time per measurement")
+	rafParallel := fs.Int("raf-parallel", 1, "goroutines per RAF read or write (raf.Options.Parallelism)")
+	format := fs.String("format", "table", "output format: table or json")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if !common.Available {
+		return fmt.Errorf("built without cgo; AEGIS is not available")
+	}
+	if *format != "table" && *format != "json" {
+		return fmt.Errorf("unknown format %q", *format)
+	}
+	sizes, err := parseSizes(*sizesFlag)
+	if err != nil {
+		return err
+	}
+	ops := splitList(*opsFlag)
+	for _, op := range ops {
+		if !contains(operations, op) {
+			return fmt.Errorf("unknown operation %q (want one of %s)", op, strings.Join(operations, ", "))
+		}
+	}
+
+	b := &bench{duration: *duration, sizes: sizes, algs: splitList(*algsFlag), rafParallel: *rafParallel}
+	report := Report{
+		GoVersion: runtime.Version(),
+		GOOS:      runtime.GOOS,
+		GOARCH:    runtime.GOARCH,
+		CPUs:      runtime.NumCPU(),
+		Time:      duration.String(),
+		CgoCallNs: b.cgoCall(),
+		Parallel:  *rafParallel,
+	}
+	for _, op := range ops {
+		results, err := b.run(op)
+		if err != nil {
+			return err
+		}
+		report.Results = append(report.Results, results...)
+	}
+
+	if *format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+	return writeTable(w, &report)
+}
+
+func writeTable(w io.Writer, r *Report) error {
+	fmt.Fprintf(w, "%s %s/%s, %d CPUs\n", r.GoVersion, r.GOOS, r.GOARCH, r.CPUs)
+	fmt.Fprintf(w, "cgo call overhead: %.1f ns/call (included in the figures below)\n", r.CgoCallNs)
+	fmt.Fprintf(w, "RAF parallelism: %d\n\n", r.Parallel)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "operation\talgorithm\tsize\tns/op\tMB/s\t")
+	for _, m := range r.Results {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%.0f\t%.1f\t\n", m.Operation, m.Algorithm, m.Size, m.NsPerOp, m.MBPerSec)
+	}
+	return tw.Flush()
+}
+
+func parseSizes(s string) ([]int, error) {
+	var sizes []int
+	for _, f := range splitList(s) {
+		n, err := strconv.Atoi(f)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid size %q", f)
+		}
+		sizes = append(sizes, n)
+	}
+	if len(sizes) == 0 {
+		return nil, fmt.Errorf("no sizes given")
+	}
+	return sizes, nil
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, f := range strings.Split(s, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
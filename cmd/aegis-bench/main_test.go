@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/aegis-aead/go-libaegis/common"
+)
+
+func TestRunJSON(t *testing.T) {
+	if !common.Available {
+		t.Skip("CGO not available")
+	}
+
+	var out bytes.Buffer
+	args := []string{"-sizes", "0,100", "-algs", "AEGIS-128L,AES-128-GCM", "-time", "1ms", "-format", "json"}
+	if err := run(args, &out); err != nil {
+		t.Fatal(err)
+	}
+	var r Report
+	if err := json.Unmarshal(out.Bytes(), &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.CgoCallNs <= 0 {
+		t.Errorf("cgo call overhead not measured: %v", r.CgoCallNs)
+	}
+
+	// oneshot has two algorithms selected; the other operations only AEGIS-128L.
+	if want := 2 * (2 + len(operations) - 1); len(r.Results) != want {
+		t.Fatalf("got %d results, want %d", len(r.Results), want)
+	}
+	for _, m := range r.Results {
+		if m.Ops == 0 || m.NsPerOp <= 0 {
+			t.Errorf("%s %s %d: empty measurement", m.Operation, m.Algorithm, m.Size)
+		}
+	}
+}
+
+func TestRunTable(t *testing.T) {
+	if !common.Available {
+		t.Skip("CGO not available")
+	}
+
+	var out bytes.Buffer
+	if err := run([]string{"-sizes", "64", "-ops", "mac", "-algs", "AEGIS-256", "-time", "1ms"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "AEGIS-256") || !strings.Contains(out.String(), "cgo call overhead") {
+		t.Fatalf("unexpected table:\n%s", out.String())
+	}
+}
+
+func TestRunRAFParallel(t *testing.T) {
+	if !common.Available {
+		t.Skip("CGO not available")
+	}
+
+	var out bytes.Buffer
+	args := []string{"-sizes", "65536", "-ops", "raf-write,raf-read", "-algs", "AEGIS-128L", "-time", "1ms", "-raf-parallel", "4", "-format", "json"}
+	if err := run(args, &out); err != nil {
+		t.Fatal(err)
+	}
+	var r Report
+	if err := json.Unmarshal(out.Bytes(), &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Parallel != 4 || len(r.Results) != 2 {
+		t.Fatalf("got parallelism %d and %d results", r.Parallel, len(r.Results))
+	}
+}
+
+func TestRunBadFlags(t *testing.T) {
+	for _, args := range [][]string{
+		{"-sizes", "x"},
+		{"-ops", "nope"},
+		{"-format", "xml"},
+	} {
+		if err := run(args, &bytes.Buffer{}); err == nil {
+			t.Errorf("%v: expected an error", args)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+// Command aegis encrypts and decrypts files and pipes with AEGIS.
+//
+// Usage:
+//
+//	aegis keygen  [-alg name] [-o keyfile]
+//	aegis encrypt (-key-file path | -key-env name) [-alg name] [-tag 16|32] [-chunk bytes] [-o output] [input]
+//	aegis decrypt (-key-file path | -key-env name) [-stream] [-o output] [input]
+//
+// Input defaults to stdin and output to stdout; "-" also selects them.
+// Key files hold the key in hex, as written by keygen, or as raw bytes.
+// Environment variables hold the key in hex.
+//
+// Encrypted output starts with a header recording the algorithm, tag length
+// and chunk size, so decrypt needs only the key. Data is processed in
+// independently authenticated chunks.
+//
+// Output is never left partially written when decryption fails: a named
+// output file is written to a temporary file in the same directory and
+// renamed into place on success, and decrypted data for stdout is held in
+// memory until the whole input has been authenticated. Plaintext is never
+// staged on disk anywhere else, so decrypting more than 64 MiB to stdout
+// fails, without output, unless -stream is given. With -stream, each chunk
+// goes to stdout once it has been authenticated, and if a later chunk
+// fails, or the input is truncated, what was written is a prefix of the
+// plaintext that the reader must discard. On any failure aegis exits with
+// status 1; usage errors exit with status 2.
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/aegis-aead/go-libaegis/common"
+	"github.com/aegis-aead/go-libaegis/internal/keyfile"
+)
+
+const usage = `usage:
+  aegis keygen  [-alg name] [-o keyfile]
+  aegis encrypt (-key-file path | -key-env name) [-alg name] [-tag 16|32] [-chunk bytes] [-o output] [input]
+  aegis decrypt (-key-file path | -key-env name) [-stream] [-o output] [input]
+
+algorithms: AEGIS-128L, AEGIS-128X2, AEGIS-128X4, AEGIS-256 (default), AEGIS-256X2, AEGIS-256X4
+`
+
+// errUsage marks errors that should exit with status 2.
+var errUsage = errors.New("usage error")
+
+// stdoutLimit is how much plaintext decrypt holds back for stdout until the
+// input is authenticated.
+var stdoutLimit = 64 << 20
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	err := dispatch(args, stdin, stdout, stderr)
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, errUsage) || errors.Is(err, flag.ErrHelp):
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintf(stderr, "aegis: %v\n", err)
+		}
+		fmt.Fprint(stderr, usage)
+		return 2
+	default:
+		fmt.Fprintf(stderr, "aegis: %v\n", err)
+		return 1
+	}
+}
+
+func dispatch(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%w: missing command", errUsage)
+	}
+	if !common.Available {
+		return errors.New("built without cgo; AEGIS is not available")
+	}
+
+	fs := flag.NewFlagSet("aegis "+args[0], flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	algName := fs.String("alg", "AEGIS-256", "algorithm")
+	output := fs.String("o", "-", "output file")
+	var keyFile, keyEnv *string
+	var tagLen, chunkSize *int
+	stream := new(bool)
+	switch args[0] {
+	case "keygen":
+	case "encrypt":
+		tagLen = fs.Int("tag", 16, "tag length in bytes (16 or 32)")
+		chunkSize = fs.Int("chunk", defaultChunkSize, "plaintext bytes per chunk")
+		fallthrough
+	case "decrypt":
+		keyFile = fs.String("key-file", "", "file holding the key")
+		keyEnv = fs.String("key-env", "", "environment variable holding the key in hex")
+	default:
+		return fmt.Errorf("%w: unknown command %q", errUsage, args[0])
+	}
+	if args[0] == "decrypt" {
+		stream = fs.Bool("stream", false, "write authenticated chunks to stdout as they are decrypted")
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return errUsage
+	}
+	if fs.NArg() > 1 || (args[0] == "keygen" && fs.NArg() > 0) {
+		return fmt.Errorf("%w: too many arguments", errUsage)
+	}
+	alg, err := algorithmByName(*algName)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errUsage, err)
+	}
+
+	if args[0] == "keygen" {
+		key := make([]byte, alg.keySize)
+		if _, err := rand.Read(key); err != nil {
+			return err
+		}
+		return writeOutput(*output, stdout, func(w io.Writer) error {
+			_, err := fmt.Fprintln(w, hex.EncodeToString(key))
+			return err
+		})
+	}
+
+	key, err := loadKey(*keyFile, *keyEnv)
+	if err != nil {
+		return err
+	}
+	in := stdin
+	if name := fs.Arg(0); name != "" && name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	if args[0] == "encrypt" {
+		return writeOutput(*output, stdout, func(w io.Writer) error {
+			return encryptStream(w, in, key, alg, *tagLen, *chunkSize)
+		})
+	}
+	if *output == "-" && !*stream {
+		return stageOutput(stdout, func(w io.Writer) error {
+			return decryptStream(w, in, key)
+		})
+	}
+	return writeOutput(*output, stdout, func(w io.Writer) error {
+		return decryptStream(w, in, key)
+	})
+}
+
+// loadKey reads the key from exactly one of a key file or an environment
+// variable.
+func loadKey(file, env string) ([]byte, error) {
+	switch {
+	case file != "" && env != "":
+		return nil, fmt.Errorf("%w: use only one of -key-file and -key-env", errUsage)
+	case file != "":
+		return keyfile.ReadFile(file)
+	case env != "":
+		value, ok := os.LookupEnv(env)
+		if !ok {
+			return nil, fmt.Errorf("environment variable %s is not set", env)
+		}
+		key, err := hex.DecodeString(string(bytes.TrimSpace([]byte(value))))
+		if err != nil {
+			return nil, fmt.Errorf("environment variable %s does not hold a hex key", env)
+		}
+		return key, nil
+	default:
+		return nil, fmt.Errorf("%w: a key is required (-key-file or -key-env)", errUsage)
+	}
+}
+
+// errStdoutLimit is returned when decrypted data for stdout does not fit in
+// stdoutLimit.
+var errStdoutLimit = errors.New("decrypted data is too large to hold back for stdout; use -o or -stream")
+
+// limitBuffer is a buffer that fails writes past max bytes.
+type limitBuffer struct {
+	bytes.Buffer
+	max int
+}
+
+func (b *limitBuffer) Write(p []byte) (int, error) {
+	if len(p) > b.max-b.Len() {
+		return 0, errStdoutLimit
+	}
+	return b.Buffer.Write(p)
+}
+
+// stageOutput runs fn against a buffer of at most stdoutLimit bytes and
+// copies it to stdout only if fn succeeds, so that nothing is written for
+// input that fails to authenticate.
+func stageOutput(stdout io.Writer, fn func(w io.Writer) error) error {
+	b := &limitBuffer{max: stdoutLimit}
+	if err := fn(b); err != nil {
+		return err
+	}
+	_, err := b.WriteTo(stdout)
+	return err
+}
+
+// writeOutput runs fn against the output. A named file is written through
+// a temporary file in the same directory and renamed into place, so that
+// nothing is left behind if fn fails; stdout is written directly.
+func writeOutput(name string, stdout io.Writer, fn func(w io.Writer) error) error {
+	if name == "-" {
+		return fn(stdout)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}()
+
+	if err := fn(tmp); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
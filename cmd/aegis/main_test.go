@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aegis-aead/go-libaegis/common"
+)
+
+func runCmd(t *testing.T, stdin []byte, args ...string) (int, []byte, string) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	code := run(args, bytes.NewReader(stdin), &stdout, &stderr)
+	return code, stdout.Bytes(), stderr.String()
+}
+
+func writeKey(t *testing.T, dir string, size int) string {
+	t.Helper()
+	key := make([]byte, size)
+	rand.Read(key)
+	path := filepath.Join(dir, "key")
+	if err := os.WriteFile(path, []byte(hex.EncodeToString(key)+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRoundTrip(t *testing.T) {
+	if !common.Available {
+		t.Skip("CGO not available")
+	}
+
+	for _, alg := range algorithms {
+		for _, size := range []int{0, 1, minChunkSize, 3*minChunkSize + 7} {
+			dir := t.TempDir()
+			keyFile := writeKey(t, dir, alg.keySize)
+			msg := make([]byte, size)
+			rand.Read(msg)
+
+			code, ct, stderr := runCmd(t, msg, "encrypt", "-key-file", keyFile, "-alg", alg.name, "-tag", "32", "-chunk", "1024")
+			if code != 0 {
+				t.Fatalf("%s/%d: encrypt: %d %s", alg.name, size, code, stderr)
+			}
+			if hdr := ct[:fixedHeaderSize]; hdr[9] != alg.id || hdr[10] != 32 {
+				t.Fatalf("%s/%d: header does not record the parameters: %x", alg.name, size, hdr)
+			}
+
+			code, pt, stderr := runCmd(t, ct, "decrypt", "-key-file", keyFile)
+			if code != 0 {
+				t.Fatalf("%s/%d: decrypt: %d %s", alg.name, size, code, stderr)
+			}
+			if !bytes.Equal(pt, msg) {
+				t.Fatalf("%s/%d: round trip mismatch", alg.name, size)
+			}
+		}
+	}
+}
+
+func TestFilesAndEnvKey(t *testing.T) {
+	if !common.Available {
+		t.Skip("CGO not available")
+	}
+
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "key")
+	if code, _, stderr := runCmd(t, nil, "keygen", "-alg", "AEGIS-128X2", "-o", keyFile); code != 0 {
+		t.Fatalf("keygen: %s", stderr)
+	}
+	hexKey, _ := os.ReadFile(keyFile)
+	t.Setenv("TEST_AEGIS_KEY", string(hexKey))
+
+	plain := filepath.Join(dir, "plain")
+	enc := filepath.Join(dir, "plain.aegis")
+	out := filepath.Join(dir, "out")
+	msg := bytes.Repeat([]byte("file contents "), 1000)
+	os.WriteFile(plain, msg, 0o600)
+
+	if code, _, stderr := runCmd(t, nil, "encrypt", "-key-file", keyFile, "-alg", "aegis-128x2", "-o", enc, plain); code != 0 {
+		t.Fatalf("encrypt: %s", stderr)
+	}
+	if code, _, stderr := runCmd(t, nil, "decrypt", "-key-env", "TEST_AEGIS_KEY", "-o", out, enc); code != 0 {
+		t.Fatalf("decrypt: %s", stderr)
+	}
+	if got, _ := os.ReadFile(out); !bytes.Equal(got, msg) {
+		t.Fatal("round trip mismatch")
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 4 {
+		t.Fatalf("temporary files left behind: %v", entries)
+	}
+}
+
+func TestAuthFailureLeavesNoOutput(t *testing.T) {
+	if !common.Available {
+		t.Skip("CGO not available")
+	}
+
+	dir := t.TempDir()
+	keyFile := writeKey(t, dir, 32)
+	msg := make([]byte, 5*minChunkSize)
+	rand.Read(msg)
+	_, ct, _ := runCmd(t, msg, "encrypt", "-key-file", keyFile, "-chunk", "1024")
+	chunk := minChunkSize + 16
+	hdrLen := fixedHeaderSize + 32
+
+	tampered := map[string][]byte{
+		"last chunk modified":      append(append([]byte{}, ct[:len(ct)-1]...), ct[len(ct)-1]^1),
+		"truncated at chunk":       ct[:hdrLen+2*chunk],
+		"truncated inside chunk":   ct[:hdrLen+2*chunk+100],
+		"chunks swapped":           append(append(append(append([]byte{}, ct[:hdrLen]...), ct[hdrLen+chunk:hdrLen+2*chunk]...), ct[hdrLen:hdrLen+chunk]...), ct[hdrLen+2*chunk:]...),
+		"tag length changed":       append(append(append([]byte{}, ct[:10]...), 32), ct[11:]...),
+		"trailing data":            append(append([]byte{}, ct...), 0),
+		"header only":              ct[:hdrLen],
+		"not an encrypted stream":  []byte("plain text that is long enough to hold a header"),
+		"chunk size field changed": append(append(append([]byte{}, ct[:14]...), 8), ct[15:]...),
+	}
+	for name, input := range tampered {
+		code, stdout, stderr := runCmd(t, input, "decrypt", "-key-file", keyFile)
+		if code != 1 || len(stdout) != 0 {
+			t.Errorf("%s: exit %d with %d bytes of output (%s)", name, code, len(stdout), stderr)
+		}
+
+		out := filepath.Join(dir, "out")
+		in := filepath.Join(dir, "in")
+		os.WriteFile(in, input, 0o600)
+		if code, _, _ := runCmd(t, nil, "decrypt", "-key-file", keyFile, "-o", out, in); code != 1 {
+			t.Errorf("%s: decrypt to file: exit %d", name, code)
+		}
+		if _, err := os.Stat(out); !os.IsNotExist(err) {
+			t.Errorf("%s: output file was created", name)
+		}
+	}
+
+	otherKey := writeKey(t, t.TempDir(), 32)
+	if code, stdout, stderr := runCmd(t, ct, "decrypt", "-key-file", otherKey); code != 1 || len(stdout) != 0 || !strings.Contains(stderr, "authentication failed") {
+		t.Errorf("wrong key: exit %d, %d bytes of output, %q", code, len(stdout), stderr)
+	}
+}
+
+func TestStdoutLimit(t *testing.T) {
+	if !common.Available {
+		t.Skip("CGO not available")
+	}
+
+	defer func(n int) { stdoutLimit = n }(stdoutLimit)
+	stdoutLimit = 3 * minChunkSize
+	keyFile := writeKey(t, t.TempDir(), 32)
+	msg := make([]byte, 5*minChunkSize)
+	rand.Read(msg)
+	_, ct, _ := runCmd(t, msg, "encrypt", "-key-file", keyFile, "-chunk", "1024")
+
+	// Too much to hold back: nothing is written unless -stream allows it.
+	code, stdout, stderr := runCmd(t, ct, "decrypt", "-key-file", keyFile)
+	if code != 1 || len(stdout) != 0 || !strings.Contains(stderr, "-stream") {
+		t.Fatalf("decrypt past the limit: exit %d with %d bytes of output (%s)", code, len(stdout), stderr)
+	}
+	code, stdout, stderr = runCmd(t, ct, "decrypt", "-stream", "-key-file", keyFile)
+	if code != 0 || !bytes.Equal(stdout, msg) {
+		t.Fatalf("decrypt -stream: exit %d (%s)", code, stderr)
+	}
+
+	// Streaming writes the chunks authenticated before a failure.
+	tampered := append(append([]byte{}, ct[:len(ct)-1]...), ct[len(ct)-1]^1)
+	code, stdout, _ = runCmd(t, tampered, "decrypt", "-stream", "-key-file", keyFile)
+	if code != 1 || !bytes.HasPrefix(msg, stdout) {
+		t.Fatalf("decrypt -stream of a tampered stream: exit %d with %d bytes of output", code, len(stdout))
+	}
+}
+
+func TestUsage(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := writeKey(t, dir, 16)
+	for _, args := range [][]string{
+		{},
+		{"frobnicate"},
+		{"encrypt"},
+		{"encrypt", "-key-file", keyFile, "-key-env", "X"},
+		{"encrypt", "-key-file", keyFile, "-alg", "AES"},
+		{"decrypt", "-key-file", keyFile, "a", "b"},
+	} {
+		if !common.Available && len(args) > 0 {
+			continue
+		}
+		if code, _, _ := runCmd(t, nil, args...); code != 2 {
+			t.Errorf("%v: exit %d, want 2", args, code)
+		}
+	}
+}
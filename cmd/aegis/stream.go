@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bufio"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/aegis-aead/go-libaegis/aegis128l"
+	"github.com/aegis-aead/go-libaegis/aegis128x2"
+	"github.com/aegis-aead/go-libaegis/aegis128x4"
+	"github.com/aegis-aead/go-libaegis/aegis256"
+	"github.com/aegis-aead/go-libaegis/aegis256x2"
+	"github.com/aegis-aead/go-libaegis/aegis256x4"
+)
+
+// Stream format
+//
+// A stream starts with a header:
+//
+//	magic       8 bytes  "AEGISENC"
+//	version     1 byte   1
+//	algorithm   1 byte   algorithm ID, see algorithms
+//	tag length  1 byte   16 or 32
+//	reserved    1 byte   0
+//	chunk size  4 bytes  big-endian plaintext bytes per chunk
+//	nonce       N bytes  random base nonce, N = nonce size of the algorithm
+//
+// followed by one or more chunks, each the AEAD encryption of chunk size
+// plaintext bytes (fewer for the last chunk, possibly zero). Chunk i uses
+// the base nonce with its last 8 bytes XORed with i (big-endian), and the
+// associated data header || i (8 bytes, big-endian) || final (1 byte, 1 for
+// the last chunk). Binding the header detects any change to the recorded
+// parameters; binding the index and final flag detects reordered, dropped
+// and truncated chunks.
+
+const (
+	streamMagic   = "AEGISENC"
+	streamVersion = 1
+
+	fixedHeaderSize = 16
+
+	minChunkSize     = 1024
+	maxChunkSize     = 16 << 20
+	defaultChunkSize = 64 << 10
+)
+
+var (
+	errNotEncrypted = errors.New("input is not an aegis-encrypted stream")
+	errAuth         = errors.New("authentication failed: input is corrupt, truncated or was encrypted with a different key")
+)
+
+// algorithm describes a variant selectable by name.
+type algorithm struct {
+	id      byte
+	name    string
+	keySize int
+	newAEAD func(key []byte, tagLen int) (cipher.AEAD, error)
+}
+
+var algorithms = []algorithm{
+	{1, "AEGIS-128L", aegis128l.KeySize, aegis128l.New},
+	{2, "AEGIS-128X2", aegis128x2.KeySize, aegis128x2.New},
+	{3, "AEGIS-128X4", aegis128x4.KeySize, aegis128x4.New},
+	{4, "AEGIS-256", aegis256.KeySize, aegis256.New},
+	{5, "AEGIS-256X2", aegis256x2.KeySize, aegis256x2.New},
+	{6, "AEGIS-256X4", aegis256x4.KeySize, aegis256x4.New},
+}
+
+// The nonce size equals the key size for every AEGIS variant.
+func (a *algorithm) nonceSize() int {
+	return a.keySize
+}
+
+func algorithmByName(name string) (*algorithm, error) {
+	for i := range algorithms {
+		if strings.EqualFold(algorithms[i].name, name) {
+			return &algorithms[i], nil
+		}
+	}
+	return nil, fmt.Errorf("unknown algorithm %q", name)
+}
+
+func algorithmByID(id byte) (*algorithm, error) {
+	for i := range algorithms {
+		if algorithms[i].id == id {
+			return &algorithms[i], nil
+		}
+	}
+	return nil, fmt.Errorf("unknown algorithm ID %d", id)
+}
+
+// params are the values recorded in the stream header.
+type params struct {
+	alg       *algorithm
+	tagLen    int
+	chunkSize int
+	nonce     []byte
+}
+
+func (p *params) marshal() []byte {
+	hdr := make([]byte, fixedHeaderSize, fixedHeaderSize+len(p.nonce))
+	copy(hdr, streamMagic)
+	hdr[8] = streamVersion
+	hdr[9] = p.alg.id
+	hdr[10] = byte(p.tagLen)
+	binary.BigEndian.PutUint32(hdr[12:16], uint32(p.chunkSize))
+	return append(hdr, p.nonce...)
+}
+
+// readHeader reads and validates a stream header, returning the parameters
+// and the raw header bytes.
+func readHeader(r io.Reader) (*params, []byte, error) {
+	hdr := make([]byte, fixedHeaderSize)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, nil, errNotEncrypted
+		}
+		return nil, nil, err
+	}
+	if string(hdr[:8]) != streamMagic {
+		return nil, nil, errNotEncrypted
+	}
+	if hdr[8] != streamVersion {
+		return nil, nil, fmt.Errorf("unsupported stream version %d", hdr[8])
+	}
+	alg, err := algorithmByID(hdr[9])
+	if err != nil {
+		return nil, nil, err
+	}
+	p := &params{
+		alg:       alg,
+		tagLen:    int(hdr[10]),
+		chunkSize: int(binary.BigEndian.Uint32(hdr[12:16])),
+	}
+	if p.tagLen != 16 && p.tagLen != 32 {
+		return nil, nil, fmt.Errorf("invalid tag length %d in header", p.tagLen)
+	}
+	if hdr[11] != 0 || p.chunkSize < minChunkSize || p.chunkSize > maxChunkSize {
+		return nil, nil, errors.New("invalid stream header")
+	}
+	p.nonce = make([]byte, alg.nonceSize())
+	if _, err := io.ReadFull(r, p.nonce); err != nil {
+		return nil, nil, errNotEncrypted
+	}
+	return p, append(hdr, p.nonce...), nil
+}
+
+// chunkCipher seals and opens the chunks of one stream.
+type chunkCipher struct {
+	aead  cipher.AEAD
+	hdr   []byte
+	base  []byte
+	nonce []byte
+	ad    []byte
+}
+
+func newChunkCipher(p *params, hdr, key []byte) (*chunkCipher, error) {
+	if len(key) != p.alg.keySize {
+		return nil, fmt.Errorf("%s needs a %d-byte key, got %d bytes", p.alg.name, p.alg.keySize, len(key))
+	}
+	aead, err := p.alg.newAEAD(key, p.tagLen)
+	if err != nil {
+		return nil, err
+	}
+	return &chunkCipher{
+		aead:  aead,
+		hdr:   hdr,
+		base:  p.nonce,
+		nonce: make([]byte, len(p.nonce)),
+		ad:    append(append([]byte{}, hdr...), make([]byte, 9)...),
+	}, nil
+}
+
+// prepare sets the nonce and associated data for chunk i.
+func (c *chunkCipher) prepare(i uint64, final bool) {
+	copy(c.nonce, c.base)
+	tail := c.nonce[len(c.nonce)-8:]
+	binary.BigEndian.PutUint64(tail, binary.BigEndian.Uint64(tail)^i)
+	binary.BigEndian.PutUint64(c.ad[len(c.hdr):], i)
+	c.ad[len(c.ad)-1] = 0
+	if final {
+		c.ad[len(c.ad)-1] = 1
+	}
+}
+
+// readChunk fills buf from r and reports whether it was the last chunk,
+// that is, whether r has no data left after it.
+func readChunk(r *bufio.Reader, buf []byte) (int, bool, error) {
+	n, err := io.ReadFull(r, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return n, true, nil
+	}
+	if err != nil {
+		return n, false, err
+	}
+	if _, err := r.Peek(1); err != nil {
+		if err == io.EOF {
+			return n, true, nil
+		}
+		return n, false, err
+	}
+	return n, false, nil
+}
+
+// encryptStream writes the header and the encryption of everything read
+// from r to w.
+func encryptStream(w io.Writer, r io.Reader, key []byte, alg *algorithm, tagLen, chunkSize int) error {
+	p := &params{alg: alg, tagLen: tagLen, chunkSize: chunkSize, nonce: make([]byte, alg.nonceSize())}
+	if tagLen != 16 && tagLen != 32 {
+		return fmt.Errorf("invalid tag length %d (want 16 or 32)", tagLen)
+	}
+	if chunkSize < minChunkSize || chunkSize > maxChunkSize {
+		return fmt.Errorf("chunk size must be between %d and %d", minChunkSize, maxChunkSize)
+	}
+	if _, err := rand.Read(p.nonce); err != nil {
+		return err
+	}
+	hdr := p.marshal()
+	c, err := newChunkCipher(p, hdr, key)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(hdr); err != nil {
+		return err
+	}
+
+	br := bufio.NewReaderSize(r, chunkSize)
+	buf := make([]byte, chunkSize)
+	out := make([]byte, 0, chunkSize+tagLen)
+	for i := uint64(0); ; i++ {
+		n, final, err := readChunk(br, buf)
+		if err != nil {
+			return err
+		}
+		c.prepare(i, final)
+		if _, err := w.Write(c.aead.Seal(out[:0], c.nonce, buf[:n], c.ad)); err != nil {
+			return err
+		}
+		if final {
+			return nil
+		}
+	}
+}
+
+// decryptStream authenticates and decrypts a stream from r, writing the
+// plaintext of each chunk to w as soon as that chunk has been verified.
+// Callers that must not expose partial output on failure have to buffer w.
+func decryptStream(w io.Writer, r io.Reader, key []byte) error {
+	p, hdr, err := readHeader(r)
+	if err != nil {
+		return err
+	}
+	c, err := newChunkCipher(p, hdr, key)
+	if err != nil {
+		return err
+	}
+
+	br := bufio.NewReaderSize(r, p.chunkSize+p.tagLen)
+	buf := make([]byte, p.chunkSize+p.tagLen)
+	for i := uint64(0); ; i++ {
+		n, final, err := readChunk(br, buf)
+		if err != nil {
+			return err
+		}
+		if n < p.tagLen {
+			return errAuth
+		}
+		c.prepare(i, final)
+		pt, err := c.aead.Open(buf[:0], c.nonce, buf[:n], c.ad)
+		if err != nil {
+			return errAuth
+		}
+		if _, err := w.Write(pt); err != nil {
+			return err
+		}
+		if final {
+			return nil
+		}
+	}
+}
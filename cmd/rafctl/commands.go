@@ -0,0 +1,209 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/aegis-aead/go-libaegis/raf"
+)
+
+// probeFile opens name read-only and returns its header information and
+// physical size.
+func probeFile(name string) (*raf.FileInfo, int64, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+	store := raf.NewFileStore(f)
+	info, err := raf.Probe(store)
+	if err != nil {
+		return nil, 0, err
+	}
+	physical, err := store.GetSize()
+	if err != nil {
+		return nil, 0, err
+	}
+	return info, physical, nil
+}
+
+func probe(w io.Writer, name string) error {
+	info, physical, err := probeFile(name)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "algorithm:     %v\n", info.Algorithm)
+	fmt.Fprintf(w, "chunk size:    %d\n", info.ChunkSize)
+	fmt.Fprintf(w, "logical size:  %d\n", info.Size)
+	fmt.Fprintf(w, "physical size: %d\n", physical)
+	return nil
+}
+
+func stat(w io.Writer, name string) error {
+	info, physical, err := probeFile(name)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "algorithm:     %v\n", info.Algorithm)
+	fmt.Fprintf(w, "chunk size:    %d\n", info.ChunkSize)
+	fmt.Fprintf(w, "record size:   %d\n", info.RecordSize())
+	if info.ExtensionSize > 0 {
+		fmt.Fprintf(w, "extension:     %d bytes\n", info.ExtensionSize)
+	}
+	fmt.Fprintf(w, "chunks:        %d\n", info.NumChunks())
+	fmt.Fprintf(w, "logical size:  %d\n", info.Size)
+	fmt.Fprintf(w, "physical size: %d\n", physical)
+	fmt.Fprintf(w, "overhead:      %d bytes\n", physical-info.Size)
+	if info.Size > 0 {
+		fmt.Fprintf(w, "overhead ratio: %.4f\n", float64(physical)/float64(info.Size))
+	} else {
+		fmt.Fprintf(w, "overhead ratio: n/a\n")
+	}
+	if expected := info.StoreSize(); physical != expected {
+		fmt.Fprintf(w, "warning: physical size differs from the %d bytes expected from the header\n", expected)
+	}
+	return nil
+}
+
+// openFile opens an existing RAF file, authenticating its header.
+func openFile(name string, key []byte, writable bool) (*raf.File, *os.File, error) {
+	flag := os.O_RDONLY
+	if writable {
+		flag = os.O_RDWR
+	}
+	osf, err := os.OpenFile(name, flag, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	f, err := raf.Open(raf.NewFileStore(osf), key, nil)
+	if err != nil {
+		osf.Close()
+		if errors.Is(err, raf.ErrAuth) {
+			return nil, nil, fmt.Errorf("%s: header authentication failed (wrong key or corrupted header)", name)
+		}
+		return nil, nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return f, osf, nil
+}
+
+func closeFile(f *raf.File, osf *os.File) error {
+	err := f.Close()
+	if cerr := osf.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// cat decrypts name to w, one chunk at a time.
+func cat(w io.Writer, name string, key []byte) error {
+	f, osf, err := openFile(name, key, false)
+	if err != nil {
+		return err
+	}
+	defer closeFile(f, osf)
+
+	buf := make([]byte, f.Info().ChunkSize)
+	for off := int64(0); ; off += int64(len(buf)) {
+		n, err := f.ReadAt(buf, off)
+		if _, werr := w.Write(buf[:n]); werr != nil {
+			return werr
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: chunk %d: %w", name, off/int64(len(buf)), err)
+		}
+	}
+}
+
+// verify decrypts every chunk and reports those that fail authentication.
+func verify(w io.Writer, name string, key []byte) error {
+	f, osf, err := openFile(name, key, false)
+	if err != nil {
+		return err
+	}
+	defer closeFile(f, osf)
+
+	info := f.Info()
+	buf := make([]byte, info.ChunkSize)
+	var corrupted []int64
+	for idx := int64(0); idx < info.NumChunks(); idx++ {
+		off := idx * int64(info.ChunkSize)
+		n := int64(len(buf))
+		if rest := info.Size - off; rest < n {
+			n = rest
+		}
+		if _, err := f.ReadAt(buf[:n], off); err != nil {
+			if !errors.Is(err, raf.ErrAuth) {
+				return fmt.Errorf("%s: chunk %d: %w", name, idx, err)
+			}
+			corrupted = append(corrupted, idx)
+			fmt.Fprintf(w, "chunk %d: authentication failed\n", idx)
+		}
+	}
+
+	fmt.Fprintf(w, "%d chunks, %d corrupted\n", info.NumChunks(), len(corrupted))
+	if len(corrupted) > 0 {
+		return errCorrupted
+	}
+	return nil
+}
+
+// importFile encrypts input ("-" for stdin) into a new RAF file.
+func importFile(stdin io.Reader, input, name string, key []byte, opts *raf.Options, force bool) error {
+	if !force {
+		if _, err := os.Stat(name); err == nil {
+			return fmt.Errorf("%s already exists (use -force to overwrite)", name)
+		}
+	}
+	in := stdin
+	if input != "-" {
+		f, err := os.Open(input)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	return writeFileAtomic(name, func(osf *os.File) error {
+		f, err := raf.Create(raf.NewFileStore(osf), key, opts)
+		if err != nil {
+			return err
+		}
+		buf := make([]byte, f.Info().ChunkSize)
+		var off int64
+		for {
+			n, rerr := io.ReadFull(in, buf)
+			if n > 0 {
+				if _, err := f.WriteAt(buf[:n], off); err != nil {
+					f.Close()
+					return err
+				}
+				off += int64(n)
+			}
+			if rerr == io.EOF || rerr == io.ErrUnexpectedEOF {
+				return f.Close()
+			}
+			if rerr != nil {
+				f.Close()
+				return rerr
+			}
+		}
+	})
+}
+
+func truncate(name string, key []byte, size int64) error {
+	f, osf, err := openFile(name, key, true)
+	if err != nil {
+		return err
+	}
+	if err := f.Truncate(size); err != nil {
+		closeFile(f, osf)
+		return err
+	}
+	return closeFile(f, osf)
+}
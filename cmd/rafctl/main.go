@@ -0,0 +1,245 @@
+// Command rafctl inspects and maintains RAF (random-access encrypted) files.
+//
+// Usage:
+//
+//	rafctl probe    FILE
+//	rafctl stat     FILE
+//	rafctl cat      KEY FILE
+//	rafctl export   KEY [-o output] FILE
+//	rafctl import   KEY [-alg name] [-chunk bytes] [-force] INPUT FILE
+//	rafctl verify   KEY FILE
+//	rafctl truncate KEY -size bytes FILE
+//
+// KEY is either -key-file path or -key-fd n. The key is read as hex, or as
+// raw bytes if it is not valid hex. Reading it from a file descriptor keeps
+// it out of the file system and the process arguments, for example
+// "rafctl cat -key-fd 3 data.raf 3< <(vault read ...)".
+//
+// probe and stat only read the header and need no key. verify decrypts every
+// chunk and lists the indices of those that fail authentication. export and
+// import write through a temporary file that is renamed into place, so a
+// failure never leaves a partial output file.
+//
+// rafctl exits with status 1 on failure, including when verify finds
+// corrupted chunks, and with status 2 on usage errors.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aegis-aead/go-libaegis/common"
+	"github.com/aegis-aead/go-libaegis/internal/keyfile"
+	"github.com/aegis-aead/go-libaegis/raf"
+)
+
+const usage = `usage:
+  rafctl probe    FILE
+  rafctl stat     FILE
+  rafctl cat      KEY FILE
+  rafctl export   KEY [-o output] FILE
+  rafctl import   KEY [-alg name] [-chunk bytes] [-force] INPUT FILE
+  rafctl verify   KEY FILE
+  rafctl truncate KEY -size bytes FILE
+
+KEY is -key-file path or -key-fd n.
+`
+
+// errUsage marks errors that should exit with status 2.
+var errUsage = errors.New("usage error")
+
+// errCorrupted is returned by verify when any chunk fails authentication.
+var errCorrupted = errors.New("file is corrupted")
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	err := dispatch(args, stdin, stdout, stderr)
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, flag.ErrHelp):
+		fmt.Fprint(stderr, usage)
+		return 2
+	case errors.Is(err, errUsage):
+		fmt.Fprintf(stderr, "rafctl: %v\n", err)
+		fmt.Fprint(stderr, usage)
+		return 2
+	default:
+		fmt.Fprintf(stderr, "rafctl: %v\n", err)
+		return 1
+	}
+}
+
+// command holds the flags shared by the subcommands.
+type command struct {
+	fs      *flag.FlagSet
+	keyFile string
+	keyFD   int
+}
+
+func newCommand(name string, withKey bool, stderr io.Writer) *command {
+	c := &command{fs: flag.NewFlagSet("rafctl "+name, flag.ContinueOnError), keyFD: -1}
+	c.fs.SetOutput(stderr)
+	if withKey {
+		c.fs.StringVar(&c.keyFile, "key-file", "", "file holding the key")
+		c.fs.IntVar(&c.keyFD, "key-fd", -1, "file descriptor to read the key from")
+	}
+	return c
+}
+
+// parse parses args and checks the number of positional arguments.
+func (c *command) parse(args []string, nargs int) error {
+	if err := c.fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return errUsage
+	}
+	if c.fs.NArg() != nargs {
+		return fmt.Errorf("%w: %s takes %d file argument(s)", errUsage, c.fs.Name(), nargs)
+	}
+	return nil
+}
+
+func (c *command) key() ([]byte, error) {
+	switch {
+	case c.keyFile != "" && c.keyFD >= 0:
+		return nil, fmt.Errorf("%w: use only one of -key-file and -key-fd", errUsage)
+	case c.keyFile != "":
+		return keyfile.ReadFile(c.keyFile)
+	case c.keyFD >= 0:
+		return keyfile.ReadFD(c.keyFD)
+	default:
+		return nil, fmt.Errorf("%w: a key is required (-key-file or -key-fd)", errUsage)
+	}
+}
+
+func dispatch(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%w: missing command", errUsage)
+	}
+	if !common.Available {
+		return errors.New("built without cgo; RAF is not available")
+	}
+	name, args := args[0], args[1:]
+
+	switch name {
+	case "probe", "stat":
+		c := newCommand(name, false, stderr)
+		if err := c.parse(args, 1); err != nil {
+			return err
+		}
+		if name == "probe" {
+			return probe(stdout, c.fs.Arg(0))
+		}
+		return stat(stdout, c.fs.Arg(0))
+
+	case "cat", "verify":
+		c := newCommand(name, true, stderr)
+		if err := c.parse(args, 1); err != nil {
+			return err
+		}
+		key, err := c.key()
+		if err != nil {
+			return err
+		}
+		if name == "cat" {
+			return cat(stdout, c.fs.Arg(0), key)
+		}
+		return verify(stdout, c.fs.Arg(0), key)
+
+	case "export":
+		c := newCommand(name, true, stderr)
+		output := c.fs.String("o", "-", "output file")
+		if err := c.parse(args, 1); err != nil {
+			return err
+		}
+		key, err := c.key()
+		if err != nil {
+			return err
+		}
+		if *output == "-" {
+			return cat(stdout, c.fs.Arg(0), key)
+		}
+		return writeFileAtomic(*output, func(f *os.File) error {
+			return cat(f, c.fs.Arg(0), key)
+		})
+
+	case "import":
+		c := newCommand(name, true, stderr)
+		algName := c.fs.String("alg", "AEGIS-256", "algorithm")
+		chunkSize := c.fs.Int("chunk", raf.DefaultChunk, "plaintext bytes per chunk")
+		force := c.fs.Bool("force", false, "overwrite an existing output file")
+		if err := c.parse(args, 2); err != nil {
+			return err
+		}
+		alg, err := parseAlgorithm(*algName)
+		if err != nil {
+			return fmt.Errorf("%w: %v", errUsage, err)
+		}
+		key, err := c.key()
+		if err != nil {
+			return err
+		}
+		return importFile(stdin, c.fs.Arg(0), c.fs.Arg(1), key, &raf.Options{Algorithm: alg, ChunkSize: *chunkSize}, *force)
+
+	case "truncate":
+		c := newCommand(name, true, stderr)
+		size := c.fs.Int64("size", -1, "new logical size in bytes")
+		if err := c.parse(args, 1); err != nil {
+			return err
+		}
+		if *size < 0 {
+			return fmt.Errorf("%w: -size is required", errUsage)
+		}
+		key, err := c.key()
+		if err != nil {
+			return err
+		}
+		return truncate(c.fs.Arg(0), key, *size)
+
+	default:
+		return fmt.Errorf("%w: unknown command %q", errUsage, name)
+	}
+}
+
+func parseAlgorithm(name string) (raf.Algorithm, error) {
+	for alg := raf.AEGIS128L; alg <= raf.AEGIS256X4; alg++ {
+		if strings.EqualFold(alg.String(), name) {
+			return alg, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown algorithm %q", name)
+}
+
+// writeFileAtomic writes name through a temporary file in the same
+// directory, renaming it into place only if fn succeeds.
+func writeFileAtomic(name string, fn func(f *os.File) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}()
+
+	if err := fn(tmp); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
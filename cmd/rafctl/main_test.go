@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aegis-aead/go-libaegis/common"
+	"github.com/aegis-aead/go-libaegis/raf"
+)
+
+func runCmd(t *testing.T, stdin []byte, args ...string) (int, string, string) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	code := run(args, bytes.NewReader(stdin), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+// setup imports a random plaintext of the given size into dir/data.raf
+// using AEGIS-128L with the minimum chunk size.
+func setup(t *testing.T, size int) (dir, keyFile, file string, msg []byte) {
+	t.Helper()
+	dir = t.TempDir()
+	key := make([]byte, 16)
+	rand.Read(key)
+	keyFile = filepath.Join(dir, "key")
+	os.WriteFile(keyFile, []byte(hex.EncodeToString(key)), 0o600)
+
+	msg = make([]byte, size)
+	rand.Read(msg)
+	file = filepath.Join(dir, "data.raf")
+	code, _, stderr := runCmd(t, msg, "import", "-key-file", keyFile, "-alg", "AEGIS-128L",
+		"-chunk", fmt.Sprint(raf.MinChunkSize), "-", file)
+	if code != 0 {
+		t.Fatalf("import: %s", stderr)
+	}
+	return dir, keyFile, file, msg
+}
+
+func TestImportCatExport(t *testing.T) {
+	if !common.Available {
+		t.Skip("CGO not available")
+	}
+
+	dir, keyFile, file, msg := setup(t, 5*raf.MinChunkSize+100)
+
+	code, out, stderr := runCmd(t, nil, "cat", "-key-file", keyFile, file)
+	if code != 0 || out != string(msg) {
+		t.Fatalf("cat: exit %d, %d bytes (%s)", code, len(out), stderr)
+	}
+
+	exported := filepath.Join(dir, "plain")
+	if code, _, stderr := runCmd(t, nil, "export", "-key-file", keyFile, "-o", exported, file); code != 0 {
+		t.Fatalf("export: %s", stderr)
+	}
+	if got, _ := os.ReadFile(exported); !bytes.Equal(got, msg) {
+		t.Fatal("export mismatch")
+	}
+
+	// import refuses to overwrite without -force.
+	if code, _, _ := runCmd(t, nil, "import", "-key-file", keyFile, exported, file); code != 1 {
+		t.Fatalf("import over an existing file: exit %d", code)
+	}
+	if code, _, stderr := runCmd(t, nil, "import", "-key-file", keyFile, "-alg", "AEGIS-128L", "-force", exported, file); code != 0 {
+		t.Fatalf("import -force: %s", stderr)
+	}
+}
+
+func TestKeyFD(t *testing.T) {
+	if !common.Available {
+		t.Skip("CGO not available")
+	}
+
+	_, keyFile, file, msg := setup(t, 100)
+	key, _ := os.ReadFile(keyFile)
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(key)
+	w.Close()
+
+	code, out, stderr := runCmd(t, nil, "cat", "-key-fd", fmt.Sprint(r.Fd()), file)
+	if code != 0 || out != string(msg) {
+		t.Fatalf("cat with -key-fd: exit %d (%s)", code, stderr)
+	}
+}
+
+func TestProbeStat(t *testing.T) {
+	if !common.Available {
+		t.Skip("CGO not available")
+	}
+
+	_, _, file, _ := setup(t, 3*raf.MinChunkSize)
+	code, out, stderr := runCmd(t, nil, "probe", file)
+	if code != 0 || !strings.Contains(out, "AEGIS-128L") || !strings.Contains(out, "logical size:  3072") {
+		t.Fatalf("probe: exit %d\n%s%s", code, out, stderr)
+	}
+
+	code, out, stderr = runCmd(t, nil, "stat", file)
+	if code != 0 || !strings.Contains(out, "chunks:        3") || strings.Contains(out, "warning") {
+		t.Fatalf("stat: exit %d\n%s%s", code, out, stderr)
+	}
+	physical := raf.HeaderSize + 3*(16+raf.MinChunkSize+raf.TagSize)
+	if !strings.Contains(out, fmt.Sprintf("physical size: %d", physical)) {
+		t.Fatalf("stat: physical size, want %d\n%s", physical, out)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	if !common.Available {
+		t.Skip("CGO not available")
+	}
+
+	_, keyFile, file, _ := setup(t, 4*raf.MinChunkSize+1)
+	if code, out, stderr := runCmd(t, nil, "verify", "-key-file", keyFile, file); code != 0 || !strings.Contains(out, "5 chunks, 0 corrupted") {
+		t.Fatalf("verify: exit %d\n%s%s", code, out, stderr)
+	}
+
+	data, _ := os.ReadFile(file)
+	record := 16 + raf.MinChunkSize + raf.TagSize
+	for _, idx := range []int{1, 4} {
+		data[raf.HeaderSize+idx*record+100] ^= 1
+	}
+	os.WriteFile(file, data, 0o600)
+
+	code, out, _ := runCmd(t, nil, "verify", "-key-file", keyFile, file)
+	if code != 1 || !strings.Contains(out, "chunk 1:") || !strings.Contains(out, "chunk 4:") || !strings.Contains(out, "2 corrupted") {
+		t.Fatalf("verify corrupted: exit %d\n%s", code, out)
+	}
+
+	wrongKey := filepath.Join(t.TempDir(), "key")
+	os.WriteFile(wrongKey, []byte(strings.Repeat("00", 16)), 0o600)
+	if code, _, stderr := runCmd(t, nil, "verify", "-key-file", wrongKey, file); code != 1 || !strings.Contains(stderr, "header authentication failed") {
+		t.Fatalf("verify with wrong key: exit %d, %s", code, stderr)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	if !common.Available {
+		t.Skip("CGO not available")
+	}
+
+	_, keyFile, file, msg := setup(t, 3000)
+	if code, _, stderr := runCmd(t, nil, "truncate", "-key-file", keyFile, "-size", "1500", file); code != 0 {
+		t.Fatalf("truncate: %s", stderr)
+	}
+	code, out, stderr := runCmd(t, nil, "cat", "-key-file", keyFile, file)
+	if code != 0 || out != string(msg[:1500]) {
+		t.Fatalf("cat after truncate: exit %d, %d bytes (%s)", code, len(out), stderr)
+	}
+}
+
+func TestUsage(t *testing.T) {
+	for _, args := range [][]string{
+		{},
+		{"frobnicate", "x"},
+		{"probe"},
+		{"cat", "file"},
+		{"cat", "-key-file", "k", "-key-fd", "3", "file"},
+		{"truncate", "-key-file", "k", "file"},
+		{"import", "-key-file", "k", "-alg", "AES", "in", "out"},
+	} {
+		if !common.Available && len(args) > 0 {
+			continue
+		}
+		if code, _, _ := runCmd(t, nil, args...); code != 2 {
+			t.Errorf("%v: exit %d, want 2", args, code)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+/*
+ * The raf package's own operations on libaegis RAF contexts; see
+ * raf_binding.h. They are kept here rather than in the vendored sources
+ * under libaegis/, which stay as upstream ships them. They build on the
+ * context layout of raf/raf_internal.h and the key derivation and header
+ * format of raf/raf_variant.h, so they must be checked against both
+ * whenever libaegis is updated.
+ */
+
+#include <errno.h>
+#include <stddef.h>
+#include <stdint.h>
+#include <string.h>
+
+#include "libaegis/src/common/keccak.h"
+#include "libaegis/src/raf/raf_internal.h"
+
+#include <aegis128l.h>
+#include <aegis128x2.h>
+#include <aegis128x4.h>
+#include <aegis256.h>
+#include <aegis256x2.h>
+#include <aegis256x4.h>
+
+#include "raf_binding.h"
+
+#define CONCAT_(a, b)     a##b
+#define CONCAT(a, b)      CONCAT_(a, b)
+#define CONCAT3_(a, b, c) a##b##c
+#define CONCAT3(a, b, c)  CONCAT3_(a, b, c)
+
+/* As KDF_CONST in raf/raf_variant.h. */
+#define RAF_KDF_CONST     "aegis-raf-kdf-v1"
+#define RAF_KDF_CONST_LEN 16
+
+#define VARIANT   aegis128l
+#define KEYBYTES  aegis128l_KEYBYTES
+#define NPUBBYTES aegis128l_NPUBBYTES
+#include "raf_binding_variant.h"
+#undef VARIANT
+#undef KEYBYTES
+#undef NPUBBYTES
+
+#define VARIANT   aegis128x2
+#define KEYBYTES  aegis128x2_KEYBYTES
+#define NPUBBYTES aegis128x2_NPUBBYTES
+#include "raf_binding_variant.h"
+#undef VARIANT
+#undef KEYBYTES
+#undef NPUBBYTES
+
+#define VARIANT   aegis128x4
+#define KEYBYTES  aegis128x4_KEYBYTES
+#define NPUBBYTES aegis128x4_NPUBBYTES
+#include "raf_binding_variant.h"
+#undef VARIANT
+#undef KEYBYTES
+#undef NPUBBYTES
+
+#define VARIANT   aegis256
+#define KEYBYTES  aegis256_KEYBYTES
+#define NPUBBYTES aegis256_NPUBBYTES
+#include "raf_binding_variant.h"
+#undef VARIANT
+#undef KEYBYTES
+#undef NPUBBYTES
+
+#define VARIANT   aegis256x2
+#define KEYBYTES  aegis256x2_KEYBYTES
+#define NPUBBYTES aegis256x2_NPUBBYTES
+#include "raf_binding_variant.h"
+#undef VARIANT
+#undef KEYBYTES
+#undef NPUBBYTES
+
+#define VARIANT   aegis256x4
+#define KEYBYTES  aegis256x4_KEYBYTES
+#define NPUBBYTES aegis256x4_NPUBBYTES
+#include "raf_binding_variant.h"
+#undef VARIANT
+#undef KEYBYTES
+#undef NPUBBYTES
+
+#define DISPATCH(alg, name, ...)                                    \
+    switch (alg) {                                                  \
+    case AEGIS_RAF_ALG_128L:                                        \
+        return aegis128l_binding_##name(__VA_ARGS__);               \
+    case AEGIS_RAF_ALG_128X2:                                       \
+        return aegis128x2_binding_##name(__VA_ARGS__);              \
+    case AEGIS_RAF_ALG_128X4:                                       \
+        return aegis128x4_binding_##name(__VA_ARGS__);              \
+    case AEGIS_RAF_ALG_256:                                         \
+        return aegis256_binding_##name(__VA_ARGS__);                \
+    case AEGIS_RAF_ALG_256X2:                                       \
+        return aegis256x2_binding_##name(__VA_ARGS__);              \
+    case AEGIS_RAF_ALG_256X4:                                       \
+        return aegis256x4_binding_##name(__VA_ARGS__);              \
+    default:                                                        \
+        errno = EINVAL;                                             \
+        return -1;                                                  \
+    }
+
+static int
+valid_alg(int alg)
+{
+    return alg >= AEGIS_RAF_ALG_128L && alg <= AEGIS_RAF_ALG_256X4;
+}
+
+int
+raf_binding_file_id(int alg, const void *ctx, uint8_t out[AEGIS_RAF_FILE_ID_BYTES])
+{
+    const aegis_raf_ctx_internal *internal = (const aegis_raf_ctx_internal *) ctx;
+
+    if (!valid_alg(alg) || ctx == NULL || out == NULL) {
+        errno = EINVAL;
+        return -1;
+    }
+    memcpy(out, internal->file_id, AEGIS_RAF_FILE_ID_BYTES);
+    return 0;
+}
+
+int
+raf_binding_worker_init(int alg, void *worker, const void *ctx, const aegis_raf_io *io,
+                        const aegis_raf_scratch *scratch, uint64_t file_size)
+{
+    if (worker == NULL || ctx == NULL || io == NULL || scratch == NULL) {
+        errno = EINVAL;
+        return -1;
+    }
+    if (io->read_at == NULL || io->write_at == NULL || io->get_size == NULL ||
+        io->set_size == NULL) {
+        errno = EINVAL;
+        return -1;
+    }
+    DISPATCH(alg, worker_init, worker, ctx, io, scratch, file_size)
+}
+
+int
+raf_binding_commit_chunks(int alg, void *ctx, const uint8_t *data, uint64_t first_chunk,
+                          uint64_t count)
+{
+    if (ctx == NULL || (count > 0 && data == NULL)) {
+        errno = EINVAL;
+        return -1;
+    }
+    DISPATCH(alg, commit_chunks, ctx, data, first_chunk, count)
+}
+
+int
+raf_binding_rekey_chunk(int alg, void *ctx, const uint8_t *new_master_key, uint64_t chunk_idx)
+{
+    if (ctx == NULL || new_master_key == NULL) {
+        errno = EINVAL;
+        return -1;
+    }
+    DISPATCH(alg, rekey_chunk, ctx, new_master_key, chunk_idx)
+}
+
+int
+raf_binding_rekey_header(int alg, void *ctx, const uint8_t *new_master_key)
+{
+    if (ctx == NULL || new_master_key == NULL) {
+        errno = EINVAL;
+        return -1;
+    }
+    DISPATCH(alg, rekey_header, ctx, new_master_key)
+}
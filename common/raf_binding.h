@@ -0,0 +1,51 @@
+#ifndef raf_binding_H
+#define raf_binding_H
+
+#include <stdint.h>
+
+#include <aegis_raf.h>
+
+/*
+ * Operations the raf package needs on an open libaegis RAF context that the
+ * libaegis API does not offer. They are the binding's own, in
+ * raf_binding.c, not part of libaegis. alg is one of AEGIS_RAF_ALG_*, and
+ * ctx a context of that variant. They return -1 and set errno on failure.
+ */
+
+/* Copy the random file identifier chosen at create time to out. */
+int raf_binding_file_id(int alg, const void *ctx, uint8_t out[AEGIS_RAF_FILE_ID_BYTES]);
+
+/*
+ * Initialize worker as a copy of ctx that reads and writes the file's
+ * chunks through its own io and scratch buffer, so that several workers can
+ * run at once on chunks no other context is writing. It never updates the
+ * Merkle tree and never syncs. Its file size is file_size, which must cover
+ * every byte it is asked to read or write. Use raf_binding_commit_chunks on
+ * ctx to record the chunks workers wrote, and *_raf_close on the worker.
+ */
+int raf_binding_worker_init(int alg, void *worker, const void *ctx, const aegis_raf_io *io,
+                            const aegis_raf_scratch *scratch, uint64_t file_size);
+
+/*
+ * Record count full chunks, starting at chunk first_chunk, that workers
+ * wrote with the plaintext in data: update their Merkle leaves and parents,
+ * and extend the file to the end of the last one if it ends before.
+ */
+int raf_binding_commit_chunks(int alg, void *ctx, const uint8_t *data, uint64_t first_chunk,
+                              uint64_t count);
+
+/*
+ * Re-encrypt a chunk under the keys derived from new_master_key, with a
+ * fresh nonce. A chunk already under the new keys is left as it is, and one
+ * under neither fails with EBADMSG.
+ */
+int raf_binding_rekey_chunk(int alg, void *ctx, const uint8_t *new_master_key,
+                            uint64_t chunk_idx);
+
+/*
+ * Switch ctx to the keys derived from new_master_key and rewrite the header
+ * under them, completing a key rotation.
+ */
+int raf_binding_rekey_header(int alg, void *ctx, const uint8_t *new_master_key);
+
+#endif
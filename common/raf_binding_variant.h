@@ -0,0 +1,208 @@
+/*
+ * Per-variant half of raf_binding.c, included once for each variant with
+ * VARIANT, KEYBYTES and NPUBBYTES defined, in the way libaegis builds
+ * raf/raf_variant.h.
+ */
+
+#define BFN(name) CONCAT3(VARIANT, _binding_, name)
+#define CTX_TYPE  CONCAT3(VARIANT, _raf_, ctx)
+#define MAC_STATE CONCAT(VARIANT, _mac_state)
+
+static void
+BFN(derive_keys)(uint8_t *enc_key, uint8_t *hdr_key, const uint8_t *master_key,
+                 const uint8_t file_id[AEGIS_RAF_FILE_ID_BYTES])
+{
+    uint8_t key_material[KEYBYTES * 2];
+
+#if KEYBYTES == 16
+    aegis_kdf_128(key_material, sizeof key_material, (const uint8_t *) RAF_KDF_CONST,
+                  RAF_KDF_CONST_LEN, master_key, KEYBYTES, file_id, AEGIS_RAF_FILE_ID_BYTES);
+#else
+    aegis_kdf_256(key_material, sizeof key_material, (const uint8_t *) RAF_KDF_CONST,
+                  RAF_KDF_CONST_LEN, master_key, KEYBYTES, file_id, AEGIS_RAF_FILE_ID_BYTES);
+#endif
+
+    memcpy(enc_key, key_material, KEYBYTES);
+    if (hdr_key != NULL) {
+        memcpy(hdr_key, key_material + KEYBYTES, KEYBYTES);
+    }
+    memset(key_material, 0, sizeof key_material);
+}
+
+static uint64_t
+BFN(record_size)(uint32_t chunk_size)
+{
+    return (uint64_t) NPUBBYTES + chunk_size + AEGIS_RAF_TAG_BYTES;
+}
+
+/* Mirrors write_header in raf/raf_variant.h; the layout is the file format. */
+static int
+BFN(write_header)(aegis_raf_ctx_internal *ctx)
+{
+    uint8_t   hdr[AEGIS_RAF_HEADER_SIZE];
+    MAC_STATE st;
+
+    memcpy(hdr, AEGIS_RAF_MAGIC, 8);
+    STORE16_LE(hdr + 8, AEGIS_RAF_HEADER_SIZE);
+    hdr[10] = AEGIS_RAF_VERSION;
+    hdr[11] = (uint8_t) ctx->alg_id;
+    STORE32_LE(hdr + 12, ctx->chunk_size);
+    STORE64_LE(hdr + 16, ctx->file_size);
+    memcpy(hdr + 24, ctx->file_id, AEGIS_RAF_FILE_ID_BYTES);
+
+    CONCAT(VARIANT, _mac_init)(&st, ctx->hdr_key, NULL);
+    if (CONCAT(VARIANT, _mac_update)(&st, hdr, AEGIS_RAF_HEADER_SIZE - AEGIS_RAF_TAG_BYTES) != 0 ||
+        CONCAT(VARIANT, _mac_final)(&st, hdr + AEGIS_RAF_HEADER_SIZE - AEGIS_RAF_TAG_BYTES,
+                                    AEGIS_RAF_TAG_BYTES) != 0) {
+        return -1;
+    }
+
+    return ctx->io.write_at(ctx->io.user, hdr, AEGIS_RAF_HEADER_SIZE, 0);
+}
+
+static int
+BFN(worker_init)(void *worker, const void *ctx, const aegis_raf_io *io,
+                 const aegis_raf_scratch *scratch, uint64_t file_size)
+{
+    aegis_raf_ctx_internal       *internal = (aegis_raf_ctx_internal *) worker;
+    const aegis_raf_ctx_internal *parent   = (const aegis_raf_ctx_internal *) ctx;
+    size_t                        rec_size;
+
+    COMPILER_ASSERT(sizeof(CTX_TYPE) >= sizeof(aegis_raf_ctx_internal));
+
+    if (CONCAT(VARIANT, _raf_scratch_validate)(scratch, parent->chunk_size) != 0) {
+        return -1;
+    }
+    rec_size = (size_t) BFN(record_size)(parent->chunk_size);
+
+    memcpy(internal, parent, sizeof(aegis_raf_ctx_internal));
+
+    internal->io             = *io;
+    internal->io.sync        = NULL;
+    internal->file_size      = file_size;
+    internal->merkle_enabled = 0;
+    memset(&internal->merkle_cfg, 0, sizeof(internal->merkle_cfg));
+
+    internal->scratch_buf     = scratch->buf;
+    internal->scratch_len     = scratch->len;
+    internal->record_buf      = scratch->buf;
+    internal->record_buf_size = rec_size;
+    internal->chunk_buf       = scratch->buf + AEGIS_RAF_ALIGN_UP(rec_size, AEGIS_RAF_SCRATCH_ALIGN);
+    internal->chunk_buf_size  = internal->chunk_size;
+
+    return 0;
+}
+
+static int
+BFN(commit_chunks)(void *ctx, const uint8_t *data, uint64_t first_chunk, uint64_t count)
+{
+    aegis_raf_ctx_internal *internal = (aegis_raf_ctx_internal *) ctx;
+    uint64_t                end_chunk;
+    uint64_t                ci;
+
+    if (count == 0) {
+        return 0;
+    }
+    if (first_chunk > UINT64_MAX - count) {
+        errno = EOVERFLOW;
+        return -1;
+    }
+    end_chunk = first_chunk + count;
+    if (end_chunk > UINT64_MAX / internal->chunk_size) {
+        errno = EOVERFLOW;
+        return -1;
+    }
+
+    if (internal->merkle_enabled) {
+        if (end_chunk > internal->merkle_cfg.max_chunks) {
+            errno = EOVERFLOW;
+            return -1;
+        }
+        for (ci = 0; ci < count; ci++) {
+            if (raf_merkle_update_leaf(&internal->merkle_cfg,
+                                       data + (size_t) ci * internal->chunk_size,
+                                       internal->chunk_size, first_chunk + ci) != 0) {
+                return -1;
+            }
+        }
+        if (raf_merkle_update_parents(&internal->merkle_cfg, first_chunk, end_chunk - 1) != 0) {
+            return -1;
+        }
+    }
+
+    if (end_chunk * internal->chunk_size > internal->file_size) {
+        internal->file_size = end_chunk * internal->chunk_size;
+        return BFN(write_header)(internal);
+    }
+    return 0;
+}
+
+static int
+BFN(rekey_chunk)(void *ctx, const uint8_t *new_master_key, uint64_t chunk_idx)
+{
+    aegis_raf_ctx_internal *internal = (aegis_raf_ctx_internal *) ctx;
+    uint8_t                 enc_key[KEYBYTES];
+    uint8_t                 aad[AAD_BYTES];
+    uint64_t                rec_size = BFN(record_size)(internal->chunk_size);
+    uint64_t                off;
+    uint8_t                *record     = internal->record_buf;
+    uint8_t                *nonce      = record;
+    uint8_t                *ciphertext = record + NPUBBYTES;
+    uint8_t                *tag        = record + NPUBBYTES + internal->chunk_size;
+    int                     ret        = -1;
+
+    if (internal->file_size == 0 || chunk_idx > (internal->file_size - 1) / internal->chunk_size) {
+        errno = EINVAL;
+        return -1;
+    }
+    off = AEGIS_RAF_HEADER_SIZE + chunk_idx * rec_size;
+
+    BFN(derive_keys)(enc_key, NULL, new_master_key, internal->file_id);
+
+    if (internal->io.read_at(internal->io.user, record, rec_size, off) != 0) {
+        goto cleanup;
+    }
+
+    build_aad(aad, internal->file_id, chunk_idx, internal->chunk_size);
+
+    if (CONCAT(VARIANT, _decrypt_detached)(internal->chunk_buf, ciphertext, internal->chunk_size,
+                                           tag, AEGIS_RAF_TAG_BYTES, aad, AAD_BYTES, nonce,
+                                           enc_key) == 0) {
+        ret = 0;
+        goto cleanup;
+    }
+    if (CONCAT(VARIANT, _decrypt_detached)(internal->chunk_buf, ciphertext, internal->chunk_size,
+                                           tag, AEGIS_RAF_TAG_BYTES, aad, AAD_BYTES, nonce,
+                                           internal->enc_key) != 0) {
+        errno = EBADMSG;
+        goto cleanup;
+    }
+    if (internal->rng.random(internal->rng.user, nonce, NPUBBYTES) != 0) {
+        goto cleanup;
+    }
+    if (CONCAT(VARIANT, _encrypt_detached)(ciphertext, tag, AEGIS_RAF_TAG_BYTES,
+                                           internal->chunk_buf, internal->chunk_size, aad,
+                                           AAD_BYTES, nonce, enc_key) != 0) {
+        goto cleanup;
+    }
+    ret = internal->io.write_at(internal->io.user, record, rec_size, off);
+
+cleanup:
+    memset(record, 0, rec_size);
+    memset(internal->chunk_buf, 0, internal->chunk_size);
+    memset(enc_key, 0, sizeof enc_key);
+    return ret;
+}
+
+static int
+BFN(rekey_header)(void *ctx, const uint8_t *new_master_key)
+{
+    aegis_raf_ctx_internal *internal = (aegis_raf_ctx_internal *) ctx;
+
+    BFN(derive_keys)(internal->enc_key, internal->hdr_key, new_master_key, internal->file_id);
+    return BFN(write_header)(internal);
+}
+
+#undef BFN
+#undef CTX_TYPE
+#undef MAC_STATE
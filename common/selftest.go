@@ -0,0 +1,202 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// SelfTestFailureMode selects what happens process-wide once any
+// power-on self-test has failed.
+type SelfTestFailureMode int32
+
+const (
+	// SelfTestPanic makes every constructor panic after a self-test failure.
+	// This is the default.
+	SelfTestPanic SelfTestFailureMode = iota
+
+	// SelfTestDisable makes every constructor return ErrSelfTestFailed after
+	// a self-test failure, for all algorithms.
+	SelfTestDisable
+)
+
+// SelfTestState is the state of a single registered self-test.
+type SelfTestState int
+
+const (
+	SelfTestPending SelfTestState = iota // not run yet
+	SelfTestPassed                       // known answers matched
+	SelfTestFailed                       // known answers did not match
+)
+
+func (s SelfTestState) String() string {
+	switch s {
+	case SelfTestPending:
+		return "pending"
+	case SelfTestPassed:
+		return "passed"
+	case SelfTestFailed:
+		return "failed"
+	default:
+		return "unknown"
+	}
+}
+
+// SelfTestResult reports the outcome of a registered self-test.
+type SelfTestResult struct {
+	Name  string
+	State SelfTestState
+	Err   error // set when State is SelfTestFailed
+}
+
+// ErrSelfTestFailed is returned by constructors once a self-test has failed
+// and the failure mode is SelfTestDisable.
+var ErrSelfTestFailed = errors.New("aegis: power-on self-test failed")
+
+// SelfTestEnv is the environment variable read at startup to configure
+// self-tests. It holds a comma-separated list of:
+//
+//	init     run every self-test at package initialization
+//	lazy     run each self-test on first use of its constructors (default)
+//	panic    panic after a failure (default)
+//	disable  make all constructors return ErrSelfTestFailed after a failure
+const SelfTestEnv = "GOLIBAEGIS_SELFTEST"
+
+// SelfTest is a registered known-answer test. Packages register one at
+// initialization and call Check from their constructors.
+type SelfTest struct {
+	name  string
+	fn    func() error
+	once  sync.Once
+	state atomic.Int32
+	err   error
+}
+
+var (
+	selfTestMu      sync.Mutex
+	selfTestList    []*SelfTest
+	selfTestFailure error // first failure observed, guarded by selfTestMu
+	selfTestFailed  atomic.Bool
+	selfTestMode    atomic.Int32
+	selfTestEager   bool
+)
+
+func init() {
+	for _, opt := range strings.Split(os.Getenv(SelfTestEnv), ",") {
+		switch strings.TrimSpace(opt) {
+		case "init":
+			selfTestEager = true
+		case "lazy":
+			selfTestEager = false
+		case "panic":
+			selfTestMode.Store(int32(SelfTestPanic))
+		case "disable":
+			selfTestMode.Store(int32(SelfTestDisable))
+		}
+	}
+}
+
+// SetSelfTestFailureMode sets the process-wide behavior after a self-test
+// failure. It applies to all subsequent constructor calls.
+func SetSelfTestFailureMode(mode SelfTestFailureMode) {
+	selfTestMode.Store(int32(mode))
+}
+
+// RegisterSelfTest registers a known-answer test under the given name.
+// If SelfTestEnv requests it, the test runs immediately; otherwise it runs
+// on the first call to Check.
+func RegisterSelfTest(name string, fn func() error) *SelfTest {
+	t := &SelfTest{name: name, fn: fn}
+	selfTestMu.Lock()
+	selfTestList = append(selfTestList, t)
+	selfTestMu.Unlock()
+	if selfTestEager {
+		// In SelfTestPanic mode a failure aborts initialization here;
+		// otherwise constructors report it later.
+		_ = t.Check()
+	}
+	return t
+}
+
+// Check runs the self-test if it hasn't run yet, then reports whether
+// constructors may proceed. After any registered self-test has failed,
+// Check panics or returns ErrSelfTestFailed depending on the failure mode.
+func (t *SelfTest) Check() error {
+	t.run()
+	if !selfTestFailed.Load() {
+		return nil
+	}
+	failure := firstSelfTestFailure()
+	if SelfTestFailureMode(selfTestMode.Load()) == SelfTestPanic {
+		panic(failure.Error())
+	}
+	return failure
+}
+
+func (t *SelfTest) run() {
+	t.once.Do(func() {
+		err := runGuarded(t.fn)
+		if err == nil {
+			t.state.Store(int32(SelfTestPassed))
+			return
+		}
+		t.err = err
+		t.state.Store(int32(SelfTestFailed))
+		selfTestMu.Lock()
+		if selfTestFailure == nil {
+			selfTestFailure = fmt.Errorf("%w: %s: %v", ErrSelfTestFailed, t.name, err)
+		}
+		selfTestMu.Unlock()
+		selfTestFailed.Store(true)
+	})
+}
+
+func firstSelfTestFailure() error {
+	selfTestMu.Lock()
+	defer selfTestMu.Unlock()
+	return selfTestFailure
+}
+
+// runGuarded turns a panic inside a self-test into a failure.
+func runGuarded(fn func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return fn()
+}
+
+// SelfTestStatus returns the state of every registered self-test,
+// in registration order.
+func SelfTestStatus() []SelfTestResult {
+	selfTestMu.Lock()
+	list := append([]*SelfTest(nil), selfTestList...)
+	selfTestMu.Unlock()
+
+	results := make([]SelfTestResult, len(list))
+	for i, t := range list {
+		results[i] = SelfTestResult{Name: t.name, State: SelfTestState(t.state.Load())}
+		if results[i].State == SelfTestFailed {
+			results[i].Err = t.err
+		}
+	}
+	return results
+}
+
+// RunSelfTests runs every registered self-test that hasn't run yet and
+// returns the first failure, if any. It never panics, regardless of the
+// failure mode.
+func RunSelfTests() error {
+	selfTestMu.Lock()
+	list := append([]*SelfTest(nil), selfTestList...)
+	selfTestMu.Unlock()
+
+	for _, t := range list {
+		t.run()
+	}
+	return firstSelfTestFailure()
+}
@@ -0,0 +1,110 @@
+package common
+
+import (
+	"errors"
+	"testing"
+)
+
+// resetSelfTests drops the given registrations and clears any recorded
+// failure so that other tests in this package are unaffected.
+func resetSelfTests(t *testing.T, tests ...*SelfTest) {
+	t.Helper()
+	selfTestMu.Lock()
+	kept := selfTestList[:0]
+	for _, st := range selfTestList {
+		drop := false
+		for _, d := range tests {
+			if st == d {
+				drop = true
+			}
+		}
+		if !drop {
+			kept = append(kept, st)
+		}
+	}
+	selfTestList = kept
+	selfTestFailure = nil
+	selfTestMu.Unlock()
+	selfTestFailed.Store(false)
+	SetSelfTestFailureMode(SelfTestPanic)
+}
+
+func findSelfTest(name string) (SelfTestResult, bool) {
+	for _, r := range SelfTestStatus() {
+		if r.Name == name {
+			return r, true
+		}
+	}
+	return SelfTestResult{}, false
+}
+
+func TestSelfTestLazy(t *testing.T) {
+	runs := 0
+	st := RegisterSelfTest("lazy-pass", func() error {
+		runs++
+		return nil
+	})
+	defer resetSelfTests(t, st)
+
+	if r, ok := findSelfTest("lazy-pass"); !ok || r.State != SelfTestPending {
+		t.Fatalf("before Check: got %+v, want pending", r)
+	}
+	for i := 0; i < 3; i++ {
+		if err := st.Check(); err != nil {
+			t.Fatalf("Check: %v", err)
+		}
+	}
+	if runs != 1 {
+		t.Fatalf("self-test ran %d times, want 1", runs)
+	}
+	if r, _ := findSelfTest("lazy-pass"); r.State != SelfTestPassed {
+		t.Fatalf("after Check: got %v, want passed", r.State)
+	}
+}
+
+func TestSelfTestDisable(t *testing.T) {
+	SetSelfTestFailureMode(SelfTestDisable)
+	good := RegisterSelfTest("disable-good", func() error { return nil })
+	bad := RegisterSelfTest("disable-bad", func() error { return errors.New("mismatch") })
+	defer resetSelfTests(t, good, bad)
+
+	if err := good.Check(); err != nil {
+		t.Fatalf("Check before failure: %v", err)
+	}
+	if err := bad.Check(); !errors.Is(err, ErrSelfTestFailed) {
+		t.Fatalf("failing Check: got %v, want ErrSelfTestFailed", err)
+	}
+	// A failure disables every constructor, not just the failing algorithm.
+	if err := good.Check(); !errors.Is(err, ErrSelfTestFailed) {
+		t.Fatalf("Check after failure: got %v, want ErrSelfTestFailed", err)
+	}
+	r, _ := findSelfTest("disable-bad")
+	if r.State != SelfTestFailed || r.Err == nil {
+		t.Fatalf("status: got %+v, want failed with error", r)
+	}
+}
+
+func TestSelfTestPanic(t *testing.T) {
+	st := RegisterSelfTest("panic-bad", func() error { panic("boom") })
+	defer resetSelfTests(t, st)
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Check should panic in SelfTestPanic mode")
+		}
+		if r, _ := findSelfTest("panic-bad"); r.State != SelfTestFailed {
+			t.Fatalf("status: got %v, want failed", r.State)
+		}
+	}()
+	st.Check()
+}
+
+func TestRunSelfTests(t *testing.T) {
+	st := RegisterSelfTest("run-bad", func() error { return errors.New("mismatch") })
+	defer resetSelfTests(t, st)
+
+	// RunSelfTests reports failures without panicking.
+	if err := RunSelfTests(); !errors.Is(err, ErrSelfTestFailed) {
+		t.Fatalf("RunSelfTests: got %v, want ErrSelfTestFailed", err)
+	}
+}
@@ -0,0 +1,63 @@
+// Package keyfile reads key material for the command-line tools.
+package keyfile
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// maxKeyFileSize bounds how much is read, so pointing a key option at a
+// large file or an endless stream fails instead of consuming memory.
+const maxKeyFileSize = 4096
+
+// Decode returns the key held in data: hex, optionally surrounded by
+// whitespace, or otherwise the raw bytes.
+func Decode(data []byte) []byte {
+	if key, err := hex.DecodeString(string(bytes.TrimSpace(data))); err == nil && len(key) > 0 {
+		return key
+	}
+	return data
+}
+
+// ReadFile reads a key from the named file.
+func ReadFile(name string) ([]byte, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return read(f)
+}
+
+// ReadFD reads a key from an open file descriptor, such as one inherited
+// from a parent process or set up by a shell redirection like 3<keyfile.
+// The descriptor is closed afterwards.
+func ReadFD(fd int) ([]byte, error) {
+	if fd < 0 {
+		return nil, fmt.Errorf("invalid key file descriptor %d", fd)
+	}
+	f := os.NewFile(uintptr(fd), fmt.Sprintf("fd %d", fd))
+	if f == nil {
+		return nil, fmt.Errorf("invalid key file descriptor %d", fd)
+	}
+	defer f.Close()
+	return read(f)
+}
+
+func read(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxKeyFileSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxKeyFileSize {
+		return nil, errors.New("key file is too large")
+	}
+	if len(data) == 0 {
+		return nil, errors.New("key file is empty")
+	}
+	return Decode(data), nil
+}
@@ -0,0 +1,314 @@
+package raf
+
+import (
+	"container/list"
+	"io"
+	"sort"
+)
+
+// Chunk cache
+//
+// A File opened with Options.CacheSize keeps recently used chunks in
+// memory as plaintext. Reads are served from it, and writes modify the
+// cached chunks and mark them dirty instead of going to the store, so that
+// small writes to the same chunk cost one encryption when it is written
+// back. Dirty chunks are written back when they are evicted, and all of
+// them on Sync, Close, Truncate and the Merkle operations, with adjacent
+// chunks coalesced into a single write. Plaintext leaving the cache is
+// wiped.
+
+// CacheStats reports the activity of a File's chunk cache.
+type CacheStats struct {
+	Hits       uint64 // chunks served from the cache
+	Misses     uint64 // chunks read from the store into the cache
+	Evictions  uint64 // chunks dropped to stay within the budget
+	WriteBacks uint64 // dirty chunks written to the store
+	Cached     int    // chunks currently held
+	Dirty      int    // cached chunks not yet written back
+}
+
+// chunkBackend is how the cache reads and writes the file underneath it.
+type chunkBackend interface {
+	// readChunks and writeChunks transfer plaintext at a chunk boundary.
+	readChunks(p []byte, off int64) (int, error)
+	writeChunks(p []byte, off int64) (int, error)
+
+	// storedSize is the plaintext size the store currently holds.
+	storedSize() (int64, error)
+}
+
+type cachedChunk struct {
+	index uint64
+	data  []byte // a whole chunk; zero past the end of the file
+	dirty bool
+	elem  *list.Element
+}
+
+// chunkCache is an LRU set of decrypted chunks and the logical size of the
+// file, which runs ahead of the stored size while dirty chunks extend it.
+type chunkCache struct {
+	b         chunkBackend
+	chunkSize int
+	max       int
+	chunks    map[uint64]*cachedChunk
+	lru       list.List // most recently used first
+	size      int64
+	stats     CacheStats
+}
+
+func newChunkCache(b chunkBackend, chunkSize, budget int, size int64) *chunkCache {
+	max := budget / chunkSize
+	if max < 1 {
+		max = 1
+	}
+	return &chunkCache{
+		b:         b,
+		chunkSize: chunkSize,
+		max:       max,
+		chunks:    map[uint64]*cachedChunk{},
+		size:      size,
+	}
+}
+
+// used returns how many bytes of chunk index lie within the file.
+func (c *chunkCache) used(index uint64) int {
+	n := c.size - int64(index)*int64(c.chunkSize)
+	if n > int64(c.chunkSize) {
+		return c.chunkSize
+	}
+	return int(n)
+}
+
+// lookup returns chunk index if it is cached, marking it most recent.
+func (c *chunkCache) lookup(index uint64) *cachedChunk {
+	ch := c.chunks[index]
+	if ch != nil {
+		c.lru.MoveToFront(ch.elem)
+	}
+	return ch
+}
+
+// insert adds a chunk holding data, evicting the least recently used
+// chunks beyond the budget.
+func (c *chunkCache) insert(index uint64, data []byte) (*cachedChunk, error) {
+	for len(c.chunks) >= c.max {
+		if err := c.evict(); err != nil {
+			return nil, err
+		}
+	}
+	ch := &cachedChunk{index: index, data: data}
+	ch.elem = c.lru.PushFront(ch)
+	c.chunks[index] = ch
+	return ch, nil
+}
+
+// evict drops the least recently used chunk, writing it back first if it
+// is dirty. The chunk stays cached if that fails.
+func (c *chunkCache) evict() error {
+	ch := c.lru.Back().Value.(*cachedChunk)
+	if ch.dirty {
+		if err := c.writeBack([]*cachedChunk{ch}); err != nil {
+			return err
+		}
+	}
+	c.drop(ch)
+	c.stats.Evictions++
+	return nil
+}
+
+func (c *chunkCache) drop(ch *cachedChunk) {
+	wipe(ch.data)
+	c.lru.Remove(ch.elem)
+	delete(c.chunks, ch.index)
+}
+
+// load reads count chunks from first on into the cache. Chunks past the
+// stored size are zero and are not read.
+func (c *chunkCache) load(first, count uint64) error {
+	stored, err := c.b.storedSize()
+	if err != nil {
+		return err
+	}
+	cs := int64(c.chunkSize)
+	buf := make([]byte, int(count)*c.chunkSize)
+	defer wipe(buf)
+	if n := stored - int64(first)*cs; n > 0 {
+		if n > int64(len(buf)) {
+			n = int64(len(buf))
+		}
+		if got, err := c.b.readChunks(buf[:n], int64(first)*cs); int64(got) < n {
+			if err == nil || err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+	}
+	for i := uint64(0); i < count; i++ {
+		data := make([]byte, c.chunkSize)
+		copy(data, buf[int(i)*c.chunkSize:])
+		if _, err := c.insert(first+i, data); err != nil {
+			wipe(data)
+			return err
+		}
+		c.stats.Misses++
+	}
+	return nil
+}
+
+// read is ReadAt served from the cache. Runs of chunks that are not cached
+// are loaded with one read each.
+func (c *chunkCache) read(p []byte, off int64) (int, error) {
+	if off >= c.size {
+		return 0, io.EOF
+	}
+	want := len(p)
+	if int64(want) > c.size-off {
+		p = p[:c.size-off]
+	}
+
+	cs := uint64(c.chunkSize)
+	first, last := uint64(off)/cs, (uint64(off)+uint64(len(p))-1)/cs
+	n := 0
+	var loaded uint64 // chunks below this were loaded by this call
+	for i := first; i <= last; i++ {
+		ch := c.lookup(i)
+		if ch == nil {
+			end := i + 1
+			for end <= last && c.chunks[end] == nil && end-i < uint64(c.max) {
+				end++
+			}
+			if err := c.load(i, end-i); err != nil {
+				return n, err
+			}
+			ch, loaded = c.chunks[i], end
+		} else if i >= loaded {
+			c.stats.Hits++
+		}
+		start := 0
+		if i == first {
+			start = int(uint64(off) % cs)
+		}
+		n += copy(p[n:], ch.data[start:])
+	}
+	if n < want {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// write is WriteAt into the cache. Chunks it covers entirely are not read
+// first.
+func (c *chunkCache) write(p []byte, off int64) (int, error) {
+	cs := uint64(c.chunkSize)
+	first, last := uint64(off)/cs, (uint64(off)+uint64(len(p))-1)/cs
+	n := 0
+	for i := first; i <= last; i++ {
+		start := 0
+		if i == first {
+			start = int(uint64(off) % cs)
+		}
+		whole := start == 0 && len(p)-n >= c.chunkSize
+		ch := c.lookup(i)
+		switch {
+		case ch != nil:
+			c.stats.Hits++
+		case whole:
+			var err error
+			if ch, err = c.insert(i, make([]byte, c.chunkSize)); err != nil {
+				return n, err
+			}
+		default:
+			if err := c.load(i, 1); err != nil {
+				return n, err
+			}
+			ch = c.chunks[i]
+		}
+		n += copy(ch.data[start:], p[n:])
+		ch.dirty = true
+		if end := off + int64(n); end > c.size {
+			c.size = end
+		}
+	}
+	return n, nil
+}
+
+// flush writes back every dirty chunk, in order.
+func (c *chunkCache) flush() error {
+	var dirty []*cachedChunk
+	for _, ch := range c.chunks {
+		if ch.dirty {
+			dirty = append(dirty, ch)
+		}
+	}
+	sort.Slice(dirty, func(i, j int) bool { return dirty[i].index < dirty[j].index })
+	for len(dirty) > 0 {
+		run := 1
+		for run < len(dirty) && dirty[run].index == dirty[0].index+uint64(run) {
+			run++
+		}
+		if err := c.writeBack(dirty[:run]); err != nil {
+			return err
+		}
+		dirty = dirty[run:]
+	}
+	return nil
+}
+
+// writeBack writes a run of consecutive dirty chunks with a single write.
+func (c *chunkCache) writeBack(run []*cachedChunk) error {
+	first := run[0].index
+	last := run[len(run)-1].index
+	p := run[0].data[:c.used(first)]
+	if len(run) > 1 {
+		p = make([]byte, (len(run)-1)*c.chunkSize+c.used(last))
+		defer wipe(p)
+		for i, ch := range run {
+			copy(p[i*c.chunkSize:], ch.data)
+		}
+	}
+	if _, err := c.b.writeChunks(p, int64(first)*int64(c.chunkSize)); err != nil {
+		return err
+	}
+	for _, ch := range run {
+		ch.dirty = false
+	}
+	c.stats.WriteBacks += uint64(len(run))
+	return nil
+}
+
+// truncate follows a Truncate of the file to size, which must come after
+// a flush. Chunks from the one holding the new end on are dropped.
+func (c *chunkCache) truncate(size int64) {
+	from := uint64(size) / uint64(c.chunkSize)
+	for index, ch := range c.chunks {
+		if index >= from {
+			c.drop(ch)
+		}
+	}
+	c.size = size
+}
+
+// clear drops every chunk, wiping its plaintext.
+func (c *chunkCache) clear() {
+	for _, ch := range c.chunks {
+		c.drop(ch)
+	}
+}
+
+func (c *chunkCache) statistics() CacheStats {
+	s := c.stats
+	s.Cached = len(c.chunks)
+	for _, ch := range c.chunks {
+		if ch.dirty {
+			s.Dirty++
+		}
+	}
+	return s
+}
+
+// wipe zeroes b.
+func wipe(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
@@ -0,0 +1,307 @@
+package raf
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	mrand "math/rand"
+	"testing"
+
+	"github.com/aegis-aead/go-libaegis/common"
+)
+
+// countingStore counts writes to a MemStore and fails them once fail is
+// set.
+type countingStore struct {
+	MemStore
+	writes int
+	fail   bool
+}
+
+var errInjected = errors.New("injected write failure")
+
+func (s *countingStore) WriteAt(p []byte, off int64) (int, error) {
+	if s.fail {
+		return 0, errInjected
+	}
+	s.writes++
+	return s.MemStore.WriteAt(p, off)
+}
+
+func TestCacheModel(t *testing.T) {
+	if !common.Available {
+		t.Skip("CGO not available")
+	}
+
+	for _, budget := range []int{1, 4 * MinChunkSize, 64 * MinChunkSize} {
+		for _, merkle := range []bool{false, true} {
+			for _, parallel := range []int{1, 4} {
+				name := fmt.Sprintf("budget=%d/merkle=%v/p=%d", budget, merkle, parallel)
+				t.Run(name, func(t *testing.T) {
+					testCacheModel(t, budget, merkle, parallel)
+				})
+			}
+		}
+	}
+}
+
+func testCacheModel(t *testing.T, budget int, merkle bool, parallel int) {
+	key := make([]byte, 16)
+	rand.Read(key)
+	opts := &Options{Algorithm: AEGIS128L, ChunkSize: MinChunkSize, CacheSize: budget, Parallelism: parallel}
+	if merkle {
+		opts.Merkle = suiteOptions(MerkleSHA256, 64)
+	}
+	store := NewMemStore()
+	f, err := Create(store, key, opts)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	rng := mrand.New(mrand.NewSource(int64(budget)))
+	var model []byte
+	for i := 0; i < 300; i++ {
+		switch op := rng.Intn(10); {
+		case op < 5:
+			off := rng.Intn(len(model) + MinChunkSize)
+			if off > 40*MinChunkSize {
+				off = rng.Intn(20 * MinChunkSize)
+			}
+			p := make([]byte, rng.Intn(3*MinChunkSize)+1)
+			rand.Read(p)
+			if n, err := f.WriteAt(p, int64(off)); n != len(p) || err != nil {
+				t.Fatalf("WriteAt(%d bytes at %d) = %d, %v", len(p), off, n, err)
+			}
+			if end := off + len(p); end > len(model) {
+				model = append(model, make([]byte, end-len(model))...)
+			}
+			copy(model[off:], p)
+		case op < 8:
+			if len(model) == 0 {
+				continue
+			}
+			off := rng.Intn(len(model))
+			buf := make([]byte, rng.Intn(3*MinChunkSize)+1)
+			n, err := f.ReadAt(buf, int64(off))
+			want := model[off:]
+			if len(want) > len(buf) {
+				want = want[:len(buf)]
+			}
+			if n != len(want) || !bytes.Equal(buf[:n], want) || (n < len(buf)) != (err != nil) {
+				t.Fatalf("ReadAt(%d bytes at %d) = %d, %v", len(buf), off, n, err)
+			}
+		case op < 9:
+			size := rng.Intn(len(model) + 2*MinChunkSize)
+			if err := f.Truncate(int64(size)); err != nil {
+				t.Fatalf("Truncate(%d): %v", size, err)
+			}
+			if size > len(model) {
+				model = append(model, make([]byte, size-len(model))...)
+			}
+			model = model[:size]
+		default:
+			if err := f.Sync(); err != nil {
+				t.Fatalf("Sync: %v", err)
+			}
+		}
+		if size, _ := f.Size(); size != int64(len(model)) {
+			t.Fatalf("Size = %d, want %d", size, len(model))
+		}
+	}
+
+	var commitment []byte
+	if merkle {
+		if idx, err := f.MerkleVerify(); err != nil {
+			t.Fatalf("MerkleVerify: chunk %d, %v", idx, err)
+		}
+		commitment, _ = f.MerkleCommitment()
+	}
+	if err := f.Sync(); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if stats := f.CacheStats(); stats.Cached > opts.CacheSize/MinChunkSize+1 || stats.Dirty != 0 {
+		t.Fatalf("CacheStats: %+v", stats)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	f, err = Open(store, key, &Options{Merkle: opts.Merkle})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer f.Close()
+	got := make([]byte, len(model))
+	if n, err := f.ReadAt(got, 0); n != len(model) || !bytes.Equal(got, model) {
+		t.Fatalf("contents after reopening differ: %d, %v", n, err)
+	}
+	if merkle {
+		f.MerkleRebuild()
+		if rebuilt, _ := f.MerkleCommitment(); !bytes.Equal(rebuilt, commitment) {
+			t.Fatal("commitment of the cached file differs from the rebuilt one")
+		}
+	}
+}
+
+func TestCacheCoalescing(t *testing.T) {
+	if !common.Available {
+		t.Skip("CGO not available")
+	}
+
+	key := make([]byte, 16)
+	rand.Read(key)
+	store := &countingStore{}
+	f, err := Create(store, key, &Options{Algorithm: AEGIS128L, ChunkSize: MinChunkSize, CacheSize: 16 * MinChunkSize})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	created := store.writes
+
+	// Byte-sized writes across four chunks reach the store only on Sync,
+	// as one write.
+	for i := 0; i < 4*MinChunkSize; i += 7 {
+		if _, err := f.WriteAt([]byte{byte(i)}, int64(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if store.writes != created {
+		t.Fatalf("%d store writes before Sync", store.writes-created)
+	}
+	if stats := f.CacheStats(); stats.Dirty != 4 || stats.Misses != 4 {
+		t.Fatalf("CacheStats before Sync: %+v", stats)
+	}
+	if err := f.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	stats := f.CacheStats()
+	if stats.Dirty != 0 || stats.WriteBacks != 4 || stats.Cached != 4 {
+		t.Fatalf("CacheStats after Sync: %+v", stats)
+	}
+
+	// Reads of cached chunks are hits and do not touch the store.
+	buf := make([]byte, 4*MinChunkSize)
+	if _, err := f.ReadAt(buf, 0); err != nil {
+		t.Fatal(err)
+	}
+	if after := f.CacheStats(); after.Hits != stats.Hits+4 || after.Misses != stats.Misses {
+		t.Fatalf("CacheStats after a cached read: %+v", after)
+	}
+	for i := 0; i < len(buf); i += 7 {
+		if buf[i] != byte(i) {
+			t.Fatalf("byte %d = %d", i, buf[i])
+		}
+	}
+}
+
+func TestCacheEviction(t *testing.T) {
+	if !common.Available {
+		t.Skip("CGO not available")
+	}
+
+	key := make([]byte, 16)
+	rand.Read(key)
+	f, err := Create(NewMemStore(), key, &Options{Algorithm: AEGIS128L, ChunkSize: MinChunkSize, CacheSize: 2 * MinChunkSize})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	msg := make([]byte, 8*MinChunkSize)
+	rand.Read(msg)
+	if _, err := f.WriteAt(msg, 0); err != nil {
+		t.Fatal(err)
+	}
+	stats := f.CacheStats()
+	if stats.Cached != 2 || stats.Evictions != 6 || stats.WriteBacks != 6 {
+		t.Fatalf("CacheStats: %+v", stats)
+	}
+
+	got := make([]byte, len(msg))
+	if _, err := f.ReadAt(got, 0); err != nil || !bytes.Equal(got, msg) {
+		t.Fatalf("ReadAt: %v", err)
+	}
+}
+
+// plainBackend is a chunkBackend over a byte slice, so the cache can be
+// tested on its own.
+type plainBackend struct{ data []byte }
+
+func (b *plainBackend) readChunks(p []byte, off int64) (int, error) {
+	return copy(p, b.data[off:]), nil
+}
+
+func (b *plainBackend) writeChunks(p []byte, off int64) (int, error) {
+	if end := int(off) + len(p); end > len(b.data) {
+		b.data = append(b.data, make([]byte, end-len(b.data))...)
+	}
+	return copy(b.data[off:], p), nil
+}
+
+func (b *plainBackend) storedSize() (int64, error) { return int64(len(b.data)), nil }
+
+func TestCacheWipe(t *testing.T) {
+	b := &plainBackend{data: bytes.Repeat([]byte{0xaa}, 64)}
+	c := newChunkCache(b, 16, 32, 64)
+	buf := make([]byte, 16)
+	c.read(buf, 0)
+	first := c.chunks[0].data
+	c.read(buf, 16)
+	c.write([]byte{1, 2, 3}, 32)
+	if !bytes.Equal(first, make([]byte, 16)) {
+		t.Fatal("evicted chunk was not wiped")
+	}
+	if b.data[32] != 0xaa {
+		t.Fatal("write reached the backend before a flush")
+	}
+
+	held := [][]byte{c.chunks[1].data, c.chunks[2].data}
+	if err := c.flush(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b.data[32:36], []byte{1, 2, 3, 0xaa}) {
+		t.Fatalf("flushed data: %x", b.data[32:36])
+	}
+	c.clear()
+	for _, data := range held {
+		if !bytes.Equal(data, make([]byte, 16)) {
+			t.Fatal("cached chunk was not wiped on clear")
+		}
+	}
+	if stats := c.statistics(); stats.Misses != 3 || stats.Evictions != 1 || stats.WriteBacks != 1 || stats.Cached != 0 {
+		t.Fatalf("statistics: %+v", stats)
+	}
+}
+
+func TestCacheWriteBackError(t *testing.T) {
+	if !common.Available {
+		t.Skip("CGO not available")
+	}
+
+	key := make([]byte, 16)
+	rand.Read(key)
+	store := &countingStore{}
+	f, err := Create(store, key, &Options{Algorithm: AEGIS128L, ChunkSize: MinChunkSize, CacheSize: MinChunkSize})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.fail = true
+	if _, err := f.WriteAt([]byte("cached"), 0); err != nil {
+		t.Fatalf("WriteAt to the cache: %v", err)
+	}
+
+	// Evicting the dirty chunk fails, and it stays cached.
+	if _, err := f.WriteAt([]byte("next"), MinChunkSize); !errors.Is(err, errInjected) {
+		t.Fatalf("WriteAt evicting a dirty chunk: got %v", err)
+	}
+	if stats := f.CacheStats(); stats.Dirty != 1 {
+		t.Fatalf("CacheStats: %+v", stats)
+	}
+	if err := f.Sync(); !errors.Is(err, errInjected) {
+		t.Fatalf("Sync: got %v", err)
+	}
+	if err := f.Close(); !errors.Is(err, errInjected) {
+		t.Fatalf("Close: got %v", err)
+	}
+}
@@ -21,6 +21,7 @@ type callbackState struct {
 	store        Store
 	lastErr      error
 	syncDisabled bool // set before raf_close to prevent double-sync
+	merkle       MerkleHasher
 }
 
 //export goRAFReadAt
@@ -93,3 +94,48 @@ func goRAFRandom(out *C.uint8_t, length C.size_t) C.int {
 	}
 	return 0
 }
+
+// cBytes views a C buffer as a Go slice. C may pass NULL for empty inputs.
+func cBytes(p *C.uint8_t, length C.size_t) []byte {
+	if p == nil || length == 0 {
+		return nil
+	}
+	return unsafe.Slice((*byte)(unsafe.Pointer(p)), int(length))
+}
+
+// merkleResult stashes a hasher error the same way as the I/O callbacks.
+func merkleResult(state *callbackState, err error) C.int {
+	if err != nil {
+		state.lastErr = err
+		return -1
+	}
+	return 0
+}
+
+//export goRAFHashLeaf
+func goRAFHashLeaf(handle C.uintptr_t, out *C.uint8_t, outLen C.size_t, chunk *C.uint8_t, chunkLen C.size_t, idx C.uint64_t) C.int {
+	state := cgo.Handle(handle).Value().(*callbackState)
+	err := state.merkle.HashLeaf(cBytes(out, outLen), cBytes(chunk, chunkLen), uint64(idx))
+	return merkleResult(state, err)
+}
+
+//export goRAFHashParent
+func goRAFHashParent(handle C.uintptr_t, out *C.uint8_t, outLen C.size_t, left, right *C.uint8_t, level C.uint32_t, idx C.uint64_t) C.int {
+	state := cgo.Handle(handle).Value().(*callbackState)
+	err := state.merkle.HashParent(cBytes(out, outLen), cBytes(left, outLen), cBytes(right, outLen), uint32(level), uint64(idx))
+	return merkleResult(state, err)
+}
+
+//export goRAFHashEmpty
+func goRAFHashEmpty(handle C.uintptr_t, out *C.uint8_t, outLen C.size_t, level C.uint32_t, idx C.uint64_t) C.int {
+	state := cgo.Handle(handle).Value().(*callbackState)
+	err := state.merkle.HashEmpty(cBytes(out, outLen), uint32(level), uint64(idx))
+	return merkleResult(state, err)
+}
+
+//export goRAFHashCommitment
+func goRAFHashCommitment(handle C.uintptr_t, out *C.uint8_t, outLen C.size_t, root *C.uint8_t, ctx *C.uint8_t, ctxLen C.size_t, fileSize C.uint64_t) C.int {
+	state := cgo.Handle(handle).Value().(*callbackState)
+	err := state.merkle.HashCommitment(cBytes(out, outLen), cBytes(root, outLen), cBytes(ctx, ctxLen), uint64(fileSize))
+	return merkleResult(state, err)
+}
@@ -0,0 +1,229 @@
+package raf
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	mrand "math/rand"
+	"sync"
+	"testing"
+
+	"github.com/aegis-aead/go-libaegis/common"
+)
+
+// concurrentFile creates a concurrent AEGIS-128L file holding size random
+// bytes, which it returns.
+func concurrentFile(t *testing.T, store Store, size int) (*File, []byte) {
+	t.Helper()
+	key := make([]byte, 16)
+	rand.Read(key)
+	f, err := Create(store, key, &Options{Algorithm: AEGIS128L, ChunkSize: MinChunkSize, Concurrent: true})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	msg := make([]byte, size)
+	rand.Read(msg)
+	if _, err := f.WriteAt(msg, 0); err != nil {
+		t.Fatalf("WriteAt: %v", err)
+	}
+	return f, msg
+}
+
+func TestConcurrentReads(t *testing.T) {
+	if !common.Available {
+		t.Skip("CGO not available")
+	}
+
+	f, msg := concurrentFile(t, NewMemStore(), 64*MinChunkSize+123)
+	defer f.Close()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			rng := mrand.New(mrand.NewSource(seed))
+			for i := 0; i < 200; i++ {
+				off := rng.Intn(len(msg))
+				buf := make([]byte, rng.Intn(3*MinChunkSize)+1)
+				n, err := f.ReadAt(buf, int64(off))
+				want := msg[off:]
+				if len(want) > len(buf) {
+					want = want[:len(buf)]
+				}
+				if n != len(want) || !bytes.Equal(buf[:n], want) || (n < len(buf) && err == nil) || (n == len(buf) && err != nil) {
+					errs <- fmt.Errorf("ReadAt(%d bytes at %d) = %d, %v", len(buf), off, n, err)
+					return
+				}
+			}
+		}(int64(g))
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+}
+
+func TestConcurrentWriters(t *testing.T) {
+	if !common.Available {
+		t.Skip("CGO not available")
+	}
+
+	const writers, perWriter = 8, 4
+	f, msg := concurrentFile(t, NewMemStore(), writers*perWriter*MinChunkSize)
+	defer f.Close()
+
+	// Each writer owns the chunks i with i%writers == g and rewrites parts
+	// of them, while readers check the whole file stays readable. Others
+	// grow the file and query it, which takes the exclusive path.
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	errs := make(chan error, 2*writers+1)
+	for g := 0; g < writers; g++ {
+		wg.Add(2)
+		go func(g int) {
+			defer wg.Done()
+			rng := mrand.New(mrand.NewSource(int64(g)))
+			for i := 0; i < 100; i++ {
+				chunk := (rng.Intn(perWriter)*writers + g) * MinChunkSize
+				off := chunk + rng.Intn(MinChunkSize-64)
+				p := make([]byte, rng.Intn(64)+1)
+				rand.Read(p)
+				if _, err := f.WriteAt(p, int64(off)); err != nil {
+					errs <- fmt.Errorf("WriteAt: %v", err)
+					return
+				}
+				mu.Lock()
+				copy(msg[off:], p)
+				mu.Unlock()
+			}
+		}(g)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, len(msg))
+			for i := 0; i < 20; i++ {
+				if _, err := f.ReadAt(buf, 0); err != nil {
+					errs <- fmt.Errorf("ReadAt: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		tail := make([]byte, 100)
+		for i := 0; i < 20; i++ {
+			size, err := f.Size()
+			if err == nil {
+				_, err = f.WriteAt(tail, size)
+			}
+			if err == nil {
+				err = f.Sync()
+			}
+			if err != nil {
+				errs <- fmt.Errorf("append: %v", err)
+				return
+			}
+		}
+	}()
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	got := make([]byte, len(msg))
+	if _, err := f.ReadAt(got, 0); err != nil || !bytes.Equal(got, msg) {
+		t.Fatalf("contents after concurrent writes differ: %v", err)
+	}
+	if size, _ := f.Size(); size != int64(len(msg))+20*100 {
+		t.Fatalf("Size = %d, want %d", size, len(msg)+20*100)
+	}
+}
+
+func TestConcurrentErrors(t *testing.T) {
+	if !common.Available {
+		t.Skip("CGO not available")
+	}
+
+	store := NewMemStore()
+	f, msg := concurrentFile(t, store, 8*MinChunkSize)
+	defer f.Close()
+	info := f.Info()
+	store.Bytes()[HeaderSize+3*info.RecordSize()+100] ^= 1
+
+	// Only readers of the damaged chunk see the failure.
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(chunk int) {
+			defer wg.Done()
+			buf := make([]byte, MinChunkSize)
+			for i := 0; i < 50; i++ {
+				_, err := f.ReadAt(buf, int64(chunk*MinChunkSize))
+				if chunk == 3 && err != ErrAuth {
+					errs <- fmt.Errorf("chunk 3: got %v, want ErrAuth", err)
+					return
+				}
+				if chunk != 3 && (err != nil || !bytes.Equal(buf, msg[chunk*MinChunkSize:][:MinChunkSize])) {
+					errs <- fmt.Errorf("chunk %d: %v", chunk, err)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	f.Close()
+	if _, err := f.ReadAt(make([]byte, 1), 0); err != ErrClosed {
+		t.Fatalf("ReadAt after Close: got %v, want ErrClosed", err)
+	}
+	if _, err := f.WriteAt(make([]byte, 1), 0); err != ErrClosed {
+		t.Fatalf("WriteAt after Close: got %v, want ErrClosed", err)
+	}
+}
+
+// TestSerializedFile checks that a File without Options.Concurrent may be
+// shared by goroutines over a Store that is not safe for concurrent use.
+func TestSerializedFile(t *testing.T) {
+	if !common.Available {
+		t.Skip("CGO not available")
+	}
+
+	key := make([]byte, 16)
+	rand.Read(key)
+	f, err := Create(NewMemStore(), key, &Options{Algorithm: AEGIS128L, ChunkSize: MinChunkSize})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			p := bytes.Repeat([]byte{byte(g)}, MinChunkSize)
+			for i := 0; i < 20; i++ {
+				f.WriteAt(p, int64(g*MinChunkSize))
+				f.ReadAt(p, int64(g*MinChunkSize))
+				f.Size()
+			}
+		}(g)
+	}
+	wg.Wait()
+	for g := 0; g < 8; g++ {
+		p := make([]byte, MinChunkSize)
+		if _, err := f.ReadAt(p, int64(g*MinChunkSize)); err != nil || !bytes.Equal(p, bytes.Repeat([]byte{byte(g)}, MinChunkSize)) {
+			t.Fatalf("chunk %d: %v", g, err)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package raf
+
+import (
+	"fmt"
+	"io"
+	"math"
+)
+
+// convertBatch is roughly how many bytes of plaintext Convert holds at a
+// time.
+const convertBatch = 1 << 20
+
+// Convert copies the plaintext of the file on src, opened with srcKey, to
+// a new file on dst created with dstKey and dstOpts, which can use another
+// algorithm, chunk size or any other setting Create takes. Every chunk of
+// the source is authenticated as it is read, and Convert fails with
+// ErrAuth on the first that does not.
+//
+// Convert streams the data, holding about 1 MiB of plaintext, or one
+// destination chunk per goroutine if that is more, at a time, and wipes
+// it afterwards. dstOpts.Parallelism spreads the reads and writes of each
+// batch over that many goroutines, as it does for ReadAt and WriteAt.
+//
+// Settings dstOpts leaves unset are copied from the source where it
+// records them: a nil Metadata copies the source's metadata, and a zero
+// ExtensionSize its extension area size. A nil Merkle copies the Merkle
+// suite of a source with a freshness record, with MaxChunks scaled to keep
+// the same size limit, and gives the destination a freshness record too,
+// as that is where the settings are kept; a non-nil Freshness is used as
+// given. Set Metadata to an empty map to leave the metadata out.
+//
+// The source must not have a pending journal transaction: open it with
+// its journal first. If Convert fails, dst holds a partial file.
+func Convert(src Store, srcKey []byte, dst Store, dstKey []byte, dstOpts *Options) error {
+	if dstOpts == nil {
+		return fmt.Errorf("raf: options are required for Convert")
+	}
+	sf, err := Open(src, srcKey, &Options{Parallelism: dstOpts.Parallelism})
+	if err != nil {
+		return err
+	}
+	defer sf.Close()
+
+	opts := *dstOpts
+	info := sf.Info()
+	if opts.ExtensionSize == 0 {
+		opts.ExtensionSize = info.ExtensionSize
+	}
+	if opts.Metadata == nil {
+		if opts.Metadata, err = sf.Metadata(); err != nil {
+			return err
+		}
+	}
+	chunkSize := opts.ChunkSize
+	if chunkSize == 0 {
+		chunkSize = DefaultChunk
+	}
+	if suite, maxChunks, ok := sf.freshnessMerkle(); ok && opts.Merkle == nil {
+		if maxChunks > math.MaxUint64/uint64(info.ChunkSize) {
+			return ErrOverflow
+		}
+		limit := maxChunks * uint64(info.ChunkSize)
+		scaled := limit / uint64(chunkSize)
+		if limit%uint64(chunkSize) != 0 {
+			scaled++
+		}
+		opts.Merkle = &MerkleOptions{Suite: suite, MaxChunks: scaled}
+		if opts.Freshness == nil {
+			opts.Freshness = &Freshness{}
+		}
+	}
+
+	df, err := Create(dst, dstKey, &opts)
+	if err != nil {
+		return err
+	}
+	n := (convertBatch + chunkSize - 1) / chunkSize
+	if n < opts.Parallelism {
+		n = opts.Parallelism
+	}
+	buf := make([]byte, n*chunkSize)
+	defer wipe(buf)
+	for off := int64(0); off < info.Size; {
+		p := buf
+		if rest := info.Size - off; rest < int64(len(p)) {
+			p = p[:rest]
+		}
+		if _, err := sf.ReadAt(p, off); err != nil && err != io.EOF {
+			df.Close()
+			return err
+		}
+		if _, err := df.WriteAt(p, off); err != nil {
+			df.Close()
+			return err
+		}
+		off += int64(len(p))
+	}
+	return df.Close()
+}
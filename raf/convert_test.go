@@ -0,0 +1,126 @@
+package raf
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"reflect"
+	"testing"
+
+	"github.com/aegis-aead/go-libaegis/common"
+)
+
+func TestConvert(t *testing.T) {
+	if !common.Available {
+		t.Skip("CGO not available")
+	}
+
+	srcKey, dstKey := make([]byte, 16), make([]byte, 32)
+	rand.Read(srcKey)
+	rand.Read(dstKey)
+	meta := map[string]string{"name": "report.pdf"}
+	src := NewMemStore()
+	f, err := Create(src, srcKey, &Options{
+		Algorithm:     AEGIS128L,
+		ChunkSize:     4096,
+		Merkle:        suiteOptions(MerkleSHA256, 1024),
+		ExtensionSize: 4096,
+		Freshness:     &Freshness{},
+		Metadata:      meta,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 3<<20+1234)
+	rand.Read(data)
+	f.WriteAt(data, 0)
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Settings left out are copied: the metadata, the extension area, and
+	// the Merkle suite, whose limit is scaled to the larger chunks.
+	dst := NewMemStore()
+	err = Convert(src, srcKey, dst, dstKey, &Options{Algorithm: AEGIS256X2, ChunkSize: 64 << 10, Parallelism: 4})
+	if err != nil {
+		t.Fatalf("Convert: %v", err)
+	}
+	f, err = Open(dst, dstKey, &Options{Freshness: &Freshness{}})
+	if err != nil {
+		t.Fatalf("Open of the converted file: %v", err)
+	}
+	info := f.Info()
+	if info.Algorithm != AEGIS256X2 || info.ChunkSize != 64<<10 || info.ExtensionSize != 4096 || info.Size != int64(len(data)) {
+		t.Fatalf("Info: %+v", info)
+	}
+	got := make([]byte, len(data))
+	if _, err := f.ReadAt(got, 0); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("ReadAt: %v", err)
+	}
+	if m, err := f.Metadata(); err != nil || !reflect.DeepEqual(m, meta) {
+		t.Fatalf("Metadata: %v, %v", m, err)
+	}
+	if _, err := f.WriteAt([]byte{1}, 4096*1024-1); err != nil {
+		t.Fatalf("WriteAt at the source's size limit: %v", err)
+	}
+	if _, err := f.WriteAt([]byte{1}, 4096*1024); err != ErrOverflow {
+		t.Fatalf("WriteAt beyond the source's size limit: %v", err)
+	}
+	f.Close()
+
+	// The limit is the one Open verified, not whatever a copy of the
+	// record that does not verify says.
+	ext, _ := readExtArea(src)
+	forged := src.Snapshot()
+	entry := bytes.Index(forged, ext.entries[extFreshness])
+	binary.LittleEndian.PutUint64(forged[entry+1+8+1+len(MerkleSHA256):], 1<<40)
+	altered := NewMemStore()
+	altered.Restore(forged)
+	dst = NewMemStore()
+	if err := Convert(altered, srcKey, dst, dstKey, &Options{Algorithm: AEGIS256X2, ChunkSize: 64 << 10}); err != nil {
+		t.Fatalf("Convert with a copy of the record altered: %v", err)
+	}
+	if f, err = Open(dst, dstKey, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte{1}, 4096*1024); err != ErrOverflow {
+		t.Fatalf("WriteAt beyond the verified size limit: %v", err)
+	}
+	f.Close()
+
+	// Explicit settings win, and an empty map leaves the metadata out.
+	dst = NewMemStore()
+	err = Convert(src, srcKey, dst, dstKey, &Options{Algorithm: AEGIS256, ExtensionSize: 512, Metadata: map[string]string{}, Merkle: suiteOptions(MerkleSHA512_256, 1024)})
+	if err != nil {
+		t.Fatalf("Convert with explicit settings: %v", err)
+	}
+	f, err = Open(dst, dstKey, &Options{Merkle: suiteOptions(MerkleSHA512_256, 1024)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m, err := f.Metadata(); m != nil || err != nil {
+		t.Fatalf("Metadata left out: %v, %v", m, err)
+	}
+	if _, _, err := f.Version(); err != ErrNoFreshness {
+		t.Fatalf("Version without a freshness record: %v", err)
+	}
+	if info := f.Info(); info.ExtensionSize != 512 || info.ChunkSize != DefaultChunk {
+		t.Fatalf("Info: %+v", info)
+	}
+	f.Close()
+
+	// Every source chunk is authenticated.
+	plain := NewMemStore()
+	f, _ = Create(plain, srcKey, &Options{Algorithm: AEGIS128L, ChunkSize: MinChunkSize})
+	f.WriteAt(data[:10*MinChunkSize], 0)
+	f.Close()
+	b := plain.Snapshot()
+	b[len(b)-100] ^= 1
+	plain.Restore(b)
+	if err := Convert(plain, srcKey, NewMemStore(), dstKey, &Options{Algorithm: AEGIS256}); err != ErrAuth {
+		t.Fatalf("Convert of a damaged file: %v", err)
+	}
+	if err := Convert(plain, dstKey, NewMemStore(), dstKey, &Options{Algorithm: AEGIS256}); err != ErrBadKeyLength {
+		t.Fatalf("Convert with a wrong source key: %v", err)
+	}
+}
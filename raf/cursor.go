@@ -0,0 +1,264 @@
+package raf
+
+import (
+	"errors"
+	"io"
+)
+
+// Cursor is a position in a File with sequential Read, Write and Seek, for
+// APIs that take an io.Reader, io.Writer or io.Seeker. Any number of
+// cursors can be opened over one File; each has its own position, and they
+// share the File's safety for concurrent use, though a single Cursor must
+// not be used by several goroutines at once.
+//
+// With readahead, a Cursor reading sequentially decrypts the chunks after
+// the ones it returns in the background, so that they are ready for the
+// next Read. Data read ahead is dropped as soon as anything writes to the
+// File, through this Cursor or otherwise, so reads never return stale
+// plaintext.
+type Cursor struct {
+	f         *File
+	chunkSize int
+	readahead int // bytes to read past what Read asked for
+	off       int64
+	closed    bool
+
+	// win holds the plaintext at winOff as of File generation winGen.
+	win    []byte
+	winOff int64
+	winGen uint64
+	next   chan *window // the window after win, being read
+}
+
+// window is a run of plaintext read ahead.
+type window struct {
+	buf []byte
+	off int64
+	gen uint64
+	err error
+}
+
+// ErrCursorClosed is returned by the methods of a closed Cursor.
+var ErrCursorClosed = errors.New("raf: cursor is closed")
+
+// bulkChunks is how many chunks ReadFrom and WriteTo move per call.
+const bulkChunks = 16
+
+// NewCursor returns a Cursor at the start of f that reads ahead the given
+// number of chunks past each sequential Read. Zero disables readahead.
+// Closing the Cursor does not close f.
+func (f *File) NewCursor(readahead int) *Cursor {
+	cs := f.Info().ChunkSize
+	return &Cursor{f: f, chunkSize: cs, readahead: readahead * cs}
+}
+
+// Read reads up to len(p) bytes at the cursor and advances it.
+// Implements io.Reader.
+func (c *Cursor) Read(p []byte) (int, error) {
+	if c.closed {
+		return 0, ErrCursorClosed
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if c.readahead == 0 {
+		n, err := c.f.ReadAt(p, c.off)
+		c.off += int64(n)
+		if n > 0 && err == io.EOF {
+			err = nil
+		}
+		return n, err
+	}
+
+	if !c.inWindow() {
+		if err := c.fill(len(p)); err != nil {
+			return 0, err
+		}
+		if !c.inWindow() {
+			return 0, io.EOF
+		}
+	}
+	n := copy(p, c.win[c.off-c.winOff:])
+	c.off += int64(n)
+	return n, nil
+}
+
+// inWindow reports whether the window holds current plaintext at the
+// cursor.
+func (c *Cursor) inWindow() bool {
+	return c.off >= c.winOff && c.off < c.winOff+int64(len(c.win)) &&
+		c.winGen == c.f.generation()
+}
+
+// fill replaces the window with one starting at the cursor. If the cursor
+// has moved on from the end of the last window, the read is sequential: it
+// takes the window read ahead if that is there and current, and starts
+// reading the following one.
+func (c *Cursor) fill(want int) error {
+	sequential := c.off == c.winOff+int64(len(c.win))
+	var w *window
+	if c.next != nil {
+		w = <-c.next
+		c.next = nil
+		if w.off != c.off || w.gen != c.f.generation() || (w.err != nil && w.err != io.EOF) {
+			wipe(w.buf)
+			w = nil
+		}
+	}
+	if w == nil {
+		// A window ends at a chunk boundary, so that the next one starts
+		// at one and the File decrypts whole chunks.
+		end := c.off + int64(want)
+		if sequential {
+			end += int64(c.readahead)
+		}
+		if r := end % int64(c.chunkSize); r != 0 {
+			end += int64(c.chunkSize) - r
+		}
+		w = c.read(c.off, int(end-c.off))
+		if w.err != nil && w.err != io.EOF {
+			wipe(w.buf)
+			return w.err
+		}
+	}
+
+	wipe(c.win)
+	c.win, c.winOff, c.winGen = w.buf, w.off, w.gen
+	if sequential && w.err == nil {
+		next := make(chan *window, 1)
+		off := w.off + int64(len(w.buf))
+		go func() { next <- c.read(off, c.readahead) }()
+		c.next = next
+	}
+	return nil
+}
+
+// read reads n bytes at off into a new window.
+func (c *Cursor) read(off int64, n int) *window {
+	w := &window{buf: make([]byte, n), off: off, gen: c.f.generation()}
+	var got int
+	got, w.err = c.f.ReadAt(w.buf, off)
+	w.buf = w.buf[:got]
+	return w
+}
+
+// drop discards the window and any readahead in flight.
+func (c *Cursor) drop() {
+	if c.next != nil {
+		wipe((<-c.next).buf)
+		c.next = nil
+	}
+	wipe(c.win)
+	c.win = nil
+}
+
+// Write writes p at the cursor and advances it. Implements io.Writer.
+func (c *Cursor) Write(p []byte) (int, error) {
+	if c.closed {
+		return 0, ErrCursorClosed
+	}
+	n, err := c.f.WriteAt(p, c.off)
+	c.off += int64(n)
+	return n, err
+}
+
+// Seek sets the position of the next Read or Write. Implements io.Seeker.
+func (c *Cursor) Seek(offset int64, whence int) (int64, error) {
+	if c.closed {
+		return 0, ErrCursorClosed
+	}
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += c.off
+	case io.SeekEnd:
+		size, err := c.f.Size()
+		if err != nil {
+			return 0, err
+		}
+		offset += size
+	default:
+		return 0, errors.New("raf: invalid whence")
+	}
+	if offset < 0 {
+		return 0, ErrNegativeOffset
+	}
+	c.off = offset
+	return offset, nil
+}
+
+// ReadFrom writes everything read from r at the cursor, in chunk-aligned
+// blocks after the first, and advances the cursor. Implements
+// io.ReaderFrom.
+func (c *Cursor) ReadFrom(r io.Reader) (int64, error) {
+	if c.closed {
+		return 0, ErrCursorClosed
+	}
+	buf := make([]byte, bulkChunks*c.chunkSize)
+	defer wipe(buf)
+	var total int64
+	for {
+		// The first block ends at a chunk boundary, so that the others
+		// replace whole chunks without reading them first.
+		block := buf[:len(buf)-int(c.off%int64(c.chunkSize))]
+		n, err := io.ReadFull(r, block)
+		if n > 0 {
+			w, werr := c.f.WriteAt(block[:n], c.off)
+			c.off += int64(w)
+			total += int64(w)
+			if werr != nil {
+				return total, werr
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return total, nil
+		}
+		if err != nil {
+			return total, err
+		}
+	}
+}
+
+// WriteTo writes the file from the cursor to its end to w, in
+// chunk-aligned blocks after the first, and advances the cursor.
+// Implements io.WriterTo.
+func (c *Cursor) WriteTo(w io.Writer) (int64, error) {
+	if c.closed {
+		return 0, ErrCursorClosed
+	}
+	buf := make([]byte, bulkChunks*c.chunkSize)
+	defer wipe(buf)
+	var total int64
+	for {
+		block := buf[:len(buf)-int(c.off%int64(c.chunkSize))]
+		n, err := c.f.ReadAt(block, c.off)
+		if n > 0 {
+			m, werr := w.Write(block[:n])
+			c.off += int64(m)
+			total += int64(m)
+			if werr == nil && m < n {
+				werr = io.ErrShortWrite
+			}
+			if werr != nil {
+				return total, werr
+			}
+		}
+		if err == io.EOF {
+			return total, nil
+		}
+		if err != nil {
+			return total, err
+		}
+	}
+}
+
+// Close waits for any readahead, wipes the plaintext the Cursor holds and
+// makes it unusable. It does not close the File.
+func (c *Cursor) Close() error {
+	if c.closed {
+		return ErrCursorClosed
+	}
+	c.drop()
+	c.closed = true
+	return nil
+}
@@ -0,0 +1,116 @@
+package raf
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"testing"
+	"testing/iotest"
+
+	"github.com/aegis-aead/go-libaegis/common"
+)
+
+func TestCursor(t *testing.T) {
+	if !common.Available {
+		t.Skip("CGO not available")
+	}
+
+	msg := make([]byte, 40*MinChunkSize+321)
+	rand.Read(msg)
+	for _, readahead := range []int{0, 1, 8} {
+		t.Run(fmt.Sprintf("readahead=%d", readahead), func(t *testing.T) {
+			key := make([]byte, 16)
+			rand.Read(key)
+			f, err := Create(NewMemStore(), key, &Options{Algorithm: AEGIS128L, ChunkSize: MinChunkSize})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			// io.Copy uses ReadFrom and WriteTo; the odd-sized reader starts
+			// the transfer off a chunk boundary.
+			w := f.NewCursor(readahead)
+			w.Seek(100, io.SeekStart)
+			if n, err := io.Copy(w, iotest.HalfReader(bytes.NewReader(msg[100:]))); n != int64(len(msg)-100) || err != nil {
+				t.Fatalf("io.Copy into the file = %d, %v", n, err)
+			}
+			w.Seek(0, io.SeekStart)
+			if _, err := w.Write(msg[:100]); err != nil {
+				t.Fatal(err)
+			}
+			var out bytes.Buffer
+			if off, _ := w.Seek(-int64(len(msg)), io.SeekEnd); off != 0 {
+				t.Fatalf("Seek from the end = %d", off)
+			}
+			if n, err := io.Copy(&out, w); n != int64(len(msg)) || err != nil || !bytes.Equal(out.Bytes(), msg) {
+				t.Fatalf("io.Copy out of the file = %d, %v", n, err)
+			}
+			w.Close()
+
+			r := f.NewCursor(readahead)
+			defer r.Close()
+			if err := iotest.TestReader(r, msg); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// TestCursorReadaheadWrites checks that a write lands in what a cursor
+// reads next even when the cursor has already read that chunk ahead.
+func TestCursorReadaheadWrites(t *testing.T) {
+	if !common.Available {
+		t.Skip("CGO not available")
+	}
+
+	key := make([]byte, 16)
+	rand.Read(key)
+	f, err := Create(NewMemStore(), key, &Options{Algorithm: AEGIS128L, ChunkSize: MinChunkSize})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	msg := make([]byte, 16*MinChunkSize)
+	rand.Read(msg)
+	f.WriteAt(msg, 0)
+
+	r := f.NewCursor(4)
+	defer r.Close()
+	w := f.NewCursor(0)
+	defer w.Close()
+	buf := make([]byte, MinChunkSize)
+	for chunk := 0; chunk < 16; chunk++ {
+		// Overwrite the chunk after the one about to be read, which the
+		// reader has read ahead by now.
+		if chunk < 15 {
+			w.Seek(int64(chunk+1)*MinChunkSize, io.SeekStart)
+			fmt.Fprintf(w, "chunk %d", chunk+1)
+			copy(msg[(chunk+1)*MinChunkSize:], fmt.Sprintf("chunk %d", chunk+1))
+		}
+		if _, err := io.ReadFull(r, buf); err != nil || !bytes.Equal(buf, msg[chunk*MinChunkSize:][:MinChunkSize]) {
+			t.Fatalf("chunk %d: %v", chunk, err)
+		}
+	}
+	if n, err := r.Read(buf); n != 0 || err != io.EOF {
+		t.Fatalf("Read at the end = %d, %v", n, err)
+	}
+
+	// The file grows after the reader has reached its end.
+	f.WriteAt([]byte("more"), int64(len(msg)))
+	if n, err := r.Read(buf); n != 4 || err != nil || string(buf[:4]) != "more" {
+		t.Fatalf("Read after the file grew = %d, %v", n, err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Read(buf); err != ErrCursorClosed {
+		t.Fatalf("Read after Close: got %v", err)
+	}
+	if _, err := r.Seek(-1, io.SeekStart); err != ErrCursorClosed {
+		t.Fatalf("Seek after Close: got %v", err)
+	}
+	if _, err := w.Seek(-1, io.SeekStart); err != ErrNegativeOffset {
+		t.Fatalf("Seek before the start: got %v", err)
+	}
+}
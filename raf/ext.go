@@ -0,0 +1,270 @@
+package raf
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// Extension area
+//
+// A file created with Options.ExtensionSize starts with a fixed-size area
+// that holds data the C library's 64-byte header has no room for, such as
+// signatures. The RAF header and chunk records follow it unchanged, so the
+// C library sees an ordinary RAF file through an offset view of the store.
+//
+// The area is laid out as:
+//
+//	"AEGISRFX" || u32 area size || u32 payload length || payload || zeros
+//
+// with little-endian integers. The payload is a sequence of entries, each
+// u16 type || u32 length || value, sorted by type. The area itself is not
+// authenticated: every entry type protects its own value.
+//
+// Updates write only the bytes that changed, so that an entry that keeps
+// its length, such as the freshness record, is rewritten without touching
+// the entries around it, and a write torn by a crash cannot damage them.
+// When the payload grows, the bytes past its old end are written before
+// the rest, which holds the new length, and when it shrinks, the bytes
+// past its new end after, so that an entry added or removed at the end
+// never leaves a length that covers bytes that are not entries.
+
+const (
+	// MinExtensionSize is the smallest extension area Create accepts.
+	MinExtensionSize = 256
+
+	// MaxExtensionSize is the largest extension area Create accepts.
+	MaxExtensionSize = 1 << 20
+
+	extMagic      = "AEGISRFX"
+	extHeaderSize = 16
+	extEntryHdr   = 6
+)
+
+// Extension entry types.
+const (
+	extKeySlots  uint16 = 0
+	extSignature uint16 = 1
+	extFreshness uint16 = 2
+	extMetadata  uint16 = 3
+	extRekey     uint16 = 4
+)
+
+var (
+	// ErrNoExtensionArea is returned by operations that store data in the
+	// extension area of a file created without Options.ExtensionSize.
+	ErrNoExtensionArea = errors.New("raf: file has no extension area")
+
+	// ErrExtensionFull is returned when the entries no longer fit in the
+	// extension area reserved at Create.
+	ErrExtensionFull = errors.New("raf: extension area is full")
+
+	// ErrBadExtensionSize is returned by Create when Options.ExtensionSize
+	// is out of range.
+	ErrBadExtensionSize = errors.New("raf: invalid extension area size")
+)
+
+// extArea is the decoded extension area of a file.
+type extArea struct {
+	size    int
+	entries map[uint16][]byte
+	raw     []byte // the area as last read or written, or nil if unknown
+}
+
+func newExtArea(size int) (*extArea, error) {
+	if size < MinExtensionSize || size > MaxExtensionSize {
+		return nil, ErrBadExtensionSize
+	}
+	return &extArea{size: size, entries: map[uint16][]byte{}}, nil
+}
+
+// readExtArea reads the extension area at the start of store. It returns
+// nil without an error if the store does not start with one.
+func readExtArea(store Store) (*extArea, error) {
+	var hdr [extHeaderSize]byte
+	if n, err := store.ReadAt(hdr[:], 0); n < len(hdr) {
+		if n > 0 || err == nil {
+			return nil, ErrInvalidHeader
+		}
+		return nil, nil
+	}
+	if string(hdr[:8]) != extMagic {
+		return nil, nil
+	}
+	size := int(binary.LittleEndian.Uint32(hdr[8:]))
+	payloadLen := int(binary.LittleEndian.Uint32(hdr[12:]))
+	if size < MinExtensionSize || size > MaxExtensionSize || payloadLen > size-extHeaderSize {
+		return nil, ErrInvalidHeader
+	}
+
+	raw := make([]byte, size)
+	if _, err := store.ReadAt(raw, 0); err != nil {
+		return nil, ErrInvalidHeader
+	}
+	payload := raw[extHeaderSize : extHeaderSize+payloadLen]
+	a := &extArea{size: size, entries: map[uint16][]byte{}, raw: raw}
+	for len(payload) > 0 {
+		if len(payload) < extEntryHdr {
+			return nil, ErrInvalidHeader
+		}
+		typ := binary.LittleEndian.Uint16(payload)
+		n := binary.LittleEndian.Uint32(payload[2:])
+		payload = payload[extEntryHdr:]
+		if uint64(n) > uint64(len(payload)) {
+			return nil, ErrInvalidHeader
+		}
+		if _, dup := a.entries[typ]; dup {
+			return nil, ErrInvalidHeader
+		}
+		a.entries[typ] = payload[:n:n]
+		payload = payload[n:]
+	}
+	return a, nil
+}
+
+// extAreaSize returns the size of the extension area at the start of store,
+// or 0 if there is none, reading only the area's fixed header.
+func extAreaSize(store Store) (int64, error) {
+	var hdr [extHeaderSize]byte
+	if n, _ := store.ReadAt(hdr[:], 0); n < len(hdr) || string(hdr[:8]) != extMagic {
+		return 0, nil
+	}
+	size := binary.LittleEndian.Uint32(hdr[8:])
+	if size < MinExtensionSize || size > MaxExtensionSize {
+		return 0, ErrInvalidHeader
+	}
+	return int64(size), nil
+}
+
+// encode returns the whole area, padded with zeros to its size.
+func (a *extArea) encode() ([]byte, error) {
+	types := make([]int, 0, len(a.entries))
+	for typ := range a.entries {
+		types = append(types, int(typ))
+	}
+	sort.Ints(types)
+
+	b := make([]byte, extHeaderSize, a.size)
+	copy(b, extMagic)
+	binary.LittleEndian.PutUint32(b[8:], uint32(a.size))
+	for _, typ := range types {
+		v := a.entries[uint16(typ)]
+		if len(b)+extEntryHdr+len(v) > a.size {
+			return nil, ErrExtensionFull
+		}
+		b = binary.LittleEndian.AppendUint16(b, uint16(typ))
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(v)))
+		b = append(b, v...)
+	}
+	binary.LittleEndian.PutUint32(b[12:], uint32(len(b)-extHeaderSize))
+	return b[:a.size], nil
+}
+
+// set replaces an entry, or removes it if value is nil, and writes the
+// bytes of the area that changed back to store. The area is unchanged if
+// it does not fit.
+func (a *extArea) set(store Store, typ uint16, value []byte) error {
+	old, had := a.entries[typ]
+	if value == nil {
+		delete(a.entries, typ)
+	} else {
+		a.entries[typ] = value
+	}
+	b, err := a.encode()
+	if err == nil {
+		lo, hi := 0, len(b)
+		if len(a.raw) == len(b) {
+			for lo < hi && b[lo] == a.raw[lo] {
+				lo++
+			}
+			for hi > lo && b[hi-1] == a.raw[hi-1] {
+				hi--
+			}
+		}
+		if lo < hi {
+			if err = a.write(store, b, lo, hi); err != nil {
+				a.raw = nil
+				err = fmt.Errorf("raf: %w", err)
+			}
+		}
+		if err == nil {
+			a.raw = b
+		}
+	}
+	if err != nil {
+		if had {
+			a.entries[typ] = old
+		} else {
+			delete(a.entries, typ)
+		}
+	}
+	return err
+}
+
+// write writes b[lo:hi] over the area last written to store, split at the
+// end of the shorter payload: the part past it first if the payload grows,
+// and last otherwise.
+func (a *extArea) write(store Store, b []byte, lo, hi int) error {
+	parts := [][2]int{{lo, hi}}
+	if len(a.raw) == len(b) {
+		oldLen, newLen := binary.LittleEndian.Uint32(a.raw[12:]), binary.LittleEndian.Uint32(b[12:])
+		end := extHeaderSize + int(oldLen)
+		if newLen < oldLen {
+			end = extHeaderSize + int(newLen)
+		}
+		if lo < end && end < hi {
+			parts = [][2]int{{lo, end}, {end, hi}}
+			if newLen > oldLen {
+				parts[0], parts[1] = parts[1], parts[0]
+			}
+		}
+	}
+	for _, p := range parts {
+		if _, err := store.WriteAt(b[p[0]:p[1]], int64(p[0])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// offsetStore presents the part of a store after the extension area as a
+// store of its own, which is what the C library reads and writes.
+type offsetStore struct {
+	s   Store
+	off int64
+}
+
+func (o *offsetStore) ReadAt(p []byte, off int64) (int, error) {
+	return o.s.ReadAt(p, off+o.off)
+}
+
+func (o *offsetStore) WriteAt(p []byte, off int64) (int, error) {
+	return o.s.WriteAt(p, off+o.off)
+}
+
+func (o *offsetStore) GetSize() (int64, error) {
+	size, err := o.s.GetSize()
+	if err != nil || size < o.off {
+		return 0, err
+	}
+	return size - o.off, nil
+}
+
+func (o *offsetStore) SetSize(size int64) error {
+	return o.s.SetSize(size + o.off)
+}
+
+func (o *offsetStore) Sync() error {
+	return o.s.Sync()
+}
+
+// dataStore returns the store holding the RAF header and records, and the
+// extension area if there is one.
+func dataStore(store Store) (Store, *extArea, error) {
+	ext, err := readExtArea(store)
+	if err != nil || ext == nil {
+		return store, nil, err
+	}
+	return &offsetStore{s: store, off: int64(ext.size)}, ext, nil
+}
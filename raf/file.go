@@ -8,8 +8,10 @@ package raf
 #include <stdlib.h>
 #include <stdint.h>
 #include <errno.h>
+#include <string.h>
+#include "raf_binding.h"
 
-#cgo CFLAGS: -I../common/libaegis/src/include
+#cgo CFLAGS: -I../common/libaegis/src/include -I../common
 
 // Forward-declare Go-exported callbacks.
 extern int goRAFReadAt(uintptr_t h, uint8_t *buf, size_t len, uint64_t off);
@@ -18,6 +20,14 @@ extern int goRAFGetSize(uintptr_t h, uint64_t *size);
 extern int goRAFSetSize(uintptr_t h, uint64_t size);
 extern int goRAFSync(uintptr_t h);
 extern int goRAFRandom(uint8_t *out, size_t len);
+extern int goRAFHashLeaf(uintptr_t h, uint8_t *out, size_t out_len,
+	const uint8_t *chunk, size_t chunk_len, uint64_t idx);
+extern int goRAFHashParent(uintptr_t h, uint8_t *out, size_t out_len,
+	const uint8_t *left, const uint8_t *right, uint32_t level, uint64_t idx);
+extern int goRAFHashEmpty(uintptr_t h, uint8_t *out, size_t out_len,
+	uint32_t level, uint64_t idx);
+extern int goRAFHashCommitment(uintptr_t h, uint8_t *out, size_t out_len,
+	const uint8_t *root, const uint8_t *ctx, size_t ctx_len, uint64_t file_size);
 
 // --- I/O callback shims ---
 // These dereference the C-allocated box to recover the cgo.Handle.
@@ -67,6 +77,46 @@ static int shim_random(void *user, uint8_t *out, size_t len) {
 	return goRAFRandom(out, len);
 }
 
+// --- Merkle hash shims ---
+// These use the same handle box as the I/O shims; the Go side reaches the
+// MerkleHasher through the callbackState.
+
+static int shim_hash_leaf(void *user, uint8_t *out, size_t out_len,
+	const uint8_t *chunk, size_t chunk_len, uint64_t idx)
+{
+	uintptr_t h = *(uintptr_t *)user;
+	int ret = goRAFHashLeaf(h, out, out_len, chunk, chunk_len, idx);
+	if (ret != 0) { errno = EIO; }
+	return ret;
+}
+
+static int shim_hash_parent(void *user, uint8_t *out, size_t out_len,
+	const uint8_t *left, const uint8_t *right, uint32_t level, uint64_t idx)
+{
+	uintptr_t h = *(uintptr_t *)user;
+	int ret = goRAFHashParent(h, out, out_len, left, right, level, idx);
+	if (ret != 0) { errno = EIO; }
+	return ret;
+}
+
+static int shim_hash_empty(void *user, uint8_t *out, size_t out_len,
+	uint32_t level, uint64_t idx)
+{
+	uintptr_t h = *(uintptr_t *)user;
+	int ret = goRAFHashEmpty(h, out, out_len, level, idx);
+	if (ret != 0) { errno = EIO; }
+	return ret;
+}
+
+static int shim_hash_commitment(void *user, uint8_t *out, size_t out_len,
+	const uint8_t *root, const uint8_t *ctx, size_t ctx_len, uint64_t file_size)
+{
+	uintptr_t h = *(uintptr_t *)user;
+	int ret = goRAFHashCommitment(h, out, out_len, root, ctx, ctx_len, file_size);
+	if (ret != 0) { errno = EIO; }
+	return ret;
+}
+
 // --- Helpers ---
 
 static aegis_raf_io make_raf_io(void *box) {
@@ -108,6 +158,147 @@ static void raf_aligned_free(void *ptr) {
 #endif
 }
 
+// --- Built-in AEGIS-MAC Merkle suite ---
+// The MerkleAEGISMAC suite runs entirely in C, without calling back into
+// Go. user points to an AEGIS-256 MAC state initialized with the suite key
+// and an all-zero nonce; each hash starts from a clone of it. The messages
+// are encoded as documented in merkle_suite.go.
+
+#define RAF_MERKLE_MAC_PREFIX "aegis-raf-merkle/aegis-mac"
+#define RAF_MERKLE_MAC_SIZE   32
+
+static void raf_le32(uint8_t *p, uint32_t v) {
+	int i;
+	for (i = 0; i < 4; i++) { p[i] = (uint8_t)(v >> (8 * i)); }
+}
+
+static void raf_le64(uint8_t *p, uint64_t v) {
+	int i;
+	for (i = 0; i < 8; i++) { p[i] = (uint8_t)(v >> (8 * i)); }
+}
+
+static void mac_begin(aegis256_mac_state *st, const void *user, uint8_t domain,
+	const uint8_t *fields, size_t fields_len)
+{
+	aegis256_mac_state_clone(st, (const aegis256_mac_state *)user);
+	aegis256_mac_update(st, (const uint8_t *)RAF_MERKLE_MAC_PREFIX, sizeof RAF_MERKLE_MAC_PREFIX - 1);
+	aegis256_mac_update(st, &domain, 1);
+	aegis256_mac_update(st, fields, fields_len);
+}
+
+static int mac_finish(aegis256_mac_state *st, uint8_t *out, size_t out_len) {
+	if (out_len != RAF_MERKLE_MAC_SIZE) {
+		errno = EINVAL;
+		return -1;
+	}
+	return aegis256_mac_final(st, out, out_len);
+}
+
+static int mac_hash_leaf(void *user, uint8_t *out, size_t out_len,
+	const uint8_t *chunk, size_t chunk_len, uint64_t idx)
+{
+	aegis256_mac_state st;
+	uint8_t f[16];
+	raf_le64(f, idx);
+	raf_le64(f + 8, (uint64_t)chunk_len);
+	mac_begin(&st, user, 0x00, f, sizeof f);
+	aegis256_mac_update(&st, chunk, chunk_len);
+	return mac_finish(&st, out, out_len);
+}
+
+static int mac_hash_parent(void *user, uint8_t *out, size_t out_len,
+	const uint8_t *left, const uint8_t *right, uint32_t level, uint64_t idx)
+{
+	aegis256_mac_state st;
+	uint8_t f[12];
+	raf_le32(f, level);
+	raf_le64(f + 4, idx);
+	mac_begin(&st, user, 0x01, f, sizeof f);
+	aegis256_mac_update(&st, left, out_len);
+	aegis256_mac_update(&st, right, out_len);
+	return mac_finish(&st, out, out_len);
+}
+
+static int mac_hash_empty(void *user, uint8_t *out, size_t out_len,
+	uint32_t level, uint64_t idx)
+{
+	aegis256_mac_state st;
+	uint8_t f[12];
+	raf_le32(f, level);
+	raf_le64(f + 4, idx);
+	mac_begin(&st, user, 0x02, f, sizeof f);
+	return mac_finish(&st, out, out_len);
+}
+
+static int mac_hash_commitment(void *user, uint8_t *out, size_t out_len,
+	const uint8_t *root, const uint8_t *ctx, size_t ctx_len, uint64_t file_size)
+{
+	aegis256_mac_state st;
+	uint8_t f[8];
+	raf_le64(f, (uint64_t)ctx_len);
+	mac_begin(&st, user, 0x03, f, sizeof f);
+	if (ctx_len > 0) {
+		aegis256_mac_update(&st, ctx, ctx_len);
+	}
+	raf_le64(f, file_size);
+	aegis256_mac_update(&st, f, sizeof f);
+	aegis256_mac_update(&st, root, out_len);
+	return mac_finish(&st, out, out_len);
+}
+
+static void *raf_merkle_mac_new(const uint8_t *key) {
+	aegis256_mac_state *st = raf_aligned_alloc(64, sizeof *st);
+	if (st == NULL) {
+		return NULL;
+	}
+	aegis256_mac_init(st, key, NULL);
+	return st;
+}
+
+static void raf_merkle_mac_free(void *st) {
+	volatile uint8_t *p = (volatile uint8_t *)st;
+	size_t i;
+	if (st == NULL) {
+		return;
+	}
+	for (i = 0; i < sizeof(aegis256_mac_state); i++) { p[i] = 0; }
+	raf_aligned_free(st);
+}
+
+// make_raf_merkle routes the hash callbacks to the Go MerkleHasher through
+// the handle box, or to the C suite when mac is not NULL.
+static aegis_raf_merkle_config make_raf_merkle(void *box, void *mac, uint8_t *buf,
+	size_t len, uint64_t max_chunks, uint32_t hash_len)
+{
+	aegis_raf_merkle_config m;
+	if (mac != NULL) {
+		m.hash_leaf       = mac_hash_leaf;
+		m.hash_parent     = mac_hash_parent;
+		m.hash_empty      = mac_hash_empty;
+		m.hash_commitment = mac_hash_commitment;
+		m.user            = mac;
+	} else {
+		m.hash_leaf       = shim_hash_leaf;
+		m.hash_parent     = shim_hash_parent;
+		m.hash_empty      = shim_hash_empty;
+		m.hash_commitment = shim_hash_commitment;
+		m.user            = box;
+	}
+	m.buf        = buf;
+	m.len        = len;
+	m.max_chunks = max_chunks;
+	m.hash_len   = hash_len;
+	return m;
+}
+
+static size_t raf_merkle_buffer_size(uint64_t max_chunks, uint32_t hash_len) {
+	aegis_raf_merkle_config m;
+	memset(&m, 0, sizeof m);
+	m.max_chunks = max_chunks;
+	m.hash_len   = hash_len;
+	return aegis_raf_merkle_buffer_size(&m);
+}
+
 // --- Variant dispatch ---
 // Each function switches on the C algorithm ID to call the right variant.
 
@@ -226,28 +417,72 @@ static void raf_close(int alg, void *ctx) {
 	}
 }
 
+static int raf_merkle_rebuild(int alg, void *ctx) {
+	switch (alg) {
+	case AEGIS_RAF_ALG_128L:  return aegis128l_raf_merkle_rebuild((aegis128l_raf_ctx *)ctx);
+	case AEGIS_RAF_ALG_128X2: return aegis128x2_raf_merkle_rebuild((aegis128x2_raf_ctx *)ctx);
+	case AEGIS_RAF_ALG_128X4: return aegis128x4_raf_merkle_rebuild((aegis128x4_raf_ctx *)ctx);
+	case AEGIS_RAF_ALG_256:   return aegis256_raf_merkle_rebuild((aegis256_raf_ctx *)ctx);
+	case AEGIS_RAF_ALG_256X2: return aegis256x2_raf_merkle_rebuild((aegis256x2_raf_ctx *)ctx);
+	case AEGIS_RAF_ALG_256X4: return aegis256x4_raf_merkle_rebuild((aegis256x4_raf_ctx *)ctx);
+	default: errno = EINVAL; return -1;
+	}
+}
+
+static int raf_merkle_verify(int alg, void *ctx, uint64_t *corrupted_chunk) {
+	switch (alg) {
+	case AEGIS_RAF_ALG_128L:  return aegis128l_raf_merkle_verify((aegis128l_raf_ctx *)ctx, corrupted_chunk);
+	case AEGIS_RAF_ALG_128X2: return aegis128x2_raf_merkle_verify((aegis128x2_raf_ctx *)ctx, corrupted_chunk);
+	case AEGIS_RAF_ALG_128X4: return aegis128x4_raf_merkle_verify((aegis128x4_raf_ctx *)ctx, corrupted_chunk);
+	case AEGIS_RAF_ALG_256:   return aegis256_raf_merkle_verify((aegis256_raf_ctx *)ctx, corrupted_chunk);
+	case AEGIS_RAF_ALG_256X2: return aegis256x2_raf_merkle_verify((aegis256x2_raf_ctx *)ctx, corrupted_chunk);
+	case AEGIS_RAF_ALG_256X4: return aegis256x4_raf_merkle_verify((aegis256x4_raf_ctx *)ctx, corrupted_chunk);
+	default: errno = EINVAL; return -1;
+	}
+}
+
+static int raf_merkle_commitment(int alg, const void *ctx, uint8_t *out, size_t out_len) {
+	switch (alg) {
+	case AEGIS_RAF_ALG_128L:  return aegis128l_raf_merkle_commitment((const aegis128l_raf_ctx *)ctx, out, out_len);
+	case AEGIS_RAF_ALG_128X2: return aegis128x2_raf_merkle_commitment((const aegis128x2_raf_ctx *)ctx, out, out_len);
+	case AEGIS_RAF_ALG_128X4: return aegis128x4_raf_merkle_commitment((const aegis128x4_raf_ctx *)ctx, out, out_len);
+	case AEGIS_RAF_ALG_256:   return aegis256_raf_merkle_commitment((const aegis256_raf_ctx *)ctx, out, out_len);
+	case AEGIS_RAF_ALG_256X2: return aegis256x2_raf_merkle_commitment((const aegis256x2_raf_ctx *)ctx, out, out_len);
+	case AEGIS_RAF_ALG_256X4: return aegis256x4_raf_merkle_commitment((const aegis256x4_raf_ctx *)ctx, out, out_len);
+	default: errno = EINVAL; return -1;
+	}
+}
+
 // --- High-level helpers that build all C structs internally ---
 // These avoid passing Go stack pointers containing pointers into C.
 
+// A NULL merkle_buf disables the Merkle tree, and a non-NULL merkle_mac
+// selects the C AEGIS-MAC suite. The context keeps its own
+// copy of the Merkle configuration, so it may live on the C stack here.
+
 static int raf_do_create(int alg, void *ctx, void *box,
 	uint8_t *scratch_buf, size_t scratch_len,
+	uint8_t *merkle_buf, size_t merkle_len, uint64_t max_chunks, uint32_t hash_len, void *merkle_mac,
 	uint32_t chunk_size, uint8_t flags, const uint8_t *key)
 {
-	aegis_raf_io      io  = make_raf_io(box);
-	aegis_raf_rng     rng = make_raf_rng();
-	aegis_raf_scratch scr = { scratch_buf, scratch_len };
-	aegis_raf_config  cfg = { &scr, NULL, chunk_size, flags };
+	aegis_raf_io            io  = make_raf_io(box);
+	aegis_raf_rng           rng = make_raf_rng();
+	aegis_raf_scratch       scr = { scratch_buf, scratch_len };
+	aegis_raf_merkle_config m   = make_raf_merkle(box, merkle_mac, merkle_buf, merkle_len, max_chunks, hash_len);
+	aegis_raf_config        cfg = { &scr, merkle_buf != NULL ? &m : NULL, chunk_size, flags };
 	return raf_create(alg, ctx, &io, &rng, &cfg, key);
 }
 
 static int raf_do_open(int alg, void *ctx, void *box,
 	uint8_t *scratch_buf, size_t scratch_len,
+	uint8_t *merkle_buf, size_t merkle_len, uint64_t max_chunks, uint32_t hash_len, void *merkle_mac,
 	uint32_t chunk_size, const uint8_t *key)
 {
-	aegis_raf_io      io  = make_raf_io(box);
-	aegis_raf_rng     rng = make_raf_rng();
-	aegis_raf_scratch scr = { scratch_buf, scratch_len };
-	aegis_raf_config  cfg = { &scr, NULL, chunk_size, 0 };
+	aegis_raf_io            io  = make_raf_io(box);
+	aegis_raf_rng           rng = make_raf_rng();
+	aegis_raf_scratch       scr = { scratch_buf, scratch_len };
+	aegis_raf_merkle_config m   = make_raf_merkle(box, merkle_mac, merkle_buf, merkle_len, max_chunks, hash_len);
+	aegis_raf_config        cfg = { &scr, merkle_buf != NULL ? &m : NULL, chunk_size, 0 };
 	return raf_open(alg, ctx, &io, &rng, &cfg, key);
 }
 
@@ -255,13 +490,70 @@ static int raf_do_probe(void *box, aegis_raf_info *info) {
 	aegis_raf_io io = make_raf_io(box);
 	return aegis_raf_probe(&io, info);
 }
+
+// --- Concurrent workers ---
+// A worker runs one read or write on a worker context of the file's, set up
+// with its own scratch buffer and handle box, so that several can run at
+// once. Its file size is file_size, which covers every byte it is asked to
+// read or write, and the caller records the chunks it wrote afterwards. It
+// is closed, which zeroizes it, after every call.
+
+static int raf_worker_read(int alg, void *wctx, const void *ctx, void *box,
+	uint8_t *scratch_buf, size_t scratch_len, uint64_t file_size,
+	uint8_t *out, size_t *bytes_read, size_t len, uint64_t offset)
+{
+	aegis_raf_io      io  = make_raf_io(box);
+	aegis_raf_scratch scr = { scratch_buf, scratch_len };
+	int               ret;
+
+	if (raf_binding_worker_init(alg, wctx, ctx, &io, &scr, file_size) != 0) {
+		return -1;
+	}
+	ret = raf_read(alg, wctx, out, bytes_read, len, offset);
+	raf_close(alg, wctx);
+	return ret;
+}
+
+static int raf_worker_write(int alg, void *wctx, const void *ctx, void *box,
+	uint8_t *scratch_buf, size_t scratch_len, uint64_t file_size,
+	size_t *bytes_written, const uint8_t *in, size_t len, uint64_t offset)
+{
+	aegis_raf_io      io  = make_raf_io(box);
+	aegis_raf_scratch scr = { scratch_buf, scratch_len };
+	int               ret;
+
+	if (raf_binding_worker_init(alg, wctx, ctx, &io, &scr, file_size) != 0) {
+		return -1;
+	}
+	ret = raf_write(alg, wctx, bytes_written, in, len, offset);
+	raf_close(alg, wctx);
+	return ret;
+}
+
+static void raf_wipe_free(void *p, size_t len) {
+	volatile uint8_t *v = (volatile uint8_t *)p;
+	size_t i;
+	if (p == NULL) {
+		return;
+	}
+	for (i = 0; i < len; i++) { v[i] = 0; }
+	raf_aligned_free(p);
+}
 */
 import "C"
 
 import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/subtle"
 	"fmt"
 	"io"
+	"math"
 	"runtime/cgo"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"unsafe"
 
@@ -270,16 +562,42 @@ import (
 
 // File is an encrypted random-access file.
 //
-// A File is NOT safe for concurrent use from multiple goroutines.
-// This is stricter than *os.File (which is concurrent-safe at the kernel level).
-// If concurrent access is needed, callers must provide external synchronization.
+// A File is safe for concurrent use from multiple goroutines. By default its
+// operations are serialized, so the Store sees one call at a time. With
+// Options.Concurrent, ReadAt calls run in parallel, as do WriteAt calls to
+// different chunks that neither extend the file nor update a Merkle tree;
+// the Store must then be safe for concurrent use.
 type File struct {
+	mu         sync.RWMutex // exclusive for operations on ctx, shared for workers
+	concurrent bool
+	locks      *chunkLocks   // nil unless concurrent
+	parallel   int           // workers for one ReadAt or WriteAt
+	cache      *chunkCache   // nil unless Options.CacheSize is set
+	writes     atomic.Uint64 // WriteAt and Truncate calls that changed the file
+	workerMu   sync.Mutex
+	workers    []*worker // idle workers
+
 	ctx        unsafe.Pointer // C-allocated context (64-byte aligned, 512 bytes)
 	scratchBuf unsafe.Pointer // C-allocated scratch buffer
+	scratchLen C.size_t
 	handleBox  unsafe.Pointer // C-allocated uintptr_t box holding cgo.Handle
+	merkleBuf  unsafe.Pointer // C-allocated Merkle tree, nil if disabled
+	merkleMAC  unsafe.Pointer // C-allocated AEGIS-MAC suite state, or nil
+	merkleSize int            // Merkle hash size in bytes
+	merkleLen  int            // Merkle tree buffer size in bytes
+	merkleMax  uint64         // Merkle tree leaves
+	suite      string         // built-in Merkle suite, or empty
 	cbState    *callbackState // stashes Store callback errors
 	algID      C.int
 	chunkSize  int
+	ext        *extArea    // extension area, nil if the file has none
+	outer      Store       // store holding the extension area and the file
+	journal    *journal    // nil unless Options.Journal is set
+	broken     error       // set once a failed write leaves the context out of step
+	fresh      *freshState // nil unless the file has a freshness record
+	slots      *slotState  // nil unless the file has key slots
+	metaKey    []byte      // seals the metadata, nil without an extension area
+	readOnly   bool
 	closed     bool
 }
 
@@ -291,6 +609,14 @@ type resources struct {
 	box     unsafe.Pointer
 	handle  cgo.Handle
 	state   *callbackState
+
+	scratchLen      C.size_t
+	merkleBuf       unsafe.Pointer
+	merkleMAC       unsafe.Pointer
+	suite           string
+	merkleLen       C.size_t
+	merkleMaxChunks C.uint64_t
+	merkleHashLen   C.uint32_t
 }
 
 func (r *resources) free() {
@@ -306,13 +632,38 @@ func (r *resources) free() {
 	if r.scratch != nil {
 		C.raf_aligned_free(r.scratch)
 	}
+	if r.merkleBuf != nil {
+		C.free(r.merkleBuf)
+	}
+	C.raf_merkle_mac_free(r.merkleMAC)
+}
+
+// file wraps the resources in a File once the C context is initialized.
+func (r *resources) file(algID C.int, chunkSize int) *File {
+	return &File{
+		ctx:        r.ctx,
+		scratchBuf: r.scratch,
+		scratchLen: r.scratchLen,
+		handleBox:  r.box,
+		merkleBuf:  r.merkleBuf,
+		merkleMAC:  r.merkleMAC,
+		merkleSize: int(r.merkleHashLen),
+		merkleLen:  int(r.merkleLen),
+		merkleMax:  uint64(r.merkleMaxChunks),
+		suite:      r.suite,
+		cbState:    r.state,
+		algID:      algID,
+		chunkSize:  chunkSize,
+	}
 }
 
-// allocResources allocates the C-side resources needed for Create/Open.
-func allocResources(store Store, algID C.int, chunkSize int) (*resources, error) {
+// allocResources allocates the C-side resources needed for Create/Open,
+// including the Merkle tree buffer if merkle is not nil. hasher is the
+// result of merkle.hasher().
+func allocResources(store Store, algID C.int, chunkSize int, merkle *MerkleOptions, hasher MerkleHasher) (*resources, error) {
 	r := &resources{}
 
-	r.state = &callbackState{store: store}
+	r.state = &callbackState{store: store, merkle: hasher}
 	r.box = C.malloc(C.size_t(unsafe.Sizeof(C.uintptr_t(0))))
 	if r.box == nil {
 		return nil, fmt.Errorf("raf: failed to allocate handle box")
@@ -331,12 +682,38 @@ func allocResources(store Store, algID C.int, chunkSize int) (*resources, error)
 		r.free()
 		return nil, fmt.Errorf("raf: failed to compute scratch size")
 	}
+	r.scratchLen = scratchSize
 	r.scratch = C.raf_aligned_alloc(C.AEGIS_RAF_SCRATCH_ALIGN, scratchSize)
 	if r.scratch == nil {
 		r.free()
 		return nil, fmt.Errorf("raf: failed to allocate scratch buffer")
 	}
 
+	if merkle != nil {
+		r.suite = merkle.Suite
+		r.merkleMaxChunks = C.uint64_t(merkle.MaxChunks)
+		if hasher != nil {
+			r.merkleHashLen = C.uint32_t(hasher.Size())
+		} else {
+			r.merkleHashLen = C.RAF_MERKLE_MAC_SIZE
+			r.merkleMAC = C.raf_merkle_mac_new((*C.uint8_t)(&merkle.Key[0]))
+			if r.merkleMAC == nil {
+				r.free()
+				return nil, fmt.Errorf("raf: failed to allocate Merkle MAC state")
+			}
+		}
+		r.merkleLen = C.raf_merkle_buffer_size(r.merkleMaxChunks, r.merkleHashLen)
+		if r.merkleLen == 0 || r.merkleLen == C.SIZE_MAX {
+			r.free()
+			return nil, ErrBadMerkleConfig
+		}
+		r.merkleBuf = C.malloc(r.merkleLen)
+		if r.merkleBuf == nil {
+			r.free()
+			return nil, fmt.Errorf("raf: failed to allocate Merkle tree")
+		}
+	}
+
 	return r, nil
 }
 
@@ -347,11 +724,36 @@ func allocResources(store Store, algID C.int, chunkSize int) (*resources, error)
 // Create returns ErrExists. Stores smaller than HeaderSize are treated
 // as empty regardless of their contents.
 func Create(store Store, key []byte, opts *Options) (*File, error) {
+	if err := selfTest.Check(); err != nil {
+		return nil, err
+	}
+	return create(store, key, opts, nil)
+}
+
+// create creates a file. With kdf, the file has key slots whatever opts
+// says, and key is the key-encryption key kdf derived for the first.
+func create(store Store, key []byte, opts *Options, kdf *slotKDF) (*File, error) {
 	if opts == nil {
 		return nil, fmt.Errorf("raf: options are required for Create")
 	}
 
 	alg := opts.Algorithm
+	var kek []byte
+	if opts.KeySlots || kdf != nil {
+		if opts.ExtensionSize == 0 {
+			return nil, ErrNoExtensionArea
+		}
+		if kdf == nil {
+			kdf = &slotKDF{kind: slotKey}
+		}
+		if (len(key) != 16 && len(key) != 32) || alg.KeySize() == 0 {
+			return nil, ErrBadKeyLength
+		}
+		kek, key = key, make([]byte, alg.KeySize())
+		if _, err := rand.Read(key); err != nil {
+			return nil, fmt.Errorf("raf: %w", err)
+		}
+	}
 	if len(key) != alg.KeySize() {
 		return nil, ErrBadKeyLength
 	}
@@ -364,9 +766,49 @@ func Create(store Store, key []byte, opts *Options) (*File, error) {
 		return nil, ErrBadChunkSize
 	}
 
+	hasher, err := opts.Merkle.hasher()
+	if err != nil {
+		return nil, err
+	}
+	if opts.Metadata != nil && opts.ExtensionSize == 0 {
+		return nil, ErrNoExtensionArea
+	}
+	if opts.Freshness != nil {
+		if opts.ExtensionSize == 0 {
+			return nil, ErrNoExtensionArea
+		}
+		if opts.Merkle == nil {
+			return nil, ErrMerkleDisabled
+		}
+		if (opts.Merkle.Suite != MerkleSHA256 && opts.Merkle.Suite != MerkleSHA512_256) ||
+			opts.Merkle.MaxChunks > maxRecordedChunks {
+			return nil, ErrBadMerkleConfig
+		}
+	}
+
+	// The journal covers the whole store, so that a transaction can update
+	// the extension area along with the data.
+	var j *journal
+	if opts.Journal != nil {
+		if j, err = newJournal(store, opts.Journal, key, make([]byte, FileIDSize)); err != nil {
+			return nil, err
+		}
+		store = j
+	}
+
+	outer, ext := store, (*extArea)(nil)
+	if opts.ExtensionSize != 0 {
+		if ext, err = newExtArea(opts.ExtensionSize); err != nil {
+			return nil, err
+		}
+		if store, err = createExtArea(outer, ext, opts.Truncate); err != nil {
+			return nil, err
+		}
+	}
+
 	algID := C.int(cAlgID(alg))
 
-	r, err := allocResources(store, algID, chunkSize)
+	r, err := allocResources(store, algID, chunkSize, opts.Merkle, hasher)
 	if err != nil {
 		return nil, err
 	}
@@ -380,6 +822,7 @@ func Create(store Store, key []byte, opts *Options) (*File, error) {
 	r.state.lastErr = nil
 	ret, cerr := C.raf_do_create(algID, r.ctx, r.box,
 		(*C.uint8_t)(r.scratch), scratchSize,
+		(*C.uint8_t)(r.merkleBuf), r.merkleLen, r.merkleMaxChunks, r.merkleHashLen, r.merkleMAC,
 		C.uint32_t(chunkSize), flags, (*C.uint8_t)(&key[0]))
 	if ret != 0 {
 		e := mapErrno(cerr, r.state)
@@ -387,14 +830,67 @@ func Create(store Store, key []byte, opts *Options) (*File, error) {
 		return nil, e
 	}
 
-	return &File{
-		ctx:        r.ctx,
-		scratchBuf: r.scratch,
-		handleBox:  r.box,
-		cbState:    r.state,
-		algID:      algID,
-		chunkSize:  chunkSize,
-	}, nil
+	f := r.file(algID, chunkSize)
+	f.ext, f.outer = ext, outer
+	if j != nil {
+		// A record left from an earlier file would not authenticate with
+		// this file's identifier, but is dropped now rather than at Open.
+		C.raf_binding_file_id(f.algID, f.ctx, (*C.uint8_t)(&j.id[0]))
+		if err := j.clear(); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("raf: %w", err)
+		}
+		f.journal = j
+	}
+	if kek != nil {
+		if err := f.createKeySlots(kek, key, *kdf); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	if ext != nil {
+		if f.metaKey, err = metadataKey(key); err != nil {
+			f.Close()
+			return nil, err
+		}
+		if err := f.setMetadata(opts.Metadata); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	if opts.Freshness != nil {
+		if err := f.createFreshness(key); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	f.setOptions(opts)
+	return f, nil
+}
+
+// createExtArea empties store, the whole of which Create is about to
+// overwrite, writes an empty extension area at its start and returns the
+// store for the RAF data after it.
+func createExtArea(store Store, ext *extArea, truncate bool) (Store, error) {
+	size, err := store.GetSize()
+	if err != nil {
+		return nil, fmt.Errorf("raf: %w", err)
+	}
+	if size >= HeaderSize && !truncate {
+		return nil, ErrExists
+	}
+	b, err := ext.encode()
+	if err != nil {
+		return nil, err
+	}
+	if err := store.SetSize(0); err != nil {
+		return nil, fmt.Errorf("raf: %w", err)
+	}
+	if _, err := store.WriteAt(b, 0); err != nil {
+		return nil, fmt.Errorf("raf: %w", err)
+	}
+	ext.raw = b
+	return &offsetStore{s: store, off: int64(ext.size)}, nil
 }
 
 // Open opens an existing encrypted file.
@@ -402,50 +898,278 @@ func Create(store Store, key []byte, opts *Options) (*File, error) {
 //
 // If the key is wrong or the header has been tampered with, Open returns
 // ErrAuth. These two cases are indistinguishable by design.
+//
+// With Options.PreviousKey, a file that does not open with key but records
+// a File.Rekey to key that stopped partway is opened with the previous key
+// and the rotation finished before opening it. Without such a record, Open
+// returns ErrAuth.
 func Open(store Store, key []byte, opts *Options) (*File, error) {
-	// Probe the header to discover algorithm and chunk size.
-	info, err := Probe(store)
+	if err := selfTest.Check(); err != nil {
+		return nil, err
+	}
+	f, err := open(store, key, opts, openKey)
+	if err != ErrAuth || opts == nil || opts.PreviousKey == nil {
+		return f, err
+	}
+	// The header is still under the previous key. Either the rotation
+	// stopped before rewriting it, or a journal record holds the rewrite,
+	// which opening with the previous key applies.
+	old, err := open(store, opts.PreviousKey, &Options{Journal: opts.Journal}, openResume)
+	if err == nil {
+		var id, marker []byte
+		if id, err = old.ID(); err == nil {
+			marker, err = old.rekeyRecord()
+		}
+		if err == nil {
+			err = checkRekeyMarker(marker, id, key)
+		}
+		if err == nil {
+			err = old.Rekey(key, nil)
+		}
+		if cerr := old.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return nil, err
+		}
+	} else if err != ErrAuth {
+		return nil, err
+	}
+	return open(store, key, opts, openKey)
+}
+
+// CreateWithPassphrase creates a new encrypted file, as Create does, whose
+// file key is random and kept in a key slot that opens with passphrase.
+// The slot's key is derived with Argon2id and params, or the defaults if
+// params is nil, and a random salt, all kept in the slot. opts must set
+// ExtensionSize; KeySlots is implied.
+func CreateWithPassphrase(store Store, passphrase []byte, params *KDFParams, opts *Options) (*File, error) {
+	if err := selfTest.Check(); err != nil {
+		return nil, err
+	}
+	kdf, err := newPassphraseKDF(params)
+	if err != nil {
+		return nil, err
+	}
+	kek, err := kdf.derive(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	defer wipe(kek)
+	return create(store, kek, opts, &kdf)
+}
+
+// OpenWithPassphrase opens an existing file with a key slot for
+// passphrase, such as one made by CreateWithPassphrase or
+// File.AddPassphrase. It runs Argon2id with the parameters of each slot
+// that has them until one opens, so its cost grows with the number of
+// passphrase slots. A wrong passphrase returns ErrAuth, and a file without
+// key slots ErrNoKeySlots.
+func OpenWithPassphrase(store Store, passphrase []byte, opts *Options) (*File, error) {
+	if err := selfTest.Check(); err != nil {
+		return nil, err
+	}
+	return open(store, passphrase, opts, openPassphrase)
+}
+
+// openMode says what open is given.
+type openMode int
+
+const (
+	// openKey takes the file key, or a key-encryption key for a file with
+	// key slots.
+	openKey openMode = iota
+
+	// openPassphrase takes a passphrase for a file with key slots.
+	openPassphrase
+
+	// openResume is openKey skipping the freshness record, for a file
+	// whose chunks may be under two keys: Open finishes the rotation with
+	// it, and checks the record once it opens with the new key.
+	openResume
+)
+
+// open opens a file with key, which mode says what it is.
+func open(store Store, kek []byte, opts *Options, mode openMode) (*File, error) {
+	// A file with key slots is opened with a key-encryption key or a
+	// passphrase, which unwraps the file key. The journal is keyed by the
+	// file key, so the slots are first read from the store as it is,
+	// only to key the journal; the slots the File keeps are read once the
+	// journal has been recovered.
+	passphrase := mode == openPassphrase
+	key, slots, err := openKeySlots(store, kek, passphrase)
 	if err != nil {
 		return nil, err
 	}
 
+	// Until the File owns them, the journal and an unwrapped file key are
+	// wiped on every error.
+	var j *journal
+	fail := func(err error) (*File, error) {
+		if j != nil {
+			j.wipe()
+		}
+		if slots != nil {
+			wipe(key)
+		}
+		return nil, err
+	}
+
+	// Finish or drop a transaction a crash interrupted before anything
+	// reads the extension area or the header, which it may rewrite. Neither
+	// the area's size nor the file identifier ever changes.
+	if opts != nil && opts.Journal != nil {
+		off, err := extAreaSize(store)
+		if err != nil {
+			return fail(err)
+		}
+		id := make([]byte, FileIDSize)
+		if _, err := store.ReadAt(id, off+headerIDOffset); err != nil && err != io.EOF {
+			return fail(fmt.Errorf("raf: %w", err))
+		}
+		if j, err = newJournal(store, opts.Journal, key, id); err != nil {
+			return fail(err)
+		}
+		applied, err := j.recover()
+		if err != nil {
+			return fail(err)
+		}
+		store = j
+		// The record may have rewritten the extension area, key slots
+		// included.
+		if applied {
+			k, s, err := openKeySlots(store, kek, passphrase)
+			if err != nil {
+				return fail(err)
+			}
+			if !bytes.Equal(k, key) {
+				err = j.rekey(k)
+			}
+			if slots != nil {
+				wipe(key)
+			}
+			key, slots = k, s
+			if err != nil {
+				return fail(err)
+			}
+		}
+	}
+
+	outer := store
+	store, ext, err := dataStore(outer)
+	if err != nil {
+		return fail(err)
+	}
+
+	// Probe the header to discover algorithm and chunk size.
+	info, err := probe(store)
+	if err != nil {
+		return fail(err)
+	}
+
 	alg := info.Algorithm
 	if len(key) != alg.KeySize() {
-		return nil, ErrBadKeyLength
+		return fail(ErrBadKeyLength)
+	}
+
+	var merkle *MerkleOptions
+	var expect *Freshness
+	var previous []byte
+	if opts != nil {
+		merkle, expect, previous = opts.Merkle, opts.Freshness, opts.PreviousKey
+	}
+	var recs [freshnessCopies]*freshnessRecord
+	fresh := false
+	if mode == openResume {
+		merkle, expect = nil, nil
+	} else if ext != nil && ext.entries[extFreshness] != nil {
+		if recs, err = parseFreshnessEntry(ext.entries[extFreshness]); err != nil {
+			return fail(err)
+		}
+		// The Merkle tree is set up from a copy of the record that
+		// verifies and fits the file. Without one, the file is opened
+		// only to tell a wrong key from a stale record, and openFreshness
+		// rejects it.
+		fresh = true
+		rec, err := configRecord(store, recs, key, previous, info)
+		if err != nil {
+			return fail(err)
+		}
+		if rec != nil {
+			if merkle == nil {
+				merkle = &MerkleOptions{Suite: rec.suite, MaxChunks: rec.maxChunks}
+			} else if merkle.Suite != rec.suite || merkle.MaxChunks != rec.maxChunks {
+				return fail(ErrBadMerkleConfig)
+			}
+		}
+	} else if expect != nil {
+		return fail(ErrNoFreshness)
+	}
+	hasher, err := merkle.hasher()
+	if err != nil {
+		return fail(err)
 	}
 
 	algID := C.int(cAlgID(alg))
 	chunkSize := info.ChunkSize
 
-	r, err := allocResources(store, algID, chunkSize)
+	r, err := allocResources(store, algID, chunkSize, merkle, hasher)
 	if err != nil {
-		return nil, err
+		return fail(err)
 	}
 
 	scratchSize := C.raf_scratch_size(algID, C.uint32_t(chunkSize))
 	r.state.lastErr = nil
 	ret, cerr := C.raf_do_open(algID, r.ctx, r.box,
 		(*C.uint8_t)(r.scratch), scratchSize,
+		(*C.uint8_t)(r.merkleBuf), r.merkleLen, r.merkleMaxChunks, r.merkleHashLen, r.merkleMAC,
 		C.uint32_t(chunkSize), (*C.uint8_t)(&key[0]))
 	if ret != 0 {
 		e := mapErrno(cerr, r.state)
 		r.free()
-		return nil, e
+		return fail(e)
 	}
 
-	return &File{
-		ctx:        r.ctx,
-		scratchBuf: r.scratch,
-		handleBox:  r.box,
-		cbState:    r.state,
-		algID:      algID,
-		chunkSize:  chunkSize,
-	}, nil
+	f := r.file(algID, chunkSize)
+	f.ext, f.outer, f.journal = ext, outer, j
+	if slots != nil {
+		f.slots = &slotState{key: key, slots: slots}
+	}
+	if ext != nil {
+		if f.metaKey, err = metadataKey(key); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	if fresh {
+		if err := f.openFreshness(key, previous, ext.entries[extFreshness], recs, expect); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	f.setOptions(opts)
+	return f, nil
 }
 
 // Probe reads the file header without decrypting or verifying the MAC.
 // Useful to discover the algorithm and chunk size before opening.
 func Probe(store Store) (*FileInfo, error) {
+	inner, ext, err := dataStore(store)
+	if err != nil {
+		return nil, err
+	}
+	info, err := probe(inner)
+	if err != nil {
+		return nil, err
+	}
+	if ext != nil {
+		info.ExtensionSize = ext.size
+	}
+	return info, nil
+}
+
+// probe reads the RAF header at the start of store.
+func probe(store Store) (*FileInfo, error) {
 	// Set up a temporary I/O bridge for the probe call.
 	state := &callbackState{store: store}
 	box := C.malloc(C.size_t(unsafe.Sizeof(C.uintptr_t(0))))
@@ -475,16 +1199,35 @@ func Probe(store Store) (*FileInfo, error) {
 // ReadAt reads len(p) plaintext bytes starting at byte offset off.
 // Implements io.ReaderAt.
 func (f *File) ReadAt(p []byte, off int64) (int, error) {
+	if f.concurrent {
+		return f.readAtShared(p, off)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
 	if f.closed {
 		return 0, ErrClosed
 	}
+	if f.broken != nil {
+		return 0, f.broken
+	}
 	if off < 0 {
 		return 0, ErrNegativeOffset
 	}
 	if len(p) == 0 {
 		return 0, nil
 	}
+	if f.cache != nil {
+		return f.cache.read(p, off)
+	}
+	return f.readAt(p, off)
+}
 
+// readAt reads p at off through the file's context or, with Parallelism,
+// its workers.
+func (f *File) readAt(p []byte, off int64) (int, error) {
+	if f.parallel > 1 {
+		return f.readWorkers(p, off, false)
+	}
 	f.cbState.lastErr = nil
 	var bytesRead C.size_t
 	ret, cerr := C.raf_read(f.algID, f.ctx, (*C.uint8_t)(&p[0]), &bytesRead,
@@ -502,16 +1245,117 @@ func (f *File) ReadAt(p []byte, off int64) (int, error) {
 // WriteAt writes len(p) plaintext bytes starting at byte offset off.
 // Implements io.WriterAt.
 func (f *File) WriteAt(p []byte, off int64) (int, error) {
+	if f.concurrent && f.journal == nil {
+		if n, ok, err := f.writeAtShared(p, off); ok {
+			if n > 0 {
+				f.writes.Add(1)
+			}
+			return n, err
+		}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
 	if f.closed {
 		return 0, ErrClosed
 	}
+	if f.readOnly {
+		return 0, ErrReadOnly
+	}
+	if f.broken != nil {
+		return 0, f.broken
+	}
 	if off < 0 {
 		return 0, ErrNegativeOffset
 	}
 	if len(p) == 0 {
 		return 0, nil
 	}
+	if err := f.markDirty(); err != nil {
+		return 0, err
+	}
+	f.begin()
+	var n int
+	var err error
+	if f.cache != nil {
+		n, err = f.cache.write(p, off)
+		if err == nil && f.journal != nil {
+			// Evictions may have written back part of p already, so the
+			// rest goes in the same transaction rather than a later one.
+			err = f.cache.flush()
+		}
+	} else {
+		n, err = f.writeAt(p, off)
+	}
+	if n > 0 {
+		f.writes.Add(1)
+	}
+	return n, f.end(err)
+}
+
+// writeAt writes p at off through the file's context or, with
+// Parallelism, its workers.
+func (f *File) writeAt(p []byte, off int64) (int, error) {
+	if f.parallel > 1 && f.journal == nil {
+		if n, ok, err := f.writeParallel(p, off); ok {
+			return n, err
+		}
+	}
+	return f.writeSerial(p, off)
+}
+
+// The chunk cache reads and writes through the File's unlocked paths.
+func (f *File) readChunks(p []byte, off int64) (int, error)  { return f.readAt(p, off) }
+func (f *File) writeChunks(p []byte, off int64) (int, error) { return f.writeAt(p, off) }
+func (f *File) storedSize() (int64, error)                   { return f.size() }
+
+// flush writes back the dirty chunks of the cache, if there is one.
+func (f *File) flush() error {
+	if f.cache == nil {
+		return nil
+	}
+	if f.broken != nil {
+		return f.broken
+	}
+	f.begin()
+	return f.end(f.cache.flush())
+}
+
+// begin starts a transaction if the file has a journal, or joins the one
+// under way. Every begin must be followed by end.
+func (f *File) begin() {
+	if f.journal != nil {
+		f.journal.begin()
+	}
+}
+
+// end finishes a transaction begun by begin. The outermost call commits it
+// if err is nil, and otherwise drops it; either way, a transaction that
+// fails after changing something breaks the file, since its context may
+// not match the store any more. end returns err, or the commit error.
+func (f *File) end(err error) error {
+	if f.journal == nil {
+		return err
+	}
+	// The freshness record changes with the data it describes.
+	if err == nil && f.fresh != nil && !f.readOnly &&
+		(f.journal.pending() || (f.journal.depth == 1 && f.fresh.rec.dirty)) {
+		err = f.writeFreshness(false)
+	}
+	written, cerr := f.journal.end(err == nil)
+	if cerr != nil {
+		err = cerr
+	}
+	if err != nil && written {
+		f.broken = fmt.Errorf("%w: %v", ErrTransaction, err)
+		if cerr != nil {
+			return f.broken
+		}
+	}
+	return err
+}
 
+// writeSerial writes p at off through the file's own context.
+func (f *File) writeSerial(p []byte, off int64) (int, error) {
 	f.cbState.lastErr = nil
 	var bytesWritten C.size_t
 	ret, cerr := C.raf_write(f.algID, f.ctx, &bytesWritten,
@@ -524,51 +1368,109 @@ func (f *File) WriteAt(p []byte, off int64) (int, error) {
 
 // Truncate changes the logical plaintext size.
 func (f *File) Truncate(size int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
 	if f.closed {
 		return ErrClosed
 	}
+	if f.readOnly {
+		return ErrReadOnly
+	}
+	if f.broken != nil {
+		return f.broken
+	}
 	if size < 0 {
 		return ErrNegativeOffset
 	}
-	f.cbState.lastErr = nil
+	if err := f.markDirty(); err != nil {
+		return err
+	}
+	f.begin()
+	err := f.end(f.truncate(size))
+	if err == nil {
+		f.writes.Add(1)
+	}
+	return err
+}
+
+func (f *File) truncate(size int64) error {
+	if err := f.flush(); err != nil {
+		return err
+	}
+	f.cbState.lastErr = nil
 	ret, cerr := C.raf_truncate(f.algID, f.ctx, C.uint64_t(size))
 	if ret != 0 {
 		return mapErrno(cerr, f.cbState)
 	}
+	if f.cache != nil {
+		f.cache.truncate(size)
+	}
 	return nil
 }
 
-// Size returns the current logical plaintext size.
+// Size returns the current logical plaintext size, including data still
+// held in the chunk cache.
 func (f *File) Size() (int64, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if f.cache != nil && !f.closed {
+		return f.cache.size, nil
+	}
+	return f.size()
+}
+
+func (f *File) size() (int64, error) {
 	if f.closed {
 		return 0, ErrClosed
 	}
 	var size C.uint64_t
 	ret, cerr := C.raf_get_size(f.algID, f.ctx, &size)
 	if ret != 0 {
-		return 0, mapErrno(cerr, f.cbState)
+		return 0, mapErrno(cerr, nil)
 	}
 	return int64(size), nil
 }
 
-// Sync flushes writes to the backing store.
+// Sync writes back the chunk cache and flushes writes to the backing store.
 func (f *File) Sync() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.sync()
+}
+
+func (f *File) sync() error {
 	if f.closed {
 		return ErrClosed
 	}
+	if f.broken != nil {
+		return f.broken
+	}
+	if err := f.flush(); err != nil {
+		return err
+	}
 	f.cbState.lastErr = nil
 	ret, cerr := C.raf_sync(f.algID, f.ctx)
 	if ret != 0 {
 		return mapErrno(cerr, f.cbState)
 	}
-	return nil
+	return f.checkpoint()
 }
 
-// Close flushes, zeroizes keys, and releases all C-allocated resources.
+// Close writes back the chunk cache, flushes, zeroizes keys and cached
+// plaintext, and releases all C-allocated resources.
 func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
 	if f.closed {
 		return ErrClosed
 	}
+	flushErr := f.flush()
+	if flushErr == nil {
+		flushErr = f.checkpoint()
+	}
+	if f.cache != nil {
+		f.cache.clear()
+	}
 	f.closed = true
 
 	// Sync at the Go level so we can report failures.
@@ -581,32 +1483,1143 @@ func (f *File) Close() error {
 	// The C close function zeroizes the context.
 	C.raf_close(f.algID, f.ctx)
 
-	// Free C-allocated resources.
+	// Free C-allocated resources. No worker is in use while f.mu is held.
+	for _, w := range f.workers {
+		w.free(f.scratchLen)
+	}
+	f.workers = nil
 	handle := cgo.Handle(*(*C.uintptr_t)(f.handleBox))
 	handle.Delete()
 	C.free(f.handleBox)
 	C.raf_aligned_free(f.ctx)
 	C.raf_aligned_free(f.scratchBuf)
+	if f.merkleBuf != nil {
+		C.free(f.merkleBuf)
+	}
+	C.raf_merkle_mac_free(f.merkleMAC)
+	if f.journal != nil {
+		f.journal.wipe()
+	}
+	if f.fresh != nil {
+		wipe(f.fresh.key)
+	}
+	if f.slots != nil {
+		wipe(f.slots.key)
+	}
+	wipe(f.metaKey)
 
 	f.ctx = nil
 	f.scratchBuf = nil
+	f.merkleBuf = nil
+	f.merkleMAC = nil
 	f.handleBox = nil
 	f.cbState = nil
 
+	if flushErr != nil {
+		return flushErr
+	}
 	if syncErr != nil {
 		return fmt.Errorf("raf: %w", syncErr)
 	}
 	return nil
 }
 
+// ID returns the random identifier the file was given at Create. It stays
+// the same for the file's lifetime, whatever is written to it.
+func (f *File) ID() ([]byte, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if f.closed {
+		return nil, ErrClosed
+	}
+	id := make([]byte, FileIDSize)
+	C.raf_binding_file_id(f.algID, f.ctx, (*C.uint8_t)(&id[0]))
+	return id, nil
+}
+
+// freshnessMerkle returns the Merkle suite and MaxChunks of a file with a
+// freshness record, which Open set up the tree with from a copy of the
+// record that verified, and false for a file without one.
+func (f *File) freshnessMerkle() (string, uint64, bool) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if f.fresh == nil {
+		return "", 0, false
+	}
+	return f.suite, f.merkleMax, true
+}
+
+// generation changes after every write to the file that wrote something,
+// so that a Cursor can tell whether the plaintext it read ahead is still
+// current.
+func (f *File) generation() uint64 {
+	return f.writes.Load()
+}
+
+// CacheStats returns the activity of the chunk cache, which is all zero
+// for a file opened without Options.CacheSize.
+func (f *File) CacheStats() CacheStats {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.cache == nil {
+		return CacheStats{}
+	}
+	return f.cache.statistics()
+}
+
 // Info returns metadata about the open file.
 func (f *File) Info() FileInfo {
 	size, _ := f.Size()
-	return FileInfo{
+	info := FileInfo{
 		Size:      size,
 		ChunkSize: f.chunkSize,
 		Algorithm: algFromCID(int(f.algID)),
 	}
+	if f.ext != nil {
+		info.ExtensionSize = f.ext.size
+	}
+	return info
+}
+
+// MerkleCommitment returns the commitment to the file's current plaintext:
+// the root of the Merkle tree bound to the file's header parameters,
+// identifier and size. It returns ErrMerkleDisabled if the file was not
+// created or opened with Options.Merkle.
+func (f *File) MerkleCommitment() ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.flush(); err != nil {
+		return nil, err
+	}
+	return f.merkleCommitment()
+}
+
+func (f *File) merkleCommitment() ([]byte, error) {
+	if f.closed {
+		return nil, ErrClosed
+	}
+	if f.merkleBuf == nil {
+		return nil, ErrMerkleDisabled
+	}
+	out := make([]byte, f.merkleSize)
+	f.cbState.lastErr = nil
+	ret, cerr := C.raf_merkle_commitment(f.algID, f.ctx, (*C.uint8_t)(&out[0]), C.size_t(len(out)))
+	if ret != 0 {
+		return nil, mapErrno(cerr, f.cbState)
+	}
+	return out, nil
+}
+
+// MerkleVerify decrypts every chunk and checks it, and every inner node,
+// against the in-memory Merkle tree. On a mismatch it returns ErrAuth along
+// with the index of the first corrupted chunk, or -1 if the chunks are
+// intact but the inner nodes are not. On success it returns -1 and nil.
+func (f *File) MerkleVerify() (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return -1, ErrClosed
+	}
+	if err := f.flush(); err != nil {
+		return -1, err
+	}
+	if f.merkleBuf == nil {
+		return -1, ErrMerkleDisabled
+	}
+	f.cbState.lastErr = nil
+	corrupted := C.uint64_t(math.MaxUint64)
+	ret, cerr := C.raf_merkle_verify(f.algID, f.ctx, &corrupted)
+	if ret != 0 {
+		idx := int64(-1)
+		if corrupted != math.MaxUint64 {
+			idx = int64(corrupted)
+		}
+		return idx, mapErrno(cerr, f.cbState)
+	}
+	return -1, nil
+}
+
+// MerkleRebuild recomputes the Merkle tree from the file's contents,
+// decrypting every chunk. Call it after Open to make the tree, and so
+// MerkleCommitment, describe the existing data.
+func (f *File) MerkleRebuild() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return ErrClosed
+	}
+	if err := f.flush(); err != nil {
+		return err
+	}
+	if f.merkleBuf == nil {
+		return ErrMerkleDisabled
+	}
+	f.cbState.lastErr = nil
+	ret, cerr := C.raf_merkle_rebuild(f.algID, f.ctx)
+	if ret != 0 {
+		return mapErrno(cerr, f.cbState)
+	}
+	return nil
+}
+
+// ChunkProof returns a proof that chunk index belongs to the file with the
+// current MerkleCommitment. The sibling hashes are taken from the in-memory
+// tree, so after Open the tree must have been rebuilt with MerkleRebuild.
+func (f *File) ChunkProof(index uint64) (*ChunkProof, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return nil, ErrClosed
+	}
+	if err := f.flush(); err != nil {
+		return nil, err
+	}
+	if f.merkleBuf == nil {
+		return nil, ErrMerkleDisabled
+	}
+	size, err := f.size()
+	if err != nil {
+		return nil, err
+	}
+	if index >= uint64(FileInfo{Size: size, ChunkSize: f.chunkSize}.NumChunks()) {
+		return nil, ErrChunkIndex
+	}
+
+	var hdr [HeaderSize]byte
+	if _, err := f.cbState.store.ReadAt(hdr[:], 0); err != nil {
+		return nil, fmt.Errorf("raf: %w", err)
+	}
+
+	// The buffer holds each level in turn, leaves first.
+	tree := unsafe.Slice((*byte)(f.merkleBuf), f.merkleLen)
+	proof := &ChunkProof{
+		Suite:       f.suite,
+		MaxChunks:   f.merkleMax,
+		FileSize:    uint64(size),
+		FileContext: commitmentContext(hdr[:]),
+	}
+	hashLen := uint64(f.merkleSize)
+	var levelOff uint64
+	for idx, count := index, f.merkleMax; count > 1; idx, count = idx/2, (count+1)/2 {
+		if sibling := idx ^ 1; sibling < count {
+			off := levelOff + sibling*hashLen
+			proof.Siblings = append(proof.Siblings, append([]byte(nil), tree[off:off+hashLen]...))
+		}
+		levelOff += count * hashLen
+	}
+	return proof, nil
+}
+
+// Sign stores a publisher signature over the file's header and Merkle
+// commitment in its extension area, replacing any previous one. The file
+// must have been created with Options.ExtensionSize and use the
+// MerkleSHA256 or MerkleSHA512_256 suite with a MaxChunks of at most 1<<24,
+// and after Open the tree must have
+// been rebuilt with MerkleRebuild. Any later write invalidates the
+// signature until the file is signed again.
+func (f *File) Sign(priv ed25519.PrivateKey) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return ErrClosed
+	}
+	if f.ext == nil {
+		return ErrNoExtensionArea
+	}
+	if f.merkleBuf == nil {
+		return ErrMerkleDisabled
+	}
+	if (f.suite != MerkleSHA256 && f.suite != MerkleSHA512_256) || f.merkleMax > maxRecordedChunks {
+		return ErrBadMerkleConfig
+	}
+	if len(priv) != ed25519.PrivateKeySize {
+		return fmt.Errorf("raf: invalid Ed25519 private key")
+	}
+
+	// Sync first so that the header on the store carries the current size.
+	if err := f.sync(); err != nil {
+		return err
+	}
+	hdr, commitment, err := f.signedState()
+	if err != nil {
+		return err
+	}
+	e := &signatureEntry{suite: f.suite, maxChunks: f.merkleMax}
+	e.sig = ed25519.Sign(priv, e.signedMessage(hdr, commitment))
+	return f.ext.set(f.outer, extSignature, e.marshal())
+}
+
+// signedState returns the header and commitment a signature covers.
+func (f *File) signedState() ([]byte, []byte, error) {
+	hdr := make([]byte, HeaderSize)
+	if _, err := f.cbState.store.ReadAt(hdr, 0); err != nil {
+		return nil, nil, fmt.Errorf("raf: %w", err)
+	}
+	commitment, err := f.merkleCommitment()
+	if err != nil {
+		return nil, nil, err
+	}
+	return hdr, commitment, nil
+}
+
+// OpenVerified opens a file signed with File.Sign and checks the signature
+// against publicKey. It decrypts every chunk to rebuild the Merkle tree, so
+// a successful open means the whole file is exactly what the publisher
+// signed. The returned File is read-only: WriteAt and Truncate return
+// ErrReadOnly.
+//
+// OpenVerified returns ErrNotSigned if the file has no signature,
+// ErrBadSignature if the signature does not match or records a Merkle tree
+// out of proportion to the file, and ErrAuth if the key is wrong or a chunk
+// has been tampered with.
+func OpenVerified(store Store, key []byte, publicKey ed25519.PublicKey) (*File, error) {
+	if len(publicKey) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("raf: invalid Ed25519 public key")
+	}
+	ext, err := readExtArea(store)
+	if err != nil {
+		return nil, err
+	}
+	if ext == nil || ext.entries[extSignature] == nil {
+		return nil, ErrNotSigned
+	}
+	e, err := parseSignatureEntry(ext.entries[extSignature])
+	if err != nil {
+		return nil, err
+	}
+	// The tree is set up before anything is authenticated, so its size is
+	// bounded by the file it is for.
+	info, err := Probe(store)
+	if err != nil {
+		return nil, err
+	}
+	if !recordedChunksOK(e.maxChunks, info.Size, info.ChunkSize) {
+		return nil, ErrBadSignature
+	}
+
+	f, err := Open(store, key, &Options{Merkle: &MerkleOptions{Suite: e.suite, MaxChunks: e.maxChunks}})
+	if err != nil {
+		return nil, err
+	}
+	if err := f.MerkleRebuild(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	hdr, commitment, err := f.signedState()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if !ed25519.Verify(publicKey, e.signedMessage(hdr, commitment), e.sig) {
+		f.Close()
+		return nil, ErrBadSignature
+	}
+	f.readOnly = true
+	return f, nil
+}
+
+// freshState is a File's freshness key, the record last written and the
+// Merkle commitment it holds the tag of, nil while it is dirty, and the
+// copies in the entry, nil where one did not verify.
+type freshState struct {
+	key        []byte
+	rec        freshnessRecord
+	commitment []byte
+	copies     [freshnessCopies]*freshnessRecord
+	entry      []byte
+}
+
+// rank orders copy i by age, a copy that did not verify being the oldest.
+func (s *freshState) rank(i int) int64 {
+	if s.copies[i] == nil {
+		return -1
+	}
+	return int64(s.copies[i].order())
+}
+
+// Version returns the version in the file's freshness record and the
+// Merkle commitment recorded with it. The version grows with each Sync or
+// Close that follows changes, and with a journal with each change. Keep
+// both where the file's store cannot be rolled back along with it, and
+// pass them to Open in Options.Freshness. The commitment is nil while
+// changes are not yet recorded, and Open does not hold the file to the
+// version until they are.
+func (f *File) Version() (uint64, []byte, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if f.closed {
+		return 0, nil, ErrClosed
+	}
+	if f.fresh == nil {
+		return 0, nil, ErrNoFreshness
+	}
+	return f.fresh.rec.version, append([]byte(nil), f.fresh.commitment...), nil
+}
+
+// createFreshness gives a new file its first, clean, record at version 0.
+func (f *File) createFreshness(key []byte) error {
+	fkey, err := freshnessKey(key)
+	if err != nil {
+		return err
+	}
+	f.fresh = &freshState{key: fkey, rec: freshnessRecord{dirty: true, suite: f.suite, maxChunks: f.merkleMax}}
+	r, commitment, err := f.nextFreshness(false)
+	entry := bytes.Repeat(r.marshal(), freshnessCopies)
+	if err == nil {
+		err = f.ext.set(f.outer, extFreshness, entry)
+	}
+	if err != nil {
+		wipe(fkey)
+		f.fresh = nil
+		return err
+	}
+	f.fresh.rec, f.fresh.commitment, f.fresh.entry = r, commitment, entry
+	for i := range f.fresh.copies {
+		f.fresh.copies[i] = &r
+	}
+	if err := f.outer.Sync(); err != nil {
+		return fmt.Errorf("raf: %w", err)
+	}
+	return nil
+}
+
+// openFreshness checks an opened file against its freshness record and
+// against what the caller expects, rebuilding the Merkle tree to do so.
+// The newest copy that verifies is the record, and the file must match it
+// unless it is dirty and a copy of the clean record it follows verifies
+// too. A record still under previous, the key before a rotation, is
+// accepted and rewritten under key.
+func (f *File) openFreshness(key, previous []byte, entry []byte, recs [freshnessCopies]*freshnessRecord, expect *Freshness) error {
+	fkey, err := freshnessKey(key)
+	if err != nil {
+		return err
+	}
+	var pkey []byte
+	if previous != nil {
+		if pkey, err = freshnessKey(previous); err != nil {
+			wipe(fkey)
+			return err
+		}
+		defer wipe(pkey)
+	}
+	id := make([]byte, FileIDSize)
+	C.raf_binding_file_id(f.algID, f.ctx, (*C.uint8_t)(&id[0]))
+	s := &freshState{key: fkey, entry: append([]byte(nil), entry...)}
+	var old [freshnessCopies]bool
+	var rec *freshnessRecord
+	newest := 0
+	for i, r := range recs {
+		if r == nil || r.suite != f.suite || r.maxChunks != f.merkleMax {
+			continue
+		}
+		if r.verify(fkey, id) != nil {
+			if pkey == nil || r.verify(pkey, id) != nil {
+				continue
+			}
+			old[i] = true
+		}
+		s.copies[i] = r
+		if rec == nil || r.order() > rec.order() {
+			rec, newest = r, i
+		}
+	}
+	if rec == nil {
+		wipe(fkey)
+		return ErrStale
+	}
+	rewrite := old[newest]
+	// A dirty record means the file was changed and not closed: whatever
+	// mix of chunks a crash left is expected, and only the version can be
+	// checked. That is only so if the clean record before it is there.
+	if rec.dirty {
+		found := false
+		for i, r := range s.copies {
+			if r != nil && !r.dirty && r.version+1 == rec.version {
+				found, rewrite = true, rewrite || old[i]
+			}
+		}
+		if !found {
+			wipe(fkey)
+			return ErrStale
+		}
+	}
+	f.cbState.lastErr = nil
+	if ret, cerr := C.raf_merkle_rebuild(f.algID, f.ctx); ret != 0 {
+		wipe(fkey)
+		return mapErrno(cerr, f.cbState)
+	}
+	if !rec.dirty {
+		size, err := f.size()
+		if err != nil {
+			wipe(fkey)
+			return err
+		}
+		commitment, err := f.merkleCommitment()
+		if err != nil {
+			wipe(fkey)
+			return err
+		}
+		tkey := fkey
+		if old[newest] {
+			tkey = pkey
+		}
+		tag, err := commitmentTag(tkey, commitment)
+		if err != nil {
+			wipe(fkey)
+			return err
+		}
+		if uint64(size) != rec.size || subtle.ConstantTimeCompare(tag, rec.tag) != 1 {
+			wipe(fkey)
+			return ErrStale
+		}
+		s.commitment = commitment
+	}
+	if err := expect.check(rec, s.commitment); err != nil {
+		wipe(fkey)
+		return err
+	}
+	s.rec = *rec
+	// Copies under the previous key are as good as torn to the new one.
+	for i := range s.copies {
+		if old[i] {
+			s.copies[i] = nil
+		}
+	}
+	f.fresh = s
+	if rewrite {
+		if err := f.writeFreshness(false); err != nil {
+			wipe(fkey)
+			f.fresh = nil
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFreshness writes a dirty record with the next version over the
+// oldest copy in the entry, or a clean one for the file as it is now over
+// every copy, oldest first, syncing after each.
+func (f *File) writeFreshness(dirty bool) error {
+	r, commitment, err := f.nextFreshness(dirty)
+	if err != nil {
+		return err
+	}
+	s := f.fresh
+	order := []int{0, 1, 2}
+	sort.SliceStable(order, func(a, b int) bool { return s.rank(order[a]) < s.rank(order[b]) })
+	if dirty {
+		order = order[:1]
+	}
+	m := r.marshal()
+	for _, i := range order {
+		copy(s.entry[i*len(m):], m)
+		if err := f.ext.set(f.outer, extFreshness, append([]byte(nil), s.entry...)); err != nil {
+			return err
+		}
+		s.copies[i] = &r
+		if err := f.outer.Sync(); err != nil {
+			return fmt.Errorf("raf: %w", err)
+		}
+	}
+	s.rec, s.commitment = r, commitment
+	return nil
+}
+
+// nextFreshness returns the record that follows the last one, dirty with
+// the next version, or clean for the file as it is now along with its
+// Merkle commitment. A clean record after a dirty one keeps its version.
+func (f *File) nextFreshness(dirty bool) (freshnessRecord, []byte, error) {
+	r := f.fresh.rec
+	if !r.dirty {
+		r.version++
+	}
+	r.dirty, r.size, r.tag = dirty, 0, nil
+	var commitment []byte
+	if !dirty {
+		size, err := f.size()
+		if err != nil {
+			return r, nil, err
+		}
+		if commitment, err = f.merkleCommitment(); err != nil {
+			return r, nil, err
+		}
+		if r.tag, err = commitmentTag(f.fresh.key, commitment); err != nil {
+			return r, nil, err
+		}
+		r.size = uint64(size)
+	}
+	id := make([]byte, FileIDSize)
+	C.raf_binding_file_id(f.algID, f.ctx, (*C.uint8_t)(&id[0]))
+	mac, err := r.sum(f.fresh.key, id)
+	if err != nil {
+		return r, nil, err
+	}
+	r.mac = mac
+	return r, commitment, nil
+}
+
+// markDirty makes the freshness record dirty before the first change since
+// it was last clean. A journaled file records each change with it instead.
+func (f *File) markDirty() error {
+	if f.fresh == nil || f.fresh.rec.dirty || f.journal != nil {
+		return nil
+	}
+	return f.writeFreshness(true)
+}
+
+// rekeyBatch is roughly how many bytes of chunks Rekey re-encrypts between
+// syncs and progress calls, and with a journal, in one transaction.
+const rekeyBatch = 1 << 20
+
+// Rekey re-encrypts the file in place under newKey, which must have the
+// size the algorithm requires. Each chunk gets a fresh nonce; the file
+// identifier, size and plaintext stay the same. The header is rewritten
+// last, after every chunk, and from then on the file opens with newKey
+// only. Rekey does not preserve a publisher signature, which covers the
+// header: sign the file again.
+//
+// progress, if not nil, is called after each batch of chunks with the
+// number of chunks done and the total. If it returns an error, Rekey stops
+// and returns that error.
+//
+// Rekey records the rotation before it changes any chunk, in the extension
+// area, or just past the last chunk for a file without one. A rotation
+// that stops partway, because of an error, progress or a crash, leaves
+// chunks under both keys. The File then returns ErrRekeyIncomplete, and
+// Open with newKey and Options.PreviousKey set to the old key finishes the
+// rotation, reading every chunk again to find those left to do; until
+// then, the file must not be written to with the old key. Without a
+// journal, a crash while a chunk is being rewritten can leave it readable
+// under neither key, as a crash during WriteAt can; with Options.Journal
+// each batch is atomic.
+func (f *File) Rekey(newKey []byte, progress func(done, total int64) error) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return ErrClosed
+	}
+	if f.readOnly {
+		return ErrReadOnly
+	}
+	if f.broken != nil {
+		return f.broken
+	}
+	if f.slots != nil {
+		return ErrKeySlotRekey
+	}
+	if len(newKey) != algFromCID(int(f.algID)).KeySize() {
+		return ErrBadKeyLength
+	}
+	if err := f.flush(); err != nil {
+		return err
+	}
+	if err := f.markDirty(); err != nil {
+		return err
+	}
+	if err := f.rekey(newKey, progress); err != nil {
+		if f.broken == nil {
+			f.broken = fmt.Errorf("%w: %v", ErrRekeyIncomplete, err)
+		}
+		return err
+	}
+	return nil
+}
+
+func (f *File) rekey(newKey []byte, progress func(done, total int64) error) error {
+	size, err := f.size()
+	if err != nil {
+		return err
+	}
+	cs := int64(f.chunkSize)
+	total := (size + cs - 1) / cs
+
+	id := make([]byte, FileIDSize)
+	C.raf_binding_file_id(f.algID, f.ctx, (*C.uint8_t)(&id[0]))
+	marker, err := rekeyMarker(newKey, id)
+	if err != nil {
+		return err
+	}
+	if err := f.setRekeyRecord(marker); err != nil {
+		return err
+	}
+	if err := f.outer.Sync(); err != nil {
+		return fmt.Errorf("raf: %w", err)
+	}
+
+	// A chunk already under the new key, from a rotation that stopped,
+	// is left as it is.
+	batch := int64(rekeyBatch / f.chunkSize)
+	if batch < 1 {
+		batch = 1
+	}
+	for i := int64(0); i < total; {
+		end := i + batch
+		if end > total {
+			end = total
+		}
+		f.begin()
+		var err error
+		for ; i < end && err == nil; i++ {
+			err = f.rekeyChunk(newKey, i)
+		}
+		if err = f.end(err); err != nil {
+			return err
+		}
+		if f.journal == nil {
+			if err := f.cbState.store.Sync(); err != nil {
+				return fmt.Errorf("raf: %w", err)
+			}
+		}
+		if progress != nil {
+			if err := progress(i, total); err != nil {
+				return err
+			}
+		}
+	}
+
+	// The freshness record follows the header to the new key: in the same
+	// transaction with a journal, and at the checkpoint below without.
+	if f.fresh != nil {
+		fkey, err := freshnessKey(newKey)
+		if err != nil {
+			return err
+		}
+		wipe(f.fresh.key)
+		f.fresh.key = fkey
+	}
+	// So does the metadata, sealed again before the header is rewritten.
+	var metaKey []byte
+	if f.metaKey != nil {
+		if metaKey, err = metadataKey(newKey); err != nil {
+			return err
+		}
+		defer func() { wipe(metaKey) }()
+	}
+	f.begin()
+	err = f.resealMetadata(metaKey)
+	if err == nil {
+		f.cbState.lastErr = nil
+		ret, cerr := C.raf_binding_rekey_header(f.algID, f.ctx, (*C.uint8_t)(&newKey[0]))
+		if ret != 0 {
+			err = mapErrno(cerr, f.cbState)
+		}
+	}
+	if err == nil {
+		err = f.setRekeyRecord(nil)
+	}
+	if err = f.end(err); err != nil {
+		return err
+	}
+	if metaKey != nil {
+		wipe(f.metaKey)
+		f.metaKey, metaKey = metaKey, nil
+	}
+	if f.journal != nil {
+		if err := f.journal.rekey(newKey); err != nil {
+			return err
+		}
+	}
+	if err := f.cbState.store.Sync(); err != nil {
+		return fmt.Errorf("raf: %w", err)
+	}
+	return f.checkpoint()
+}
+
+// setRekeyRecord records a rotation with marker, or drops the record if
+// marker is nil: in the extension area, or without one just past the last
+// chunk, where nothing reads it and a write that grows the file replaces
+// it.
+func (f *File) setRekeyRecord(marker []byte) error {
+	if f.ext != nil {
+		return f.ext.set(f.outer, extRekey, marker)
+	}
+	off, err := f.rekeyTrailer()
+	if err != nil {
+		return err
+	}
+	if marker == nil {
+		err = f.cbState.store.SetSize(off)
+	} else {
+		_, err = f.cbState.store.WriteAt(marker, off)
+	}
+	if err != nil {
+		return fmt.Errorf("raf: %w", err)
+	}
+	return nil
+}
+
+// rekeyRecord returns the marker of the rotation the file records, or nil.
+func (f *File) rekeyRecord() ([]byte, error) {
+	if f.ext != nil {
+		return f.ext.entries[extRekey], nil
+	}
+	off, err := f.rekeyTrailer()
+	if err != nil {
+		return nil, err
+	}
+	marker := make([]byte, rekeyMarkerSize)
+	if n, err := f.cbState.store.ReadAt(marker, off); n < len(marker) {
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("raf: %w", err)
+		}
+		return nil, nil
+	}
+	return marker, nil
+}
+
+// rekeyTrailer returns where a file without an extension area records a
+// rotation: the end of its last chunk record.
+func (f *File) rekeyTrailer() (int64, error) {
+	size, err := f.size()
+	if err != nil {
+		return 0, err
+	}
+	info := FileInfo{Size: size, ChunkSize: f.chunkSize, Algorithm: algFromCID(int(f.algID))}
+	return info.StoreSize(), nil
+}
+
+// rekeyChunk re-encrypts chunk idx under the keys for newKey, unless it is
+// under them already.
+func (f *File) rekeyChunk(newKey []byte, idx int64) error {
+	f.cbState.lastErr = nil
+	ret, cerr := C.raf_binding_rekey_chunk(f.algID, f.ctx, (*C.uint8_t)(&newKey[0]), C.uint64_t(idx))
+	if ret != 0 {
+		return mapErrno(cerr, f.cbState)
+	}
+	return nil
+}
+
+// checkpoint makes a dirty freshness record clean once the data it
+// describes is durable.
+func (f *File) checkpoint() error {
+	if f.fresh == nil || !f.fresh.rec.dirty || f.readOnly || f.broken != nil {
+		return nil
+	}
+	if f.journal != nil {
+		f.begin()
+		return f.end(nil)
+	}
+	if err := f.cbState.store.Sync(); err != nil {
+		return fmt.Errorf("raf: %w", err)
+	}
+	return f.writeFreshness(false)
+}
+
+// worker holds what one concurrent ReadAt or WriteAt needs besides the
+// file's context: a context to copy it into, a scratch buffer, and its own
+// handle box so that Store errors reach the right caller.
+type worker struct {
+	ctx     unsafe.Pointer
+	scratch unsafe.Pointer
+	box     unsafe.Pointer
+	handle  cgo.Handle
+	state   *callbackState
+}
+
+// setOptions applies the options that only affect how the open file is
+// accessed, not its format.
+func (f *File) setOptions(opts *Options) {
+	if opts == nil {
+		return
+	}
+	if opts.CacheSize > 0 {
+		size, _ := f.size()
+		f.cache = newChunkCache(f, f.chunkSize, opts.CacheSize, size)
+	}
+	// Every cached call updates the LRU order, so a cached file takes the
+	// exclusive path.
+	if opts.Concurrent && f.cache == nil {
+		f.concurrent = true
+		f.locks = new(chunkLocks)
+	}
+	f.parallel = opts.Parallelism
+}
+
+// getWorker returns an idle worker, allocating one if there is none.
+func (f *File) getWorker() (*worker, error) {
+	f.workerMu.Lock()
+	if n := len(f.workers); n > 0 {
+		w := f.workers[n-1]
+		f.workers = f.workers[:n-1]
+		f.workerMu.Unlock()
+		return w, nil
+	}
+	f.workerMu.Unlock()
+
+	w := &worker{state: &callbackState{store: f.cbState.store}}
+	w.box = C.malloc(C.size_t(unsafe.Sizeof(C.uintptr_t(0))))
+	if w.box == nil {
+		return nil, fmt.Errorf("raf: failed to allocate handle box")
+	}
+	w.handle = cgo.NewHandle(w.state)
+	*(*C.uintptr_t)(w.box) = C.uintptr_t(w.handle)
+	w.ctx = C.raf_aligned_alloc(64, 512)
+	w.scratch = C.raf_aligned_alloc(C.AEGIS_RAF_SCRATCH_ALIGN, f.scratchLen)
+	if w.ctx == nil || w.scratch == nil {
+		w.free(f.scratchLen)
+		return nil, fmt.Errorf("raf: failed to allocate worker")
+	}
+	return w, nil
+}
+
+func (f *File) putWorker(w *worker) {
+	f.workerMu.Lock()
+	f.workers = append(f.workers, w)
+	f.workerMu.Unlock()
+}
+
+// free releases the worker's resources, wiping the plaintext that may be
+// left in its scratch buffer.
+func (w *worker) free(scratchLen C.size_t) {
+	w.handle.Delete()
+	C.free(w.box)
+	C.raf_aligned_free(w.ctx)
+	C.raf_wipe_free(w.scratch, scratchLen)
+}
+
+// chunkRange returns the stripes covering n bytes at off, which must be
+// positive.
+func (f *File) chunkRange(off int64, n int) uint64 {
+	cs := uint64(f.chunkSize)
+	return f.locks.stripes(uint64(off)/cs, (uint64(off)+uint64(n)-1)/cs)
+}
+
+// readAtShared is ReadAt for a concurrent File. It holds f.mu shared, so
+// the context does not change, and the chunks it reads shared, so that no
+// writer replaces them meanwhile.
+func (f *File) readAtShared(p []byte, off int64) (int, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if f.closed {
+		return 0, ErrClosed
+	}
+	if f.broken != nil {
+		return 0, f.broken
+	}
+	if off < 0 {
+		return 0, ErrNegativeOffset
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	return f.readWorkers(p, off, true)
+}
+
+// readWorkers reads p at off on workers, split across f.parallel of them,
+// locking the chunks it reads if shared is set.
+func (f *File) readWorkers(p []byte, off int64, shared bool) (int, error) {
+	size, err := f.size()
+	if err != nil {
+		return 0, err
+	}
+	if off >= size {
+		return 0, io.EOF
+	}
+	n := len(p)
+	if int64(n) > size-off {
+		n = int(size - off)
+	}
+	if shared {
+		defer f.locks.lock(f.chunkRange(off, n), true)()
+	}
+	read, err := f.runWorkers(p[:n], off, size, false)
+	if err == nil && read < len(p) {
+		err = io.EOF
+	}
+	return read, err
+}
+
+// writeAtShared is WriteAt for a concurrent File when the write needs no
+// change to the context: it stays within the file and there is no Merkle
+// tree to update. It holds the chunks it writes exclusively. ok is false if
+// the write must take the exclusive path instead.
+func (f *File) writeAtShared(p []byte, off int64) (n int, ok bool, err error) {
+	if f.merkleBuf != nil || off < 0 || len(p) == 0 {
+		return 0, false, nil
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if f.closed || f.readOnly || f.broken != nil {
+		return 0, false, nil
+	}
+	size, err := f.size()
+	if err != nil || off > size-int64(len(p)) {
+		return 0, false, nil
+	}
+
+	defer f.locks.lock(f.chunkRange(off, len(p)), false)()
+	n, err = f.runWorkers(p, off, size, true)
+	return n, true, err
+}
+
+// writeParallel splits a write of at least two whole chunks across
+// workers. The partial chunks at either end, which may need reading first,
+// go through the file's own context. ok is false if the write is not
+// eligible and must be done serially.
+func (f *File) writeParallel(p []byte, off int64) (n int, ok bool, err error) {
+	size, err := f.size()
+	if err != nil {
+		return 0, true, err
+	}
+	cs := int64(f.chunkSize)
+	end := off + int64(len(p))
+	first, last := (off+cs-1)/cs*cs, end/cs*cs
+	if off > size || last-first < 2*cs {
+		return 0, false, nil
+	}
+	if f.merkleBuf != nil && uint64((end+cs-1)/cs) > f.merkleMax {
+		return 0, false, nil
+	}
+
+	if off < first {
+		n, err = f.writeSerial(p[:first-off], off)
+		if err != nil {
+			return n, true, err
+		}
+		if size < first {
+			size = first
+		}
+	}
+
+	// The workers write the whole chunks within a file size that covers
+	// them, so the store is grown first to hold their records. Only
+	// committing the chunks extends the file in its header, so until then
+	// the file keeps its size, and a failed write cuts the store back.
+	workerSize := size
+	grown := int64(-1)
+	if last > size {
+		prev, err := f.cbState.store.GetSize()
+		if err != nil {
+			return n, true, fmt.Errorf("raf: %w", err)
+		}
+		workerSize = last
+		info := FileInfo{Size: last, ChunkSize: f.chunkSize, Algorithm: algFromCID(int(f.algID))}
+		if err := f.cbState.store.SetSize(info.StoreSize()); err != nil {
+			return n, true, fmt.Errorf("raf: %w", err)
+		}
+		grown = prev
+	}
+	w, err := f.runWorkers(p[first-off:last-off], first, workerSize, true)
+	n += w
+	if err != nil {
+		// The chunks past the end were never part of the file.
+		if int64(n) > size-off {
+			n = int(size - off)
+		}
+		if grown >= 0 {
+			if serr := f.cbState.store.SetSize(grown); serr != nil {
+				f.broken = fmt.Errorf("%w: %v", ErrBroken, serr)
+			}
+		}
+		return n, true, err
+	}
+	f.cbState.lastErr = nil
+	ret, cerr := C.raf_binding_commit_chunks(f.algID, f.ctx, (*C.uint8_t)(&p[first-off]),
+		C.uint64_t(first/cs), C.uint64_t((last-first)/cs))
+	if ret != 0 {
+		// The context may have taken the new size or tree without the
+		// header, so it no longer matches the store.
+		err = mapErrno(cerr, f.cbState)
+		f.broken = fmt.Errorf("%w: %v", ErrBroken, err)
+		return n, true, err
+	}
+
+	if last < end {
+		w, err = f.writeSerial(p[last-off:], last)
+		n += w
+	}
+	return n, true, err
+}
+
+// span is a part of a read or write handled by one worker.
+type span struct {
+	off    int64
+	lo, hi int // bounds in the caller's buffer
+}
+
+// split divides n bytes at off into at most f.parallel spans of whole
+// chunks, except where off and off+n fall inside a chunk.
+func (f *File) split(off int64, n int) []span {
+	cs := int64(f.chunkSize)
+	chunks := (off+int64(n)-1)/cs - off/cs + 1
+	pieces := int64(f.parallel)
+	if pieces < 1 {
+		pieces = 1
+	}
+	if pieces > chunks {
+		pieces = chunks
+	}
+	per := (chunks + pieces - 1) / pieces
+	spans := make([]span, 0, pieces)
+	for start := off; start < off+int64(n); {
+		stop := (start/cs + per) * cs
+		if stop > off+int64(n) {
+			stop = off + int64(n)
+		}
+		spans = append(spans, span{off: start, lo: int(start - off), hi: int(stop - off)})
+		start = stop
+	}
+	return spans
+}
+
+// runWorkers reads or writes p at off on workers, one per span, using
+// fileSize as the size of the workers' contexts. It returns the number of
+// bytes before the first span that failed, and that span's error.
+func (f *File) runWorkers(p []byte, off int64, fileSize int64, write bool) (int, error) {
+	spans := f.split(off, len(p))
+	done := make([]int, len(spans))
+	errs := make([]error, len(spans))
+	run := func(i int) {
+		s := spans[i]
+		done[i], errs[i] = f.runWorker(p[s.lo:s.hi], s.off, fileSize, write)
+	}
+	if len(spans) == 1 {
+		run(0)
+	} else {
+		var wg sync.WaitGroup
+		for i := range spans {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				run(i)
+			}(i)
+		}
+		wg.Wait()
+	}
+
+	n := 0
+	for i := range spans {
+		n += done[i]
+		if errs[i] != nil {
+			return n, errs[i]
+		}
+	}
+	return n, nil
+}
+
+func (f *File) runWorker(p []byte, off int64, fileSize int64, write bool) (int, error) {
+	w, err := f.getWorker()
+	if err != nil {
+		return 0, err
+	}
+	defer f.putWorker(w)
+	w.state.lastErr = nil
+	var n C.size_t
+	var ret C.int
+	var cerr error
+	if write {
+		ret, cerr = C.raf_worker_write(f.algID, w.ctx, f.ctx, w.box,
+			(*C.uint8_t)(w.scratch), f.scratchLen, C.uint64_t(fileSize),
+			&n, (*C.uint8_t)(&p[0]), C.size_t(len(p)), C.uint64_t(off))
+	} else {
+		ret, cerr = C.raf_worker_read(f.algID, w.ctx, f.ctx, w.box,
+			(*C.uint8_t)(w.scratch), f.scratchLen, C.uint64_t(fileSize),
+			(*C.uint8_t)(&p[0]), &n, C.size_t(len(p)), C.uint64_t(off))
+	}
+	if ret != 0 {
+		return int(n), mapErrno(cerr, w.state)
+	}
+	return int(n), nil
 }
 
 // mapErrno converts a C errno (returned via CGO's multi-value form) to a Go error.
@@ -635,3 +2648,275 @@ func mapErrno(err error, state *callbackState) error {
 		return fmt.Errorf("raf: %w", e)
 	}
 }
+
+// slotState is a File's file key and its key slots.
+type slotState struct {
+	key   []byte
+	slots *keySlots
+}
+
+// createKeySlots gives a new file, encrypted under key, its first slot,
+// which wraps key under kek, derived by kdf.
+func (f *File) createKeySlots(kek, key []byte, kdf slotKDF) error {
+	id := make([]byte, FileIDSize)
+	C.raf_binding_file_id(f.algID, f.ctx, (*C.uint8_t)(&id[0]))
+	s := &keySlots{keyLen: len(key)}
+	if _, err := s.add(kek, id, key, kdf); err != nil {
+		return err
+	}
+	if err := f.ext.set(f.outer, extKeySlots, s.marshal()); err != nil {
+		return err
+	}
+	f.slots = &slotState{key: key, slots: s}
+	return nil
+}
+
+// AddKeySlot wraps the file key under kek, a 16- or 32-byte key, in a new
+// key slot and returns the slot's number. From then on Open accepts kek.
+// The slot is synced to the store before AddKeySlot returns.
+func (f *File) AddKeySlot(kek []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.addKeySlot(kek, slotKDF{kind: slotKey})
+}
+
+// AddPassphrase adds a key slot that OpenWithPassphrase opens with
+// passphrase, deriving its key with Argon2id and params, and returns the
+// slot's number. The slot is synced to the store before AddPassphrase
+// returns.
+func (f *File) AddPassphrase(passphrase []byte, params *KDFParams) (int, error) {
+	kdf, err := newPassphraseKDF(params)
+	if err != nil {
+		return 0, err
+	}
+	kek, err := kdf.derive(passphrase)
+	if err != nil {
+		return 0, err
+	}
+	defer wipe(kek)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.addKeySlot(kek, kdf)
+}
+
+// SetPassphrase replaces key slot slot with a slot for passphrase under
+// params and returns the new slot's number. Called with the passphrase
+// the slot already has and stronger params, it raises the cost of the
+// slot's key derivation; the chunks are not rewritten. The new slot is
+// written and synced before the old one is removed, so a free slot is
+// needed.
+func (f *File) SetPassphrase(slot int, passphrase []byte, params *KDFParams) (int, error) {
+	kdf, err := newPassphraseKDF(params)
+	if err != nil {
+		return 0, err
+	}
+	kek, err := kdf.derive(passphrase)
+	if err != nil {
+		return 0, err
+	}
+	defer wipe(kek)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.keySlotsWritable(); err != nil {
+		return 0, err
+	}
+	if f.slots.slots.find(slot) == nil {
+		return 0, ErrKeySlot
+	}
+	added, err := f.addKeySlot(kek, kdf)
+	if err != nil {
+		return 0, err
+	}
+	return added, f.removeKeySlot(slot)
+}
+
+// KDFParams returns the Argon2id parameters of key slot slot, or nil for
+// a slot that takes a key.
+func (f *File) KDFParams(slot int) (*KDFParams, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if f.closed {
+		return nil, ErrClosed
+	}
+	if f.slots == nil {
+		return nil, ErrNoKeySlots
+	}
+	s := f.slots.slots.find(slot)
+	if s == nil {
+		return nil, ErrKeySlot
+	}
+	if s.kdf.kind == slotKey {
+		return nil, nil
+	}
+	params := s.kdf.params
+	return &params, nil
+}
+
+// addKeySlot wraps the file key under kek, derived by kdf, in a new slot.
+func (f *File) addKeySlot(kek []byte, kdf slotKDF) (int, error) {
+	if err := f.keySlotsWritable(); err != nil {
+		return 0, err
+	}
+	s := &keySlots{keyLen: f.slots.slots.keyLen, slots: append([]keySlot(nil), f.slots.slots.slots...)}
+	id := make([]byte, FileIDSize)
+	C.raf_binding_file_id(f.algID, f.ctx, (*C.uint8_t)(&id[0]))
+	slot, err := s.add(kek, id, f.slots.key, kdf)
+	if err != nil {
+		return 0, err
+	}
+	if err := f.writeKeySlots(s); err != nil {
+		return 0, err
+	}
+	return slot, nil
+}
+
+// RemoveKeySlot removes key slot slot, after which Open no longer accepts
+// its key. The last slot cannot be removed. The file key stays the same,
+// and anyone who could open the file may have kept it; to shut them out,
+// copy the data to a new file.
+func (f *File) RemoveKeySlot(slot int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.keySlotsWritable(); err != nil {
+		return err
+	}
+	return f.removeKeySlot(slot)
+}
+
+func (f *File) removeKeySlot(slot int) error {
+	s := &keySlots{keyLen: f.slots.slots.keyLen, slots: append([]keySlot(nil), f.slots.slots.slots...)}
+	if err := s.remove(slot); err != nil {
+		return err
+	}
+	return f.writeKeySlots(s)
+}
+
+// KeySlots returns the numbers of the key slots in use, in order.
+func (f *File) KeySlots() ([]int, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if f.closed {
+		return nil, ErrClosed
+	}
+	if f.slots == nil {
+		return nil, ErrNoKeySlots
+	}
+	slots := make([]int, len(f.slots.slots.slots))
+	for i, slot := range f.slots.slots.slots {
+		slots[i] = int(slot.index)
+	}
+	return slots, nil
+}
+
+func (f *File) keySlotsWritable() error {
+	if f.closed {
+		return ErrClosed
+	}
+	if f.readOnly {
+		return ErrReadOnly
+	}
+	if f.broken != nil {
+		return f.broken
+	}
+	if f.slots == nil {
+		return ErrNoKeySlots
+	}
+	return nil
+}
+
+// writeKeySlots replaces the file's key slots with s and syncs them.
+func (f *File) writeKeySlots(s *keySlots) error {
+	if err := f.ext.set(f.outer, extKeySlots, s.marshal()); err != nil {
+		return err
+	}
+	f.slots.slots = s
+	if err := f.outer.Sync(); err != nil {
+		return fmt.Errorf("raf: %w", err)
+	}
+	return nil
+}
+
+// SetMetadata replaces the file's metadata with m, which may be empty to
+// clear it. The pairs are sealed in the extension area, which they must
+// fit in along with its other entries, and bound to the file. With a
+// journal the change is atomic; without one, a crash while it is written
+// leaves metadata that Metadata reports as ErrAuth.
+func (f *File) SetMetadata(m map[string]string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return ErrClosed
+	}
+	if f.readOnly {
+		return ErrReadOnly
+	}
+	if f.broken != nil {
+		return f.broken
+	}
+	if f.ext == nil {
+		return ErrNoExtensionArea
+	}
+	f.begin()
+	return f.end(f.setMetadata(m))
+}
+
+// Metadata returns the file's metadata, or nil if it has none or no
+// extension area. It returns ErrAuth if the metadata was altered, removed,
+// or copied from another file.
+func (f *File) Metadata() (map[string]string, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if f.closed {
+		return nil, ErrClosed
+	}
+	if f.ext == nil {
+		return nil, nil
+	}
+	if f.ext.entries[extMetadata] == nil {
+		return nil, ErrAuth
+	}
+	id := make([]byte, FileIDSize)
+	C.raf_binding_file_id(f.algID, f.ctx, (*C.uint8_t)(&id[0]))
+	m, err := openMetadata(f.metaKey, id, f.ext.entries[extMetadata])
+	if len(m) == 0 {
+		return nil, err
+	}
+	return m, err
+}
+
+func (f *File) setMetadata(m map[string]string) error {
+	id := make([]byte, FileIDSize)
+	C.raf_binding_file_id(f.algID, f.ctx, (*C.uint8_t)(&id[0]))
+	entry, err := sealMetadata(f.metaKey, id, m)
+	if err != nil {
+		return err
+	}
+	return f.ext.set(f.outer, extMetadata, entry)
+}
+
+// resealMetadata seals the metadata under metaKey, for a key rotation. The
+// metadata may be under metaKey already, if an earlier rotation stopped
+// after sealing it. Metadata that opens under neither key is left as it
+// is, no less readable than before.
+func (f *File) resealMetadata(metaKey []byte) error {
+	if metaKey == nil || f.ext.entries[extMetadata] == nil {
+		return nil
+	}
+	id := make([]byte, FileIDSize)
+	C.raf_binding_file_id(f.algID, f.ctx, (*C.uint8_t)(&id[0]))
+	m, err := openMetadata(f.metaKey, id, f.ext.entries[extMetadata])
+	if err == ErrAuth {
+		m, err = openMetadata(metaKey, id, f.ext.entries[extMetadata])
+	}
+	if err == ErrAuth {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	entry, err := sealMetadata(metaKey, id, m)
+	if err != nil {
+		return err
+	}
+	return f.ext.set(f.outer, extMetadata, entry)
+}
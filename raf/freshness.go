@@ -0,0 +1,273 @@
+package raf
+
+import (
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/aegis-aead/go-libaegis/aegis256"
+)
+
+// Freshness records
+//
+// Every chunk authenticates on its own, so someone with access to the store
+// can put back an older copy of a chunk, of the header, or of the whole
+// file, and each still decrypts. A file created with Options.Freshness
+// keeps a record in its extension area that counts its versions and holds
+// the size and a tag of the Merkle commitment of the current one, under a
+// MAC keyed by the file key. Open rebuilds the Merkle tree and checks it against the
+// record, which catches old chunks and headers mixed into a newer file.
+// Rolling back the whole file, record included, can only be caught by the
+// caller: it keeps the version from File.Version somewhere the attacker
+// cannot reach, and passes it back to Open.
+//
+// A record is clean, describing the data on the store, or dirty: before
+// the first change after a clean record, a dirty one with the next version
+// is written and synced, and on Sync or Close the data is synced and the
+// record made clean again. With a journal, each transaction writes a clean
+// record with the next version along with its changes instead.
+//
+// The extension entry holds three copies of the record. A dirty record
+// overwrites the oldest copy, and a clean one overwrites every copy in
+// turn, oldest first, with a sync after each, so that a write torn by a
+// crash spoils at most one copy. A dirty record admits whatever mix of
+// old and new chunks a crash left, so Open only accepts one alongside a
+// copy of the clean record it follows; otherwise it checks the file
+// against the newest clean record. A copy that fails to verify, whether
+// torn or altered on the store, thus never weakens the check, and
+// altering every clean copy leaves no record to open the file with.
+//
+// A record is
+//
+//	u8 flags || u64 version || u8 len(suite) || suite || u64 max_chunks ||
+//	u64 size || commitment_tag[32] || mac[32]
+//
+// with little-endian integers, where flags is 1 for a dirty record and 0
+// otherwise. The commitment tag is the AEGIS-256-MAC of
+// "aegis-raf-commitment-v1" || the Merkle commitment, so that the record
+// does not let anyone without the key confirm a guess of the contents. A
+// dirty record has a zero size and tag, so that every record of a file
+// has the same length. Both MACs are keyed by a key derived from the file
+// key, and the record's is over "aegis-raf-freshness-v1" || file
+// identifier || the record before it.
+
+const (
+	freshnessDomain  = "aegis-raf-freshness-v1"
+	commitmentDomain = "aegis-raf-commitment-v1"
+	freshnessMACLen  = 32
+	freshnessDirty   = 1
+	freshnessCopies  = 3
+)
+
+var (
+	// ErrStale is returned by Open when a file with a freshness record is
+	// older than Options.Freshness asks for, or does not match its record:
+	// some of its chunks or its header were replaced by older copies, or
+	// the record itself was altered.
+	ErrStale = errors.New("raf: file is stale or was rolled back")
+
+	// ErrNoFreshness is returned for a file without a freshness record by
+	// File.Version, and by Open when Options.Freshness is set.
+	ErrNoFreshness = errors.New("raf: file has no freshness record")
+)
+
+// Freshness configures rollback protection. Set with Create, even empty,
+// it adds a freshness record to the file, which needs an ExtensionSize of
+// 512 or more and a Merkle tree with the MerkleSHA256 or MerkleSHA512_256
+// suite. Set with Open, it states what the caller expects of the file. A
+// file with a record keeps it up to date whether or not Open was given
+// Freshness, and Open sets up its Merkle tree from the record if
+// Options.Merkle is nil.
+type Freshness struct {
+	// MinVersion makes Open fail with ErrStale if the file's version is
+	// lower, or is MinVersion in a dirty record, as a crash leaves before
+	// the clean record of that version: pass the version File.Version
+	// returns after Sync or Close. Ignored for Create.
+	MinVersion uint64
+
+	// Commitment, if set, makes Open fail with ErrStale if the file's
+	// version is MinVersion but its commitment is not Commitment. Ignored
+	// for Create.
+	Commitment []byte
+}
+
+// check returns ErrStale if a file whose record is rec, with commitment,
+// is not what fr expects. A dirty record at MinVersion admits any mix of
+// chunks, including older ones, and is older than the clean record that
+// reported the version.
+func (fr *Freshness) check(rec *freshnessRecord, commitment []byte) error {
+	if fr == nil {
+		return nil
+	}
+	version := rec.version
+	if version < fr.MinVersion || (rec.dirty && version == fr.MinVersion) {
+		return ErrStale
+	}
+	if fr.Commitment != nil && version == fr.MinVersion &&
+		subtle.ConstantTimeCompare(commitment, fr.Commitment) != 1 {
+		return ErrStale
+	}
+	return nil
+}
+
+// freshnessRecord is the decoded extFreshness entry.
+type freshnessRecord struct {
+	dirty     bool
+	version   uint64
+	suite     string
+	maxChunks uint64
+	size      uint64
+	tag       []byte // MAC of the Merkle commitment
+	mac       []byte
+}
+
+// body returns the entry without its MAC.
+func (r *freshnessRecord) body() []byte {
+	b := make([]byte, 0, 1+8+1+len(r.suite)+8+8+32+freshnessMACLen)
+	if r.dirty {
+		b = append(b, freshnessDirty)
+	} else {
+		b = append(b, 0)
+	}
+	b = binary.LittleEndian.AppendUint64(b, r.version)
+	b = append(b, byte(len(r.suite)))
+	b = append(b, r.suite...)
+	b = binary.LittleEndian.AppendUint64(b, r.maxChunks)
+	if r.dirty {
+		b = append(b, make([]byte, 8+32)...)
+	} else {
+		b = binary.LittleEndian.AppendUint64(b, r.size)
+		b = append(b, r.tag...)
+	}
+	return b
+}
+
+func (r *freshnessRecord) marshal() []byte {
+	return append(r.body(), r.mac...)
+}
+
+func parseFreshnessRecord(b []byte) (*freshnessRecord, error) {
+	d := proofDecoder{b: b}
+	u64 := func() uint64 {
+		if v := d.bytes(8); v != nil {
+			return binary.LittleEndian.Uint64(v)
+		}
+		return 0
+	}
+	r := &freshnessRecord{}
+	flags := d.byte()
+	r.version = u64()
+	r.suite = string(d.bytes(int(d.byte())))
+	r.maxChunks = u64()
+	r.dirty = flags == freshnessDirty
+	r.size = u64()
+	r.tag = append([]byte(nil), d.bytes(32)...)
+	r.mac = append([]byte(nil), d.bytes(freshnessMACLen)...)
+	if d.err || len(d.b) != 0 || flags > freshnessDirty ||
+		(r.suite != MerkleSHA256 && r.suite != MerkleSHA512_256) {
+		return nil, ErrInvalidHeader
+	}
+	if r.dirty {
+		r.size, r.tag = 0, nil
+	}
+	return r, nil
+}
+
+// parseFreshnessEntry decodes the copies of the record in an extFreshness
+// entry. A copy that does not decode is nil; one of them must.
+func parseFreshnessEntry(b []byte) ([freshnessCopies]*freshnessRecord, error) {
+	var recs [freshnessCopies]*freshnessRecord
+	if len(b)%freshnessCopies != 0 {
+		return recs, ErrInvalidHeader
+	}
+	n := len(b) / freshnessCopies
+	ok := false
+	for i := range recs {
+		recs[i], _ = parseFreshnessRecord(b[i*n : (i+1)*n])
+		ok = ok || recs[i] != nil
+	}
+	if !ok {
+		return recs, ErrInvalidHeader
+	}
+	return recs, nil
+}
+
+// configRecord returns a copy of the record that verifies under key, or
+// previous if not nil, and whose Merkle tree fits the file info describes,
+// or nil if there is none. It takes the file identifier from the header,
+// not yet authenticated: openFreshness checks the copies again once the
+// file is open.
+func configRecord(store Store, recs [freshnessCopies]*freshnessRecord, key, previous []byte, info *FileInfo) (*freshnessRecord, error) {
+	id := make([]byte, FileIDSize)
+	if _, err := store.ReadAt(id, headerIDOffset); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("raf: %w", err)
+	}
+	for _, k := range [][]byte{key, previous} {
+		if k == nil {
+			continue
+		}
+		fkey, err := freshnessKey(k)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range recs {
+			if r != nil && recordedChunksOK(r.maxChunks, info.Size, info.ChunkSize) && r.verify(fkey, id) == nil {
+				wipe(fkey)
+				return r, nil
+			}
+		}
+		wipe(fkey)
+	}
+	return nil, nil
+}
+
+// order ranks records by age: a dirty record comes after the clean one
+// before it, and the clean one that follows after both.
+func (r *freshnessRecord) order() uint64 {
+	if r.dirty {
+		return 2 * r.version
+	}
+	return 2*r.version + 1
+}
+
+// freshnessKey derives the key of the records' MAC from the file key.
+func freshnessKey(key []byte) ([]byte, error) {
+	return deriveKey(key, "raf freshness key", aegis256.KeySize)
+}
+
+// commitmentTag returns the tag a clean record keeps of commitment.
+func commitmentTag(key, commitment []byte) ([]byte, error) {
+	mac, err := aegis256.NewMAC(key, make([]byte, aegis256.NonceSize), freshnessMACLen)
+	if err != nil {
+		return nil, err
+	}
+	mac.Write([]byte(commitmentDomain))
+	mac.Write(commitment)
+	return mac.Sum(nil), nil
+}
+
+// sum returns the MAC of the record for the file with identifier id.
+func (r *freshnessRecord) sum(key, id []byte) ([]byte, error) {
+	mac, err := aegis256.NewMAC(key, make([]byte, aegis256.NonceSize), freshnessMACLen)
+	if err != nil {
+		return nil, err
+	}
+	mac.Write([]byte(freshnessDomain))
+	mac.Write(id)
+	mac.Write(r.body())
+	return mac.Sum(nil), nil
+}
+
+// verify checks the record's MAC, returning ErrStale if it does not match.
+func (r *freshnessRecord) verify(key, id []byte) error {
+	want, err := r.sum(key, id)
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare(want, r.mac) != 1 {
+		return ErrStale
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+package raf
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"testing"
+
+	"github.com/aegis-aead/go-libaegis/common"
+)
+
+func TestFreshness(t *testing.T) {
+	if !common.Available {
+		t.Skip("CGO not available")
+	}
+
+	key := make([]byte, 16)
+	rand.Read(key)
+	store := NewMemStore()
+	opts := &Options{
+		Algorithm:     AEGIS128L,
+		ChunkSize:     MinChunkSize,
+		Merkle:        suiteOptions(MerkleSHA256, 16),
+		ExtensionSize: 512,
+		Freshness:     &Freshness{},
+	}
+	f, err := Create(store, key, opts)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if v, c, err := f.Version(); v != 0 || len(c) != 32 || err != nil {
+		t.Fatalf("Version after Create: %d, %x, %v", v, c, err)
+	}
+	data := make([]byte, 3*MinChunkSize)
+	rand.Read(data)
+	f.WriteAt(data, 0)
+	if v, c, _ := f.Version(); v != 1 || c != nil {
+		t.Fatalf("Version before Sync: %d, %x", v, c)
+	}
+	if err := f.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	v1, c1, _ := f.Version()
+	if v1 != 1 || c1 == nil {
+		t.Fatalf("Version after Sync: %d, %x", v1, c1)
+	}
+	if bytes.Contains(store.Bytes(), c1) {
+		t.Fatal("the record holds the commitment in the clear")
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	old := store.Snapshot()
+
+	// Changing three chunks makes a new version; putting any of the old
+	// chunks back is detected.
+	f, err = Open(store, key, &Options{Freshness: &Freshness{MinVersion: v1, Commitment: c1}})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	f.WriteAt(bytes.Repeat([]byte{1}, 2*MinChunkSize), MinChunkSize/2)
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	newer := store.Snapshot()
+	info, _ := Probe(store)
+	rs := int64(info.RecordSize())
+	for _, chunk := range []int64{0, 1, 2} {
+		mixed := append([]byte(nil), newer...)
+		at := 512 + HeaderSize + chunk*rs
+		copy(mixed[at:at+rs], old[at:])
+		store.Restore(mixed)
+		if _, err := Open(store, key, nil); err != ErrStale {
+			t.Errorf("Open with chunk %d rolled back: %v", chunk, err)
+		}
+	}
+	store.Restore(newer)
+	f, err = Open(store, key, nil)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	v2, c2, _ := f.Version()
+	f.Close()
+	if v2 != 2 || bytes.Equal(c1, c2) {
+		t.Fatalf("Version after a change: %d, %x", v2, c2)
+	}
+
+	// Rolling back the whole file is only caught with the version the
+	// caller kept.
+	store.Restore(old)
+	if f, err := Open(store, key, nil); err != nil {
+		t.Fatalf("Open of an older file without expectations: %v", err)
+	} else {
+		f.Close()
+	}
+	if _, err := Open(store, key, &Options{Freshness: &Freshness{MinVersion: v2}}); err != ErrStale {
+		t.Fatalf("Open of an older file: %v", err)
+	}
+	store.Restore(newer)
+	if _, err := Open(store, key, &Options{Freshness: &Freshness{MinVersion: v2, Commitment: c1}}); err != ErrStale {
+		t.Fatalf("Open with another commitment: %v", err)
+	}
+
+	// A file that was changed and not closed is accepted as a crash left
+	// it, and the next Close records its state.
+	f, _ = Open(store, key, nil)
+	f.WriteAt([]byte("crash"), 0)
+	crashed := store.Snapshot()
+	f.Close()
+	store.Restore(crashed)
+	f, err = Open(store, key, &Options{Freshness: &Freshness{MinVersion: v2}})
+	if err != nil {
+		t.Fatalf("Open after a crash: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f, _ = Open(store, key, nil)
+	if v, c, _ := f.Version(); v != v2+1 || c == nil {
+		t.Fatalf("Version after recovering from a crash: %d, %x", v, c)
+	}
+	f.Close()
+
+	// Once that state is recorded, the dirty record before it, which
+	// admits old chunks, no longer meets its version.
+	recovered := store.Snapshot()
+	store.Restore(crashed)
+	if _, err := Open(store, key, &Options{Freshness: &Freshness{MinVersion: v2 + 1}}); err != ErrStale {
+		t.Fatalf("Open of a dirty record at MinVersion: %v", err)
+	}
+	store.Restore(recovered)
+
+	// A copy that does not verify, as a torn write leaves, is passed over
+	// while another does; with every copy altered the file is rejected.
+	clean := store.Snapshot()
+	ext, _ := readExtArea(store)
+	entry := bytes.Index(clean, ext.entries[extFreshness])
+	n := len(ext.entries[extFreshness]) / freshnessCopies
+	b := store.Snapshot()
+	for i := 0; i < freshnessCopies; i++ {
+		b[entry+i*n+1] ^= 1
+		store.Restore(b)
+		f, err := Open(store, key, nil)
+		if i < freshnessCopies-1 {
+			if err != nil {
+				t.Fatalf("Open with %d altered copies: %v", i+1, err)
+			}
+			f.Close()
+		} else if err != ErrStale {
+			t.Fatalf("Open with every copy altered: %v", err)
+		}
+	}
+
+	// The Merkle tree is only set up from a copy that verifies, so one
+	// with its tree size altered is passed over too.
+	huge := append([]byte(nil), clean...)
+	binary.LittleEndian.PutUint64(huge[entry+1+8+1+len(MerkleSHA256):], 1<<40)
+	store.Restore(huge)
+	if f, err := Open(store, key, nil); err != nil {
+		t.Fatalf("Open with a copy's tree size altered: %v", err)
+	} else {
+		f.Close()
+	}
+
+	// A dirty record only stands in for the file's state next to the
+	// clean one it follows: altering the copies of that one must not let
+	// old chunks through.
+	store.Restore(clean)
+	f, _ = Open(store, key, nil)
+	f.WriteAt([]byte("crash"), 0)
+	crashed = store.Snapshot()
+	f.Close()
+	recs, _ := parseFreshnessEntry(crashed[entry : entry+freshnessCopies*n])
+	all := append([]byte(nil), crashed...)
+	altered := 0
+	for i, r := range recs {
+		if r.dirty {
+			continue
+		}
+		b := append([]byte(nil), crashed...)
+		b[entry+i*n+1] ^= 1
+		store.Restore(b)
+		if f, err := Open(store, key, nil); err != nil {
+			t.Fatalf("Open of a crashed file with one clean copy altered: %v", err)
+		} else {
+			f.Close()
+		}
+		all[entry+i*n+1] ^= 1
+		altered++
+	}
+	if altered != freshnessCopies-1 {
+		t.Fatalf("%d clean copies after a crash", altered)
+	}
+	store.Restore(all)
+	if _, err := Open(store, key, nil); err != ErrStale {
+		t.Fatalf("Open of a crashed file with every clean copy altered: %v", err)
+	}
+	store.Restore(clean)
+	ext.set(store, extFreshness, nil)
+	if _, err := Open(store, key, &Options{Freshness: &Freshness{}}); err != ErrNoFreshness {
+		t.Fatalf("Open without a record: %v", err)
+	}
+
+	bad := *opts
+	bad.Truncate = true
+	bad.ExtensionSize = 0
+	if _, err := Create(NewMemStore(), key, &bad); err != ErrNoExtensionArea {
+		t.Fatalf("Create without an extension area: %v", err)
+	}
+	bad.ExtensionSize, bad.Merkle = MinExtensionSize, suiteOptions(MerkleAEGISMAC, 16)
+	if _, err := Create(NewMemStore(), key, &bad); err != ErrBadMerkleConfig {
+		t.Fatalf("Create with a keyed suite: %v", err)
+	}
+}
@@ -0,0 +1,464 @@
+package raf
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/aegis-aead/go-libaegis/aegis128l"
+	"github.com/aegis-aead/go-libaegis/aegis256"
+)
+
+// Write-ahead journal
+//
+// A File with Options.Journal runs every WriteAt and Truncate, and every
+// write-back of the chunk cache, as a transaction. While one runs, the
+// changes the file makes to its store, extension area included, are held
+// in memory. To
+// commit, the journal stores them in a single encrypted record and syncs
+// it, then applies them to the store and syncs that, and empties the
+// journal. If the process stops before the record is complete, the store
+// was never touched; if it stops after, Open finds the record and applies
+// it again. Either way the file holds the old or the new version, never a
+// mix of both.
+//
+// A record is
+//
+//	"RAFJ" || u8 version || check[16] || nonce[32] || AEGIS-256(body) || tag[32]
+//
+// where check identifies the key, so that Open with a wrong key fails
+// instead of discarding the record, and the associated data is everything
+// before the nonce followed by the file identifier. The body is
+//
+//	uvarint base || uvarint size || uvarint count || count * (uvarint off || uvarint len || data)
+//
+// and is applied by resizing the store to base, writing the extents in
+// order and resizing it to size.
+
+const (
+	journalVersion = 1
+	journalCheck   = 16
+	journalHeader  = 4 + 1 + journalCheck + aegis256.NonceSize
+	journalTag     = 32
+
+	// headerIDOffset is where the file identifier sits in the RAF header.
+	headerIDOffset = 24
+)
+
+var journalMagic = []byte("RAFJ")
+
+// ErrBadJournal is returned by Open when the journal store holds data that
+// is not a journal record.
+var ErrBadJournal = errors.New("raf: invalid journal")
+
+// deriveKey returns n bytes derived from key for the given purpose, using
+// AEGIS-MAC as a PRF with the purpose as its nonce. The nonce is never one
+// the file uses for encryption.
+func deriveKey(key []byte, purpose string, n int) ([]byte, error) {
+	var mac interface {
+		Write([]byte) (int, error)
+		Sum([]byte) []byte
+	}
+	var err error
+	switch len(key) {
+	case 16:
+		nonce := make([]byte, aegis128l.NonceSize)
+		copy(nonce, purpose)
+		mac, err = aegis128l.NewMAC(key, nonce, n)
+	case 32:
+		nonce := make([]byte, aegis256.NonceSize)
+		copy(nonce, purpose)
+		mac, err = aegis256.NewMAC(key, nonce, n)
+	default:
+		return nil, ErrBadKeyLength
+	}
+	if err != nil {
+		return nil, err
+	}
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil), nil
+}
+
+// journal is the Store a journaled File's context writes through. Outside
+// a transaction it passes calls through to data; inside one it records the
+// changes in tx and serves reads from them.
+type journal struct {
+	data  Store // the file's store
+	store Store // Options.Journal
+	key   []byte
+	check []byte
+	id    []byte // file identifier, bound to every record
+	depth int    // nesting of begin calls
+	tx    *overlay
+}
+
+// newJournal returns a journal in store for the file with identifier id
+// on data, keyed by key.
+func newJournal(data, store Store, key, id []byte) (*journal, error) {
+	jkey, err := deriveKey(key, "raf journal key", aegis256.KeySize)
+	if err != nil {
+		return nil, err
+	}
+	check, err := deriveKey(key, "raf journal check", journalCheck)
+	if err != nil {
+		return nil, err
+	}
+	return &journal{data: data, store: store, key: jkey, check: check, id: id}, nil
+}
+
+// rekey switches the journal to the keys for key, once a rotation has
+// been committed under the old ones.
+func (j *journal) rekey(key []byte) error {
+	n, err := newJournal(j.data, j.store, key, j.id)
+	if err != nil {
+		return err
+	}
+	j.wipe()
+	j.key, j.check = n.key, n.check
+	return nil
+}
+
+// wipe clears the journal's keys.
+func (j *journal) wipe() {
+	wipe(j.key)
+	wipe(j.check)
+}
+
+// begin starts a transaction, or joins the one under way.
+func (j *journal) begin() {
+	if j.depth == 0 {
+		size, _ := j.data.GetSize()
+		j.tx = &overlay{base: size, size: size}
+	}
+	j.depth++
+}
+
+// end finishes a transaction started by begin. The outermost call commits
+// the changes if ok, and drops them otherwise. written reports whether the
+// transaction had changed anything, whether or not that reached the store.
+func (j *journal) end(ok bool) (written bool, err error) {
+	j.depth--
+	if j.depth > 0 {
+		return false, nil
+	}
+	tx := j.tx
+	j.tx = nil
+	if !tx.changed {
+		return false, nil
+	}
+	if !ok {
+		tx.wipe()
+		return true, nil
+	}
+	defer tx.wipe()
+	return true, j.commit(tx)
+}
+
+// pending reports whether the transaction under way is the outermost one
+// and has changed something, so that ending it will commit.
+func (j *journal) pending() bool {
+	return j.depth == 1 && j.tx.changed
+}
+
+// commit makes the changes of tx durable in the journal, then applies them.
+func (j *journal) commit(tx *overlay) error {
+	record, err := j.seal(tx.encode())
+	if err != nil {
+		return err
+	}
+	if err := j.store.SetSize(0); err != nil {
+		return err
+	}
+	if _, err := j.store.WriteAt(record, 0); err != nil {
+		return err
+	}
+	if err := j.store.Sync(); err != nil {
+		return err
+	}
+	if err := tx.apply(j.data); err != nil {
+		return err
+	}
+	if err := j.data.Sync(); err != nil {
+		return err
+	}
+	return j.clear()
+}
+
+// clear empties the journal once its record has been applied.
+func (j *journal) clear() error {
+	if err := j.store.SetSize(0); err != nil {
+		return err
+	}
+	return j.store.Sync()
+}
+
+func (j *journal) ad(header []byte) []byte {
+	return append(append([]byte(nil), header...), j.id...)
+}
+
+// seal encrypts a transaction body into a record.
+func (j *journal) seal(body []byte) ([]byte, error) {
+	aead, err := aegis256.New(j.key, journalTag)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, journalHeader, journalHeader+len(body)+journalTag)
+	copy(header, journalMagic)
+	header[4] = journalVersion
+	copy(header[5:], j.check)
+	nonce := header[5+journalCheck:]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	record := aead.Seal(header, nonce, body, j.ad(header[:5+journalCheck]))
+	wipe(body)
+	return record, nil
+}
+
+// recover applies a committed record left in the journal and empties it,
+// reporting whether there was one to apply. A record that does not
+// authenticate was not completely written before a crash, so the
+// transaction never reached the store and is dropped.
+func (j *journal) recover() (applied bool, err error) {
+	size, err := j.store.GetSize()
+	if err != nil {
+		return false, fmt.Errorf("raf: %w", err)
+	}
+	if size == 0 {
+		return false, nil
+	}
+	record := make([]byte, size)
+	if _, err := j.store.ReadAt(record, 0); err != nil && err != io.EOF {
+		return false, fmt.Errorf("raf: %w", err)
+	}
+	if len(record) >= 5+journalCheck {
+		if !bytes.Equal(record[:4], journalMagic) || record[4] != journalVersion {
+			return false, ErrBadJournal
+		}
+		if subtle.ConstantTimeCompare(record[5:5+journalCheck], j.check) != 1 {
+			return false, ErrAuth
+		}
+	}
+	if len(record) >= journalHeader+journalTag {
+		aead, err := aegis256.New(j.key, journalTag)
+		if err != nil {
+			return false, err
+		}
+		body, err := aead.Open(nil, record[5+journalCheck:journalHeader], record[journalHeader:], j.ad(record[:5+journalCheck]))
+		if err == nil {
+			defer wipe(body)
+			tx, err := decodeOverlay(body)
+			if err != nil {
+				return false, err
+			}
+			if err := tx.apply(j.data); err != nil {
+				return false, fmt.Errorf("raf: %w", err)
+			}
+			if err := j.data.Sync(); err != nil {
+				return false, fmt.Errorf("raf: %w", err)
+			}
+			applied = true
+		}
+	}
+	if err := j.clear(); err != nil {
+		return false, fmt.Errorf("raf: %w", err)
+	}
+	return applied, nil
+}
+
+func (j *journal) ReadAt(p []byte, off int64) (int, error) {
+	if j.tx == nil {
+		return j.data.ReadAt(p, off)
+	}
+	return j.tx.readAt(j.data, p, off)
+}
+
+func (j *journal) WriteAt(p []byte, off int64) (int, error) {
+	if j.tx == nil {
+		return j.data.WriteAt(p, off)
+	}
+	j.tx.writeAt(p, off)
+	return len(p), nil
+}
+
+func (j *journal) GetSize() (int64, error) {
+	if j.tx == nil {
+		return j.data.GetSize()
+	}
+	return j.tx.size, nil
+}
+
+func (j *journal) SetSize(size int64) error {
+	if j.tx == nil {
+		return j.data.SetSize(size)
+	}
+	j.tx.setSize(size)
+	return nil
+}
+
+// Sync within a transaction waits for the commit, which syncs.
+func (j *journal) Sync() error {
+	if j.tx == nil {
+		return j.data.Sync()
+	}
+	return nil
+}
+
+// overlay is the changes of a transaction over the store: the store's
+// bytes below base, then the extents written, in order, within size.
+type overlay struct {
+	base    int64
+	size    int64
+	writes  []extent
+	changed bool // whether writeAt or setSize was called with an effect
+}
+
+type extent struct {
+	off  int64
+	data []byte
+}
+
+func (o *overlay) readAt(data Store, p []byte, off int64) (int, error) {
+	if off >= o.size {
+		return 0, io.EOF
+	}
+	n := len(p)
+	if int64(n) > o.size-off {
+		n = int(o.size - off)
+	}
+	wipe(p[:n])
+	if off < o.base {
+		m := n
+		if int64(m) > o.base-off {
+			m = int(o.base - off)
+		}
+		if _, err := data.ReadAt(p[:m], off); err != nil && err != io.EOF {
+			return 0, err
+		}
+	}
+	for _, e := range o.writes {
+		lo, hi := e.off, e.off+int64(len(e.data))
+		if hi <= off || lo >= off+int64(n) {
+			continue
+		}
+		if lo < off {
+			copy(p[:n], e.data[off-lo:])
+		} else {
+			copy(p[lo-off:n], e.data)
+		}
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (o *overlay) writeAt(p []byte, off int64) {
+	o.writes = append(o.writes, extent{off: off, data: append([]byte(nil), p...)})
+	o.changed = true
+	if end := off + int64(len(p)); end > o.size {
+		o.size = end
+	}
+}
+
+// setSize resizes the overlay. Shrinking cuts the extents, so that every
+// extent lies within every later size and growing again reads zeros.
+func (o *overlay) setSize(size int64) {
+	if size != o.size {
+		o.changed = true
+	}
+	if size < o.size {
+		writes := o.writes[:0]
+		for _, e := range o.writes {
+			if e.off >= size {
+				wipe(e.data)
+				continue
+			}
+			if e.off+int64(len(e.data)) > size {
+				e.data = e.data[:size-e.off]
+			}
+			writes = append(writes, e)
+		}
+		o.writes = writes
+	}
+	if size < o.base {
+		o.base = size
+	}
+	o.size = size
+}
+
+// apply makes data hold what the overlay does. Applying it again, over
+// any part of an earlier application, gives the same result.
+func (o *overlay) apply(data Store) error {
+	if err := data.SetSize(o.base); err != nil {
+		return err
+	}
+	for _, e := range o.writes {
+		if _, err := data.WriteAt(e.data, e.off); err != nil {
+			return err
+		}
+	}
+	return data.SetSize(o.size)
+}
+
+func (o *overlay) wipe() {
+	for _, e := range o.writes {
+		wipe(e.data)
+	}
+	o.writes = nil
+}
+
+func (o *overlay) encode() []byte {
+	n := 3 * binary.MaxVarintLen64
+	for _, e := range o.writes {
+		n += 2*binary.MaxVarintLen64 + len(e.data)
+	}
+	b := make([]byte, 0, n)
+	b = binary.AppendUvarint(b, uint64(o.base))
+	b = binary.AppendUvarint(b, uint64(o.size))
+	b = binary.AppendUvarint(b, uint64(len(o.writes)))
+	for _, e := range o.writes {
+		b = binary.AppendUvarint(b, uint64(e.off))
+		b = binary.AppendUvarint(b, uint64(len(e.data)))
+		b = append(b, e.data...)
+	}
+	return b
+}
+
+// decodeOverlay parses a record body. The body authenticated, so a
+// malformed one means a bug rather than damage; it is still checked, so
+// that applying it cannot write outside the sizes it declares.
+func decodeOverlay(b []byte) (*overlay, error) {
+	r := bytes.NewReader(b)
+	next := func() uint64 {
+		v, err := binary.ReadUvarint(r)
+		if err != nil || v > 1<<62 {
+			r = bytes.NewReader(nil)
+			return 1 << 63
+		}
+		return v
+	}
+	o := &overlay{}
+	base, size, count := next(), next(), next()
+	if base > size || size > 1<<62 || count > uint64(len(b)) {
+		return nil, ErrBadJournal
+	}
+	o.base, o.size = int64(base), int64(size)
+	for i := uint64(0); i < count; i++ {
+		off, n := next(), next()
+		if off+n > size || n > uint64(r.Len()) {
+			return nil, ErrBadJournal
+		}
+		e := extent{off: int64(off), data: make([]byte, n)}
+		r.Read(e.data)
+		o.writes = append(o.writes, e)
+	}
+	if r.Len() != 0 {
+		return nil, ErrBadJournal
+	}
+	return o, nil
+}
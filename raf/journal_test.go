@@ -0,0 +1,134 @@
+package raf
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"github.com/aegis-aead/go-libaegis/common"
+)
+
+func TestOverlay(t *testing.T) {
+	data := NewMemStore()
+	data.WriteAt([]byte("0123456789"), 0)
+	o := &overlay{base: 10, size: 10}
+	o.writeAt([]byte("abc"), 8)
+	o.setSize(9)
+	o.setSize(12)
+	o.writeAt([]byte("XY"), 1)
+	o.setSize(4)
+	o.setSize(6)
+	o.writeAt([]byte("z"), 5)
+
+	want := []byte("0XY3\x00z")
+	p := make([]byte, 8)
+	if n, err := o.readAt(data, p, 0); n != 6 || !bytes.Equal(p[:n], want) {
+		t.Fatalf("readAt: %q, %v", p[:n], err)
+	}
+
+	// Applying the decoded overlay gives the same bytes, however much of
+	// an earlier application reached the store.
+	decoded, err := decodeOverlay(o.encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, partial := range []int{0, 1, len(decoded.writes)} {
+		s := NewMemStore()
+		s.Restore(data.Bytes())
+		half := &overlay{base: decoded.base, size: decoded.size, writes: decoded.writes[:partial]}
+		half.apply(s)
+		if err := decoded.apply(s); err != nil || !bytes.Equal(s.Bytes(), want) {
+			t.Fatalf("apply after %d extents: %q, %v", partial, s.Bytes(), err)
+		}
+	}
+
+	b := o.encode()
+	for i := range b {
+		if _, err := decodeOverlay(b[:i]); err != ErrBadJournal {
+			t.Fatalf("decodeOverlay of %d bytes: %v", i, err)
+		}
+	}
+	if _, err := decodeOverlay(append(b, 0)); err != ErrBadJournal {
+		t.Fatalf("decodeOverlay with trailing data: %v", err)
+	}
+}
+
+func TestJournal(t *testing.T) {
+	if !common.Available {
+		t.Skip("CGO not available")
+	}
+
+	key := make([]byte, 32)
+	rand.Read(key)
+	store := &countingStore{}
+	journal := NewMemStore()
+	opts := &Options{Algorithm: AEGIS256, ChunkSize: MinChunkSize, Journal: journal}
+	f, err := Create(store, key, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := make([]byte, 3*MinChunkSize)
+	rand.Read(old)
+	if _, err := f.WriteAt(old, 0); err != nil {
+		t.Fatal(err)
+	}
+	if len(journal.Bytes()) != 0 {
+		t.Fatal("the journal is not empty after a write")
+	}
+
+	// A write whose record reached the journal but not the store breaks
+	// the file, and is completed by the next Open.
+	update := bytes.Repeat([]byte{7}, 2*MinChunkSize)
+	store.fail = true
+	if _, err := f.WriteAt(update, 100); !errors.Is(err, ErrTransaction) {
+		t.Fatalf("WriteAt with a failing store: %v", err)
+	}
+	if _, err := f.ReadAt(make([]byte, 1), 0); !errors.Is(err, ErrTransaction) {
+		t.Fatalf("ReadAt after a failed transaction: %v", err)
+	}
+	f.Close()
+	store.fail = false
+	if len(journal.Bytes()) == 0 {
+		t.Fatal("the journal is empty after a failed commit")
+	}
+	pending, stale := journal.Snapshot(), store.Snapshot()
+
+	// With a wrong key the record is kept, and with garbage in the journal
+	// Open fails rather than guess.
+	if _, err := Open(store, make([]byte, 32), &Options{Journal: journal}); err != ErrAuth {
+		t.Fatalf("Open with a wrong key: %v", err)
+	}
+	garbage := NewMemStore()
+	garbage.WriteAt(bytes.Repeat([]byte{1}, 100), 0)
+	if _, err := Open(store, key, &Options{Journal: garbage}); err != ErrBadJournal {
+		t.Fatalf("Open with a garbage journal: %v", err)
+	}
+
+	f, err = Open(store, key, &Options{Journal: journal})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	want := append([]byte(nil), old...)
+	copy(want[100:], update)
+	got := make([]byte, len(want)+1)
+	if n, _ := f.ReadAt(got, 0); n != len(want) || !bytes.Equal(got[:n], want) {
+		t.Fatalf("ReadAt after recovery: %d bytes", n)
+	}
+	if len(journal.Bytes()) != 0 {
+		t.Fatal("the journal is not empty after recovery")
+	}
+	f.Close()
+
+	// A torn record is dropped, leaving the file as it was.
+	store.Restore(stale)
+	journal.Restore(pending[:len(pending)-1])
+	f, err = Open(store, key, &Options{Journal: journal})
+	if err != nil {
+		t.Fatalf("Open with a torn record: %v", err)
+	}
+	f.Close()
+	if !bytes.Equal(store.Bytes(), stale) || len(journal.Bytes()) != 0 {
+		t.Fatal("a torn record was applied or kept")
+	}
+}
@@ -0,0 +1,253 @@
+package raf
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/aegis-aead/go-libaegis/aegis256"
+)
+
+// Key slots
+//
+// A file created with Options.KeySlots is encrypted under a random file key
+// instead of the key given to Create. That key becomes a key-encryption
+// key: the file key is kept in a slot of the extension area, wrapped under
+// it. Other slots wrap the same file key under other key-encryption keys,
+// so access is granted or revoked by adding or removing a slot, and the
+// chunks are never touched. Open takes a key-encryption key and tries it
+// against every slot; the journal, freshness records and everything else
+// derived from the key use the file key it unwraps.
+//
+// The extension entry is
+//
+//	u8 len(file key) || MaxKeySlots * (kdf[26] || nonce[32] || AEGIS-256(file key) || tag[32])
+//
+// where kdf says how the slot's key-encryption key is obtained, as
+// described with passphrases, and a free slot is all zeros. It has room
+// for every slot from the start and sorts before the other entries, so
+// that it never moves: adding or removing a slot rewrites that slot alone,
+// and a crash cannot damage the others. Each slot is sealed under a key
+// derived from its key-encryption key, which is 16 or 32 bytes, with the
+// associated data "aegis-raf-keyslot-v1" || file identifier || u8 slot
+// number || kdf.
+
+const (
+	// MaxKeySlots is the number of key slots a file can have.
+	MaxKeySlots = 8
+
+	keySlotDomain = "aegis-raf-keyslot-v1"
+	keySlotTag    = 32
+)
+
+var (
+	// ErrNoKeySlots is returned by the key slot methods of a File created
+	// without key slots, and by OpenWithPassphrase for such a file.
+	ErrNoKeySlots = errors.New("raf: file has no key slots")
+
+	// ErrKeySlot is returned by File.RemoveKeySlot for a slot that is not
+	// in use.
+	ErrKeySlot = errors.New("raf: no such key slot")
+
+	// ErrLastKeySlot is returned by File.RemoveKeySlot for the only slot
+	// left, without which the file could not be opened.
+	ErrLastKeySlot = errors.New("raf: cannot remove the last key slot")
+
+	// ErrKeySlotsFull is returned by File.AddKeySlot when all MaxKeySlots
+	// slots are in use.
+	ErrKeySlotsFull = errors.New("raf: all key slots are in use")
+
+	// ErrKeySlotRekey is returned by File.Rekey for a file with key slots,
+	// whose file key cannot be replaced without every key-encryption key.
+	ErrKeySlotRekey = errors.New("raf: cannot rekey a file with key slots")
+)
+
+// keySlot is one wrapped copy of the file key.
+type keySlot struct {
+	index  byte
+	kdf    slotKDF
+	nonce  []byte
+	sealed []byte // the wrapped file key and its tag
+}
+
+// keySlots is the decoded extKeySlots entry, sorted by slot number.
+type keySlots struct {
+	keyLen int
+	slots  []keySlot
+}
+
+func parseKeySlots(b []byte) (*keySlots, error) {
+	d := proofDecoder{b: b}
+	s := &keySlots{keyLen: int(d.byte())}
+	for i := 0; i < MaxKeySlots && !d.err; i++ {
+		kdf := d.bytes(slotKDFSize)
+		nonce := d.bytes(aegis256.NonceSize)
+		sealed := d.bytes(s.keyLen + keySlotTag)
+		if d.err || kdf[0] == 0 {
+			continue
+		}
+		slot := keySlot{index: byte(i), nonce: nonce, sealed: sealed}
+		var ok bool
+		if slot.kdf, ok = parseSlotKDF(kdf); !ok {
+			return nil, ErrInvalidHeader
+		}
+		s.slots = append(s.slots, slot)
+	}
+	if d.err || len(d.b) != 0 || (s.keyLen != 16 && s.keyLen != 32) || len(s.slots) == 0 {
+		return nil, ErrInvalidHeader
+	}
+	return s, nil
+}
+
+func (s *keySlots) marshal() []byte {
+	size := slotKDFSize + aegis256.NonceSize + s.keyLen + keySlotTag
+	b := make([]byte, 1+MaxKeySlots*size)
+	b[0] = byte(s.keyLen)
+	for _, slot := range s.slots {
+		at := b[1+int(slot.index)*size:]
+		copy(at, slot.kdf.marshal())
+		copy(at[slotKDFSize:], slot.nonce)
+		copy(at[slotKDFSize+len(slot.nonce):], slot.sealed)
+	}
+	return b
+}
+
+// keySlotAEAD returns the cipher that seals a slot under kek, and the
+// associated data of slot index, with kdf, of the file with identifier id.
+func keySlotAEAD(kek, id []byte, index byte, kdf *slotKDF) (cipher.AEAD, []byte, error) {
+	k, err := deriveKey(kek, "raf key slot", aegis256.KeySize)
+	if err != nil {
+		return nil, nil, err
+	}
+	aead, err := aegis256.New(k, keySlotTag)
+	if err != nil {
+		return nil, nil, err
+	}
+	ad := append(append([]byte(keySlotDomain), id...), index)
+	return aead, append(ad, kdf.marshal()...), nil
+}
+
+// wrapKeySlot returns slot index holding key wrapped under kek, which kdf
+// derives.
+func wrapKeySlot(kek, id []byte, index byte, kdf slotKDF, key []byte) (keySlot, error) {
+	aead, ad, err := keySlotAEAD(kek, id, index, &kdf)
+	if err != nil {
+		return keySlot{}, err
+	}
+	nonce := make([]byte, aegis256.NonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return keySlot{}, err
+	}
+	return keySlot{index: index, kdf: kdf, nonce: nonce, sealed: aead.Seal(nil, nonce, key, ad)}, nil
+}
+
+// unwrap returns the file key from the first slot kek opens, or ErrAuth.
+// With passphrase, kek is a passphrase and only the slots it derives keys
+// for are tried; otherwise only the slots that take a key are.
+func (s *keySlots) unwrap(kek, id []byte, passphrase bool) ([]byte, error) {
+	if passphrase {
+		for _, slot := range s.slots {
+			if slot.kdf.kind != slotKey && !slot.kdf.params.valid() {
+				return nil, ErrBadKDFParams
+			}
+		}
+	}
+	for _, slot := range s.slots {
+		k := kek
+		if passphrase != (slot.kdf.kind != slotKey) {
+			continue
+		}
+		if passphrase {
+			var err error
+			if k, err = slot.kdf.derive(kek); err != nil {
+				return nil, err
+			}
+		}
+		aead, ad, err := keySlotAEAD(k, id, slot.index, &slot.kdf)
+		if err != nil {
+			return nil, err
+		}
+		if key, err := aead.Open(nil, slot.nonce, slot.sealed, ad); err == nil {
+			return key, nil
+		}
+	}
+	return nil, ErrAuth
+}
+
+// add wraps key under kek, which kdf derives, into the lowest free slot
+// and returns its number.
+func (s *keySlots) add(kek, id, key []byte, kdf slotKDF) (int, error) {
+	if len(s.slots) >= MaxKeySlots {
+		return 0, ErrKeySlotsFull
+	}
+	at := 0
+	for at < len(s.slots) && s.slots[at].index == byte(at) {
+		at++
+	}
+	slot, err := wrapKeySlot(kek, id, byte(at), kdf, key)
+	if err != nil {
+		return 0, err
+	}
+	s.slots = append(s.slots, keySlot{})
+	copy(s.slots[at+1:], s.slots[at:])
+	s.slots[at] = slot
+	return at, nil
+}
+
+// find returns slot index, or nil if it is free.
+func (s *keySlots) find(index int) *keySlot {
+	for i := range s.slots {
+		if int(s.slots[i].index) == index {
+			return &s.slots[i]
+		}
+	}
+	return nil
+}
+
+// remove drops slot index.
+func (s *keySlots) remove(index int) error {
+	for i, slot := range s.slots {
+		if int(slot.index) != index {
+			continue
+		}
+		if len(s.slots) == 1 {
+			return ErrLastKeySlot
+		}
+		s.slots = append(s.slots[:i:i], s.slots[i+1:]...)
+		return nil
+	}
+	return ErrKeySlot
+}
+
+// openKeySlots returns the file key of a file with key slots, unwrapped
+// with kek, or with passphrase, kek taken as a passphrase, along with its
+// slots. For a file without them it returns kek itself and nil, or
+// ErrNoKeySlots with passphrase. It reads the store directly, as the key
+// is needed before a journal can be recovered.
+func openKeySlots(store Store, kek []byte, passphrase bool) ([]byte, *keySlots, error) {
+	ext, err := readExtArea(store)
+	if err != nil {
+		return nil, nil, err
+	}
+	if ext == nil || ext.entries[extKeySlots] == nil {
+		if passphrase {
+			return nil, nil, ErrNoKeySlots
+		}
+		return kek, nil, nil
+	}
+	s, err := parseKeySlots(ext.entries[extKeySlots])
+	if err != nil {
+		return nil, nil, err
+	}
+	id := make([]byte, FileIDSize)
+	if _, err := store.ReadAt(id, int64(ext.size)+headerIDOffset); err != nil && err != io.EOF {
+		return nil, nil, fmt.Errorf("raf: %w", err)
+	}
+	key, err := s.unwrap(kek, id, passphrase)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, s, nil
+}
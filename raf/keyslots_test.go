@@ -0,0 +1,171 @@
+package raf
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+
+	"github.com/aegis-aead/go-libaegis/common"
+)
+
+func TestKeySlots(t *testing.T) {
+	if !common.Available {
+		t.Skip("CGO not available")
+	}
+
+	kek1, kek2 := make([]byte, 16), make([]byte, 32)
+	rand.Read(kek1)
+	rand.Read(kek2)
+	store, journal := NewMemStore(), NewMemStore()
+	f, err := Create(store, kek1, &Options{
+		Algorithm:     AEGIS256,
+		ChunkSize:     MinChunkSize,
+		ExtensionSize: 4096,
+		Merkle:        suiteOptions(MerkleSHA256, 16),
+		Freshness:     &Freshness{},
+		Journal:       journal,
+		KeySlots:      true,
+	})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	data := make([]byte, 3*MinChunkSize+7)
+	rand.Read(data)
+	f.WriteAt(data, 0)
+	if err := f.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	chunks := append([]byte(nil), store.Bytes()[4096:]...)
+
+	if slot, err := f.AddKeySlot(kek2); slot != 1 || err != nil {
+		t.Fatalf("AddKeySlot: %d, %v", slot, err)
+	}
+	if slots, _ := f.KeySlots(); len(slots) != 2 || slots[0] != 0 || slots[1] != 1 {
+		t.Fatalf("KeySlots: %v", slots)
+	}
+	f.Close()
+	if !bytes.Equal(store.Bytes()[4096:], chunks) {
+		t.Fatal("AddKeySlot changed the chunks")
+	}
+
+	// Either key opens the file, which is not under either of them.
+	for _, kek := range [][]byte{kek1, kek2} {
+		f, err := Open(store, kek, &Options{Journal: journal})
+		if err != nil {
+			t.Fatalf("Open with a %d-byte key: %v", len(kek), err)
+		}
+		got := make([]byte, len(data))
+		if _, err := f.ReadAt(got, 0); err != nil || !bytes.Equal(got, data) {
+			t.Fatalf("ReadAt: %v", err)
+		}
+		f.Close()
+	}
+	if _, err := Open(store, make([]byte, 32), nil); err != ErrAuth {
+		t.Fatalf("Open with a wrong key: %v", err)
+	}
+	if _, err := Open(store, make([]byte, 24), nil); err != ErrBadKeyLength {
+		t.Fatalf("Open with a 24-byte key: %v", err)
+	}
+
+	// Removing a slot revokes its key only.
+	f, _ = Open(store, kek2, &Options{Journal: journal})
+	if err := f.RemoveKeySlot(0); err != nil {
+		t.Fatalf("RemoveKeySlot: %v", err)
+	}
+	if err := f.RemoveKeySlot(0); err != ErrKeySlot {
+		t.Fatalf("RemoveKeySlot of a free slot: %v", err)
+	}
+	if err := f.RemoveKeySlot(1); err != ErrLastKeySlot {
+		t.Fatalf("RemoveKeySlot of the last slot: %v", err)
+	}
+	if err := f.Rekey(make([]byte, 32), nil); err != ErrKeySlotRekey {
+		t.Fatalf("Rekey: %v", err)
+	}
+	f.Close()
+	if _, err := Open(store, kek1, &Options{Journal: journal}); err != ErrAuth {
+		t.Fatalf("Open with a removed key: %v", err)
+	}
+
+	// Freed slots are reused, up to MaxKeySlots.
+	f, err = Open(store, kek2, &Options{Journal: journal})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < MaxKeySlots-1; i++ {
+		want := i + 1
+		if i == 0 {
+			want = 0
+		}
+		if slot, err := f.AddKeySlot(kek1); slot != want || err != nil {
+			t.Fatalf("AddKeySlot: %d, %v", slot, err)
+		}
+	}
+	if _, err := f.AddKeySlot(kek1); err != ErrKeySlotsFull {
+		t.Fatalf("AddKeySlot with every slot in use: %v", err)
+	}
+	f.Close()
+	if f, err := Open(store, kek1, &Options{Journal: journal}); err != nil {
+		t.Fatalf("Open with a re-added key: %v", err)
+	} else {
+		f.Close()
+	}
+
+	key := make([]byte, 16)
+	f, _ = Create(NewMemStore(), key, &Options{Algorithm: AEGIS128L})
+	if _, err := f.AddKeySlot(kek1); err != ErrNoKeySlots {
+		t.Fatalf("AddKeySlot without key slots: %v", err)
+	}
+	f.Close()
+	if _, err := Create(NewMemStore(), key, &Options{Algorithm: AEGIS128L, KeySlots: true}); err != ErrNoExtensionArea {
+		t.Fatalf("Create without an extension area: %v", err)
+	}
+	if _, err := Create(NewMemStore(), make([]byte, 20), &Options{Algorithm: AEGIS128L, ExtensionSize: MinExtensionSize, KeySlots: true}); err != ErrBadKeyLength {
+		t.Fatalf("Create with a 20-byte key: %v", err)
+	}
+}
+
+func TestKeySlotsJournalRecovery(t *testing.T) {
+	if !common.Available {
+		t.Skip("CGO not available")
+	}
+
+	kek := make([]byte, 32)
+	rand.Read(kek)
+	store, journal := &countingStore{}, NewMemStore()
+	f, err := Create(store, kek, &Options{
+		Algorithm:     AEGIS256,
+		ChunkSize:     MinChunkSize,
+		ExtensionSize: 4096,
+		Journal:       journal,
+		KeySlots:      true,
+	})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	// A change to the extension area whose record reached the journal but
+	// not the store is applied before Open reads the key slots it keeps.
+	store.fail = true
+	if err := f.SetMetadata(map[string]string{"k": "v"}); err == nil {
+		t.Fatal("SetMetadata with a failing store succeeded")
+	}
+	f.Close()
+	store.fail = false
+	if len(journal.Bytes()) == 0 {
+		t.Fatal("the journal is empty after a failed commit")
+	}
+	f, err = Open(store, kek, &Options{Journal: journal})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer f.Close()
+	if m, err := f.Metadata(); err != nil || m["k"] != "v" {
+		t.Fatalf("Metadata after recovery: %v, %v", m, err)
+	}
+	if slot, err := f.AddKeySlot(kek); slot != 1 || err != nil {
+		t.Fatalf("AddKeySlot after recovery: %d, %v", slot, err)
+	}
+	if m, err := f.Metadata(); err != nil || m["k"] != "v" {
+		t.Fatalf("Metadata after AddKeySlot: %v, %v", m, err)
+	}
+}
@@ -0,0 +1,48 @@
+package raf
+
+import (
+	"math/bits"
+	"sync"
+)
+
+// chunkLockStripes is the number of locks chunks are spread over. Chunk i
+// uses stripe i mod chunkLockStripes, so a set of stripes fits in a uint64.
+const chunkLockStripes = 64
+
+// chunkLocks serializes concurrent access to the same chunk. Readers of a
+// chunk share its stripe; a writer holds it exclusively, so that no reader
+// sees a record while it is being replaced.
+type chunkLocks [chunkLockStripes]sync.RWMutex
+
+// stripes returns the set of stripes covering chunks first through last.
+func (l *chunkLocks) stripes(first, last uint64) uint64 {
+	if last-first >= chunkLockStripes-1 {
+		return ^uint64(0)
+	}
+	var set uint64
+	for i := first; i <= last; i++ {
+		set |= 1 << (i % chunkLockStripes)
+	}
+	return set
+}
+
+// lock locks the stripes in set in increasing order, so that callers
+// locking overlapping sets cannot deadlock, and returns the matching unlock.
+func (l *chunkLocks) lock(set uint64, shared bool) func() {
+	for s := set; s != 0; s &= s - 1 {
+		if i := bits.TrailingZeros64(s); shared {
+			l[i].RLock()
+		} else {
+			l[i].Lock()
+		}
+	}
+	return func() {
+		for s := set; s != 0; s &= s - 1 {
+			if i := bits.TrailingZeros64(s); shared {
+				l[i].RUnlock()
+			} else {
+				l[i].Unlock()
+			}
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package raf
+
+import "errors"
+
+const (
+	MinMerkleHashSize = 8  // Minimum Merkle node hash size in bytes
+	MaxMerkleHashSize = 64 // Maximum Merkle node hash size in bytes
+)
+
+// MerkleHasher computes the nodes of a file's Merkle tree. Each method
+// writes exactly len(out) bytes to out, where len(out) is Size().
+//
+// The inputs passed to each method are what make a tree unambiguous, so
+// implementations should hash all of them with distinct domain separators
+// for leaves, parents, empty nodes and the commitment.
+type MerkleHasher interface {
+	// Size returns the hash size in bytes, between MinMerkleHashSize and
+	// MaxMerkleHashSize.
+	Size() int
+
+	// HashLeaf hashes the plaintext of chunk idx. The final chunk of a
+	// file may be shorter than the chunk size.
+	HashLeaf(out, chunk []byte, idx uint64) error
+
+	// HashParent hashes two children at level (0 for leaves) into the
+	// parent node idx of the next level.
+	HashParent(out, left, right []byte, level uint32, idx uint64) error
+
+	// HashEmpty produces the hash of the missing node idx at level, used
+	// for leaves past the end of the file and for missing right siblings.
+	HashEmpty(out []byte, level uint32, idx uint64) error
+
+	// HashCommitment binds the structural root of the tree to the file.
+	// fileCtx encodes the header version, algorithm, chunk size and file
+	// identifier; fileSize is the logical plaintext size.
+	HashCommitment(out, root, fileCtx []byte, fileSize uint64) error
+}
+
+// MerkleOptions enables a Merkle tree over the plaintext of a file.
+//
+// The tree lives in memory and is updated on every write and truncation,
+// so that MerkleCommitment always reflects the current contents. It is not
+// stored in the file: after Open the tree describes an empty file until
+// MerkleRebuild is called.
+type MerkleOptions struct {
+	// Hasher computes the tree nodes. Exactly one of Hasher and Suite
+	// must be set.
+	Hasher MerkleHasher
+
+	// Suite selects a built-in hash suite by name: MerkleSHA256,
+	// MerkleSHA512_256 or MerkleAEGISMAC.
+	Suite string
+
+	// Key is the MerkleAEGISMAC key, MerkleMACKeySize bytes long. It must
+	// be nil for the other suites.
+	Key []byte
+
+	// MaxChunks is the number of leaves in the tree, which bounds the
+	// file size at MaxChunks chunks. Writes and truncations beyond it
+	// fail with ErrOverflow. Setting up the tree costs O(MaxChunks) hash
+	// calls, and its buffer holds about 2*MaxChunks hashes. A file that
+	// records it, in a signature or a freshness record, is limited to
+	// 1<<24 leaves, since whoever opens it sets up the tree before the
+	// record is authenticated.
+	MaxChunks uint64
+}
+
+// maxRecordedChunks caps the MaxChunks a file records for its readers.
+const maxRecordedChunks = 1 << 24
+
+// recordedChunksOK reports whether maxChunks, read from a record that is
+// not yet authenticated, is within maxRecordedChunks and holds the chunks
+// of a file of size bytes, as its header gives it.
+func recordedChunksOK(maxChunks uint64, size int64, chunkSize int) bool {
+	chunks := uint64(size) / uint64(chunkSize)
+	if uint64(size)%uint64(chunkSize) != 0 {
+		chunks++
+	}
+	return maxChunks <= maxRecordedChunks && maxChunks >= chunks
+}
+
+var (
+	// ErrMerkleDisabled is returned by the Merkle methods of a File that
+	// was created or opened without Options.Merkle.
+	ErrMerkleDisabled = errors.New("raf: Merkle tree not enabled")
+
+	// ErrBadMerkleConfig is returned when Options.Merkle does not select
+	// exactly one hasher or suite, names an unknown suite, has a key of the
+	// wrong size, an unsupported hash size, or a zero MaxChunks.
+	ErrBadMerkleConfig = errors.New("raf: invalid Merkle configuration")
+)
+
+// hasher validates a Merkle configuration and returns the hasher to call
+// from C. It returns nil for a nil configuration, which disables the tree,
+// and for MerkleAEGISMAC, which is implemented in C.
+func (m *MerkleOptions) hasher() (MerkleHasher, error) {
+	if m == nil {
+		return nil, nil
+	}
+	if m.MaxChunks == 0 || (m.Hasher == nil) == (m.Suite == "") || (m.Hasher != nil && m.Key != nil) {
+		return nil, ErrBadMerkleConfig
+	}
+	if m.Suite == MerkleAEGISMAC {
+		if len(m.Key) != MerkleMACKeySize {
+			return nil, ErrBadMerkleConfig
+		}
+		return nil, nil
+	}
+	h := m.Hasher
+	if h == nil {
+		var err error
+		if h, err = NewMerkleHasher(m.Suite, m.Key); err != nil {
+			return nil, err
+		}
+	}
+	if size := h.Size(); size < MinMerkleHashSize || size > MaxMerkleHashSize {
+		return nil, ErrBadMerkleConfig
+	}
+	return h, nil
+}
@@ -0,0 +1,137 @@
+package raf
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"hash"
+
+	"github.com/aegis-aead/go-libaegis/aegis256"
+)
+
+// Built-in Merkle hash suites, selected by name with MerkleOptions.Suite.
+//
+// All suites produce 32-byte hashes and hash the following messages, with
+// integers encoded little-endian and prefix being "aegis-raf-merkle/"
+// followed by the suite name:
+//
+//	leaf:       prefix || 0x00 || u64 idx || u64 len(chunk) || chunk
+//	parent:     prefix || 0x01 || u32 level || u64 idx || left || right
+//	empty:      prefix || 0x02 || u32 level || u64 idx
+//	commitment: prefix || 0x03 || u64 len(ctx) || ctx || u64 size || root
+//
+// MerkleAEGISMAC is keyed: it computes a 256-bit AEGIS-256 MAC with
+// MerkleOptions.Key and an all-zero nonce, and is evaluated entirely in C
+// when used through Options. Its key must not be used for anything else,
+// and in particular must differ from the file's encryption key.
+//
+// The tree shape depends on MerkleOptions.MaxChunks, so commitments are only
+// comparable between files opened with the same suite, key and MaxChunks.
+const (
+	MerkleSHA256     = "sha256"
+	MerkleSHA512_256 = "sha512-256"
+	MerkleAEGISMAC   = "aegis-mac"
+)
+
+// MerkleMACKeySize is the key size of the MerkleAEGISMAC suite.
+const MerkleMACKeySize = 32
+
+const merkleSuiteSize = 32
+
+// NewMerkleHasher returns the Go implementation of a built-in suite. The key
+// is required for MerkleAEGISMAC and must be nil for the other suites. The
+// returned hasher keeps internal state and is not safe for concurrent use.
+func NewMerkleHasher(suite string, key []byte) (MerkleHasher, error) {
+	var h hash.Hash
+	switch suite {
+	case MerkleSHA256, MerkleSHA512_256:
+		if key != nil {
+			return nil, ErrBadMerkleConfig
+		}
+		if suite == MerkleSHA256 {
+			h = sha256.New()
+		} else {
+			h = sha512.New512_256()
+		}
+	case MerkleAEGISMAC:
+		if len(key) != MerkleMACKeySize {
+			return nil, ErrBadMerkleConfig
+		}
+		mac, err := aegis256.NewMAC(key, nil, merkleSuiteSize)
+		if err != nil {
+			return nil, err
+		}
+		h = mac
+	default:
+		return nil, ErrBadMerkleConfig
+	}
+	return &suiteHasher{h: h, prefix: []byte("aegis-raf-merkle/" + suite)}, nil
+}
+
+// suiteHasher implements the message encoding shared by the built-in suites
+// on top of any 32-byte hash.Hash.
+type suiteHasher struct {
+	h      hash.Hash
+	prefix []byte
+	buf    [1 + 8 + 8]byte
+}
+
+func (s *suiteHasher) Size() int { return merkleSuiteSize }
+
+// begin resets the hash and writes the prefix, the domain byte and the
+// fixed-size fields.
+func (s *suiteHasher) begin(domain byte, fields ...uint64) {
+	s.h.Reset()
+	s.h.Write(s.prefix)
+	b := append(s.buf[:0], domain)
+	for _, f := range fields {
+		b = binary.LittleEndian.AppendUint64(b, f)
+	}
+	s.h.Write(b)
+}
+
+// beginLevel is begin for the messages holding a u32 level and a u64 index.
+func (s *suiteHasher) beginLevel(domain byte, level uint32, idx uint64) {
+	s.h.Reset()
+	s.h.Write(s.prefix)
+	b := append(s.buf[:0], domain)
+	b = binary.LittleEndian.AppendUint32(b, level)
+	b = binary.LittleEndian.AppendUint64(b, idx)
+	s.h.Write(b)
+}
+
+func (s *suiteHasher) finish(out []byte) error {
+	if len(out) != merkleSuiteSize {
+		return ErrBadMerkleConfig
+	}
+	s.h.Sum(out[:0])
+	return nil
+}
+
+func (s *suiteHasher) HashLeaf(out, chunk []byte, idx uint64) error {
+	s.begin(0x00, idx, uint64(len(chunk)))
+	s.h.Write(chunk)
+	return s.finish(out)
+}
+
+func (s *suiteHasher) HashParent(out, left, right []byte, level uint32, idx uint64) error {
+	s.beginLevel(0x01, level, idx)
+	s.h.Write(left)
+	s.h.Write(right)
+	return s.finish(out)
+}
+
+func (s *suiteHasher) HashEmpty(out []byte, level uint32, idx uint64) error {
+	s.beginLevel(0x02, level, idx)
+	return s.finish(out)
+}
+
+func (s *suiteHasher) HashCommitment(out, root, fileCtx []byte, fileSize uint64) error {
+	s.begin(0x03, uint64(len(fileCtx)))
+	s.h.Write(fileCtx)
+	var size [8]byte
+	binary.LittleEndian.PutUint64(size[:], fileSize)
+	s.h.Write(size[:])
+	s.h.Write(root)
+	return s.finish(out)
+}
@@ -0,0 +1,271 @@
+package raf
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aegis-aead/go-libaegis/common"
+)
+
+var merkleSuites = []string{MerkleSHA256, MerkleSHA512_256, MerkleAEGISMAC}
+
+func suiteOptions(suite string, maxChunks uint64) *MerkleOptions {
+	m := &MerkleOptions{Suite: suite, MaxChunks: maxChunks}
+	if suite == MerkleAEGISMAC {
+		m.Key = bytes.Repeat([]byte{0x80}, MerkleMACKeySize)
+	}
+	return m
+}
+
+// referenceCommitment computes a commitment in Go, independently of the C
+// tree code: leaves for every chunk, empty leaves up to maxChunks, parents
+// with empty right siblings on odd levels, then the commitment over the
+// root and the context built from the file header.
+func referenceCommitment(t *testing.T, h MerkleHasher, header, plaintext []byte, chunkSize int, maxChunks uint64) []byte {
+	t.Helper()
+	size := h.Size()
+	level := make([][]byte, maxChunks)
+	for i := range level {
+		level[i] = make([]byte, size)
+		off := i * chunkSize
+		var err error
+		if off < len(plaintext) {
+			end := off + chunkSize
+			if end > len(plaintext) {
+				end = len(plaintext)
+			}
+			err = h.HashLeaf(level[i], plaintext[off:end], uint64(i))
+		} else {
+			err = h.HashEmpty(level[i], 0, uint64(i))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for l := uint32(0); len(level) > 1; l++ {
+		next := make([][]byte, (len(level)+1)/2)
+		for i := range next {
+			next[i] = make([]byte, size)
+			right := make([]byte, size)
+			if 2*i+1 < len(level) {
+				right = level[2*i+1]
+			} else if err := h.HashEmpty(right, l, uint64(2*i+1)); err != nil {
+				t.Fatal(err)
+			}
+			if err := h.HashParent(next[i], level[2*i], right, l, uint64(i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		level = next
+	}
+
+	fileCtx := commitmentContext(header)
+	out := make([]byte, size)
+	if err := h.HashCommitment(out, level[0], fileCtx, uint64(len(plaintext))); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestMerkleSuites(t *testing.T) {
+	if !common.Available {
+		t.Skip("CGO not available")
+	}
+
+	key := make([]byte, 32)
+	rand.Read(key)
+	for _, suite := range merkleSuites {
+		for _, size := range []int{0, 1, MinChunkSize, 4*MinChunkSize + 17} {
+			store := NewMemStore()
+			f, err := Create(store, key, &Options{
+				Algorithm: AEGIS256,
+				ChunkSize: MinChunkSize,
+				Merkle:    suiteOptions(suite, 7),
+			})
+			if err != nil {
+				t.Fatalf("%s: Create: %v", suite, err)
+			}
+			msg := make([]byte, size)
+			rand.Read(msg)
+			f.WriteAt(msg, 0)
+			got, err := f.MerkleCommitment()
+			if err != nil {
+				t.Fatalf("%s/%d: MerkleCommitment: %v", suite, size, err)
+			}
+			if idx, err := f.MerkleVerify(); err != nil {
+				t.Fatalf("%s/%d: MerkleVerify: %d, %v", suite, size, idx, err)
+			}
+			f.Close()
+
+			m := suiteOptions(suite, 7)
+			h, err := NewMerkleHasher(suite, m.Key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := referenceCommitment(t, h, store.Bytes()[:HeaderSize], msg, MinChunkSize, 7)
+			if !bytes.Equal(got, want) {
+				t.Fatalf("%s/%d: commitment\n got %x\nwant %x", suite, size, got, want)
+			}
+		}
+	}
+}
+
+func TestMerkleSuiteDomainSeparation(t *testing.T) {
+	if !common.Available {
+		t.Skip("CGO not available")
+	}
+
+	macKey := bytes.Repeat([]byte{0x80}, MerkleMACKeySize)
+	otherKey := bytes.Repeat([]byte{0x81}, MerkleMACKeySize)
+	hashers := map[string]MerkleHasher{}
+	for name, key := range map[string][]byte{MerkleSHA256: nil, MerkleSHA512_256: nil, MerkleAEGISMAC: macKey, "other key": otherKey} {
+		suite := name
+		if key != nil {
+			suite = MerkleAEGISMAC
+		}
+		h, err := NewMerkleHasher(suite, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hashers[name] = h
+	}
+
+	seen := map[string]string{}
+	record := func(what string, out []byte) {
+		k := string(out)
+		if prev, ok := seen[k]; ok {
+			t.Fatalf("%s and %s produce the same hash", prev, what)
+		}
+		seen[k] = what
+	}
+	zero := make([]byte, 32)
+	for name, h := range hashers {
+		out := make([]byte, 32)
+		h.HashLeaf(out, nil, 0)
+		record(name+" leaf", out)
+		h.HashLeaf(out, []byte{0}, 0)
+		record(name+" leaf 0x00", out)
+		h.HashLeaf(out, nil, 1)
+		record(name+" leaf 1", out)
+		h.HashEmpty(out, 0, 0)
+		record(name+" empty", out)
+		h.HashEmpty(out, 1, 0)
+		record(name+" empty level 1", out)
+		h.HashParent(out, zero, zero, 0, 0)
+		record(name+" parent", out)
+		h.HashCommitment(out, zero, nil, 0)
+		record(name+" commitment", out)
+	}
+
+	for _, bad := range []struct {
+		suite string
+		key   []byte
+	}{
+		{"md5", nil},
+		{MerkleSHA256, macKey},
+		{MerkleAEGISMAC, nil},
+		{MerkleAEGISMAC, macKey[:16]},
+	} {
+		if _, err := NewMerkleHasher(bad.suite, bad.key); !errors.Is(err, ErrBadMerkleConfig) {
+			t.Errorf("NewMerkleHasher(%q, %d-byte key): got %v", bad.suite, len(bad.key), err)
+		}
+	}
+	for _, m := range []*MerkleOptions{
+		{Suite: MerkleSHA256, Hasher: hashers[MerkleSHA256], MaxChunks: 1},
+		{Suite: MerkleAEGISMAC, MaxChunks: 1},
+		{Hasher: hashers[MerkleSHA256], Key: macKey, MaxChunks: 1},
+		{Suite: "sha3", MaxChunks: 1},
+	} {
+		opts := &Options{Algorithm: AEGIS128L, Merkle: m}
+		if _, err := Create(NewMemStore(), make([]byte, 16), opts); !errors.Is(err, ErrBadMerkleConfig) {
+			t.Errorf("Create with suite %q: got %v, want ErrBadMerkleConfig", m.Suite, err)
+		}
+	}
+}
+
+// merkleVector is a commitment of a file in testdata, for comparing
+// implementations across services.
+type merkleVector struct {
+	File       string `json:"file"`
+	Key        string `json:"key"`
+	Suite      string `json:"suite"`
+	SuiteKey   string `json:"suite_key,omitempty"`
+	MaxChunks  uint64 `json:"max_chunks"`
+	Size       int64  `json:"size"`
+	Commitment string `json:"commitment"`
+}
+
+// merklePattern is the plaintext of the vector files: byte i is i mod 251.
+func merklePattern(size int64) []byte {
+	p := make([]byte, size)
+	for i := range p {
+		p[i] = byte(i % 251)
+	}
+	return p
+}
+
+func TestMerkleVectors(t *testing.T) {
+	if !common.Available {
+		t.Skip("CGO not available")
+	}
+
+	data, err := os.ReadFile(filepath.Join("testdata", "merkle_vectors.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var vectors []merkleVector
+	if err := json.Unmarshal(data, &vectors); err != nil {
+		t.Fatal(err)
+	}
+	suites := map[string]bool{}
+	for _, v := range vectors {
+		suites[v.Suite] = true
+		raw, err := os.ReadFile(filepath.Join("testdata", v.File))
+		if err != nil {
+			t.Fatal(err)
+		}
+		store := NewReaderAtStore(bytes.NewReader(raw), int64(len(raw)))
+		key, _ := hex.DecodeString(v.Key)
+		m := &MerkleOptions{Suite: v.Suite, MaxChunks: v.MaxChunks}
+		if v.SuiteKey != "" {
+			m.Key, _ = hex.DecodeString(v.SuiteKey)
+		}
+		f, err := Open(store, key, &Options{Merkle: m})
+		if err != nil {
+			t.Fatalf("%s/%s: Open: %v", v.File, v.Suite, err)
+		}
+		plaintext := make([]byte, v.Size)
+		if n, err := f.ReadAt(plaintext, 0); int64(n) != v.Size || (err != nil && n > 0) || !bytes.Equal(plaintext, merklePattern(v.Size)) {
+			t.Fatalf("%s: unexpected plaintext", v.File)
+		}
+		if err := f.MerkleRebuild(); err != nil {
+			t.Fatalf("%s/%s: MerkleRebuild: %v", v.File, v.Suite, err)
+		}
+		got, err := f.MerkleCommitment()
+		f.Close()
+		if err != nil {
+			t.Fatalf("%s/%s: MerkleCommitment: %v", v.File, v.Suite, err)
+		}
+		if hex.EncodeToString(got) != v.Commitment {
+			t.Errorf("%s/%s: commitment\n got %x\nwant %s", v.File, v.Suite, got, v.Commitment)
+		}
+
+		h, _ := NewMerkleHasher(v.Suite, m.Key)
+		info, _ := Probe(store)
+		ref := referenceCommitment(t, h, raw[:HeaderSize], plaintext, info.ChunkSize, v.MaxChunks)
+		if hex.EncodeToString(ref) != v.Commitment {
+			t.Errorf("%s/%s: reference commitment differs from the vector", v.File, v.Suite)
+		}
+	}
+	for _, suite := range merkleSuites {
+		if !suites[suite] {
+			t.Errorf("no vectors for suite %s", suite)
+		}
+	}
+}
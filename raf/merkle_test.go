@@ -0,0 +1,238 @@
+package raf
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/aegis-aead/go-libaegis/common"
+)
+
+// testHasher is a SHA-256 MerkleHasher with one-byte domain separators.
+// failAfter, if positive, makes it fail after that many calls.
+type testHasher struct {
+	calls     int
+	failAfter int
+}
+
+var errHasher = errors.New("hasher failure")
+
+func (h *testHasher) Size() int { return sha256.Size }
+
+func (h *testHasher) sum(out []byte, parts ...[]byte) error {
+	h.calls++
+	if h.failAfter > 0 && h.calls > h.failAfter {
+		return errHasher
+	}
+	d := sha256.New()
+	for _, p := range parts {
+		d.Write(p)
+	}
+	copy(out, d.Sum(nil))
+	return nil
+}
+
+func u64(v uint64) []byte { return binary.LittleEndian.AppendUint64(nil, v) }
+
+func (h *testHasher) HashLeaf(out, chunk []byte, idx uint64) error {
+	return h.sum(out, []byte{0}, u64(idx), chunk)
+}
+
+func (h *testHasher) HashParent(out, left, right []byte, level uint32, idx uint64) error {
+	return h.sum(out, []byte{1}, u64(uint64(level)), u64(idx), left, right)
+}
+
+func (h *testHasher) HashEmpty(out []byte, level uint32, idx uint64) error {
+	return h.sum(out, []byte{2}, u64(uint64(level)), u64(idx))
+}
+
+func (h *testHasher) HashCommitment(out, root, fileCtx []byte, fileSize uint64) error {
+	return h.sum(out, []byte{3}, fileCtx, u64(fileSize), root)
+}
+
+func merkleOptions(maxChunks uint64) *Options {
+	return &Options{
+		Algorithm: AEGIS128L,
+		ChunkSize: MinChunkSize,
+		Merkle:    &MerkleOptions{Hasher: &testHasher{}, MaxChunks: maxChunks},
+	}
+}
+
+func TestMerkleCommitment(t *testing.T) {
+	if !common.Available {
+		t.Skip("CGO not available")
+	}
+
+	store := NewMemStore()
+	key := make([]byte, 16)
+	rand.Read(key)
+	f, err := Create(store, key, merkleOptions(16))
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	empty, err := f.MerkleCommitment()
+	if err != nil {
+		t.Fatalf("MerkleCommitment: %v", err)
+	}
+	if len(empty) != sha256.Size {
+		t.Fatalf("commitment length: got %d, want %d", len(empty), sha256.Size)
+	}
+
+	msg := make([]byte, 3*MinChunkSize+100)
+	rand.Read(msg)
+	if _, err := f.WriteAt(msg, 0); err != nil {
+		t.Fatalf("WriteAt: %v", err)
+	}
+	c1, _ := f.MerkleCommitment()
+	if bytes.Equal(c1, empty) {
+		t.Fatal("commitment did not change after a write")
+	}
+
+	// Changing one byte and restoring it returns to the same commitment.
+	if _, err := f.WriteAt([]byte{msg[1500] ^ 1}, 1500); err != nil {
+		t.Fatalf("WriteAt: %v", err)
+	}
+	c2, _ := f.MerkleCommitment()
+	if bytes.Equal(c2, c1) {
+		t.Fatal("commitment did not change after modifying a chunk")
+	}
+	f.WriteAt(msg[1500:1501], 1500)
+	if c3, _ := f.MerkleCommitment(); !bytes.Equal(c3, c1) {
+		t.Fatal("commitment differs after restoring the original contents")
+	}
+
+	if err := f.Truncate(2000); err != nil {
+		t.Fatalf("Truncate: %v", err)
+	}
+	truncated, _ := f.MerkleCommitment()
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	// The tree is not stored: after Open it describes an empty file until
+	// it is rebuilt.
+	f, err = Open(store, key, merkleOptions(16))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer f.Close()
+	if c, _ := f.MerkleCommitment(); bytes.Equal(c, truncated) {
+		t.Fatal("commitment after Open matches before MerkleRebuild")
+	}
+	if err := f.MerkleRebuild(); err != nil {
+		t.Fatalf("MerkleRebuild: %v", err)
+	}
+	if c, _ := f.MerkleCommitment(); !bytes.Equal(c, truncated) {
+		t.Fatal("commitment after MerkleRebuild differs from the one before Close")
+	}
+}
+
+func TestMerkleVerify(t *testing.T) {
+	if !common.Available {
+		t.Skip("CGO not available")
+	}
+
+	store := NewMemStore()
+	key := make([]byte, 16)
+	rand.Read(key)
+	f, err := Create(store, key, merkleOptions(8))
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	defer f.Close()
+
+	msg := make([]byte, 5*MinChunkSize)
+	rand.Read(msg)
+	f.WriteAt(msg, 0)
+	if idx, err := f.MerkleVerify(); idx != -1 || err != nil {
+		t.Fatalf("MerkleVerify: got (%d, %v), want (-1, nil)", idx, err)
+	}
+
+	info := f.Info()
+	record := info.RecordSize()
+	store.Bytes()[HeaderSize+3*record+16+10] ^= 1
+	idx, err := f.MerkleVerify()
+	if idx != 3 || !errors.Is(err, ErrAuth) {
+		t.Fatalf("MerkleVerify after corrupting chunk 3: got (%d, %v)", idx, err)
+	}
+}
+
+func TestMerkleLimits(t *testing.T) {
+	if !common.Available {
+		t.Skip("CGO not available")
+	}
+
+	store := NewMemStore()
+	key := make([]byte, 16)
+	rand.Read(key)
+	f, err := Create(store, key, merkleOptions(2))
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if _, err := f.WriteAt(make([]byte, 2*MinChunkSize), 0); err != nil {
+		t.Fatalf("WriteAt within MaxChunks: %v", err)
+	}
+	if _, err := f.WriteAt([]byte{1}, 2*MinChunkSize); !errors.Is(err, ErrOverflow) {
+		t.Fatalf("WriteAt beyond MaxChunks: got %v, want ErrOverflow", err)
+	}
+	f.Close()
+
+	if _, err := Open(store, key, merkleOptions(1)); !errors.Is(err, ErrOverflow) {
+		t.Fatalf("Open with too small a tree: got %v, want ErrOverflow", err)
+	}
+
+	for _, m := range []*MerkleOptions{
+		{MaxChunks: 4},
+		{Hasher: &testHasher{}},
+	} {
+		opts := &Options{Algorithm: AEGIS128L, Merkle: m}
+		if _, err := Create(NewMemStore(), key, opts); !errors.Is(err, ErrBadMerkleConfig) {
+			t.Fatalf("Create with %+v: got %v, want ErrBadMerkleConfig", m, err)
+		}
+	}
+
+	f, err = Open(store, key, nil)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer f.Close()
+	if _, err := f.MerkleCommitment(); err != ErrMerkleDisabled {
+		t.Fatalf("MerkleCommitment without a tree: got %v", err)
+	}
+	if _, err := f.MerkleVerify(); err != ErrMerkleDisabled {
+		t.Fatalf("MerkleVerify without a tree: got %v", err)
+	}
+	if err := f.MerkleRebuild(); err != ErrMerkleDisabled {
+		t.Fatalf("MerkleRebuild without a tree: got %v", err)
+	}
+}
+
+func TestMerkleHasherError(t *testing.T) {
+	if !common.Available {
+		t.Skip("CGO not available")
+	}
+
+	key := make([]byte, 16)
+	rand.Read(key)
+	h := &testHasher{}
+	f, err := Create(NewMemStore(), key, &Options{
+		Algorithm: AEGIS128L,
+		Merkle:    &MerkleOptions{Hasher: h, MaxChunks: 4},
+	})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	defer f.Close()
+
+	h.failAfter = h.calls
+	if _, err := f.WriteAt([]byte("data"), 0); !errors.Is(err, errHasher) {
+		t.Fatalf("WriteAt: got %v, want the hasher error", err)
+	}
+	if _, err := f.MerkleCommitment(); !errors.Is(err, errHasher) {
+		t.Fatalf("MerkleCommitment: got %v, want the hasher error", err)
+	}
+}
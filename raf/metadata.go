@@ -0,0 +1,132 @@
+package raf
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"sort"
+
+	"github.com/aegis-aead/go-libaegis/aegis256"
+)
+
+// Metadata
+//
+// A file with an extension area can carry a small set of key/value pairs,
+// such as a content type, an original name or a modification time, next to
+// its data. They are sealed under a key derived from the file key and bound
+// to the file identifier, so that they can be neither read without the key
+// nor moved to another file; Probe shows only the extension area's size.
+// Every file with an extension area has the entry from Create on, sealing
+// no pairs if there are none, so that removing it is caught like altering
+// it. Like the chunks, an older copy of the entry can be put back in place
+// of a newer one.
+//
+// The extension entry is
+//
+//	nonce[32] || AEGIS-256(pairs) || tag[32]
+//
+// with the associated data "aegis-raf-metadata-v1" || file identifier,
+// where pairs is
+//
+//	uvarint count || count * (uvarint len(key) || key || uvarint len(value) || value)
+//
+// sorted by key.
+
+const (
+	metadataDomain = "aegis-raf-metadata-v1"
+	metadataTag    = 32
+)
+
+// metadataKey derives the key that seals the metadata from the file key.
+func metadataKey(key []byte) ([]byte, error) {
+	return deriveKey(key, "raf metadata key", aegis256.KeySize)
+}
+
+func encodeMetadata(m map[string]string) []byte {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	b := binary.AppendUvarint(nil, uint64(len(keys)))
+	for _, k := range keys {
+		b = binary.AppendUvarint(b, uint64(len(k)))
+		b = append(b, k...)
+		b = binary.AppendUvarint(b, uint64(len(m[k])))
+		b = append(b, m[k]...)
+	}
+	return b
+}
+
+func decodeMetadata(b []byte) (map[string]string, error) {
+	next := func() (string, bool) {
+		n, w := binary.Uvarint(b)
+		if w <= 0 || n > uint64(len(b)-w) {
+			return "", false
+		}
+		s := string(b[w : w+int(n)])
+		b = b[w+int(n):]
+		return s, true
+	}
+	count, w := binary.Uvarint(b)
+	if w <= 0 || count > uint64(len(b)) {
+		return nil, ErrInvalidHeader
+	}
+	b = b[w:]
+	m := make(map[string]string, count)
+	for i := uint64(0); i < count; i++ {
+		k, ok := next()
+		if !ok {
+			return nil, ErrInvalidHeader
+		}
+		v, ok := next()
+		if !ok {
+			return nil, ErrInvalidHeader
+		}
+		if _, dup := m[k]; dup {
+			return nil, ErrInvalidHeader
+		}
+		m[k] = v
+	}
+	if len(b) != 0 {
+		return nil, ErrInvalidHeader
+	}
+	return m, nil
+}
+
+func metadataAD(id []byte) []byte {
+	return append([]byte(metadataDomain), id...)
+}
+
+// sealMetadata returns the extension entry holding m for the file with
+// identifier id.
+func sealMetadata(key, id []byte, m map[string]string) ([]byte, error) {
+	aead, err := aegis256.New(key, metadataTag)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aegis256.NonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	pairs := encodeMetadata(m)
+	defer wipe(pairs)
+	return aead.Seal(nonce, nonce, pairs, metadataAD(id)), nil
+}
+
+// openMetadata returns the pairs in an extension entry, or ErrAuth if it
+// was not sealed under key for the file with identifier id.
+func openMetadata(key, id, entry []byte) (map[string]string, error) {
+	if len(entry) < aegis256.NonceSize+metadataTag {
+		return nil, ErrAuth
+	}
+	aead, err := aegis256.New(key, metadataTag)
+	if err != nil {
+		return nil, err
+	}
+	pairs, err := aead.Open(nil, entry[:aegis256.NonceSize], entry[aegis256.NonceSize:], metadataAD(id))
+	if err != nil {
+		return nil, ErrAuth
+	}
+	defer wipe(pairs)
+	return decodeMetadata(pairs)
+}
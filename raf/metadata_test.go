@@ -0,0 +1,112 @@
+package raf
+
+import (
+	"bytes"
+	"crypto/rand"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aegis-aead/go-libaegis/common"
+)
+
+func TestMetadata(t *testing.T) {
+	if !common.Available {
+		t.Skip("CGO not available")
+	}
+
+	key := make([]byte, 32)
+	rand.Read(key)
+	meta := map[string]string{
+		"content-type": "video/mp4",
+		"name":         "holiday.mp4",
+		"mtime":        "2026-10-19T08:00:00Z",
+		"":             "",
+	}
+	opts := &Options{Algorithm: AEGIS256X2, ExtensionSize: 1024, Metadata: meta}
+	store := NewMemStore()
+	f, err := Create(store, key, opts)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	f.WriteAt([]byte("data"), 0)
+	f.Close()
+	if bytes.Contains(store.Bytes(), []byte("holiday")) {
+		t.Fatal("the metadata is stored in the clear")
+	}
+	if info, err := Probe(store); err != nil || info.ExtensionSize != 1024 {
+		t.Fatalf("Probe: %v, %v", info, err)
+	}
+
+	f, err = Open(store, key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := f.Metadata(); err != nil || !reflect.DeepEqual(got, meta) {
+		t.Fatalf("Metadata: %v, %v", got, err)
+	}
+
+	// A change that does not fit leaves the metadata as it was.
+	if err := f.SetMetadata(map[string]string{"big": strings.Repeat("x", 1024)}); err != ErrExtensionFull {
+		t.Fatalf("SetMetadata of too much: %v", err)
+	}
+	meta2 := map[string]string{"name": "renamed.mp4"}
+	if err := f.SetMetadata(meta2); err != nil {
+		t.Fatalf("SetMetadata: %v", err)
+	}
+
+	// The metadata follows the file to a new key.
+	newKey := make([]byte, 32)
+	rand.Read(newKey)
+	if err := f.Rekey(newKey, nil); err != nil {
+		t.Fatalf("Rekey: %v", err)
+	}
+	f.Close()
+	f, err = Open(store, newKey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := f.Metadata(); err != nil || !reflect.DeepEqual(got, meta2) {
+		t.Fatalf("Metadata after Rekey: %v, %v", got, err)
+	}
+	if err := f.SetMetadata(nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := f.Metadata(); got != nil || err != nil {
+		t.Fatalf("Metadata after removing it: %v, %v", got, err)
+	}
+	f.Close()
+
+	// Metadata copied from another file under the same key is rejected.
+	other := NewMemStore()
+	f, _ = Create(other, newKey, opts)
+	f.Close()
+	src, _ := readExtArea(other)
+	dst, _ := readExtArea(store)
+	dst.set(store, extMetadata, src.entries[extMetadata])
+	f, _ = Open(store, newKey, nil)
+	if _, err := f.Metadata(); err != ErrAuth {
+		t.Fatalf("Metadata of another file: %v", err)
+	}
+	f.Close()
+
+	// So is a file whose entry was removed, even if it had no pairs.
+	dst.set(store, extMetadata, nil)
+	f, _ = Open(store, newKey, nil)
+	if got, err := f.Metadata(); got != nil || err != ErrAuth {
+		t.Fatalf("Metadata after removing the entry: %v, %v", got, err)
+	}
+	f.Close()
+
+	if _, err := Create(NewMemStore(), key, &Options{Algorithm: AEGIS256, Metadata: meta}); err != ErrNoExtensionArea {
+		t.Fatalf("Create with metadata and no extension area: %v", err)
+	}
+	f, _ = Create(NewMemStore(), key, &Options{Algorithm: AEGIS256})
+	if err := f.SetMetadata(meta); err != ErrNoExtensionArea {
+		t.Fatalf("SetMetadata without an extension area: %v", err)
+	}
+	if got, err := f.Metadata(); got != nil || err != nil {
+		t.Fatalf("Metadata without an extension area: %v, %v", got, err)
+	}
+	f.Close()
+}
@@ -0,0 +1,212 @@
+package raf
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	mrand "math/rand"
+	"testing"
+
+	"github.com/aegis-aead/go-libaegis/common"
+)
+
+// TestParallelEquivalence checks that files written and read with several
+// workers hold the same plaintext, and the same Merkle commitment, as the
+// model, and that they read back without Parallelism.
+func TestParallelEquivalence(t *testing.T) {
+	if !common.Available {
+		t.Skip("CGO not available")
+	}
+
+	for _, merkle := range []bool{false, true} {
+		for _, concurrent := range []bool{false, true} {
+			for _, parallel := range []int{1, 2, 4, 8} {
+				name := fmt.Sprintf("merkle=%v/concurrent=%v/p=%d", merkle, concurrent, parallel)
+				t.Run(name, func(t *testing.T) {
+					testParallel(t, merkle, concurrent, parallel)
+				})
+			}
+		}
+	}
+}
+
+func testParallel(t *testing.T, merkle, concurrent bool, parallel int) {
+	key := make([]byte, 16)
+	rand.Read(key)
+	opts := &Options{Algorithm: AEGIS128L, ChunkSize: MinChunkSize, Concurrent: concurrent, Parallelism: parallel}
+	if merkle {
+		opts.Merkle = suiteOptions(MerkleSHA256, 256)
+	}
+	store := NewMemStore()
+	f, err := Create(store, key, opts)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	// Writes of a few bytes to many chunks, unaligned at both ends, some
+	// starting past the end of the file and some growing it.
+	rng := mrand.New(mrand.NewSource(int64(parallel)))
+	var model []byte
+	for i := 0; i < 40; i++ {
+		off := rng.Intn(len(model) + 3*MinChunkSize)
+		if off+32*MinChunkSize > 200*MinChunkSize {
+			off = rng.Intn(100 * MinChunkSize)
+		}
+		p := make([]byte, rng.Intn(32*MinChunkSize)+1)
+		rand.Read(p)
+		if n, err := f.WriteAt(p, int64(off)); n != len(p) || err != nil {
+			t.Fatalf("WriteAt(%d bytes at %d) = %d, %v", len(p), off, n, err)
+		}
+		if end := off + len(p); end > len(model) {
+			model = append(model, make([]byte, end-len(model))...)
+		}
+		copy(model[off:], p)
+
+		off = rng.Intn(len(model))
+		buf := make([]byte, rng.Intn(40*MinChunkSize)+1)
+		n, err := f.ReadAt(buf, int64(off))
+		want := model[off:]
+		if len(want) > len(buf) {
+			want = want[:len(buf)]
+		}
+		if n != len(want) || !bytes.Equal(buf[:n], want) || (n < len(buf)) != (err != nil) {
+			t.Fatalf("ReadAt(%d bytes at %d) = %d, %v", len(buf), off, n, err)
+		}
+	}
+	if size, _ := f.Size(); size != int64(len(model)) {
+		t.Fatalf("Size = %d, want %d", size, len(model))
+	}
+
+	var commitment []byte
+	if merkle {
+		if idx, err := f.MerkleVerify(); err != nil {
+			t.Fatalf("MerkleVerify: chunk %d, %v", idx, err)
+		}
+		commitment, _ = f.MerkleCommitment()
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	f, err = Open(store, key, &Options{Merkle: opts.Merkle})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer f.Close()
+	got := make([]byte, len(model))
+	if _, err := f.ReadAt(got, 0); err != nil || !bytes.Equal(got, model) {
+		t.Fatalf("contents after reopening differ: %v", err)
+	}
+	if merkle {
+		if err := f.MerkleRebuild(); err != nil {
+			t.Fatalf("MerkleRebuild: %v", err)
+		}
+		if rebuilt, _ := f.MerkleCommitment(); !bytes.Equal(rebuilt, commitment) {
+			t.Fatal("commitment after parallel writes differs from the rebuilt one")
+		}
+	}
+}
+
+func TestParallelErrors(t *testing.T) {
+	if !common.Available {
+		t.Skip("CGO not available")
+	}
+
+	key := make([]byte, 16)
+	rand.Read(key)
+	for _, parallel := range []int{1, 4} {
+		store := NewMemStore()
+		f, err := Create(store, key, &Options{Algorithm: AEGIS128L, ChunkSize: MinChunkSize, Parallelism: parallel})
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg := make([]byte, 16*MinChunkSize)
+		rand.Read(msg)
+		if _, err := f.WriteAt(msg, 0); err != nil {
+			t.Fatal(err)
+		}
+		store.Bytes()[HeaderSize+9*f.Info().RecordSize()+100] ^= 1
+
+		// Whatever is returned before the damaged chunk is intact.
+		buf := make([]byte, len(msg))
+		n, err := f.ReadAt(buf, 0)
+		if err != ErrAuth || n > 9*MinChunkSize || !bytes.Equal(buf[:n], msg[:n]) {
+			t.Fatalf("p=%d: ReadAt over a damaged chunk = %d, %v", parallel, n, err)
+		}
+		if _, err := f.ReadAt(buf[:8*MinChunkSize], 0); err != nil {
+			t.Fatalf("p=%d: ReadAt before the damaged chunk: %v", parallel, err)
+		}
+		f.Close()
+	}
+
+	// A parallel write past the end that fails leaves the store and the
+	// file at their old size.
+	store := &countingStore{}
+	f, err := Create(store, key, &Options{Algorithm: AEGIS128L, ChunkSize: MinChunkSize, Parallelism: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	msg := make([]byte, 4*MinChunkSize)
+	rand.Read(msg)
+	if _, err := f.WriteAt(msg, 0); err != nil {
+		t.Fatal(err)
+	}
+	stored, _ := store.GetSize()
+	store.fail = true
+	if _, err := f.WriteAt(make([]byte, 8*MinChunkSize), int64(len(msg))); err == nil {
+		t.Fatal("WriteAt with a failing store succeeded")
+	}
+	store.fail = false
+	if size, _ := store.GetSize(); size != stored {
+		t.Fatalf("store size after a failed write = %d, want %d", size, stored)
+	}
+	if size, _ := f.Size(); size != int64(len(msg)) {
+		t.Fatalf("Size after a failed write = %d, want %d", size, len(msg))
+	}
+	if _, err := f.WriteAt(msg, int64(len(msg))); err != nil {
+		t.Fatalf("WriteAt after a failed write: %v", err)
+	}
+	buf := make([]byte, 2*len(msg))
+	if _, err := f.ReadAt(buf, 0); err != nil || !bytes.Equal(buf[:len(msg)], msg) || !bytes.Equal(buf[len(msg):], msg) {
+		t.Fatalf("ReadAt after a failed write: %v", err)
+	}
+}
+
+func benchmarkParallel(b *testing.B, write bool) {
+	if !common.Available {
+		b.Skip("CGO not available")
+	}
+
+	const size = 16 << 20
+	key := make([]byte, 16)
+	buf := make([]byte, size)
+	for _, parallel := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("p=%d", parallel), func(b *testing.B) {
+			f, err := Create(NewMemStore(), key, &Options{Algorithm: AEGIS128L, Parallelism: parallel})
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer f.Close()
+			if _, err := f.WriteAt(buf, 0); err != nil {
+				b.Fatal(err)
+			}
+			b.SetBytes(size)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if write {
+					_, err = f.WriteAt(buf, 0)
+				} else {
+					_, err = f.ReadAt(buf, 0)
+				}
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkParallelWrite(b *testing.B) { benchmarkParallel(b, true) }
+
+func BenchmarkParallelRead(b *testing.B) { benchmarkParallel(b, false) }
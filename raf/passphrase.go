@@ -0,0 +1,124 @@
+package raf
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Passphrases
+//
+// A file created with CreateWithPassphrase is a file with key slots whose
+// first slot opens with a passphrase instead of a key: the slot's
+// key-encryption key is derived with Argon2id from the passphrase, a random
+// salt and cost parameters, all three kept in the slot. The salt and
+// parameters are part of the slot's associated data, so a slot whose
+// parameters were altered fails to open instead of deriving another key.
+// File.SetPassphrase moves a passphrase to new parameters by writing a new
+// slot and then removing the old one, without touching the chunks.
+//
+// A slot's KDF field is
+//
+//	u8 kind || u32 time || u32 memory || u8 threads || salt[16]
+//
+// with little-endian integers, where kind is 1 for a slot opened with a
+// key, whose other fields are zero, and 2 for Argon2id. Memory is in KiB.
+
+const (
+	slotKey      = 1
+	slotArgon2id = 2
+
+	kdfSaltSize = 16
+	slotKDFSize = 1 + 4 + 4 + 1 + kdfSaltSize
+
+	// A file can hold MaxKeySlots passphrase slots, each of which
+	// OpenWithPassphrase may have to derive, so the parameters it accepts
+	// stay within a few times the defaults.
+	maxKDFTime   = 8
+	maxKDFMemory = 256 << 10
+)
+
+// ErrBadKDFParams is returned for Argon2id parameters that are out of
+// range, given to CreateWithPassphrase or File.SetPassphrase or found in a
+// file by OpenWithPassphrase.
+var ErrBadKDFParams = errors.New("raf: invalid key derivation parameters")
+
+// KDFParams are the Argon2id cost parameters that turn a passphrase into a
+// key. A nil *KDFParams stands for Time 3, Memory 64 MiB and Threads 4, the
+// second recommended option of RFC 9106. At most a Time of 8 and a Memory of
+// 256 MiB are accepted, and OpenWithPassphrase checks every slot of a file
+// before deriving any, so that a file cannot make it run for longer or
+// allocate more.
+type KDFParams struct {
+	// Time is the number of passes over the memory.
+	Time uint32
+
+	// Memory is the memory to fill, in KiB. It must be at least 8 KiB per
+	// thread.
+	Memory uint32
+
+	// Threads is the degree of parallelism.
+	Threads uint8
+}
+
+func (p *KDFParams) valid() bool {
+	return p.Time >= 1 && p.Time <= maxKDFTime && p.Threads >= 1 &&
+		p.Memory >= 8*uint32(p.Threads) && p.Memory <= maxKDFMemory
+}
+
+// slotKDF is how a slot's key-encryption key is obtained.
+type slotKDF struct {
+	kind   byte
+	params KDFParams
+	salt   []byte
+}
+
+// newPassphraseKDF returns Argon2id with params, or the defaults if nil,
+// and a fresh salt.
+func newPassphraseKDF(params *KDFParams) (slotKDF, error) {
+	if params == nil {
+		params = &KDFParams{Time: 3, Memory: 64 << 10, Threads: 4}
+	}
+	if !params.valid() {
+		return slotKDF{}, ErrBadKDFParams
+	}
+	kdf := slotKDF{kind: slotArgon2id, params: *params, salt: make([]byte, kdfSaltSize)}
+	if _, err := rand.Read(kdf.salt); err != nil {
+		return slotKDF{}, err
+	}
+	return kdf, nil
+}
+
+func parseSlotKDF(b []byte) (slotKDF, bool) {
+	kdf := slotKDF{kind: b[0], salt: b[10:slotKDFSize:slotKDFSize]}
+	kdf.params.Time = binary.LittleEndian.Uint32(b[1:])
+	kdf.params.Memory = binary.LittleEndian.Uint32(b[5:])
+	kdf.params.Threads = b[9]
+	switch kdf.kind {
+	case slotKey:
+		return kdf, kdf.params == KDFParams{}
+	case slotArgon2id:
+		return kdf, true
+	}
+	return kdf, false
+}
+
+func (k *slotKDF) marshal() []byte {
+	b := make([]byte, slotKDFSize)
+	b[0] = k.kind
+	binary.LittleEndian.PutUint32(b[1:], k.params.Time)
+	binary.LittleEndian.PutUint32(b[5:], k.params.Memory)
+	b[9] = k.params.Threads
+	copy(b[10:], k.salt)
+	return b
+}
+
+// derive returns the key-encryption key for passphrase.
+func (k *slotKDF) derive(passphrase []byte) ([]byte, error) {
+	if !k.params.valid() {
+		return nil, ErrBadKDFParams
+	}
+	return argon2.IDKey(passphrase, k.salt, k.params.Time, k.params.Memory, k.params.Threads, 32), nil
+}
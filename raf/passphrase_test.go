@@ -0,0 +1,126 @@
+package raf
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"testing"
+
+	"github.com/aegis-aead/go-libaegis/common"
+)
+
+func TestPassphrase(t *testing.T) {
+	if !common.Available {
+		t.Skip("CGO not available")
+	}
+
+	pw := []byte("correct horse battery staple")
+	cheap := &KDFParams{Time: 1, Memory: 64, Threads: 1}
+	store := NewMemStore()
+	f, err := CreateWithPassphrase(store, pw, cheap, &Options{
+		Algorithm:     AEGIS256,
+		ChunkSize:     MinChunkSize,
+		ExtensionSize: 4096,
+	})
+	if err != nil {
+		t.Fatalf("CreateWithPassphrase: %v", err)
+	}
+	data := make([]byte, 2*MinChunkSize+3)
+	rand.Read(data)
+	f.WriteAt(data, 0)
+	if p, err := f.KDFParams(0); err != nil || *p != *cheap {
+		t.Fatalf("KDFParams: %v, %v", p, err)
+	}
+	f.Close()
+	chunks := append([]byte(nil), store.Bytes()[4096:]...)
+
+	f, err = OpenWithPassphrase(store, pw, nil)
+	if err != nil {
+		t.Fatalf("OpenWithPassphrase: %v", err)
+	}
+	got := make([]byte, len(data))
+	if _, err := f.ReadAt(got, 0); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("ReadAt: %v", err)
+	}
+	if _, err := OpenWithPassphrase(store, []byte("wrong"), nil); err != ErrAuth {
+		t.Fatalf("OpenWithPassphrase with a wrong passphrase: %v", err)
+	}
+
+	// Raising the cost replaces the slot and leaves the chunks alone.
+	stronger := &KDFParams{Time: 2, Memory: 128, Threads: 2}
+	slot, err := f.SetPassphrase(0, pw, stronger)
+	if err != nil || slot != 1 {
+		t.Fatalf("SetPassphrase: %d, %v", slot, err)
+	}
+	if p, err := f.KDFParams(1); err != nil || *p != *stronger {
+		t.Fatalf("KDFParams after SetPassphrase: %v, %v", p, err)
+	}
+	if _, err := f.KDFParams(0); err != ErrKeySlot {
+		t.Fatalf("KDFParams of the replaced slot: %v", err)
+	}
+	kek := make([]byte, 32)
+	rand.Read(kek)
+	if slot, err := f.AddKeySlot(kek); slot != 0 || err != nil {
+		t.Fatalf("AddKeySlot: %d, %v", slot, err)
+	}
+	if p, err := f.KDFParams(0); p != nil || err != nil {
+		t.Fatalf("KDFParams of a key slot: %v, %v", p, err)
+	}
+	if _, err := f.SetPassphrase(5, pw, cheap); err != ErrKeySlot {
+		t.Fatalf("SetPassphrase of a free slot: %v", err)
+	}
+	f.Close()
+	if !bytes.Equal(store.Bytes()[4096:], chunks) {
+		t.Fatal("SetPassphrase changed the chunks")
+	}
+
+	// A passphrase only opens passphrase slots, and a key only key slots.
+	for _, open := range []func() (*File, error){
+		func() (*File, error) { return OpenWithPassphrase(store, pw, nil) },
+		func() (*File, error) { return Open(store, kek, nil) },
+	} {
+		f, err := open()
+		if err != nil {
+			t.Fatalf("Open after SetPassphrase: %v", err)
+		}
+		f.Close()
+	}
+	if _, err := OpenWithPassphrase(store, kek, nil); err != ErrAuth {
+		t.Fatalf("OpenWithPassphrase with a key: %v", err)
+	}
+
+	// The parameters are authenticated, and bounded before they are used.
+	at := extHeaderSize + extEntryHdr + 1 + 1*(slotKDFSize+32+32+keySlotTag) + 1
+	for _, c := range []struct {
+		off   int
+		value uint32
+		want  error
+	}{{0, 3, ErrAuth}, {0, maxKDFTime + 1, ErrBadKDFParams}, {4, maxKDFMemory + 1, ErrBadKDFParams}} {
+		b := store.Snapshot()
+		binary.LittleEndian.PutUint32(b[at+c.off:], c.value)
+		store.Restore(b)
+		if _, err := OpenWithPassphrase(store, pw, nil); err != c.want {
+			t.Errorf("OpenWithPassphrase with a changed field at %d: %v", c.off, err)
+		}
+		binary.LittleEndian.PutUint32(b[at+c.off:], []uint32{2, 128}[c.off/4])
+		store.Restore(b)
+	}
+	if f, err := OpenWithPassphrase(store, pw, nil); err != nil {
+		t.Fatalf("OpenWithPassphrase after restoring the slot: %v", err)
+	} else {
+		f.Close()
+	}
+
+	if _, err := CreateWithPassphrase(NewMemStore(), pw, &KDFParams{Time: 1, Memory: 4, Threads: 1}, &Options{Algorithm: AEGIS128L, ExtensionSize: 4096}); err != ErrBadKDFParams {
+		t.Fatalf("CreateWithPassphrase with too little memory: %v", err)
+	}
+	if _, err := CreateWithPassphrase(NewMemStore(), pw, cheap, &Options{Algorithm: AEGIS128L}); err != ErrNoExtensionArea {
+		t.Fatalf("CreateWithPassphrase without an extension area: %v", err)
+	}
+	plain := NewMemStore()
+	f, _ = Create(plain, make([]byte, 16), &Options{Algorithm: AEGIS128L})
+	f.Close()
+	if _, err := OpenWithPassphrase(plain, pw, nil); err != ErrNoKeySlots {
+		t.Fatalf("OpenWithPassphrase without key slots: %v", err)
+	}
+}
@@ -0,0 +1,235 @@
+package raf
+
+import (
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+)
+
+// ChunkProof proves that a chunk is part of a file with a given Merkle
+// commitment, without the rest of the tree. Proofs are produced by
+// File.ChunkProof and checked by VerifyChunkProof.
+type ChunkProof struct {
+	// Suite is the built-in suite that produced the tree, or empty for a
+	// custom MerkleHasher.
+	Suite string
+
+	// MaxChunks is the number of leaves in the tree.
+	MaxChunks uint64
+
+	// FileSize is the logical plaintext size bound by the commitment.
+	FileSize uint64
+
+	// FileContext is the commitment context derived from the file header:
+	// version, algorithm, chunk size and file identifier.
+	FileContext []byte
+
+	// Siblings holds the sibling of each node on the path from the leaf to
+	// the root, leaf level first. Missing siblings, which are empty nodes
+	// the verifier can compute, are omitted.
+	Siblings [][]byte
+}
+
+const chunkProofVersion = 1
+
+var (
+	// ErrBadProof is returned when a chunk proof is malformed or does not
+	// fit the chunk index and hasher it is checked with.
+	ErrBadProof = errors.New("raf: malformed chunk proof")
+
+	// ErrChunkIndex is returned by File.ChunkProof for an index past the
+	// last chunk of the file.
+	ErrChunkIndex = errors.New("raf: chunk index out of range")
+)
+
+// commitmentContext builds the context the C library binds into every
+// commitment from a file header: version, algorithm, little-endian chunk
+// size and file identifier, zero-padded to 32 bytes.
+func commitmentContext(hdr []byte) []byte {
+	ctx := make([]byte, 32)
+	ctx[0] = hdr[10]
+	ctx[1] = hdr[11]
+	copy(ctx[2:6], hdr[12:16])
+	copy(ctx[6:30], hdr[24:48])
+	return ctx
+}
+
+// MarshalBinary encodes the proof as:
+//
+//	u8 version (1) || u8 len(suite) || suite || uvarint max_chunks ||
+//	uvarint file_size || u8 len(ctx) || ctx || u8 hash_len ||
+//	u8 len(siblings) || siblings
+func (p *ChunkProof) MarshalBinary() ([]byte, error) {
+	hashLen := 0
+	if len(p.Siblings) > 0 {
+		hashLen = len(p.Siblings[0])
+	}
+	if len(p.Suite) > 255 || len(p.FileContext) > 255 || hashLen > MaxMerkleHashSize || len(p.Siblings) > 64 {
+		return nil, ErrBadProof
+	}
+	b := make([]byte, 0, 2+len(p.Suite)+2*binary.MaxVarintLen64+3+len(p.FileContext)+len(p.Siblings)*hashLen)
+	b = append(b, chunkProofVersion, byte(len(p.Suite)))
+	b = append(b, p.Suite...)
+	b = binary.AppendUvarint(b, p.MaxChunks)
+	b = binary.AppendUvarint(b, p.FileSize)
+	b = append(b, byte(len(p.FileContext)))
+	b = append(b, p.FileContext...)
+	b = append(b, byte(hashLen), byte(len(p.Siblings)))
+	for _, s := range p.Siblings {
+		if len(s) != hashLen {
+			return nil, ErrBadProof
+		}
+		b = append(b, s...)
+	}
+	return b, nil
+}
+
+// UnmarshalBinary decodes a proof encoded by MarshalBinary.
+func (p *ChunkProof) UnmarshalBinary(data []byte) error {
+	d := proofDecoder{b: data}
+	if d.byte() != chunkProofVersion {
+		return ErrBadProof
+	}
+	suite := string(d.bytes(int(d.byte())))
+	maxChunks := d.uvarint()
+	fileSize := d.uvarint()
+	fileCtx := d.bytes(int(d.byte()))
+	hashLen := int(d.byte())
+	n := int(d.byte())
+	var siblings [][]byte
+	for i := 0; i < n; i++ {
+		siblings = append(siblings, d.bytes(hashLen))
+	}
+	if d.err || len(d.b) != 0 {
+		return ErrBadProof
+	}
+	*p = ChunkProof{
+		Suite:       suite,
+		MaxChunks:   maxChunks,
+		FileSize:    fileSize,
+		FileContext: append([]byte(nil), fileCtx...),
+		Siblings:    siblings,
+	}
+	for i := range p.Siblings {
+		p.Siblings[i] = append([]byte(nil), p.Siblings[i]...)
+	}
+	return nil
+}
+
+// proofDecoder reads from b, recording an error instead of reading past
+// its end.
+type proofDecoder struct {
+	b   []byte
+	err bool
+}
+
+func (d *proofDecoder) bytes(n int) []byte {
+	if d.err || n > len(d.b) {
+		d.err = true
+		return nil
+	}
+	v := d.b[:n]
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *proofDecoder) byte() byte {
+	if v := d.bytes(1); v != nil {
+		return v[0]
+	}
+	return 0
+}
+
+func (d *proofDecoder) uvarint() uint64 {
+	if d.err {
+		return 0
+	}
+	v, n := binary.Uvarint(d.b)
+	if n <= 0 {
+		d.err = true
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+// VerifyChunkProof checks an encoded proof that the chunk with the given
+// index and leaf hash belongs to the file with the given commitment. The
+// leaf hash is the suite's HashLeaf of the chunk's plaintext, for example
+// from NewMerkleHasher(MerkleSHA256, nil).
+//
+// VerifyChunkProof supports the unkeyed built-in suites. Use
+// VerifyChunkProofWith for MerkleAEGISMAC or a custom hasher. It returns
+// ErrAuth if the proof does not lead to the commitment, and ErrBadProof if
+// it is malformed.
+func VerifyChunkProof(commitment []byte, chunkIndex uint64, leafHash, proof []byte) error {
+	var p ChunkProof
+	if err := p.UnmarshalBinary(proof); err != nil {
+		return err
+	}
+	if p.Suite != MerkleSHA256 && p.Suite != MerkleSHA512_256 {
+		return ErrBadProof
+	}
+	h, err := NewMerkleHasher(p.Suite, nil)
+	if err != nil {
+		return err
+	}
+	return p.verify(h, commitment, chunkIndex, leafHash)
+}
+
+// VerifyChunkProofWith is VerifyChunkProof with the hasher that built the
+// tree, which must match the suite recorded in the proof if there is one.
+func VerifyChunkProofWith(h MerkleHasher, commitment []byte, chunkIndex uint64, leafHash, proof []byte) error {
+	var p ChunkProof
+	if err := p.UnmarshalBinary(proof); err != nil {
+		return err
+	}
+	return p.verify(h, commitment, chunkIndex, leafHash)
+}
+
+// verify walks from the leaf to the root the same way the C tree code
+// builds it: a level with an odd number of nodes pairs its last node with
+// an empty right sibling.
+func (p *ChunkProof) verify(h MerkleHasher, commitment []byte, chunkIndex uint64, leafHash []byte) error {
+	size := h.Size()
+	if len(leafHash) != size || len(commitment) != size || chunkIndex >= p.MaxChunks {
+		return ErrBadProof
+	}
+
+	node := append([]byte(nil), leafHash...)
+	parent := make([]byte, size)
+	empty := make([]byte, size)
+	idx, count, siblings := chunkIndex, p.MaxChunks, p.Siblings
+	for level := uint32(0); count > 1; level++ {
+		sibling := empty
+		if idx^1 < count {
+			if len(siblings) == 0 || len(siblings[0]) != size {
+				return ErrBadProof
+			}
+			sibling, siblings = siblings[0], siblings[1:]
+		} else if err := h.HashEmpty(empty, level, idx^1); err != nil {
+			return err
+		}
+		left, right := node, sibling
+		if idx&1 == 1 {
+			left, right = sibling, node
+		}
+		if err := h.HashParent(parent, left, right, level, idx/2); err != nil {
+			return err
+		}
+		node, parent = parent, node
+		idx, count = idx/2, (count+1)/2
+	}
+	if len(siblings) != 0 {
+		return ErrBadProof
+	}
+
+	got := make([]byte, size)
+	if err := h.HashCommitment(got, node, p.FileContext, p.FileSize); err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare(got, commitment) != 1 {
+		return ErrAuth
+	}
+	return nil
+}
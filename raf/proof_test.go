@@ -0,0 +1,144 @@
+package raf
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/aegis-aead/go-libaegis/common"
+)
+
+func TestChunkProof(t *testing.T) {
+	if !common.Available {
+		t.Skip("CGO not available")
+	}
+
+	key := make([]byte, 16)
+	rand.Read(key)
+	for _, suite := range []string{MerkleSHA256, MerkleAEGISMAC} {
+		for _, maxChunks := range []uint64{1, 5, 8, 13} {
+			m := suiteOptions(suite, maxChunks)
+			f, err := Create(NewMemStore(), key, &Options{Algorithm: AEGIS128L, ChunkSize: MinChunkSize, Merkle: m})
+			if err != nil {
+				t.Fatalf("Create: %v", err)
+			}
+			msg := make([]byte, int(maxChunks)*MinChunkSize-300)
+			rand.Read(msg)
+			f.WriteAt(msg, 0)
+			commitment, _ := f.MerkleCommitment()
+			h, _ := NewMerkleHasher(suite, m.Key)
+			verify := func(idx uint64, leaf, proof []byte) error {
+				if suite == MerkleAEGISMAC {
+					return VerifyChunkProofWith(h, commitment, idx, leaf, proof)
+				}
+				return VerifyChunkProof(commitment, idx, leaf, proof)
+			}
+
+			numChunks := uint64(f.Info().NumChunks())
+			for idx := uint64(0); idx < numChunks; idx++ {
+				p, err := f.ChunkProof(idx)
+				if err != nil {
+					t.Fatalf("%s/%d: ChunkProof(%d): %v", suite, maxChunks, idx, err)
+				}
+				enc, err := p.MarshalBinary()
+				if err != nil {
+					t.Fatalf("MarshalBinary: %v", err)
+				}
+				var dec ChunkProof
+				if err := dec.UnmarshalBinary(enc); err != nil || !reflect.DeepEqual(&dec, p) {
+					t.Fatalf("%s/%d: proof %d does not round-trip: %v", suite, maxChunks, idx, err)
+				}
+
+				end := (idx + 1) * MinChunkSize
+				if end > uint64(len(msg)) {
+					end = uint64(len(msg))
+				}
+				leaf := make([]byte, h.Size())
+				h.HashLeaf(leaf, msg[idx*MinChunkSize:end], idx)
+				if err := verify(idx, leaf, enc); err != nil {
+					t.Fatalf("%s/%d: proof %d: %v", suite, maxChunks, idx, err)
+				}
+
+				bad := append([]byte(nil), leaf...)
+				bad[0] ^= 1
+				if err := verify(idx, bad, enc); err != ErrAuth {
+					t.Fatalf("%s/%d: proof %d with a modified leaf: %v", suite, maxChunks, idx, err)
+				}
+				if maxChunks > 1 {
+					bad = append([]byte(nil), enc...)
+					bad[len(bad)-1] ^= 1
+					if err := verify(idx, leaf, bad); err != ErrAuth {
+						t.Fatalf("%s/%d: proof %d with a modified sibling: %v", suite, maxChunks, idx, err)
+					}
+				}
+				if err := verify(idx, leaf, enc[:len(enc)-1]); err != ErrBadProof {
+					t.Fatalf("%s/%d: truncated proof %d: %v", suite, maxChunks, idx, err)
+				}
+				if err := verify(maxChunks, leaf, enc); err != ErrBadProof {
+					t.Fatalf("%s/%d: index past the tree: %v", suite, maxChunks, err)
+				}
+			}
+
+			if _, err := f.ChunkProof(numChunks); err != ErrChunkIndex {
+				t.Fatalf("ChunkProof past the last chunk: got %v, want ErrChunkIndex", err)
+			}
+			f.Close()
+		}
+	}
+}
+
+func TestChunkProofIsBoundToFile(t *testing.T) {
+	if !common.Available {
+		t.Skip("CGO not available")
+	}
+
+	key := make([]byte, 16)
+	rand.Read(key)
+	msg := make([]byte, 3*MinChunkSize)
+	rand.Read(msg)
+	var commitments, proofs [][]byte
+	for i := 0; i < 2; i++ {
+		f, _ := Create(NewMemStore(), key, &Options{Algorithm: AEGIS128L, ChunkSize: MinChunkSize, Merkle: suiteOptions(MerkleSHA256, 4)})
+		f.WriteAt(msg, 0)
+		c, _ := f.MerkleCommitment()
+		p, _ := f.ChunkProof(1)
+		enc, _ := p.MarshalBinary()
+		commitments, proofs = append(commitments, c), append(proofs, enc)
+		f.Close()
+	}
+
+	h, _ := NewMerkleHasher(MerkleSHA256, nil)
+	leaf := make([]byte, 32)
+	h.HashLeaf(leaf, msg[MinChunkSize:2*MinChunkSize], 1)
+	if err := VerifyChunkProof(commitments[0], 1, leaf, proofs[0]); err != nil {
+		t.Fatalf("VerifyChunkProof: %v", err)
+	}
+	// Files with identical contents have different identifiers, so a proof
+	// from one does not verify against the other's commitment.
+	if err := VerifyChunkProof(commitments[1], 1, leaf, proofs[0]); err != ErrAuth {
+		t.Fatalf("proof checked against another file: got %v, want ErrAuth", err)
+	}
+	if err := VerifyChunkProof(commitments[0], 2, leaf, proofs[0]); !errors.Is(err, ErrAuth) {
+		t.Fatalf("proof checked with another index: got %v, want ErrAuth", err)
+	}
+
+	// Keyed proofs need the key.
+	f, _ := Create(NewMemStore(), key, &Options{Algorithm: AEGIS128L, Merkle: suiteOptions(MerkleAEGISMAC, 4)})
+	defer f.Close()
+	f.WriteAt([]byte("x"), 0)
+	c, _ := f.MerkleCommitment()
+	p, _ := f.ChunkProof(0)
+	enc, _ := p.MarshalBinary()
+	if err := VerifyChunkProof(c, 0, leaf, enc); err != ErrBadProof {
+		t.Fatalf("VerifyChunkProof on an AEGIS-MAC proof: got %v, want ErrBadProof", err)
+	}
+
+	for _, bad := range [][]byte{nil, {2}, bytes.Repeat([]byte{1}, 3)} {
+		var p ChunkProof
+		if err := p.UnmarshalBinary(bad); err != ErrBadProof {
+			t.Fatalf("UnmarshalBinary(%x): got %v", bad, err)
+		}
+	}
+}
@@ -53,13 +53,36 @@ const (
 	MaxChunkSize = 1 << 20   // Maximum plaintext chunk size (1 MiB)
 	HeaderSize   = 64        // On-disk file header size in bytes
 	DefaultChunk = 64 * 1024 // Default chunk size (64 KiB)
+	TagSize      = 16        // Authentication tag bytes stored with each chunk
+	FileIDSize   = 24        // Random file identifier bytes in the header
 )
 
 // FileInfo contains metadata about an encrypted file.
 type FileInfo struct {
-	Size      int64     // Logical plaintext file size
-	ChunkSize int       // Plaintext bytes per chunk
-	Algorithm Algorithm // AEGIS variant
+	Size          int64     // Logical plaintext file size
+	ChunkSize     int       // Plaintext bytes per chunk
+	Algorithm     Algorithm // AEGIS variant
+	ExtensionSize int       // Bytes reserved before the header, or 0
+}
+
+// NumChunks returns the number of chunks holding the file's data.
+func (fi FileInfo) NumChunks() int64 {
+	if fi.ChunkSize <= 0 {
+		return 0
+	}
+	return (fi.Size + int64(fi.ChunkSize) - 1) / int64(fi.ChunkSize)
+}
+
+// RecordSize returns the on-disk size of one chunk: its nonce, ciphertext
+// and tag. Nonces are the same size as keys for every algorithm.
+func (fi FileInfo) RecordSize() int {
+	return fi.Algorithm.KeySize() + fi.ChunkSize + TagSize
+}
+
+// StoreSize returns the backing store size of a well-formed file with this
+// header: the extension area if any, the header, and one record per chunk.
+func (fi FileInfo) StoreSize() int64 {
+	return int64(fi.ExtensionSize) + HeaderSize + fi.NumChunks()*int64(fi.RecordSize())
 }
 
 // Options configures file creation or opening.
@@ -76,12 +99,80 @@ type Options struct {
 	// Truncate, when set with Create, overwrites an existing file instead
 	// of returning ErrExists.
 	Truncate bool
+
+	// Merkle, if set, maintains a Merkle tree over the file's plaintext.
+	// Used by both Create and Open.
+	Merkle *MerkleOptions
+
+	// ExtensionSize, if non-zero, reserves an extension area of that many
+	// bytes at the start of the store for data such as signatures. It must
+	// be between MinExtensionSize and MaxExtensionSize. Ignored for Open
+	// (detected from the store).
+	ExtensionSize int
+
+	// Concurrent lets ReadAt calls, and WriteAt calls to different chunks
+	// within the current file size, run in parallel when the file has no
+	// Merkle tree. Each goroutine in such a call uses its own scratch
+	// buffer. The Store must be safe for concurrent use. Used by both
+	// Create and Open.
+	Concurrent bool
+
+	// Parallelism is the number of goroutines a single ReadAt or WriteAt
+	// spreads its chunks over. Values below 2 process chunks one after
+	// another. As with Concurrent, the Store must be safe for concurrent
+	// use. The file format does not depend on it. Used by both Create and
+	// Open.
+	Parallelism int
+
+	// CacheSize, if positive, keeps up to that many bytes of decrypted
+	// chunks in memory, and at least one chunk. Writes go to the cache and
+	// reach the store when their chunks are evicted, on Sync and Close,
+	// and before Truncate and the Merkle operations. Cached plaintext is
+	// wiped when it is evicted and on Close. A cached File runs its calls
+	// one at a time, ignoring Concurrent. Used by both Create and Open.
+	CacheSize int
+
+	// Journal, if set, makes every WriteAt and Truncate atomic: after a
+	// crash the file holds either the data from before the call or all of
+	// it. Changes are first written to Journal as an encrypted record, so
+	// each call costs an extra write and three syncs, and its changes are
+	// held in memory until then. Writes run one at a time, ignoring
+	// Concurrent and Parallelism, and with CacheSize, the chunks a WriteAt
+	// changes are written back before it returns. Journal must be the same
+	// Store every time the file is opened: Open completes or discards a
+	// transaction a crash interrupted. Used by both Create and Open.
+	Journal Store
+
+	// PreviousKey, with Open, is the key a File.Rekey to the key given to
+	// Open started from. If the file does not open with the new key
+	// because the rotation was interrupted, and the file records a
+	// rotation to that key, Open finishes it. Ignored for Create.
+	PreviousKey []byte
+
+	// Freshness, if set with Create, keeps a record of the file's version
+	// and Merkle commitment that lets Open detect old chunks, headers or
+	// whole files put back in place of newer ones. With Open, it sets the
+	// oldest version the caller accepts. See Freshness.
+	Freshness *Freshness
+
+	// KeySlots, with Create, encrypts the file under a random file key and
+	// makes the key given to Create, 16 or 32 bytes long, the first of the
+	// keys that unwrap it. More can be added with File.AddKeySlot. Open
+	// detects key slots, and then takes any of those keys. Needs
+	// ExtensionSize; the slots take 977 bytes of the area with a 32-byte
+	// file key. Ignored for Open.
+	KeySlots bool
+
+	// Metadata, with Create, gives the file key/value pairs sealed in its
+	// extension area, such as a content type or an original name. Needs
+	// ExtensionSize. See File.SetMetadata. Ignored for Open.
+	Metadata map[string]string
 }
 
 // Store is the backing storage for an encrypted file.
-// Since File is not safe for concurrent use, Store methods will not be
-// called concurrently by a single File. Implementations do not need
-// internal synchronization for use with a single File.
+// A File calls Store methods one at a time unless it was created or opened
+// with Options.Concurrent, so implementations only need internal
+// synchronization for concurrent Files.
 type Store interface {
 	// ReadAt reads exactly len(p) bytes at offset off from the backing store.
 	ReadAt(p []byte, off int64) (n int, err error)
@@ -161,6 +252,26 @@ var (
 
 	// ErrNegativeOffset is returned when a negative offset or size is passed.
 	ErrNegativeOffset = errors.New("raf: negative offset or size")
+
+	// ErrReadOnly is returned when modifying a File that was opened for
+	// reading only, such as one returned by OpenVerified, or a read-only
+	// Store, such as one returned by NewReaderAtStore.
+	ErrReadOnly = errors.New("raf: file is read-only")
+
+	// ErrTransaction is returned by a journaled File once a transaction
+	// has failed partway, as its context may no longer match the store.
+	// The File must be closed and opened again with the same journal,
+	// which leaves the file as it was before or after the transaction.
+	ErrTransaction = errors.New("raf: journaled transaction failed; reopen the file")
+
+	// ErrBroken is returned by a File once a failed parallel write has
+	// left its context out of step with the store. The File must be
+	// closed and opened again.
+	ErrBroken = errors.New("raf: file state lost after a failed write; reopen the file")
+
+	// ErrRekeyIncomplete is returned by a File whose key rotation stopped
+	// partway. Open it with the new key and Options.PreviousKey to finish.
+	ErrRekeyIncomplete = errors.New("raf: key rotation incomplete; reopen with Options.PreviousKey")
 )
 
 // cAlgID maps Algorithm to the C AEGIS_RAF_ALG_* constant.
@@ -3,7 +3,11 @@
 
 package raf
 
-import "github.com/aegis-aead/go-libaegis/common"
+import (
+	"crypto/ed25519"
+
+	"github.com/aegis-aead/go-libaegis/common"
+)
 
 // File is an encrypted random-access file.
 // This is the non-CGO stub; all operations panic.
@@ -19,6 +23,16 @@ func Open(store Store, key []byte, opts *Options) (*File, error) {
 	return nil, nil
 }
 
+func CreateWithPassphrase(store Store, passphrase []byte, params *KDFParams, opts *Options) (*File, error) {
+	common.NotAvailable()
+	return nil, nil
+}
+
+func OpenWithPassphrase(store Store, passphrase []byte, opts *Options) (*File, error) {
+	common.NotAvailable()
+	return nil, nil
+}
+
 func Probe(store Store) (*FileInfo, error) {
 	common.NotAvailable()
 	return nil, nil
@@ -58,3 +72,103 @@ func (f *File) Info() FileInfo {
 	common.NotAvailable()
 	return FileInfo{}
 }
+
+func (f *File) ID() ([]byte, error) {
+	common.NotAvailable()
+	return nil, nil
+}
+
+func (f *File) generation() uint64 {
+	common.NotAvailable()
+	return 0
+}
+
+func (f *File) freshnessMerkle() (string, uint64, bool) {
+	common.NotAvailable()
+	return "", 0, false
+}
+
+func (f *File) CacheStats() CacheStats {
+	common.NotAvailable()
+	return CacheStats{}
+}
+
+func (f *File) MerkleCommitment() ([]byte, error) {
+	common.NotAvailable()
+	return nil, nil
+}
+
+func (f *File) MerkleVerify() (int64, error) {
+	common.NotAvailable()
+	return 0, nil
+}
+
+func (f *File) MerkleRebuild() error {
+	common.NotAvailable()
+	return nil
+}
+
+func (f *File) ChunkProof(index uint64) (*ChunkProof, error) {
+	common.NotAvailable()
+	return nil, nil
+}
+
+func (f *File) Sign(priv ed25519.PrivateKey) error {
+	common.NotAvailable()
+	return nil
+}
+
+func (f *File) Rekey(newKey []byte, progress func(done, total int64) error) error {
+	common.NotAvailable()
+	return nil
+}
+
+func (f *File) Version() (uint64, []byte, error) {
+	common.NotAvailable()
+	return 0, nil, nil
+}
+
+func (f *File) AddKeySlot(kek []byte) (int, error) {
+	common.NotAvailable()
+	return 0, nil
+}
+
+func (f *File) RemoveKeySlot(slot int) error {
+	common.NotAvailable()
+	return nil
+}
+
+func (f *File) KeySlots() ([]int, error) {
+	common.NotAvailable()
+	return nil, nil
+}
+
+func (f *File) AddPassphrase(passphrase []byte, params *KDFParams) (int, error) {
+	common.NotAvailable()
+	return 0, nil
+}
+
+func (f *File) SetPassphrase(slot int, passphrase []byte, params *KDFParams) (int, error) {
+	common.NotAvailable()
+	return 0, nil
+}
+
+func (f *File) KDFParams(slot int) (*KDFParams, error) {
+	common.NotAvailable()
+	return nil, nil
+}
+
+func (f *File) SetMetadata(m map[string]string) error {
+	common.NotAvailable()
+	return nil
+}
+
+func (f *File) Metadata() (map[string]string, error) {
+	common.NotAvailable()
+	return nil, nil
+}
+
+func OpenVerified(store Store, key []byte, publicKey ed25519.PublicKey) (*File, error) {
+	common.NotAvailable()
+	return nil, nil
+}
@@ -14,59 +14,6 @@ import (
 	"github.com/aegis-aead/go-libaegis/common"
 )
 
-// memStore is an in-memory Store for testing.
-type memStore struct {
-	data []byte
-}
-
-func newMemStore() *memStore {
-	return &memStore{}
-}
-
-func (s *memStore) ReadAt(p []byte, off int64) (int, error) {
-	if off >= int64(len(s.data)) {
-		return 0, io.EOF
-	}
-	n := copy(p, s.data[off:])
-	if n < len(p) {
-		return n, io.EOF
-	}
-	return n, nil
-}
-
-func (s *memStore) WriteAt(p []byte, off int64) (int, error) {
-	end := int(off) + len(p)
-	if end > len(s.data) {
-		grown := make([]byte, end)
-		copy(grown, s.data)
-		s.data = grown
-	}
-	copy(s.data[off:], p)
-	return len(p), nil
-}
-
-func (s *memStore) GetSize() (int64, error) {
-	return int64(len(s.data)), nil
-}
-
-func (s *memStore) SetSize(size int64) error {
-	if int64(len(s.data)) == size {
-		return nil
-	}
-	if size < int64(len(s.data)) {
-		s.data = s.data[:size]
-	} else {
-		grown := make([]byte, size)
-		copy(grown, s.data)
-		s.data = grown
-	}
-	return nil
-}
-
-func (s *memStore) Sync() error {
-	return nil
-}
-
 func TestCreateOpenRoundTrip(t *testing.T) {
 	if !common.Available {
 		t.Skip("CGO not available")
@@ -86,7 +33,7 @@ func TestCreateOpenRoundTrip(t *testing.T) {
 
 	for _, tc := range algorithms {
 		t.Run(tc.alg.String(), func(t *testing.T) {
-			store := newMemStore()
+			store := NewMemStore()
 			key := make([]byte, tc.keySize)
 			rand.Read(key)
 
@@ -158,7 +105,7 @@ func TestProbe(t *testing.T) {
 		t.Skip("CGO not available")
 	}
 
-	store := newMemStore()
+	store := NewMemStore()
 	key := make([]byte, 32)
 	rand.Read(key)
 
@@ -196,7 +143,7 @@ func TestWrongKey(t *testing.T) {
 		t.Skip("CGO not available")
 	}
 
-	store := newMemStore()
+	store := NewMemStore()
 	key := make([]byte, 32)
 	rand.Read(key)
 
@@ -227,7 +174,7 @@ func TestCreateExists(t *testing.T) {
 		t.Skip("CGO not available")
 	}
 
-	store := newMemStore()
+	store := NewMemStore()
 	key := make([]byte, 16)
 	rand.Read(key)
 
@@ -256,7 +203,7 @@ func TestTruncate(t *testing.T) {
 		t.Skip("CGO not available")
 	}
 
-	store := newMemStore()
+	store := NewMemStore()
 	key := make([]byte, 16)
 	rand.Read(key)
 
@@ -299,7 +246,7 @@ func TestRandomAccessWrite(t *testing.T) {
 		t.Skip("CGO not available")
 	}
 
-	store := newMemStore()
+	store := NewMemStore()
 	key := make([]byte, 32)
 	rand.Read(key)
 
@@ -418,7 +365,7 @@ func TestLargeWrite(t *testing.T) {
 		t.Skip("CGO not available")
 	}
 
-	store := newMemStore()
+	store := NewMemStore()
 	key := make([]byte, 16)
 	rand.Read(key)
 
@@ -459,7 +406,7 @@ func TestCloseReopen(t *testing.T) {
 		t.Skip("CGO not available")
 	}
 
-	store := newMemStore()
+	store := NewMemStore()
 	key := make([]byte, 32)
 	rand.Read(key)
 
@@ -503,12 +450,43 @@ func TestCloseReopen(t *testing.T) {
 	}
 }
 
+func TestFileID(t *testing.T) {
+	if !common.Available {
+		t.Skip("CGO not available")
+	}
+
+	key := make([]byte, 16)
+	rand.Read(key)
+	store := NewMemStore()
+	f, _ := Create(store, key, &Options{Algorithm: AEGIS128L})
+	id, err := f.ID()
+	if err != nil || len(id) != FileIDSize {
+		t.Fatalf("ID: %x, %v", id, err)
+	}
+	f.WriteAt([]byte("data"), 0)
+	f.Close()
+	if _, err := f.ID(); err != ErrClosed {
+		t.Fatalf("ID after Close: got %v", err)
+	}
+
+	f, _ = Open(store, key, nil)
+	defer f.Close()
+	if reopened, _ := f.ID(); !bytes.Equal(reopened, id) {
+		t.Fatalf("ID after Open: %x, want %x", reopened, id)
+	}
+	other, _ := Create(NewMemStore(), key, &Options{Algorithm: AEGIS128L})
+	defer other.Close()
+	if otherID, _ := other.ID(); bytes.Equal(otherID, id) {
+		t.Fatal("two files share an ID")
+	}
+}
+
 func TestClosedFileErrors(t *testing.T) {
 	if !common.Available {
 		t.Skip("CGO not available")
 	}
 
-	store := newMemStore()
+	store := NewMemStore()
 	key := make([]byte, 16)
 	rand.Read(key)
 
@@ -543,7 +521,7 @@ func TestBadKeyLength(t *testing.T) {
 		t.Skip("CGO not available")
 	}
 
-	store := newMemStore()
+	store := NewMemStore()
 
 	_, err := Create(store, []byte("short"), &Options{Algorithm: AEGIS256})
 	if err != ErrBadKeyLength {
@@ -556,7 +534,7 @@ func TestBadChunkSize(t *testing.T) {
 		t.Skip("CGO not available")
 	}
 
-	store := newMemStore()
+	store := NewMemStore()
 	key := make([]byte, 16)
 	rand.Read(key)
 
@@ -578,7 +556,7 @@ func TestInfo(t *testing.T) {
 		t.Skip("CGO not available")
 	}
 
-	store := newMemStore()
+	store := NewMemStore()
 	key := make([]byte, 32)
 	rand.Read(key)
 
@@ -605,11 +583,25 @@ func TestInfo(t *testing.T) {
 	if info.Size != 9 {
 		t.Fatalf("Info size: got %d, want 9", info.Size)
 	}
+
+	if _, err = f.WriteAt([]byte("x"), 2*8192); err != nil {
+		t.Fatalf("WriteAt: %v", err)
+	}
+	info = f.Info()
+	if info.NumChunks() != 3 {
+		t.Fatalf("NumChunks: got %d, want 3", info.NumChunks())
+	}
+	if info.RecordSize() != 32+8192+TagSize {
+		t.Fatalf("RecordSize: got %d", info.RecordSize())
+	}
+	if size, _ := store.GetSize(); size != info.StoreSize() {
+		t.Fatalf("StoreSize: got %d, store holds %d bytes", info.StoreSize(), size)
+	}
 }
 
-// failSyncStore wraps a memStore but makes Sync() fail.
+// failSyncStore wraps a MemStore but makes Sync() fail.
 type failSyncStore struct {
-	*memStore
+	*MemStore
 }
 
 func (s *failSyncStore) Sync() error {
@@ -618,7 +610,7 @@ func (s *failSyncStore) Sync() error {
 
 // countSyncStore counts Sync calls.
 type countSyncStore struct {
-	*memStore
+	*MemStore
 	syncCount int
 }
 
@@ -633,7 +625,7 @@ func TestCallbackErrorPropagation(t *testing.T) {
 	}
 
 	// Create a valid file first with a working store.
-	goodStore := newMemStore()
+	goodStore := NewMemStore()
 	key := make([]byte, 16)
 	rand.Read(key)
 
@@ -652,7 +644,7 @@ func TestCallbackErrorPropagation(t *testing.T) {
 	}
 
 	// Try to open with a store whose ReadAt fails.
-	badStore := &failReadStore{data: goodStore.data}
+	badStore := &failReadStore{data: goodStore.Bytes()}
 	_, err = Open(badStore, key, nil)
 	if err == nil {
 		t.Fatal("Open with failing ReadAt should error")
@@ -662,7 +654,7 @@ func TestCallbackErrorPropagation(t *testing.T) {
 	}
 }
 
-// failReadStore wraps memStore but makes ReadAt fail after first call.
+// failReadStore wraps a MemStore but makes ReadAt fail after first call.
 type failReadStore struct {
 	data  []byte
 	calls int
@@ -705,7 +697,7 @@ func TestCloseSyncError(t *testing.T) {
 		t.Skip("CGO not available")
 	}
 
-	underlying := newMemStore()
+	underlying := NewMemStore()
 	key := make([]byte, 16)
 	rand.Read(key)
 
@@ -721,7 +713,7 @@ func TestCloseSyncError(t *testing.T) {
 	f.Close()
 
 	// Reopen with a store whose Sync fails.
-	store := &failSyncStore{memStore: underlying}
+	store := &failSyncStore{MemStore: underlying}
 	f2, err := Open(store, key, nil)
 	if err != nil {
 		t.Fatalf("Open: %v", err)
@@ -741,7 +733,7 @@ func TestNegativeOffset(t *testing.T) {
 		t.Skip("CGO not available")
 	}
 
-	store := newMemStore()
+	store := NewMemStore()
 	key := make([]byte, 16)
 	rand.Read(key)
 
@@ -767,7 +759,7 @@ func TestCloseSingleSync(t *testing.T) {
 		t.Skip("CGO not available")
 	}
 
-	underlying := newMemStore()
+	underlying := NewMemStore()
 	key := make([]byte, 16)
 	rand.Read(key)
 
@@ -782,7 +774,7 @@ func TestCloseSingleSync(t *testing.T) {
 	f.Close()
 
 	// Reopen with a counting store.
-	store := &countSyncStore{memStore: underlying}
+	store := &countSyncStore{MemStore: underlying}
 	f2, err := Open(store, key, nil)
 	if err != nil {
 		t.Fatalf("Open: %v", err)
@@ -803,7 +795,7 @@ func TestSyncError(t *testing.T) {
 		t.Skip("CGO not available")
 	}
 
-	store := &failSyncStore{memStore: newMemStore()}
+	store := &failSyncStore{MemStore: NewMemStore()}
 	key := make([]byte, 16)
 	rand.Read(key)
 
@@ -824,3 +816,141 @@ func TestSyncError(t *testing.T) {
 	}
 	f.Close()
 }
+
+func TestSelfTest(t *testing.T) {
+	if !common.Available {
+		t.Skip("CGO not available")
+	}
+
+	f, err := Create(NewMemStore(), make([]byte, 16), &Options{Algorithm: AEGIS128L})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	f.Close()
+	for _, r := range common.SelfTestStatus() {
+		if r.Name == "RAF" {
+			if r.State != common.SelfTestPassed {
+				t.Fatalf("self-test state: got %v, want passed (%v)", r.State, r.Err)
+			}
+			return
+		}
+	}
+	t.Fatal("RAF self-test is not registered")
+}
+
+// FuzzOperations runs random WriteAt, Truncate, ReadAt, reopen and store
+// corruption sequences and checks them against an in-memory plaintext model.
+// Each operation takes four bytes of ops: a kind and three arguments.
+func FuzzOperations(f *testing.F) {
+	if !common.Available {
+		f.Skip("CGO not available")
+	}
+	f.Add(uint8(0), []byte{0, 0, 0, 200, 2, 0, 0, 255, 3, 0, 0, 0, 2, 0, 10, 100})
+	f.Add(uint8(3), []byte{0, 4, 10, 255, 1, 2, 0, 0, 2, 0, 0, 255, 4, 0, 1, 7, 2, 4, 0, 64})
+	f.Add(uint8(5), []byte{1, 30, 0, 0, 0, 10, 0, 50, 3, 0, 0, 0, 2, 0, 0, 255, 4, 0, 5, 128})
+
+	f.Fuzz(func(t *testing.T, algByte uint8, ops []byte) {
+		alg := Algorithm(algByte % 6)
+		key := make([]byte, alg.KeySize())
+		key[0] = algByte
+		recordSize := alg.KeySize() + MinChunkSize + 16
+
+		store := NewMemStore()
+		file, err := Create(store, key, &Options{Algorithm: alg, ChunkSize: MinChunkSize})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { file.Close() }()
+
+		var model []byte
+		for i := 0; i+4 <= len(ops) && i < 4*64; i += 4 {
+			kind, arg, n := ops[i]%5, int(ops[i+1])<<8|int(ops[i+2]), int(ops[i+3])
+			switch kind {
+			case 0: // WriteAt
+				off := arg % 6000
+				data := make([]byte, n*8)
+				for j := range data {
+					data[j] = byte(i + j)
+				}
+				if _, err := file.WriteAt(data, int64(off)); err != nil {
+					t.Fatalf("op %d: WriteAt(%d, %d): %v", i/4, len(data), off, err)
+				}
+				if len(data) == 0 {
+					continue // an empty write does not extend the file
+				}
+				if end := off + len(data); end > len(model) {
+					model = append(model, make([]byte, end-len(model))...)
+				}
+				copy(model[off:], data)
+
+			case 1: // Truncate
+				size := arg % 8000
+				if err := file.Truncate(int64(size)); err != nil {
+					t.Fatalf("op %d: Truncate(%d): %v", i/4, size, err)
+				}
+				if size < len(model) {
+					model = model[:size]
+				} else {
+					model = append(model, make([]byte, size-len(model))...)
+				}
+
+			case 2: // ReadAt
+				off := arg % 8000
+				buf := make([]byte, n*8)
+				got, err := file.ReadAt(buf, int64(off))
+				want := 0
+				if off < len(model) {
+					want = len(model) - off
+				}
+				if want > len(buf) {
+					want = len(buf)
+				}
+				if got != want {
+					t.Fatalf("op %d: ReadAt(%d, %d): read %d bytes, want %d (%v)", i/4, len(buf), off, got, want, err)
+				}
+				if want < len(buf) && err != io.EOF {
+					t.Fatalf("op %d: ReadAt(%d, %d): got %v, want io.EOF", i/4, len(buf), off, err)
+				}
+				if want == len(buf) && err != nil {
+					t.Fatalf("op %d: ReadAt(%d, %d): %v", i/4, len(buf), off, err)
+				}
+				if got > 0 && !bytes.Equal(buf[:got], model[off:off+got]) {
+					t.Fatalf("op %d: ReadAt(%d, %d): data mismatch", i/4, len(buf), off)
+				}
+
+			case 3: // reopen
+				if err := file.Close(); err != nil {
+					t.Fatalf("op %d: Close: %v", i/4, err)
+				}
+				if file, err = Open(store, key, nil); err != nil {
+					t.Fatalf("op %d: Open: %v", i/4, err)
+				}
+
+			case 4: // corrupt one byte of a live record, read it, then repair it
+				records := (len(model) + MinChunkSize - 1) / MinChunkSize
+				if records == 0 {
+					continue
+				}
+				idx := arg % records
+				pos := HeaderSize + idx*recordSize + n*recordSize/256
+				store.Bytes()[pos] ^= 0x40
+				buf := make([]byte, 1)
+				if _, err := file.ReadAt(buf, int64(idx*MinChunkSize)); err != ErrAuth {
+					t.Fatalf("op %d: corrupted chunk %d at byte %d: got %v, want ErrAuth", i/4, idx, pos, err)
+				}
+				store.Bytes()[pos] ^= 0x40
+			}
+		}
+
+		if size, err := file.Size(); err != nil || size != int64(len(model)) {
+			t.Fatalf("Size: got %d, %v; want %d", size, err, len(model))
+		}
+		buf := make([]byte, len(model))
+		if _, err := file.ReadAt(buf, 0); err != nil && len(buf) > 0 {
+			t.Fatalf("final ReadAt: %v", err)
+		}
+		if !bytes.Equal(buf, model) {
+			t.Fatal("final contents differ from the model")
+		}
+	})
+}
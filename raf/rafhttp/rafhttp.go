@@ -0,0 +1,72 @@
+// Package rafhttp serves the plaintext of encrypted RAF files over HTTP.
+//
+// Responses support Range and If-Range requests, conditional requests on
+// the ETag and Last-Modified, and carry the file's plaintext size as their
+// Content-Length. Only the chunks a request touches are decrypted.
+// Authorizing clients is left to the caller, for example as middleware
+// around Handler.
+package rafhttp
+
+import (
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/aegis-aead/go-libaegis/raf"
+)
+
+// ETag returns the strong entity tag ServeFile sends for f.
+//
+// For a file with a Merkle tree, the tag is taken from its commitment, so
+// it changes with every write; after Open the tree must have been rebuilt
+// with MerkleRebuild. Otherwise it is taken from the file identifier and
+// size, which only tells files and their lengths apart: a file served that
+// way should not be rewritten in place while clients may hold its tag.
+func ETag(f *raf.File) (string, error) {
+	commitment, err := f.MerkleCommitment()
+	if err == nil {
+		if len(commitment) > 16 {
+			commitment = commitment[:16]
+		}
+		return `"` + hex.EncodeToString(commitment) + `"`, nil
+	}
+	if err != raf.ErrMerkleDisabled {
+		return "", err
+	}
+	id, err := f.ID()
+	if err != nil {
+		return "", err
+	}
+	size, err := f.Size()
+	if err != nil {
+		return "", err
+	}
+	return `"` + hex.EncodeToString(id) + "-" + strconv.FormatInt(size, 16) + `"`, nil
+}
+
+// ServeFile replies to r with the plaintext of f. The name is used to pick
+// the Content-Type if the response has none, and a non-zero modtime is
+// sent as Last-Modified. ServeFile does not close f.
+func ServeFile(w http.ResponseWriter, r *http.Request, f *raf.File, name string, modtime time.Time) {
+	etag, err := ETag(f)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("ETag", etag)
+
+	// Without readahead, a cursor decrypts the chunks of the requested
+	// ranges and nothing else.
+	c := f.NewCursor(0)
+	defer c.Close()
+	http.ServeContent(w, r, name, modtime, c)
+}
+
+// Handler returns a handler that serves f for every request, as ServeFile
+// does.
+func Handler(f *raf.File, name string, modtime time.Time) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ServeFile(w, r, f, name, modtime)
+	})
+}
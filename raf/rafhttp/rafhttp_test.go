@@ -0,0 +1,188 @@
+package rafhttp
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aegis-aead/go-libaegis/common"
+	"github.com/aegis-aead/go-libaegis/raf"
+)
+
+const chunkSize = raf.MinChunkSize
+
+// memStore is a raf.MemStore that records the lowest and highest offsets
+// read since reset.
+type memStore struct {
+	*raf.MemStore
+	mu       sync.Mutex
+	min, max int64
+}
+
+func (s *memStore) reset() {
+	s.mu.Lock()
+	s.min, s.max = -1, -1
+	s.mu.Unlock()
+}
+
+func (s *memStore) ReadAt(p []byte, off int64) (int, error) {
+	s.mu.Lock()
+	if s.min < 0 || off < s.min {
+		s.min = off
+	}
+	if end := off + int64(len(p)); end > s.max {
+		s.max = end
+	}
+	s.mu.Unlock()
+	return s.MemStore.ReadAt(p, off)
+}
+
+func newFile(t *testing.T, opts *raf.Options, size int) (*raf.File, *memStore, []byte) {
+	t.Helper()
+	key := make([]byte, 16)
+	rand.Read(key)
+	store := &memStore{MemStore: raf.NewMemStore()}
+	opts.Algorithm, opts.ChunkSize = raf.AEGIS128L, chunkSize
+	f, err := raf.Create(store, key, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := make([]byte, size)
+	rand.Read(msg)
+	if _, err := f.WriteAt(msg, 0); err != nil {
+		t.Fatal(err)
+	}
+	return f, store, msg
+}
+
+func get(t *testing.T, h http.Handler, method string, header ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, "/data.json", nil)
+	for i := 0; i < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestServeFile(t *testing.T) {
+	if !common.Available {
+		t.Skip("CGO not available")
+	}
+
+	f, _, msg := newFile(t, &raf.Options{}, 10*chunkSize+77)
+	defer f.Close()
+	modtime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	h := Handler(f, "data.json", modtime)
+
+	rec := get(t, h, http.MethodGet)
+	etag := rec.Header().Get("ETag")
+	if rec.Code != http.StatusOK || !bytes.Equal(rec.Body.Bytes(), msg) || etag == "" ||
+		rec.Header().Get("Content-Length") != strconv.Itoa(len(msg)) ||
+		rec.Header().Get("Accept-Ranges") != "bytes" ||
+		rec.Header().Get("Last-Modified") != modtime.Format(http.TimeFormat) {
+		t.Fatalf("GET: %d %v", rec.Code, rec.Header())
+	}
+	if id, _ := f.ID(); !bytes.Contains([]byte(etag), []byte(fmt.Sprintf("%x", id))) {
+		t.Fatalf("ETag %s does not carry the file identifier", etag)
+	}
+
+	rec = get(t, h, http.MethodHead)
+	if rec.Code != http.StatusOK || rec.Body.Len() != 0 || rec.Header().Get("Content-Length") != strconv.Itoa(len(msg)) {
+		t.Fatalf("HEAD: %d %v", rec.Code, rec.Header())
+	}
+
+	rec = get(t, h, http.MethodGet, "Range", "bytes=1000-5000")
+	if rec.Code != http.StatusPartialContent || !bytes.Equal(rec.Body.Bytes(), msg[1000:5001]) ||
+		rec.Header().Get("Content-Range") != fmt.Sprintf("bytes 1000-5000/%d", len(msg)) ||
+		rec.Header().Get("Content-Length") != "4001" {
+		t.Fatalf("Range: %d %v", rec.Code, rec.Header())
+	}
+	rec = get(t, h, http.MethodGet, "Range", "bytes=-10")
+	if rec.Code != http.StatusPartialContent || !bytes.Equal(rec.Body.Bytes(), msg[len(msg)-10:]) {
+		t.Fatalf("suffix Range: %d", rec.Code)
+	}
+	rec = get(t, h, http.MethodGet, "Range", fmt.Sprintf("bytes=%d-", len(msg)+1))
+	if rec.Code != http.StatusRequestedRangeNotSatisfiable {
+		t.Fatalf("Range past the end: %d", rec.Code)
+	}
+
+	// If-Range honours the Range only while the tag matches.
+	rec = get(t, h, http.MethodGet, "Range", "bytes=0-9", "If-Range", etag)
+	if rec.Code != http.StatusPartialContent || !bytes.Equal(rec.Body.Bytes(), msg[:10]) {
+		t.Fatalf("If-Range with the current tag: %d", rec.Code)
+	}
+	rec = get(t, h, http.MethodGet, "Range", "bytes=0-9", "If-Range", `"stale"`)
+	if rec.Code != http.StatusOK || rec.Body.Len() != len(msg) {
+		t.Fatalf("If-Range with another tag: %d", rec.Code)
+	}
+	rec = get(t, h, http.MethodGet, "If-None-Match", etag)
+	if rec.Code != http.StatusNotModified {
+		t.Fatalf("If-None-Match: %d", rec.Code)
+	}
+
+	// Growing the file changes the tag.
+	f.WriteAt([]byte("more"), int64(len(msg)))
+	if rec = get(t, h, http.MethodGet); rec.Header().Get("ETag") == etag {
+		t.Fatal("ETag unchanged after the file grew")
+	}
+
+	f.Close()
+	if rec = get(t, h, http.MethodGet); rec.Code != http.StatusInternalServerError {
+		t.Fatalf("GET of a closed file: %d", rec.Code)
+	}
+}
+
+func TestServeFileMerkle(t *testing.T) {
+	if !common.Available {
+		t.Skip("CGO not available")
+	}
+
+	f, _, _ := newFile(t, &raf.Options{Merkle: &raf.MerkleOptions{Suite: raf.MerkleSHA256, MaxChunks: 64}}, 4*chunkSize)
+	defer f.Close()
+	h := Handler(f, "data.json", time.Time{})
+
+	rec := get(t, h, http.MethodGet)
+	etag := rec.Header().Get("ETag")
+	commitment, _ := f.MerkleCommitment()
+	if etag != fmt.Sprintf(`"%x"`, commitment[:16]) || rec.Header().Get("Last-Modified") != "" {
+		t.Fatalf("GET: ETag %s, headers %v", etag, rec.Header())
+	}
+
+	// An in-place write changes the commitment, so a client holding the
+	// old tag gets the whole new file.
+	f.WriteAt([]byte("edit"), 10)
+	rec = get(t, h, http.MethodGet, "Range", "bytes=0-9", "If-Range", etag)
+	if rec.Code != http.StatusOK || rec.Header().Get("ETag") == etag {
+		t.Fatalf("If-Range after a write: %d, ETag %s", rec.Code, rec.Header().Get("ETag"))
+	}
+}
+
+// TestServeFileDecryptsRange checks that a range request reads only the
+// records of the chunks it covers.
+func TestServeFileDecryptsRange(t *testing.T) {
+	if !common.Available {
+		t.Skip("CGO not available")
+	}
+
+	f, store, msg := newFile(t, &raf.Options{}, 32*chunkSize)
+	defer f.Close()
+	h := Handler(f, "data.json", time.Time{})
+	record := int64(f.Info().RecordSize())
+
+	store.reset()
+	rec := get(t, h, http.MethodGet, "Range", fmt.Sprintf("bytes=%d-%d", 10*chunkSize+5, 12*chunkSize-1))
+	if rec.Code != http.StatusPartialContent || !bytes.Equal(rec.Body.Bytes(), msg[10*chunkSize+5:12*chunkSize]) {
+		t.Fatalf("Range: %d", rec.Code)
+	}
+	if lo, hi := raf.HeaderSize+10*record, raf.HeaderSize+12*record; store.min < lo || store.max > hi {
+		t.Fatalf("read store bytes %d to %d for records %d to %d", store.min, store.max, lo, hi)
+	}
+}
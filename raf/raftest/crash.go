@@ -0,0 +1,147 @@
+package raftest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/aegis-aead/go-libaegis/raf"
+)
+
+// ReplayCrashes checks that workload leaves a store in an acceptable state
+// wherever it crashes.
+//
+// It first runs workload once on a MemStore restored from initial, to
+// record the operations that change the store or make changes durable.
+// Then it replays workload on a fresh copy for every one of those
+// operations, crashing the store as that operation runs. A crashing write
+// is replayed twice: once torn, half written, and once not written at all.
+// Each crash is checked twice: with the store as the crash left it, as
+// though every write had reached the disk, and after PowerLoss, which
+// discards the changes since the last Sync. check is given a copy of the
+// store in each state and returns an error if the state is not acceptable;
+// see Versions.
+//
+// workload must do the same operations on every run, and its errors are
+// ignored once the store has crashed. The first failed check is returned,
+// describing the crash point.
+func ReplayCrashes(initial []byte, workload func(raf.Store) error, check func(raf.Store) error) error {
+	return ReplayCrashesMulti([][]byte{initial},
+		func(s []raf.Store) error { return workload(s[0]) },
+		func(s []raf.Store) error { return check(s[0]) })
+}
+
+// ReplayCrashesMulti is ReplayCrashes for data kept in several stores that
+// crash together, such as a file and its journal. There is one store per
+// element of initial, and a crash point is any operation on any of them.
+func ReplayCrashesMulti(initial [][]byte, workload func([]raf.Store) error, check func([]raf.Store) error) error {
+	// run runs workload on fresh copies of the stores, with faults.
+	run := func(faults ...Fault) (*FaultStore, []*raf.MemStore, error) {
+		mems := make([]*raf.MemStore, len(initial))
+		stores := make([]raf.Store, len(initial))
+		var first *FaultStore
+		for i, data := range initial {
+			mems[i] = raf.NewMemStore()
+			mems[i].Restore(data)
+			if i == 0 {
+				first = NewFaultStore(mems[i])
+				stores[i] = first
+			} else {
+				stores[i] = first.Attach(mems[i])
+			}
+		}
+		for _, f := range faults {
+			first.Inject(f)
+		}
+		return first, mems, workload(stores)
+	}
+	// checkCopy checks copies of the stores, so that check may change them.
+	checkCopy := func(mems []*raf.MemStore) error {
+		stores := make([]raf.Store, len(mems))
+		for i, m := range mems {
+			c := raf.NewMemStore()
+			c.Restore(m.Bytes())
+			stores[i] = c
+		}
+		return check(stores)
+	}
+
+	clean, mems, err := run()
+	if err != nil {
+		return fmt.Errorf("raftest: workload without a crash: %w", err)
+	}
+	if err := checkCopy(mems); err != nil {
+		return fmt.Errorf("raftest: after the workload without a crash: %w", err)
+	}
+
+	for point, op := range clean.d.log {
+		tears := []int{0}
+		if op.op == OpWrite && op.n > 1 {
+			tears = append(tears, op.n/2)
+		}
+		for _, partial := range tears {
+			s, mems, err := run(Fault{Ops: OpMutate, After: point, Partial: partial, Crash: true})
+			s.d.mu.Lock()
+			crashed := s.d.crashed
+			s.d.mu.Unlock()
+			if !crashed {
+				return fmt.Errorf("raftest: workload with a crash at operation %d did not reach it: %v", point, err)
+			}
+
+			where := fmt.Sprintf("crash at operation %d (%s of %d bytes at %d", point, op.op, op.n, op.off)
+			if len(initial) > 1 {
+				where += fmt.Sprintf(" in store %d", op.store)
+			}
+			if partial > 0 {
+				where += fmt.Sprintf(", torn after %d", partial)
+			}
+			where += ")"
+			if err := checkCopy(mems); err != nil {
+				return fmt.Errorf("raftest: %s: %w", where, err)
+			}
+			if err := s.PowerLoss(); err != nil {
+				return err
+			}
+			if err := checkCopy(mems); err != nil {
+				return fmt.Errorf("raftest: %s, after power loss: %w", where, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Versions returns a check for ReplayCrashes that accepts a store holding
+// a RAF file that opens with key and opts and reads back as one of
+// versions, or one that fails authentication, which raf reports with
+// raf.ErrAuth. Anything else, such as a file that authenticates but holds
+// different data, fails the check.
+func Versions(key []byte, opts *raf.Options, versions ...[]byte) func(raf.Store) error {
+	return func(s raf.Store) error {
+		f, err := raf.Open(s, key, opts)
+		if errors.Is(err, raf.ErrAuth) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("Open: %w", err)
+		}
+		defer f.Close()
+		size, err := f.Size()
+		if err != nil {
+			return err
+		}
+		data := make([]byte, size)
+		if _, err := f.ReadAt(data, 0); err != nil && err != io.EOF {
+			if errors.Is(err, raf.ErrAuth) {
+				return nil
+			}
+			return fmt.Errorf("ReadAt: %w", err)
+		}
+		for _, v := range versions {
+			if bytes.Equal(data, v) {
+				return nil
+			}
+		}
+		return fmt.Errorf("the file authenticates but holds none of the %d versions (%d bytes)", len(versions), size)
+	}
+}
@@ -0,0 +1,378 @@
+// Package raftest provides tools for testing code built on raf: a Store
+// that injects I/O faults and simulates crashes, and a harness that replays
+// a workload with a crash at every point and checks what survives.
+package raftest
+
+import (
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/aegis-aead/go-libaegis/raf"
+)
+
+var (
+	// ErrInjected is returned by an injected fault that sets no error of
+	// its own.
+	ErrInjected = errors.New("raftest: injected fault")
+
+	// ErrCrashed is returned by every operation of a FaultStore after a
+	// simulated crash, until Restart.
+	ErrCrashed = errors.New("raftest: store crashed")
+)
+
+// Op is a set of Store operations.
+type Op uint8
+
+const (
+	OpRead Op = 1 << iota
+	OpWrite
+	OpGetSize
+	OpSetSize
+	OpSync
+
+	// OpMutate covers the operations that change the store or make
+	// changes durable.
+	OpMutate = OpWrite | OpSetSize | OpSync
+	// OpAll covers every operation.
+	OpAll = OpRead | OpWrite | OpGetSize | OpSetSize | OpSync
+)
+
+var opNames = []string{"ReadAt", "WriteAt", "GetSize", "SetSize", "Sync"}
+
+func (o Op) String() string {
+	var names []string
+	for i, name := range opNames {
+		if o&(1<<i) != 0 {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, "|")
+}
+
+// Fault describes a failure of some Store operations. A fault matches the
+// operations in Ops that touch the byte range [Off, End), or any offset if
+// End is zero. It skips the first After matches, then fires on the next
+// one, or on every one after that if Persistent is set.
+type Fault struct {
+	Ops        Op
+	Off, End   int64
+	After      int
+	Persistent bool
+
+	// Err is the error the operation returns; nil means ErrInjected.
+	Err error
+
+	// Partial makes a read or write transfer that many bytes, capped at
+	// the length of the operation, before returning Err: a short read, or
+	// a torn write.
+	Partial int
+
+	// Crash crashes the store once the fault has fired, as Crash does.
+	Crash bool
+
+	matched int
+	done    bool
+}
+
+// FaultStore wraps a Store, injecting faults into its operations and
+// simulating crashes and power loss. It keeps an undo log of the changes
+// made since the last successful Sync, so that PowerLoss can discard them.
+// A FaultStore is safe for concurrent use if the Store it wraps is.
+type FaultStore struct {
+	inner raf.Store
+	index int // position in d.stores
+	d     *domain
+	undo  []undoRecord
+}
+
+// domain is what the FaultStores attached to each other share: they fail,
+// crash and lose power together.
+type domain struct {
+	mu      sync.Mutex
+	stores  []*FaultStore
+	faults  []*Fault
+	counts  [5]int
+	crashed bool
+	log     []opRecord // the operations in OpMutate, for ReplayCrashes
+}
+
+// opRecord describes an operation on n bytes at off of a store, or a
+// resize to off.
+type opRecord struct {
+	op    Op
+	store int
+	off   int64
+	n     int
+}
+
+// undoRecord restores the store as it was before one change: its size, and
+// the bytes the change overwrote at off.
+type undoRecord struct {
+	size int64
+	off  int64
+	old  []byte
+}
+
+// NewFaultStore returns a FaultStore that passes operations through to
+// inner until a fault is injected.
+func NewFaultStore(inner raf.Store) *FaultStore {
+	s := &FaultStore{inner: inner, d: &domain{}}
+	s.d.stores = []*FaultStore{s}
+	return s
+}
+
+// Attach returns a FaultStore over inner that shares the faults, operation
+// counts and crashes of s, for data kept in several stores that go down
+// together, such as a file and its journal. Faults match operations on
+// any of the attached stores, and PowerLoss rolls back each of them to its
+// own last Sync.
+func (s *FaultStore) Attach(inner raf.Store) *FaultStore {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	a := &FaultStore{inner: inner, index: len(s.d.stores), d: s.d}
+	s.d.stores = append(s.d.stores, a)
+	return a
+}
+
+// Inject adds a fault. Faults are checked in the order they were added,
+// and the first that fires decides the outcome of an operation.
+func (s *FaultStore) Inject(f Fault) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	s.d.faults = append(s.d.faults, &f)
+}
+
+// Count returns how many operations in ops have been called, including
+// ones that failed.
+func (s *FaultStore) Count(ops Op) int {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	n := 0
+	for i, c := range s.d.counts {
+		if ops&(1<<i) != 0 {
+			n += c
+		}
+	}
+	return n
+}
+
+// Crash makes every later operation fail with ErrCrashed, on this store and
+// those attached to it. The changes made so far stay in the wrapped
+// stores, as if the system had written them out before going down.
+func (s *FaultStore) Crash() {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	s.d.crashed = true
+}
+
+// PowerLoss crashes the store and rolls the wrapped store, and those of
+// the stores attached to it, back to their state at their last successful
+// Sync, discarding every change made since.
+func (s *FaultStore) PowerLoss() error {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	s.d.crashed = true
+	for _, a := range s.d.stores {
+		if err := a.rollback(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rollback undoes the changes made since the last Sync.
+func (s *FaultStore) rollback() error {
+	for i := len(s.undo) - 1; i >= 0; i-- {
+		u := s.undo[i]
+		if err := s.inner.SetSize(u.size); err != nil {
+			return err
+		}
+		if len(u.old) == 0 {
+			continue
+		}
+		if _, err := s.inner.WriteAt(u.old, u.off); err != nil {
+			return err
+		}
+	}
+	s.undo = nil
+	return nil
+}
+
+// Restart brings a crashed store, and those attached to it, back and
+// removes all faults, so that the data can be opened again through them.
+func (s *FaultStore) Restart() {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	s.d.crashed = false
+	s.d.faults = nil
+	s.d.log = nil
+	for _, a := range s.d.stores {
+		a.undo = nil
+	}
+}
+
+// begin counts an operation on [off, off+n) and returns the fault that
+// fires for it, if any.
+func (s *FaultStore) begin(op Op, off int64, n int) (*Fault, error) {
+	for i := range opNames {
+		if op == 1<<i {
+			s.d.counts[i]++
+		}
+	}
+	if op&OpMutate != 0 {
+		s.d.log = append(s.d.log, opRecord{op, s.index, off, n})
+	}
+	if s.d.crashed {
+		return nil, ErrCrashed
+	}
+	for _, f := range s.d.faults {
+		if f.done || f.Ops&op == 0 {
+			continue
+		}
+		if f.End > 0 && (off >= f.End || off+int64(n) <= f.Off) {
+			continue
+		}
+		f.matched++
+		if f.matched <= f.After {
+			continue
+		}
+		if !f.Persistent {
+			f.done = true
+		}
+		return f, nil
+	}
+	return nil, nil
+}
+
+// fail ends an operation hit by f.
+func (s *FaultStore) fail(f *Fault) error {
+	if f.Crash {
+		s.d.crashed = true
+	}
+	if f.Err != nil {
+		return f.Err
+	}
+	return ErrInjected
+}
+
+// partial caps the Partial bytes of f at n.
+func partial(f *Fault, n int) int {
+	if f.Partial > n {
+		return n
+	}
+	return f.Partial
+}
+
+func (s *FaultStore) ReadAt(p []byte, off int64) (int, error) {
+	s.d.mu.Lock()
+	f, err := s.begin(OpRead, off, len(p))
+	s.d.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	if f == nil {
+		return s.inner.ReadAt(p, off)
+	}
+	n, err := s.inner.ReadAt(p[:partial(f, len(p))], off)
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	if err == nil {
+		err = s.fail(f)
+	}
+	return n, err
+}
+
+func (s *FaultStore) WriteAt(p []byte, off int64) (int, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	f, err := s.begin(OpWrite, off, len(p))
+	if err != nil {
+		return 0, err
+	}
+	if f != nil {
+		p = p[:partial(f, len(p))]
+	}
+	if err := s.save(off, int64(len(p))); err != nil {
+		return 0, err
+	}
+	n, err := s.inner.WriteAt(p, off)
+	if err == nil && f != nil {
+		err = s.fail(f)
+	}
+	return n, err
+}
+
+func (s *FaultStore) GetSize() (int64, error) {
+	s.d.mu.Lock()
+	f, err := s.begin(OpGetSize, 0, 0)
+	if f != nil {
+		err = s.fail(f)
+	}
+	s.d.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	return s.inner.GetSize()
+}
+
+func (s *FaultStore) SetSize(size int64) error {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	f, err := s.begin(OpSetSize, size, 0)
+	if f != nil {
+		err = s.fail(f)
+	}
+	if err != nil {
+		return err
+	}
+	if err := s.save(size, 0); err != nil {
+		return err
+	}
+	return s.inner.SetSize(size)
+}
+
+func (s *FaultStore) Sync() error {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	f, err := s.begin(OpSync, 0, 0)
+	if f != nil {
+		err = s.fail(f)
+	}
+	if err != nil {
+		return err
+	}
+	if err := s.inner.Sync(); err != nil {
+		return err
+	}
+	s.undo = nil
+	return nil
+}
+
+// save records how to undo a change to [off, off+n) of the wrapped store,
+// or, for n == 0, a resize to off.
+func (s *FaultStore) save(off, n int64) error {
+	size, err := s.inner.GetSize()
+	if err != nil {
+		return err
+	}
+	end := off + n
+	if n == 0 {
+		end = size
+	}
+	if end > size {
+		end = size
+	}
+	u := undoRecord{size: size, off: off}
+	if off < end {
+		u.old = make([]byte, end-off)
+		if _, err := s.inner.ReadAt(u.old, off); err != nil {
+			return err
+		}
+	}
+	s.undo = append(s.undo, u)
+	return nil
+}
@@ -0,0 +1,404 @@
+package raftest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/aegis-aead/go-libaegis/common"
+	"github.com/aegis-aead/go-libaegis/raf"
+)
+
+func TestFaultStore(t *testing.T) {
+	mem := raf.NewMemStore()
+	s := NewFaultStore(mem)
+	s.WriteAt([]byte("0123456789"), 0)
+
+	errBoom := errors.New("boom")
+	s.Inject(Fault{Ops: OpRead, After: 1, Partial: 3, Err: errBoom})
+	p := make([]byte, 5)
+	if n, err := s.ReadAt(p, 0); n != 5 || err != nil {
+		t.Fatalf("first ReadAt: %d, %v", n, err)
+	}
+	if n, err := s.ReadAt(p, 5); n != 3 || err != errBoom || string(p[:3]) != "567" {
+		t.Fatalf("short ReadAt: %d, %v", n, err)
+	}
+	if n, err := s.ReadAt(p, 5); n != 5 || err != nil {
+		t.Fatalf("ReadAt after a one-off fault: %d, %v", n, err)
+	}
+
+	// A persistent fault limited to a range spares writes outside it.
+	s.Inject(Fault{Ops: OpWrite, Off: 4, End: 6, Persistent: true, Partial: 1})
+	if _, err := s.WriteAt([]byte("ab"), 0); err != nil {
+		t.Fatalf("WriteAt outside the range: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if n, err := s.WriteAt([]byte("xyz"), 3); n != 1 || err != ErrInjected {
+			t.Fatalf("torn WriteAt: %d, %v", n, err)
+		}
+	}
+	if got := string(mem.Bytes()); got != "ab2x456789" {
+		t.Fatalf("after torn writes: %q", got)
+	}
+	if err := s.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Power loss rolls back to the last Sync, including resizes.
+	s.SetSize(4)
+	s.WriteAt([]byte("tail"), 12)
+	s.SetSize(20)
+	if err := s.PowerLoss(); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(mem.Bytes()); got != "ab2x456789" {
+		t.Fatalf("after PowerLoss: %q", got)
+	}
+	if _, err := s.ReadAt(p, 0); err != ErrCrashed {
+		t.Fatalf("ReadAt after a crash: %v", err)
+	}
+	s.Restart()
+	if _, err := s.ReadAt(p, 0); err != nil {
+		t.Fatalf("ReadAt after Restart: %v", err)
+	}
+
+	// A crash fault lets the operation's effects through, then stops the
+	// store.
+	s.Inject(Fault{Ops: OpMutate, Crash: true, Partial: 2})
+	s.WriteAt([]byte("CRASH"), 0)
+	if err := s.Sync(); err != ErrCrashed {
+		t.Fatalf("Sync after a crash: %v", err)
+	}
+	if got := string(mem.Bytes()); got != "CR2x456789" {
+		t.Fatalf("after a crash: %q", got)
+	}
+
+	if n := s.Count(OpRead); n != 5 {
+		t.Fatalf("Count(OpRead): %d", n)
+	}
+	if n := s.Count(OpMutate); n != 10 {
+		t.Fatalf("Count(OpMutate): %d", n)
+	}
+	// Attached stores crash and lose power together.
+	s.Restart()
+	other := raf.NewMemStore()
+	a := s.Attach(other)
+	s.Sync()
+	a.WriteAt([]byte("abc"), 0)
+	a.Sync()
+	a.WriteAt([]byte("d"), 3)
+	s.WriteAt([]byte("e"), 0)
+	s.Inject(Fault{Ops: OpSync, Crash: true})
+	if err := a.Sync(); err != ErrInjected {
+		t.Fatalf("Sync of an attached store: %v", err)
+	}
+	if _, err := s.ReadAt(p, 0); err != ErrCrashed {
+		t.Fatalf("ReadAt after an attached store crashed: %v", err)
+	}
+	if err := s.PowerLoss(); err != nil {
+		t.Fatal(err)
+	}
+	if string(other.Bytes()) != "abc" || string(mem.Bytes()) != "CR2x456789" {
+		t.Fatalf("after PowerLoss: %q, %q", other.Bytes(), mem.Bytes())
+	}
+
+	if got := (OpRead | OpSync).String(); got != "ReadAt|Sync" {
+		t.Fatalf("Op.String: %q", got)
+	}
+}
+
+func TestReplayCrashes(t *testing.T) {
+	if !common.Available {
+		t.Skip("CGO not available")
+	}
+
+	key := make([]byte, 16)
+	opts := &raf.Options{Algorithm: raf.AEGIS128L, ChunkSize: raf.MinChunkSize}
+	mem := raf.NewMemStore()
+	f, err := raf.Create(mem, key, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := bytes.Repeat([]byte{1}, 3*raf.MinChunkSize+10)
+	f.WriteAt(old, 0)
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	overwrite := func(off, n int) (func(raf.Store) error, []byte) {
+		data := append([]byte(nil), old...)
+		for len(data) < off+n {
+			data = append(data, 0)
+		}
+		copy(data[off:off+n], bytes.Repeat([]byte{2}, n))
+		return func(s raf.Store) error {
+			f, err := raf.Open(s, key, nil)
+			if err != nil {
+				return err
+			}
+			if _, err := f.WriteAt(data[off:off+n], int64(off)); err != nil {
+				f.Close()
+				return err
+			}
+			return f.Close()
+		}, data
+	}
+
+	// Writes within one chunk, and appends, leave either version behind.
+	for _, w := range []struct{ off, n int }{{100, 50}, {len(old), 30}, {len(old), raf.MinChunkSize}} {
+		workload, data := overwrite(w.off, w.n)
+		if err := ReplayCrashes(mem.Bytes(), workload, Versions(key, nil, old, data)); err != nil {
+			t.Errorf("write of %d bytes at %d: %v", w.n, w.off, err)
+		}
+	}
+
+	// A crash between the chunks of a larger write leaves a mix of both
+	// versions that authenticates, which the harness reports.
+	workload, data := overwrite(raf.MinChunkSize-10, 20)
+	if err := ReplayCrashes(mem.Bytes(), workload, Versions(key, nil, old, data)); err == nil {
+		t.Error("ReplayCrashes accepted a write across chunks")
+	}
+
+	// With a journal, the same write, a larger one and a truncation are
+	// atomic, with or without a chunk cache.
+	for _, cache := range []int{0, 2 * raf.MinChunkSize} {
+		for _, w := range []struct{ off, n int }{{raf.MinChunkSize - 10, 20}, {10, 2*raf.MinChunkSize + 100}, {-1, 100}} {
+			want := append([]byte(nil), old...)
+			if w.off < 0 {
+				want = want[:w.n]
+			} else {
+				copy(want[w.off:], bytes.Repeat([]byte{3}, w.n))
+			}
+			workload := func(s []raf.Store) error {
+				f, err := raf.Open(s[0], key, &raf.Options{Journal: s[1], CacheSize: cache})
+				if err != nil {
+					return err
+				}
+				if w.off < 0 {
+					err = f.Truncate(int64(w.n))
+				} else {
+					_, err = f.WriteAt(want[w.off:w.off+w.n], int64(w.off))
+				}
+				if err != nil {
+					f.Close()
+					return err
+				}
+				return f.Close()
+			}
+			check := func(s []raf.Store) error {
+				return Versions(key, &raf.Options{Journal: s[1]}, old, want)(s[0])
+			}
+			if err := ReplayCrashesMulti([][]byte{mem.Bytes(), nil}, workload, check); err != nil {
+				t.Errorf("journaled change at %d of %d bytes, cache %d: %v", w.off, w.n, cache, err)
+			}
+		}
+	}
+
+	// A file with a freshness record is never taken for a rolled-back one
+	// after a crash, journaled or not.
+	fresh := raf.NewMemStore()
+	f, err = raf.Create(fresh, key, &raf.Options{
+		Algorithm:     raf.AEGIS128L,
+		ChunkSize:     raf.MinChunkSize,
+		Merkle:        &raf.MerkleOptions{Suite: raf.MerkleSHA256, MaxChunks: 16},
+		ExtensionSize: 512,
+		Freshness:     &raf.Freshness{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteAt(old, 0)
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, journaled := range []bool{false, true} {
+		off, n := 100, 50
+		if journaled {
+			off, n = raf.MinChunkSize-10, 20
+		}
+		want := append([]byte(nil), old...)
+		copy(want[off:], bytes.Repeat([]byte{4}, n))
+		opts := func(s []raf.Store) *raf.Options {
+			if journaled {
+				return &raf.Options{Journal: s[1], Freshness: &raf.Freshness{MinVersion: 1}}
+			}
+			return &raf.Options{Freshness: &raf.Freshness{MinVersion: 1}}
+		}
+		workload := func(s []raf.Store) error {
+			f, err := raf.Open(s[0], key, opts(s))
+			if err != nil {
+				return err
+			}
+			if _, err := f.WriteAt(want[off:off+n], int64(off)); err != nil {
+				f.Close()
+				return err
+			}
+			return f.Close()
+		}
+		check := func(s []raf.Store) error {
+			return Versions(key, opts(s), old, want)(s[0])
+		}
+		if err := ReplayCrashesMulti([][]byte{fresh.Bytes(), nil}, workload, check); err != nil {
+			t.Errorf("freshness record, journaled %v: %v", journaled, err)
+		}
+	}
+
+	// A key rotation resumes from any crash once it is recorded, and with
+	// a journal never loses a chunk, whether the file has an extension area
+	// to record it in or not.
+	newKey := bytes.Repeat([]byte{9}, 16)
+	for _, journaled := range []bool{false, true} {
+		for _, base := range [][]byte{fresh.Bytes(), mem.Bytes()} {
+			opts := func(s []raf.Store, previous []byte) *raf.Options {
+				o := &raf.Options{PreviousKey: previous}
+				if journaled {
+					o.Journal = s[1]
+				}
+				return o
+			}
+			workload := func(s []raf.Store) error {
+				f, err := raf.Open(s[0], key, opts(s, nil))
+				if err != nil {
+					return err
+				}
+				if err := f.Rekey(newKey, nil); err != nil {
+					f.Close()
+					return err
+				}
+				return f.Close()
+			}
+			check := func(s []raf.Store) error {
+				f, err := raf.Open(s[0], newKey, opts(s, key))
+				if errors.Is(err, raf.ErrAuth) {
+					// A crash before the rotation was recorded leaves the
+					// file under the old key, and without a journal one in a
+					// chunk's rewrite may leave the chunk under neither.
+					f, err = raf.Open(s[0], key, opts(s, nil))
+					if !journaled && errors.Is(err, raf.ErrAuth) {
+						return nil
+					}
+				}
+				if err != nil {
+					return fmt.Errorf("Open: %w", err)
+				}
+				defer f.Close()
+				got := make([]byte, len(old)+1)
+				n, err := f.ReadAt(got, 0)
+				if !journaled && errors.Is(err, raf.ErrAuth) {
+					return nil
+				}
+				if n != len(old) || !bytes.Equal(got[:n], old) {
+					return fmt.Errorf("ReadAt after the rotation: %d bytes, %v", n, err)
+				}
+				return nil
+			}
+			if err := ReplayCrashesMulti([][]byte{base, nil}, workload, check); err != nil {
+				t.Errorf("Rekey, journaled %v, %d-byte store: %v", journaled, len(base), err)
+			}
+		}
+	}
+
+	// Adding or replacing a key slot never costs the file its key or
+	// passphrase.
+	pw := []byte("passphrase")
+	slotted := raf.NewMemStore()
+	f, err = raf.Create(slotted, key, &raf.Options{
+		Algorithm:     raf.AEGIS128L,
+		ChunkSize:     raf.MinChunkSize,
+		Merkle:        &raf.MerkleOptions{Suite: raf.MerkleSHA256, MaxChunks: 16},
+		ExtensionSize: 4096,
+		Freshness:     &raf.Freshness{},
+		KeySlots:      true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.AddPassphrase(pw, &raf.KDFParams{Time: 1, Memory: 64, Threads: 1}); err != nil {
+		t.Fatal(err)
+	}
+	f.WriteAt(old, 0)
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	workload = func(s raf.Store) error {
+		f, err := raf.Open(s, key, nil)
+		if err != nil {
+			return err
+		}
+		if _, err := f.AddKeySlot(newKey); err != nil {
+			f.Close()
+			return err
+		}
+		if _, err := f.SetPassphrase(1, pw, &raf.KDFParams{Time: 2, Memory: 64, Threads: 1}); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	}
+	check := func(s raf.Store) error {
+		f, err := raf.Open(s, key, nil)
+		if err != nil {
+			return fmt.Errorf("Open: %w", err)
+		}
+		f.Close()
+		if f, err = raf.OpenWithPassphrase(s, pw, nil); err != nil {
+			return fmt.Errorf("OpenWithPassphrase: %w", err)
+		}
+		return f.Close()
+	}
+	if err := ReplayCrashes(slotted.Bytes(), workload, check); err != nil {
+		t.Errorf("key slots: %v", err)
+	}
+
+	// With a journal, metadata changes are atomic.
+	meta := raf.NewMemStore()
+	f, err = raf.Create(meta, key, &raf.Options{
+		Algorithm:     raf.AEGIS128L,
+		ExtensionSize: 4096,
+		Metadata:      map[string]string{"name": "old"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	metaWorkload := func(s []raf.Store) error {
+		f, err := raf.Open(s[0], key, &raf.Options{Journal: s[1]})
+		if err != nil {
+			return err
+		}
+		if err := f.SetMetadata(map[string]string{"name": strings.Repeat("new", 100)}); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	}
+	metaCheck := func(s []raf.Store) error {
+		f, err := raf.Open(s[0], key, &raf.Options{Journal: s[1]})
+		if err != nil {
+			return fmt.Errorf("Open: %w", err)
+		}
+		defer f.Close()
+		m, err := f.Metadata()
+		if err != nil {
+			return fmt.Errorf("Metadata: %w", err)
+		}
+		if m["name"] != "old" && m["name"] != strings.Repeat("new", 100) {
+			return fmt.Errorf("Metadata: %q", m["name"])
+		}
+		return nil
+	}
+	if err := ReplayCrashesMulti([][]byte{meta.Bytes(), nil}, metaWorkload, metaCheck); err != nil {
+		t.Errorf("journaled metadata: %v", err)
+	}
+
+	// The workload itself must succeed without a crash.
+	failing := func(raf.Store) error { return io.ErrUnexpectedEOF }
+	if err := ReplayCrashes(mem.Bytes(), failing, Versions(key, nil, old)); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("ReplayCrashes with a failing workload: %v", err)
+	}
+}
@@ -0,0 +1,64 @@
+package raf
+
+import (
+	"crypto/subtle"
+
+	"github.com/aegis-aead/go-libaegis/aegis256"
+)
+
+// Key rotation
+//
+// File.Rekey re-encrypts every chunk under the new key, then rewrites the
+// header. A rotation that stops partway leaves chunks under both keys, and
+// Open given the previous key finishes it. So that Open never takes a
+// wrong key for the target of such a rotation, Rekey records the rotation,
+// and syncs it, before it touches a chunk; the record goes away with the
+// header rewrite. Open resumes a rotation only to the key the record
+// names.
+//
+// The record is
+//
+//	tag[32]
+//
+// the AEGIS-256-MAC of "aegis-raf-rekey-v1" || file identifier, keyed by a
+// key derived from the new key. It is an extension entry, or for a file
+// without an extension area, the 32 bytes after its last chunk record,
+// which the file does not otherwise use.
+
+const (
+	rekeyDomain     = "aegis-raf-rekey-v1"
+	rekeyMarkerSize = 32
+)
+
+// rekeyMarker returns the entry recording a rotation to key of the file
+// with identifier id.
+func rekeyMarker(key, id []byte) ([]byte, error) {
+	k, err := deriveKey(key, "raf rekey marker", aegis256.KeySize)
+	if err != nil {
+		return nil, err
+	}
+	defer wipe(k)
+	mac, err := aegis256.NewMAC(k, make([]byte, aegis256.NonceSize), rekeyMarkerSize)
+	if err != nil {
+		return nil, err
+	}
+	mac.Write([]byte(rekeyDomain))
+	mac.Write(id)
+	return mac.Sum(nil), nil
+}
+
+// checkRekeyMarker returns ErrAuth unless marker, the record of the file
+// with identifier id, records a rotation to key.
+func checkRekeyMarker(marker, id, key []byte) error {
+	if marker == nil {
+		return ErrAuth
+	}
+	want, err := rekeyMarker(key, id)
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare(want, marker) != 1 {
+		return ErrAuth
+	}
+	return nil
+}
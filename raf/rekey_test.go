@@ -0,0 +1,220 @@
+package raf
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"github.com/aegis-aead/go-libaegis/common"
+)
+
+func TestRekey(t *testing.T) {
+	if !common.Available {
+		t.Skip("CGO not available")
+	}
+
+	for _, alg := range []Algorithm{AEGIS128L, AEGIS256X2} {
+		oldKey, newKey := make([]byte, alg.KeySize()), make([]byte, alg.KeySize())
+		rand.Read(oldKey)
+		rand.Read(newKey)
+		store := NewMemStore()
+		f, err := Create(store, oldKey, &Options{
+			Algorithm:     alg,
+			ChunkSize:     MinChunkSize,
+			Merkle:        suiteOptions(MerkleSHA256, 16),
+			ExtensionSize: 512,
+			Freshness:     &Freshness{},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		data := make([]byte, 5*MinChunkSize+100)
+		rand.Read(data)
+		f.WriteAt(data, 0)
+		before := store.Snapshot()
+
+		var calls [][2]int64
+		err = f.Rekey(newKey, func(done, total int64) error {
+			calls = append(calls, [2]int64{done, total})
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("%v: Rekey: %v", alg, err)
+		}
+		if len(calls) != 1 || calls[0] != [2]int64{6, 6} {
+			t.Fatalf("%v: progress calls %v", alg, calls)
+		}
+		got := make([]byte, len(data))
+		if _, err := f.ReadAt(got, 0); err != nil || !bytes.Equal(got, data) {
+			t.Fatalf("%v: ReadAt after Rekey: %v", alg, err)
+		}
+		version, _, _ := f.Version()
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		// Every record changed, and only the new key opens the file.
+		after := store.Bytes()
+		info, _ := Probe(store)
+		for i := int64(0); i < 6; i++ {
+			at := 512 + HeaderSize + i*int64(info.RecordSize())
+			if bytes.Equal(before[at:at+int64(info.RecordSize())], after[at:at+int64(info.RecordSize())]) {
+				t.Fatalf("%v: chunk %d was not re-encrypted", alg, i)
+			}
+		}
+		if _, err := Open(store, oldKey, nil); err != ErrAuth {
+			t.Fatalf("%v: Open with the old key: %v", alg, err)
+		}
+		f, err = Open(store, newKey, &Options{Freshness: &Freshness{MinVersion: version}})
+		if err != nil {
+			t.Fatalf("%v: Open with the new key: %v", alg, err)
+		}
+		if _, err := f.ReadAt(got, 0); err != nil || !bytes.Equal(got, data) {
+			t.Fatalf("%v: ReadAt after Open: %v", alg, err)
+		}
+		f.Close()
+	}
+}
+
+func TestRekeyResume(t *testing.T) {
+	if !common.Available {
+		t.Skip("CGO not available")
+	}
+
+	oldKey, newKey := make([]byte, 16), make([]byte, 16)
+	rand.Read(oldKey)
+	rand.Read(newKey)
+	store := NewMemStore()
+	f, err := Create(store, oldKey, &Options{
+		Algorithm:     AEGIS128L,
+		ChunkSize:     MaxChunkSize,
+		ExtensionSize: MinExtensionSize,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 3*MaxChunkSize+10)
+	rand.Read(data)
+	f.WriteAt(data, 0)
+	f.Close()
+
+	// A file that was never rotated does not take any key for the target
+	// of a rotation.
+	if _, err := Open(store, newKey, &Options{PreviousKey: oldKey}); err != ErrAuth {
+		t.Fatalf("Open of a file never rotated with another key: %v", err)
+	}
+	f, err = Open(store, oldKey, nil)
+	if err != nil {
+		t.Fatalf("Open with the old key: %v", err)
+	}
+	got := make([]byte, len(data))
+	if _, err := f.ReadAt(got, 0); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("ReadAt with the old key: %v", err)
+	}
+
+	// Stopping after the first chunk leaves the file under both keys.
+	errStop := errors.New("stop")
+	err = f.Rekey(newKey, func(done, total int64) error {
+		if done != 1 || total != 4 {
+			t.Errorf("progress(%d, %d)", done, total)
+		}
+		return errStop
+	})
+	if err != errStop {
+		t.Fatalf("interrupted Rekey: %v", err)
+	}
+	if _, err := f.ReadAt(make([]byte, 1), 0); !errors.Is(err, ErrRekeyIncomplete) {
+		t.Fatalf("ReadAt after an interrupted Rekey: %v", err)
+	}
+	f.Close()
+	if _, err := Open(store, newKey, nil); err != ErrAuth {
+		t.Fatalf("Open of a file under two keys: %v", err)
+	}
+	f, _ = Open(store, oldKey, nil)
+	if _, err := f.ReadAt(make([]byte, 1), 0); err != ErrAuth {
+		t.Fatalf("ReadAt of a rotated chunk with the old key: %v", err)
+	}
+	f.Close()
+	if _, err := Open(store, newKey, &Options{PreviousKey: make([]byte, 16)}); err != ErrAuth {
+		t.Fatalf("Open with a wrong previous key: %v", err)
+	}
+	if _, err := Open(store, make([]byte, 16), &Options{PreviousKey: oldKey}); err != ErrAuth {
+		t.Fatalf("Open with a key the rotation is not to: %v", err)
+	}
+
+	f, err = Open(store, newKey, &Options{PreviousKey: oldKey})
+	if err != nil {
+		t.Fatalf("Open with the previous key: %v", err)
+	}
+	if _, err := f.ReadAt(got, 0); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("ReadAt after resuming: %v", err)
+	}
+	f.Close()
+	if ext, _ := readExtArea(store); ext.entries[extRekey] != nil {
+		t.Fatal("the rotation is still recorded after it finished")
+	}
+	f, err = Open(store, newKey, nil)
+	if err != nil {
+		t.Fatalf("Open after resuming: %v", err)
+	}
+	f.Close()
+
+	if err := f.Rekey(make([]byte, 32), nil); err != ErrClosed {
+		t.Fatalf("Rekey of a closed file: %v", err)
+	}
+	f, _ = Open(store, newKey, nil)
+	if err := f.Rekey(make([]byte, 32), nil); err != ErrBadKeyLength {
+		t.Fatalf("Rekey with a 32-byte key: %v", err)
+	}
+	f.Close()
+}
+
+func TestRekeyWithoutExtensionArea(t *testing.T) {
+	if !common.Available {
+		t.Skip("CGO not available")
+	}
+
+	oldKey, newKey := make([]byte, 16), make([]byte, 16)
+	rand.Read(oldKey)
+	rand.Read(newKey)
+	store := NewMemStore()
+	f, err := Create(store, oldKey, &Options{Algorithm: AEGIS128L, ChunkSize: MinChunkSize})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 3*MinChunkSize+10)
+	rand.Read(data)
+	f.WriteAt(data, 0)
+	info := f.Info()
+	f.Close()
+	if _, err := Open(store, newKey, &Options{PreviousKey: oldKey}); err != ErrAuth {
+		t.Fatalf("Open of a file never rotated with another key: %v", err)
+	}
+
+	// The rotation is recorded past the last chunk until it finishes.
+	f, _ = Open(store, oldKey, nil)
+	errStop := errors.New("stop")
+	if err := f.Rekey(newKey, func(done, total int64) error { return errStop }); err != errStop {
+		t.Fatalf("interrupted Rekey: %v", err)
+	}
+	f.Close()
+	if size := int64(len(store.Bytes())); size != info.StoreSize()+rekeyMarkerSize {
+		t.Fatalf("store of %d bytes after an interrupted Rekey, want %d", size, info.StoreSize()+rekeyMarkerSize)
+	}
+	if _, err := Open(store, make([]byte, 16), &Options{PreviousKey: oldKey}); err != ErrAuth {
+		t.Fatalf("Open with a key the rotation is not to: %v", err)
+	}
+	f, err = Open(store, newKey, &Options{PreviousKey: oldKey})
+	if err != nil {
+		t.Fatalf("Open with the previous key: %v", err)
+	}
+	got := make([]byte, len(data))
+	if _, err := f.ReadAt(got, 0); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("ReadAt after resuming: %v", err)
+	}
+	f.Close()
+	if size := int64(len(store.Bytes())); size != info.StoreSize() {
+		t.Fatalf("store of %d bytes after the rotation, want %d", size, info.StoreSize())
+	}
+}
@@ -0,0 +1,77 @@
+package raf
+
+import (
+	"crypto/ed25519"
+	"encoding/binary"
+	"errors"
+)
+
+// Publisher signatures
+//
+// File.Sign stores an Ed25519 signature in the extension area, and
+// OpenVerified checks it before returning the file. The signature covers
+// the RAF header, which carries the algorithm, chunk size, file identifier
+// and size, and a Merkle commitment to the plaintext, so it authenticates
+// every chunk without the verifier trusting whoever holds the file key.
+//
+// The signed message is:
+//
+//	"aegis-raf-signature-v1" || header || u8 len(suite) || suite ||
+//	u64 max_chunks || commitment
+//
+// and the extension entry is u8 len(suite) || suite || u64 max_chunks ||
+// signature, with little-endian integers.
+
+const signatureDomain = "aegis-raf-signature-v1"
+
+var (
+	// ErrNotSigned is returned by OpenVerified for a file without a
+	// publisher signature.
+	ErrNotSigned = errors.New("raf: file is not signed")
+
+	// ErrBadSignature is returned by OpenVerified when the signature does
+	// not match the file or the public key.
+	ErrBadSignature = errors.New("raf: signature verification failed")
+)
+
+// signatureEntry is the decoded extSignature entry.
+type signatureEntry struct {
+	suite     string
+	maxChunks uint64
+	sig       []byte
+}
+
+func (e *signatureEntry) marshal() []byte {
+	b := make([]byte, 0, 1+len(e.suite)+8+ed25519.SignatureSize)
+	b = append(b, byte(len(e.suite)))
+	b = append(b, e.suite...)
+	b = binary.LittleEndian.AppendUint64(b, e.maxChunks)
+	return append(b, e.sig...)
+}
+
+func parseSignatureEntry(b []byte) (*signatureEntry, error) {
+	d := proofDecoder{b: b}
+	suite := string(d.bytes(int(d.byte())))
+	maxChunks := d.bytes(8)
+	sig := d.bytes(ed25519.SignatureSize)
+	if d.err || len(d.b) != 0 || (suite != MerkleSHA256 && suite != MerkleSHA512_256) {
+		return nil, ErrBadSignature
+	}
+	return &signatureEntry{
+		suite:     suite,
+		maxChunks: binary.LittleEndian.Uint64(maxChunks),
+		sig:       append([]byte(nil), sig...),
+	}, nil
+}
+
+// signedMessage returns the message a publisher signs for a file with the
+// given header and commitment.
+func (e *signatureEntry) signedMessage(hdr, commitment []byte) []byte {
+	m := make([]byte, 0, len(signatureDomain)+HeaderSize+1+len(e.suite)+8+len(commitment))
+	m = append(m, signatureDomain...)
+	m = append(m, hdr[:HeaderSize]...)
+	m = append(m, byte(len(e.suite)))
+	m = append(m, e.suite...)
+	m = binary.LittleEndian.AppendUint64(m, e.maxChunks)
+	return append(m, commitment...)
+}
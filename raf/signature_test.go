@@ -0,0 +1,220 @@
+package raf
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/aegis-aead/go-libaegis/common"
+)
+
+// signedFile creates a signed AEGIS-256 file holding msg and returns its
+// store and key.
+func signedFile(t *testing.T, priv ed25519.PrivateKey, msg []byte) (*MemStore, []byte) {
+	t.Helper()
+	key := make([]byte, 32)
+	rand.Read(key)
+	store := NewMemStore()
+	f, err := Create(store, key, &Options{
+		Algorithm:     AEGIS256,
+		ChunkSize:     MinChunkSize,
+		Merkle:        suiteOptions(MerkleSHA256, 16),
+		ExtensionSize: MinExtensionSize,
+	})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if _, err := f.WriteAt(msg, 0); err != nil {
+		t.Fatalf("WriteAt: %v", err)
+	}
+	if err := f.Sign(priv); err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	return store, key
+}
+
+func TestSignedFile(t *testing.T) {
+	if !common.Available {
+		t.Skip("CGO not available")
+	}
+
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	msg := make([]byte, 3*MinChunkSize+100)
+	rand.Read(msg)
+	store, key := signedFile(t, priv, msg)
+
+	info, err := Probe(store)
+	if err != nil {
+		t.Fatalf("Probe: %v", err)
+	}
+	if info.Size != int64(len(msg)) || info.ExtensionSize != MinExtensionSize || info.StoreSize() != int64(len(store.Bytes())) {
+		t.Fatalf("Probe: got %+v for a %d-byte store", info, len(store.Bytes()))
+	}
+
+	f, err := OpenVerified(store, key, pub)
+	if err != nil {
+		t.Fatalf("OpenVerified: %v", err)
+	}
+	got := make([]byte, len(msg))
+	if _, err := f.ReadAt(got, 0); err != nil || !bytes.Equal(got, msg) {
+		t.Fatalf("ReadAt: %v", err)
+	}
+	if _, err := f.WriteAt([]byte("x"), 0); err != ErrReadOnly {
+		t.Fatalf("WriteAt on a verified file: got %v, want ErrReadOnly", err)
+	}
+	if err := f.Truncate(0); err != ErrReadOnly {
+		t.Fatalf("Truncate on a verified file: got %v, want ErrReadOnly", err)
+	}
+	if info := f.Info(); info.ExtensionSize != MinExtensionSize {
+		t.Fatalf("Info: got %+v", info)
+	}
+	f.Close()
+
+	// A signed file is still an ordinary file for Open.
+	f, err = Open(store, key, nil)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if _, err := f.ReadAt(got, 0); err != nil || !bytes.Equal(got, msg) {
+		t.Fatalf("ReadAt after Open: %v", err)
+	}
+	f.Close()
+}
+
+func TestSignedFileRejected(t *testing.T) {
+	if !common.Available {
+		t.Skip("CGO not available")
+	}
+
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	otherPub, otherPriv, _ := ed25519.GenerateKey(rand.Reader)
+	msg := make([]byte, 2*MinChunkSize)
+	rand.Read(msg)
+	store, key := signedFile(t, priv, msg)
+
+	if _, err := OpenVerified(store, key, otherPub); err != ErrBadSignature {
+		t.Fatalf("OpenVerified with another public key: got %v, want ErrBadSignature", err)
+	}
+
+	// Writing with the file key keeps every chunk authentic but changes the
+	// commitment, so the publisher's signature no longer matches.
+	modified := NewMemStore()
+	modified.Restore(store.Snapshot())
+	f, err := Open(modified, key, nil)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	f.WriteAt([]byte("forged"), 100)
+	f.Close()
+	if _, err := OpenVerified(modified, key, pub); err != ErrBadSignature {
+		t.Fatalf("OpenVerified after a write: got %v, want ErrBadSignature", err)
+	}
+
+	// So does signing with another key.
+	f, _ = Open(modified, key, &Options{Merkle: suiteOptions(MerkleSHA256, 16)})
+	f.MerkleRebuild()
+	if err := f.Sign(otherPriv); err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	f.Close()
+	if _, err := OpenVerified(modified, key, pub); err != ErrBadSignature {
+		t.Fatalf("OpenVerified after re-signing: got %v, want ErrBadSignature", err)
+	}
+	if f, err := OpenVerified(modified, key, otherPub); err != nil {
+		t.Fatalf("OpenVerified with the new signer: %v", err)
+	} else {
+		f.Close()
+	}
+
+	// Tampered ciphertext fails authentication before the signature check.
+	tampered := NewMemStore()
+	tampered.Restore(store.Snapshot())
+	tampered.Bytes()[len(tampered.Bytes())-200] ^= 1
+	if _, err := OpenVerified(tampered, key, pub); err != ErrAuth {
+		t.Fatalf("OpenVerified on a tampered chunk: got %v, want ErrAuth", err)
+	}
+	wrongKey := make([]byte, 32)
+	if _, err := OpenVerified(store, wrongKey, pub); err != ErrAuth {
+		t.Fatalf("OpenVerified with a wrong key: got %v, want ErrAuth", err)
+	}
+
+	// A damaged signature entry.
+	damaged := NewMemStore()
+	damaged.Restore(store.Snapshot())
+	damaged.Bytes()[extHeaderSize+extEntryHdr+1] ^= 1
+	if _, err := OpenVerified(damaged, key, pub); err != ErrBadSignature {
+		t.Fatalf("OpenVerified with a damaged suite name: got %v, want ErrBadSignature", err)
+	}
+
+	// A tree size out of proportion to the file is refused before the tree
+	// is set up, and so is one too small to hold it.
+	for _, maxChunks := range []uint64{1 << 40, maxRecordedChunks + 1, 3} {
+		forged := NewMemStore()
+		forged.Restore(store.Snapshot())
+		off := extHeaderSize + extEntryHdr + 1 + len(MerkleSHA256)
+		binary.LittleEndian.PutUint64(forged.Bytes()[off:], maxChunks)
+		if _, err := OpenVerified(forged, key, pub); err != ErrBadSignature {
+			t.Fatalf("OpenVerified with MaxChunks %d: got %v, want ErrBadSignature", maxChunks, err)
+		}
+	}
+}
+
+func TestUnsignedFile(t *testing.T) {
+	if !common.Available {
+		t.Skip("CGO not available")
+	}
+
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	key := make([]byte, 16)
+	rand.Read(key)
+
+	plain := NewMemStore()
+	f, _ := Create(plain, key, &Options{Algorithm: AEGIS128L, Merkle: suiteOptions(MerkleSHA256, 4)})
+	if err := f.Sign(priv); err != ErrNoExtensionArea {
+		t.Fatalf("Sign without an extension area: got %v, want ErrNoExtensionArea", err)
+	}
+	f.Close()
+	if _, err := OpenVerified(plain, key, pub); err != ErrNotSigned {
+		t.Fatalf("OpenVerified on a plain file: got %v, want ErrNotSigned", err)
+	}
+
+	extended := NewMemStore()
+	f, _ = Create(extended, key, &Options{Algorithm: AEGIS128L, ExtensionSize: 4096})
+	if err := f.Sign(priv); err != ErrMerkleDisabled {
+		t.Fatalf("Sign without a Merkle tree: got %v, want ErrMerkleDisabled", err)
+	}
+	f.Close()
+	if _, err := OpenVerified(extended, key, pub); err != ErrNotSigned {
+		t.Fatalf("OpenVerified on an unsigned file: got %v, want ErrNotSigned", err)
+	}
+
+	keyed := NewMemStore()
+	f, _ = Create(keyed, key, &Options{Algorithm: AEGIS128L, ExtensionSize: 4096, Merkle: suiteOptions(MerkleAEGISMAC, 4)})
+	if err := f.Sign(priv); !errors.Is(err, ErrBadMerkleConfig) {
+		t.Fatalf("Sign with a keyed suite: got %v, want ErrBadMerkleConfig", err)
+	}
+	f.Close()
+
+	for _, size := range []int{1, MinExtensionSize - 1, MaxExtensionSize + 1} {
+		if _, err := Create(NewMemStore(), key, &Options{Algorithm: AEGIS128L, ExtensionSize: size}); err != ErrBadExtensionSize {
+			t.Fatalf("Create with a %d-byte extension area: got %v", size, err)
+		}
+	}
+	if _, err := Create(extended, key, &Options{Algorithm: AEGIS128L, ExtensionSize: 4096}); err != ErrExists {
+		t.Fatalf("Create over an existing file: got %v, want ErrExists", err)
+	}
+	f, err := Create(extended, key, &Options{Algorithm: AEGIS128L, ExtensionSize: MinExtensionSize, Truncate: true})
+	if err != nil {
+		t.Fatalf("Create with Truncate: %v", err)
+	}
+	f.Close()
+	if info, _ := Probe(extended); info == nil || info.ExtensionSize != MinExtensionSize || len(extended.Bytes()) != MinExtensionSize+HeaderSize {
+		t.Fatalf("Probe after Truncate: got %+v for %d bytes", info, len(extended.Bytes()))
+	}
+}
@@ -0,0 +1,143 @@
+package raf
+
+import (
+	"io"
+	"sync"
+)
+
+// MemStore is a Store held in memory, safe for concurrent use. The zero
+// value is an empty store.
+type MemStore struct {
+	mu   sync.RWMutex
+	data []byte
+}
+
+// NewMemStore returns an empty MemStore.
+func NewMemStore() *MemStore {
+	return &MemStore{}
+}
+
+func (s *MemStore) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, ErrNegativeOffset
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if off >= int64(len(s.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, s.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (s *MemStore) WriteAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, ErrNegativeOffset
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if end := off + int64(len(p)); end > int64(len(s.data)) {
+		s.setSize(end)
+	}
+	return copy(s.data[off:], p), nil
+}
+
+func (s *MemStore) GetSize() (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return int64(len(s.data)), nil
+}
+
+func (s *MemStore) SetSize(size int64) error {
+	if size < 0 {
+		return ErrNegativeOffset
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.setSize(size)
+	return nil
+}
+
+func (s *MemStore) setSize(size int64) {
+	if size <= int64(len(s.data)) {
+		s.data = s.data[:size]
+		return
+	}
+	if size <= int64(cap(s.data)) {
+		// Bytes past the old size may hold data from before a shrink.
+		old := len(s.data)
+		s.data = s.data[:size]
+		wipe(s.data[old:])
+		return
+	}
+	grown := make([]byte, size, size+size/4)
+	copy(grown, s.data)
+	s.data = grown
+}
+
+func (s *MemStore) Sync() error {
+	return nil
+}
+
+// Bytes returns the contents of the store. The slice aliases the store:
+// it is valid until the next WriteAt or SetSize, and changes made to it are
+// seen by later reads, which makes it handy for simulating corruption. It
+// must not be used while the store is being written concurrently.
+func (s *MemStore) Bytes() []byte {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.data
+}
+
+// Snapshot returns a copy of the contents of the store, which Restore
+// returns it to.
+func (s *MemStore) Snapshot() []byte {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]byte(nil), s.data...)
+}
+
+// Restore replaces the contents of the store with a copy of snapshot. Any
+// File open on the store must be closed first, since its state would no
+// longer match the store.
+func (s *MemStore) Restore(snapshot []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data = append([]byte(nil), snapshot...)
+}
+
+// NewReaderAtStore returns a read-only Store over the first size bytes of
+// r, for opening a RAF file from a byte slice, an embed.FS file or a mapped
+// region. Its WriteAt and SetSize fail with ErrReadOnly, so a File opened on
+// it can be read but not modified. The store is as safe for concurrent use
+// as r.
+func NewReaderAtStore(r io.ReaderAt, size int64) Store {
+	return &readerAtStore{r: io.NewSectionReader(r, 0, size)}
+}
+
+type readerAtStore struct {
+	r *io.SectionReader
+}
+
+func (s *readerAtStore) ReadAt(p []byte, off int64) (int, error) {
+	return s.r.ReadAt(p, off)
+}
+
+func (s *readerAtStore) WriteAt(p []byte, off int64) (int, error) {
+	return 0, ErrReadOnly
+}
+
+func (s *readerAtStore) GetSize() (int64, error) {
+	return s.r.Size(), nil
+}
+
+func (s *readerAtStore) SetSize(size int64) error {
+	return ErrReadOnly
+}
+
+func (s *readerAtStore) Sync() error {
+	return nil
+}
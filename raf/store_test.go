@@ -0,0 +1,91 @@
+package raf
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/aegis-aead/go-libaegis/common"
+)
+
+func TestMemStore(t *testing.T) {
+	s := NewMemStore()
+	if _, err := s.WriteAt([]byte("hello world"), 3); err != nil {
+		t.Fatal(err)
+	}
+	if size, _ := s.GetSize(); size != 14 {
+		t.Fatalf("GetSize: got %d, want 14", size)
+	}
+	p := make([]byte, 8)
+	if n, err := s.ReadAt(p, 0); n != 8 || err != nil || !bytes.Equal(p, []byte("\x00\x00\x00hello")) {
+		t.Fatalf("ReadAt: %d, %v, %q", n, err, p)
+	}
+	if n, err := s.ReadAt(p, 10); n != 4 || err != io.EOF {
+		t.Fatalf("ReadAt past the end: got %d, %v", n, err)
+	}
+	if _, err := s.ReadAt(p, -1); err != ErrNegativeOffset {
+		t.Fatalf("ReadAt at a negative offset: %v", err)
+	}
+
+	snapshot := s.Snapshot()
+	s.WriteAt([]byte("HELLO"), 3)
+
+	// Growing after a shrink reads zeros, not the truncated bytes.
+	s.SetSize(5)
+	s.SetSize(14)
+	if !bytes.Equal(s.Bytes(), []byte("\x00\x00\x00HE\x00\x00\x00\x00\x00\x00\x00\x00\x00")) {
+		t.Fatalf("after shrinking and growing: %q", s.Bytes())
+	}
+
+	s.Restore(snapshot)
+	snapshot[3] = 'j'
+	if !bytes.Equal(s.Bytes(), []byte("\x00\x00\x00hello world")) {
+		t.Fatalf("after Restore: %q", s.Bytes())
+	}
+}
+
+func TestReaderAtStore(t *testing.T) {
+	if !common.Available {
+		t.Skip("CGO not available")
+	}
+
+	key := make([]byte, 16)
+	rand.Read(key)
+	msg := make([]byte, 3*MinChunkSize+7)
+	rand.Read(msg)
+	mem := NewMemStore()
+	f, err := Create(mem, key, &Options{Algorithm: AEGIS128L, ChunkSize: MinChunkSize})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteAt(msg, 0)
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Trailing bytes beyond size are not part of the store.
+	raw := append(mem.Snapshot(), "trailing"...)
+	store := NewReaderAtStore(bytes.NewReader(raw), int64(len(raw)-8))
+	f, err = Open(store, key, nil)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	got := make([]byte, len(msg))
+	if n, err := f.ReadAt(got, 0); n != len(msg) || (err != nil && err != io.EOF) || !bytes.Equal(got, msg) {
+		t.Fatalf("ReadAt: %d, %v", n, err)
+	}
+	if _, err := f.WriteAt([]byte("x"), 0); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("WriteAt on a read-only store: got %v, want ErrReadOnly", err)
+	}
+	if err := f.Truncate(0); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("Truncate on a read-only store: got %v, want ErrReadOnly", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if !bytes.Equal(raw[:len(raw)-8], mem.Bytes()) {
+		t.Fatal("the underlying bytes changed")
+	}
+}
@@ -0,0 +1,208 @@
+package vault
+
+import (
+	"io"
+	"io/fs"
+	"path"
+	"time"
+
+	"github.com/aegis-aead/go-libaegis/raf"
+)
+
+var (
+	_ fs.ReadDirFS = (*Vault)(nil)
+	_ fs.StatFS    = (*Vault)(nil)
+)
+
+// Open opens the named file or directory for reading. Implements fs.FS.
+// A file is read once to check it against the manifest, and one that does
+// not match fails with ErrTampered.
+func (v *Vault) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	e := v.lookup(name)
+	if e == nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if e.dir {
+		entries, err := v.readDir(name)
+		if err != nil {
+			return nil, err
+		}
+		return &dirFile{info: dirInfo(name), entries: entries}, nil
+	}
+
+	f, err := v.openFile(e, true)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	info, err := f.info(name)
+	if err != nil {
+		f.Close()
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &readFile{f: f, c: f.NewCursor(1), info: info}, nil
+}
+
+// Stat returns the FileInfo of the named file or directory. Implements
+// fs.StatFS.
+func (v *Vault) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	info, err := v.stat(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+	}
+	return info, nil
+}
+
+// ReadDir returns the entries of the named directory, sorted by name.
+// Implements fs.ReadDirFS.
+func (v *Vault) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.readDir(name)
+}
+
+func (v *Vault) readDir(name string) ([]fs.DirEntry, error) {
+	e := v.lookup(name)
+	if e == nil || !e.dir {
+		err := fs.ErrNotExist
+		if e != nil {
+			err = fs.ErrInvalid
+		}
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	var entries []fs.DirEntry
+	for _, child := range v.children(name) {
+		full := path.Join(name, child)
+		info, err := v.stat(full)
+		if err != nil {
+			return nil, &fs.PathError{Op: "readdir", Path: full, Err: err}
+		}
+		entries = append(entries, fs.FileInfoToDirEntry(info))
+	}
+	return entries, nil
+}
+
+// stat returns the FileInfo of name. For a file it opens the object and
+// checks its size against the manifest, but not its contents.
+func (v *Vault) stat(name string) (fs.FileInfo, error) {
+	e := v.lookup(name)
+	if e == nil {
+		return nil, fs.ErrNotExist
+	}
+	if e.dir {
+		return dirInfo(name), nil
+	}
+	f, err := v.openFile(e, false)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return f.info(name)
+}
+
+// info describes the file as name, with the modification time of its
+// object.
+func (f *File) info(name string) (*fileInfo, error) {
+	size, err := f.Size()
+	if err != nil {
+		return nil, err
+	}
+	st, err := f.osf.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return &fileInfo{name: path.Base(name), size: size, mode: 0o444, modTime: st.ModTime()}, nil
+}
+
+func dirInfo(name string) *fileInfo {
+	return &fileInfo{name: path.Base(name), mode: fs.ModeDir | 0o555}
+}
+
+type fileInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func (i *fileInfo) Name() string       { return i.name }
+func (i *fileInfo) Size() int64        { return i.size }
+func (i *fileInfo) Mode() fs.FileMode  { return i.mode }
+func (i *fileInfo) ModTime() time.Time { return i.modTime }
+func (i *fileInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i *fileInfo) Sys() any           { return nil }
+
+// readFile is a file opened through fs.FS: read-only, and seekable so that
+// it can be served with http.FileServer. Its cursor reads a chunk ahead,
+// so that small sequential reads do not decrypt the same chunk repeatedly.
+type readFile struct {
+	f    *File
+	c    *raf.Cursor
+	info *fileInfo
+}
+
+func (r *readFile) Stat() (fs.FileInfo, error)                   { return r.info, nil }
+func (r *readFile) Read(p []byte) (int, error)                   { return r.c.Read(p) }
+func (r *readFile) Seek(offset int64, whence int) (int64, error) { return r.c.Seek(offset, whence) }
+func (r *readFile) ReadAt(p []byte, off int64) (int, error)      { return r.f.ReadAt(p, off) }
+func (r *readFile) WriteTo(w io.Writer) (int64, error)           { return r.c.WriteTo(w) }
+
+func (r *readFile) Close() error {
+	if err := r.c.Close(); err != nil {
+		return &fs.PathError{Op: "close", Path: r.info.name, Err: fs.ErrClosed}
+	}
+	return r.f.Close()
+}
+
+// dirFile is a directory opened through fs.FS.
+type dirFile struct {
+	info    *fileInfo
+	entries []fs.DirEntry
+	closed  bool
+}
+
+func (d *dirFile) Stat() (fs.FileInfo, error) { return d.info, nil }
+
+func (d *dirFile) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: fs.ErrInvalid}
+}
+
+func (d *dirFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	if d.closed {
+		return nil, &fs.PathError{Op: "readdir", Path: d.info.name, Err: fs.ErrClosed}
+	}
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(d.entries) {
+		n = len(d.entries)
+	}
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
+
+func (d *dirFile) Close() error {
+	if d.closed {
+		return &fs.PathError{Op: "close", Path: d.info.name, Err: fs.ErrClosed}
+	}
+	d.closed = true
+	return nil
+}
@@ -0,0 +1,116 @@
+package vault
+
+import (
+	"encoding/binary"
+	"errors"
+	"io/fs"
+	"path"
+	"sort"
+)
+
+// The manifest is the plaintext of a RAF file encrypted with a key derived
+// from the master key, which authenticates it as a whole. It is laid out
+// as:
+//
+//	u8 version (1) || uvarint count || entries
+//
+// with the entries sorted by path, each
+//
+//	u8 kind || uvarint len(path) || path || id || uvarint size || sum
+//
+// where kind is 0 for a file and 1 for a directory, which has no id, size
+// or sum. The sum is the 32-byte commitment to the file's contents. Every
+// path is valid for io/fs, and its parent is listed before it unless it is
+// the root.
+
+const manifestVersion = 1
+
+const (
+	kindFile = 0
+	kindDir  = 1
+)
+
+var errBadManifest = errors.New("vault: malformed manifest")
+
+func encodeManifest(entries map[string]*entry) []byte {
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	b := []byte{manifestVersion}
+	b = binary.AppendUvarint(b, uint64(len(names)))
+	for _, name := range names {
+		e := entries[name]
+		if e.dir {
+			b = append(b, kindDir)
+		} else {
+			b = append(b, kindFile)
+		}
+		b = binary.AppendUvarint(b, uint64(len(name)))
+		b = append(b, name...)
+		if !e.dir {
+			b = append(b, e.id...)
+			b = binary.AppendUvarint(b, uint64(e.size))
+			b = append(b, e.sum...)
+		}
+	}
+	return b
+}
+
+func decodeManifest(b []byte) (map[string]*entry, error) {
+	if len(b) == 0 || b[0] != manifestVersion {
+		return nil, errBadManifest
+	}
+	b = b[1:]
+	count, n := binary.Uvarint(b)
+	if n <= 0 || count > uint64(len(b)) {
+		return nil, errBadManifest
+	}
+	b = b[n:]
+
+	entries := make(map[string]*entry, count)
+	for i := uint64(0); i < count; i++ {
+		if len(b) < 1 {
+			return nil, errBadManifest
+		}
+		kind := b[0]
+		l, n := binary.Uvarint(b[1:])
+		if n <= 0 || l > uint64(len(b)-1-n) {
+			return nil, errBadManifest
+		}
+		name := string(b[1+n : 1+n+int(l)])
+		b = b[1+n+int(l):]
+
+		e := &entry{dir: kind == kindDir}
+		switch kind {
+		case kindDir:
+		case kindFile:
+			if len(b) < idSize {
+				return nil, errBadManifest
+			}
+			e.id = append([]byte(nil), b[:idSize]...)
+			size, n := binary.Uvarint(b[idSize:])
+			if n <= 0 || size > 1<<63-1 || len(b)-idSize-n < sumSize {
+				return nil, errBadManifest
+			}
+			e.size = int64(size)
+			e.sum = append([]byte(nil), b[idSize+n:idSize+n+sumSize]...)
+			b = b[idSize+n+sumSize:]
+		default:
+			return nil, errBadManifest
+		}
+		if !fs.ValidPath(name) || name == "." || entries[name] != nil {
+			return nil, errBadManifest
+		}
+		if parent := path.Dir(name); parent != "." && (entries[parent] == nil || !entries[parent].dir) {
+			return nil, errBadManifest
+		}
+		entries[name] = e
+	}
+	if len(b) != 0 {
+		return nil, errBadManifest
+	}
+	return entries, nil
+}
@@ -0,0 +1,557 @@
+// Package vault stores a directory tree as RAF-encrypted files.
+//
+// A vault is a directory holding an encrypted manifest and one RAF file,
+// or object, per regular file. The manifest lists every path in the tree
+// and, for each file, the random identifier its object key and object
+// name are derived from, so file names and the directory structure only
+// exist inside it. Object names are keyed hashes of the identifiers, and
+// each object is encrypted with its own key, so an object moved to
+// another name, or replaced by another object, fails authentication
+// instead of being read as a different file, and an object that is
+// missing is reported rather than treated as absent. The manifest also
+// holds each file's size and a keyed commitment to its contents, recorded
+// when the file is closed after writing and checked when it is opened, so
+// that an older copy of an object is reported too. The number of files
+// and their sizes remain visible.
+//
+// All keys are derived from a KeySize-byte master key with AEGIS-256 as a
+// PRF. A Vault implements fs.FS, fs.ReadDirFS and fs.StatFS for reading,
+// and Create, Mkdir, Remove and Rename for changing the tree. Each change
+// rewrites the manifest and replaces it atomically.
+package vault
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base32"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aegis-aead/go-libaegis/aegis256"
+	"github.com/aegis-aead/go-libaegis/raf"
+)
+
+// KeySize is the size of a vault master key in bytes.
+const KeySize = aegis256.KeySize
+
+const (
+	idSize       = raf.FileIDSize
+	sumSize      = 32
+	manifestName = "manifest"
+	objectPerm   = 0o600
+)
+
+var (
+	// ErrTampered is returned when an object does not match the manifest:
+	// it is missing, it does not authenticate under the key the manifest
+	// gives it, or its size or contents are not those the manifest
+	// recorded.
+	ErrTampered = errors.New("vault: file does not match the manifest")
+
+	// ErrNotEmpty is returned by Remove for a directory that has entries.
+	ErrNotEmpty = errors.New("vault: directory not empty")
+
+	// ErrNotVault is returned by Open for a directory without a manifest,
+	// and by Create for a directory that is not empty.
+	ErrNotVault = errors.New("vault: not a vault directory")
+)
+
+// base32 without padding and in lower case keeps object names valid on
+// case-insensitive file systems.
+var objectEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// entry is a path in the manifest: a directory, or a file with the
+// identifier of its object and the size and commitment of its contents.
+type entry struct {
+	dir  bool
+	id   []byte
+	size int64
+	sum  []byte
+}
+
+// Vault is an open vault directory. It is safe for concurrent use.
+type Vault struct {
+	dir         string
+	master      []byte
+	manifestKey []byte
+
+	mu      sync.Mutex
+	entries map[string]*entry // by path, without the root "."
+}
+
+// Create makes a new, empty vault in dir, creating the directory if needed.
+// An existing directory must be empty.
+func Create(dir string, key []byte) (*Vault, error) {
+	v, err := newVault(dir, key)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	names, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(names) > 0 {
+		return nil, ErrNotVault
+	}
+	v.entries = map[string]*entry{}
+	if err := v.commit(v.entries); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// Open opens the vault in dir with its master key. It returns raf.ErrAuth
+// if the key is wrong or the manifest has been tampered with.
+func Open(dir string, key []byte) (*Vault, error) {
+	v, err := newVault(dir, key)
+	if err != nil {
+		return nil, err
+	}
+	osf, err := os.Open(filepath.Join(dir, manifestName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotVault
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer osf.Close()
+	f, err := raf.Open(raf.NewFileStore(osf), v.manifestKey, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	size, err := f.Size()
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, size)
+	if _, err := f.ReadAt(data, 0); err != nil {
+		return nil, err
+	}
+	if v.entries, err = decodeManifest(data); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func newVault(dir string, key []byte) (*Vault, error) {
+	if len(key) != KeySize {
+		return nil, errors.New("vault: invalid master key length")
+	}
+	v := &Vault{dir: dir, master: append([]byte(nil), key...)}
+	var err error
+	v.manifestKey, err = v.derive("aegis-vault manifest", nil)
+	if err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// derive returns a 32-byte key for the given purpose and input: the
+// AEGIS-256 MAC of data under the master key, with the label as nonce.
+func (v *Vault) derive(label string, data []byte) ([]byte, error) {
+	m, err := aegis256.NewMAC(v.master, []byte(label), 32)
+	if err != nil {
+		return nil, err
+	}
+	m.Write(data)
+	return m.Sum(nil), nil
+}
+
+// commitment returns the MAC, under the master key, of the contents read
+// from r of the file with the given identifier.
+func (v *Vault) commitment(id []byte, r io.Reader) ([]byte, error) {
+	m, err := aegis256.NewMAC(v.master, []byte("aegis-vault object commitment"), sumSize)
+	if err != nil {
+		return nil, err
+	}
+	m.Write(id)
+	if _, err := io.Copy(m, r); err != nil {
+		return nil, err
+	}
+	return m.Sum(nil), nil
+}
+
+// objectPath returns where the object with the given identifier is stored.
+func (v *Vault) objectPath(id []byte) (string, error) {
+	h, err := v.derive("aegis-vault object name", id)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(v.dir, objectEncoding.EncodeToString(h[:20])), nil
+}
+
+// openObject opens the object with the given identifier.
+func (v *Vault) openObject(id []byte, flag int) (*File, error) {
+	p, err := v.objectPath(id)
+	if err != nil {
+		return nil, err
+	}
+	key, err := v.derive("aegis-vault file key", id)
+	if err != nil {
+		return nil, err
+	}
+	osf, err := os.OpenFile(p, flag, objectPerm)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrTampered
+	}
+	if err != nil {
+		return nil, err
+	}
+	var f *raf.File
+	if flag&os.O_CREATE != 0 {
+		f, err = raf.Create(raf.NewFileStore(osf), key, &raf.Options{Algorithm: raf.AEGIS256})
+	} else {
+		f, err = raf.Open(raf.NewFileStore(osf), key, nil)
+		if errors.Is(err, raf.ErrAuth) || errors.Is(err, raf.ErrInvalidHeader) {
+			err = ErrTampered
+		}
+	}
+	if err != nil {
+		osf.Close()
+		return nil, err
+	}
+	return &File{File: f, osf: osf}, nil
+}
+
+// openFile opens the object of the file e for reading and checks its size
+// against the manifest, and with contents, the commitment as well, which
+// reads the whole file.
+func (v *Vault) openFile(e *entry, contents bool) (*File, error) {
+	f, err := v.openObject(e.id, os.O_RDONLY)
+	if err != nil {
+		return nil, err
+	}
+	size, err := f.Size()
+	if err == nil && size != e.size {
+		err = ErrTampered
+	}
+	if err == nil && contents {
+		var sum []byte
+		sum, err = v.commitment(e.id, io.NewSectionReader(f, 0, size))
+		if errors.Is(err, raf.ErrAuth) || (err == nil && subtle.ConstantTimeCompare(sum, e.sum) != 1) {
+			err = ErrTampered
+		}
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// File is a vault file open for writing: a raf.File over its object.
+type File struct {
+	*raf.File
+	osf *os.File
+
+	// v and id are set for a file returned by Vault.Create, whose size
+	// and commitment Close records in the manifest.
+	v  *Vault
+	id []byte
+}
+
+// Close closes the RAF file and its object. For a file returned by
+// Vault.Create, it then records the file's size and contents in the
+// manifest; until it does, the file fails to open with ErrTampered.
+func (f *File) Close() error {
+	var e *entry
+	var err error
+	if f.v != nil {
+		e = &entry{id: f.id}
+		if e.size, err = f.Size(); err == nil {
+			e.sum, err = f.v.commitment(f.id, io.NewSectionReader(f, 0, e.size))
+		}
+	}
+	if cerr := f.File.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := f.osf.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && f.v != nil {
+		err = f.v.record(e)
+	}
+	f.v = nil
+	return err
+}
+
+// record replaces the entry of the file whose object is e.id with e, if
+// it is still in the tree.
+func (v *Vault) record(e *entry) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for name, old := range v.entries {
+		if !old.dir && bytes.Equal(old.id, e.id) {
+			next := v.clone()
+			next[name] = e
+			return v.commit(next)
+		}
+	}
+	return nil
+}
+
+// lookup returns the entry for name, which must be a valid path.
+func (v *Vault) lookup(name string) *entry {
+	if name == "." {
+		return &entry{dir: true}
+	}
+	return v.entries[name]
+}
+
+// parentDir reports whether the parent of name is a directory.
+func (v *Vault) parentDir(name string) bool {
+	e := v.lookup(path.Dir(name))
+	return e != nil && e.dir
+}
+
+// clone returns a copy of the entries to change and commit.
+func (v *Vault) clone() map[string]*entry {
+	next := make(map[string]*entry, len(v.entries)+1)
+	for name, e := range v.entries {
+		next[name] = e
+	}
+	return next
+}
+
+// Create creates the named file, replacing its contents if it exists, and
+// returns it open for writing. The parent directory must exist.
+func (v *Vault) Create(name string) (*File, error) {
+	if !fs.ValidPath(name) || name == "." {
+		return nil, &fs.PathError{Op: "create", Path: name, Err: fs.ErrInvalid}
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	old := v.entries[name]
+	if old != nil && old.dir {
+		return nil, &fs.PathError{Op: "create", Path: name, Err: fs.ErrExist}
+	}
+	if !v.parentDir(name) {
+		return nil, &fs.PathError{Op: "create", Path: name, Err: fs.ErrNotExist}
+	}
+
+	// The object is created and synced, with the directory, before the
+	// manifest names it, and an object being replaced is removed after, so
+	// that a crash leaves at most an unreferenced object behind.
+	id := make([]byte, idSize)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	f, err := v.openObject(id, os.O_RDWR|os.O_CREATE|os.O_EXCL)
+	if err != nil {
+		return nil, &fs.PathError{Op: "create", Path: name, Err: err}
+	}
+	err = f.Sync()
+	if err == nil {
+		err = v.syncDir()
+	}
+	var sum []byte
+	if err == nil {
+		sum, err = v.commitment(id, bytes.NewReader(nil))
+	}
+	if err == nil {
+		next := v.clone()
+		next[name] = &entry{id: id, sum: sum}
+		err = v.commit(next)
+	}
+	if err != nil {
+		f.Close()
+		v.removeObject(id)
+		return nil, err
+	}
+	if old != nil {
+		v.removeObject(old.id)
+	}
+	f.v, f.id = v, id
+	return f, nil
+}
+
+// Mkdir creates the named directory. Its parent must exist.
+func (v *Vault) Mkdir(name string) error {
+	if !fs.ValidPath(name) || name == "." {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrInvalid}
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.entries[name] != nil {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrExist}
+	}
+	if !v.parentDir(name) {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrNotExist}
+	}
+	next := v.clone()
+	next[name] = &entry{dir: true}
+	return v.commit(next)
+}
+
+// Remove removes the named file or empty directory.
+func (v *Vault) Remove(name string) error {
+	if !fs.ValidPath(name) || name == "." {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrInvalid}
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	e := v.entries[name]
+	if e == nil {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	if e.dir && len(v.children(name)) > 0 {
+		return &fs.PathError{Op: "remove", Path: name, Err: ErrNotEmpty}
+	}
+	next := v.clone()
+	delete(next, name)
+	if err := v.commit(next); err != nil {
+		return err
+	}
+	if !e.dir {
+		v.removeObject(e.id)
+	}
+	return nil
+}
+
+// Rename moves oldname, and everything below it if it is a directory, to
+// newname. A file may replace an existing file; otherwise newname must not
+// exist, and its parent must.
+func (v *Vault) Rename(oldname, newname string) error {
+	if !fs.ValidPath(oldname) || !fs.ValidPath(newname) || oldname == "." || newname == "." {
+		return &fs.PathError{Op: "rename", Path: oldname, Err: fs.ErrInvalid}
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	e := v.entries[oldname]
+	if e == nil {
+		return &fs.PathError{Op: "rename", Path: oldname, Err: fs.ErrNotExist}
+	}
+	if oldname == newname {
+		return nil
+	}
+	replaced := v.entries[newname]
+	if replaced != nil && (e.dir || replaced.dir) {
+		return &fs.PathError{Op: "rename", Path: newname, Err: fs.ErrExist}
+	}
+	if !v.parentDir(newname) {
+		return &fs.PathError{Op: "rename", Path: newname, Err: fs.ErrNotExist}
+	}
+	if e.dir && strings.HasPrefix(newname, oldname+"/") {
+		return &fs.PathError{Op: "rename", Path: newname, Err: fs.ErrInvalid}
+	}
+
+	next := v.clone()
+	delete(next, oldname)
+	next[newname] = e
+	if e.dir {
+		for name, child := range v.entries {
+			if strings.HasPrefix(name, oldname+"/") {
+				delete(next, name)
+				next[newname+name[len(oldname):]] = child
+			}
+		}
+	}
+	if err := v.commit(next); err != nil {
+		return err
+	}
+	if replaced != nil {
+		v.removeObject(replaced.id)
+	}
+	return nil
+}
+
+// Verify checks that every file in the manifest has its object, that each
+// object authenticates, and that its size and contents are those the
+// manifest recorded.
+func (v *Vault) Verify() error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	names := make([]string, 0, len(v.entries))
+	for name := range v.entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if e := v.entries[name]; !e.dir {
+			f, err := v.openFile(e, true)
+			if err != nil {
+				return &fs.PathError{Op: "verify", Path: name, Err: err}
+			}
+			f.Close()
+		}
+	}
+	return nil
+}
+
+// children returns the names of the entries directly in dir, sorted.
+func (v *Vault) children(dir string) []string {
+	var names []string
+	for name := range v.entries {
+		if path.Dir(name) == dir {
+			names = append(names, path.Base(name))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// syncDir syncs the vault directory, so that the files created, renamed
+// and removed in it stay that way after a crash.
+func (v *Vault) syncDir() error {
+	d, err := os.Open(v.dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if cerr := d.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (v *Vault) removeObject(id []byte) {
+	if p, err := v.objectPath(id); err == nil {
+		os.Remove(p)
+	}
+}
+
+// commit writes entries as the new manifest, replacing the old one
+// atomically, and makes them current. The new manifest is on disk when
+// commit returns, so that objects it no longer names can be removed.
+func (v *Vault) commit(entries map[string]*entry) error {
+	tmp := filepath.Join(v.dir, manifestName+".tmp")
+	osf, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, objectPerm)
+	if err != nil {
+		return err
+	}
+	f, err := raf.Create(raf.NewFileStore(osf), v.manifestKey, &raf.Options{Algorithm: raf.AEGIS256, Truncate: true})
+	if err == nil {
+		if _, err = f.WriteAt(encodeManifest(entries), 0); err != nil {
+			f.Close()
+		} else {
+			err = f.Close()
+		}
+	}
+	if cerr := osf.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, filepath.Join(v.dir, manifestName))
+		if err == nil {
+			err = v.syncDir()
+		}
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	v.entries = entries
+	return nil
+}
@@ -0,0 +1,332 @@
+package vault
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/aegis-aead/go-libaegis/common"
+	"github.com/aegis-aead/go-libaegis/raf"
+)
+
+func newKey() []byte {
+	key := make([]byte, KeySize)
+	rand.Read(key)
+	return key
+}
+
+func writeFile(t *testing.T, v *Vault, name string, data []byte) {
+	t.Helper()
+	f, err := v.Create(name)
+	if err != nil {
+		t.Fatalf("Create(%q): %v", name, err)
+	}
+	if _, err := f.WriteAt(data, 0); err != nil {
+		t.Fatalf("WriteAt(%q): %v", name, err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close(%q): %v", name, err)
+	}
+}
+
+// objects returns the names of the objects in a vault directory.
+func objects(t *testing.T, dir string) []string {
+	t.Helper()
+	ents, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range ents {
+		if e.Name() != manifestName {
+			names = append(names, e.Name())
+		}
+	}
+	return names
+}
+
+func TestVault(t *testing.T) {
+	if !common.Available {
+		t.Skip("CGO not available")
+	}
+
+	dir := t.TempDir()
+	key := newKey()
+	v, err := Create(dir, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	big := make([]byte, 3*raf.DefaultChunk+5)
+	rand.Read(big)
+	if err := v.Mkdir("secret-plans"); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Mkdir("secret-plans/2024"); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, v, "secret-plans/2024/q1.txt", []byte("first quarter"))
+	writeFile(t, v, "readme.txt", []byte("hello"))
+	writeFile(t, v, "empty", nil)
+	if err := fstest.TestFS(v, "secret-plans/2024/q1.txt", "readme.txt", "empty"); err != nil {
+		t.Fatal(err)
+	}
+
+	// fstest reads files a byte at a time, which takes long for one of
+	// several chunks, so the big file is only read whole.
+	writeFile(t, v, "secret-plans/big.bin", big)
+
+	// Neither names nor the tree are visible on disk: just the manifest
+	// and one object per file.
+	if names := objects(t, dir); len(names) != 4 {
+		t.Fatalf("objects: %v", names)
+	}
+	for _, name := range objects(t, dir) {
+		if strings.Contains(name, "secret") || strings.Contains(name, "readme") {
+			t.Fatalf("object name %q leaks a file name", name)
+		}
+	}
+
+	// Replacing a file drops its old object.
+	writeFile(t, v, "readme.txt", []byte("hello again"))
+	if names := objects(t, dir); len(names) != 4 {
+		t.Fatalf("objects after replacing a file: %v", names)
+	}
+
+	// Renaming a directory moves everything below it.
+	if err := v.Rename("secret-plans", "archive"); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Rename("readme.txt", "archive/2024/readme.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Remove("empty"); err != nil {
+		t.Fatal(err)
+	}
+	if names := objects(t, dir); len(names) != 3 {
+		t.Fatalf("objects after Remove: %v", names)
+	}
+
+	// The changes survive reopening.
+	v, err = Open(dir, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := fs.ReadFile(v, "archive/big.bin"); err != nil || !bytes.Equal(data, big) {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if err := v.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Remove("archive/big.bin"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fstest.TestFS(v, "archive/2024/q1.txt", "archive/2024/readme.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := fs.ReadFile(v, "archive/2024/readme.txt"); string(data) != "hello again" {
+		t.Fatalf("ReadFile: %q", data)
+	}
+	if _, err := v.Stat("secret-plans"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Stat of a renamed directory: %v", err)
+	}
+}
+
+func TestVaultErrors(t *testing.T) {
+	if !common.Available {
+		t.Skip("CGO not available")
+	}
+
+	dir := t.TempDir()
+	key := newKey()
+	v, err := Create(dir, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.Mkdir("d")
+	writeFile(t, v, "d/f", []byte("x"))
+
+	for _, tc := range []struct {
+		op   string
+		err  error
+		want error
+	}{
+		{"Mkdir existing", v.Mkdir("d"), fs.ErrExist},
+		{"Mkdir without parent", v.Mkdir("a/b"), fs.ErrNotExist},
+		{"Mkdir of an invalid path", v.Mkdir("/abs"), fs.ErrInvalid},
+		{"Remove of a full directory", v.Remove("d"), ErrNotEmpty},
+		{"Remove missing", v.Remove("nope"), fs.ErrNotExist},
+		{"Rename into itself", v.Rename("d", "d/e"), fs.ErrInvalid},
+		{"Rename onto a directory", func() error { v.Mkdir("e"); return v.Rename("d/f", "e") }(), fs.ErrExist},
+		{"Rename missing", v.Rename("nope", "x"), fs.ErrNotExist},
+	} {
+		if !errors.Is(tc.err, tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.op, tc.err, tc.want)
+		}
+	}
+	if _, err := v.Create("d"); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("Create over a directory: %v", err)
+	}
+	if _, err := v.Create("x/f"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Create without parent: %v", err)
+	}
+
+	if _, err := Create(dir, key); err != ErrNotVault {
+		t.Errorf("Create in a vault: %v", err)
+	}
+	if _, err := Open(t.TempDir(), key); err != ErrNotVault {
+		t.Errorf("Open of an empty directory: %v", err)
+	}
+	if _, err := Open(dir, newKey()); err != raf.ErrAuth {
+		t.Errorf("Open with a wrong key: %v", err)
+	}
+	if _, err := Open(dir, key[:16]); err == nil {
+		t.Error("Open with a short key succeeded")
+	}
+}
+
+func TestVaultTampering(t *testing.T) {
+	if !common.Available {
+		t.Skip("CGO not available")
+	}
+
+	dir := t.TempDir()
+	key := newKey()
+	v, err := Create(dir, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, v, "a", []byte("file a"))
+	writeFile(t, v, "b", []byte("file b"))
+	objA, _ := v.objectPath(v.entries["a"].id)
+	objB, _ := v.objectPath(v.entries["b"].id)
+
+	// Swapped objects do not authenticate under each other's keys.
+	os.Rename(objA, objA+".tmp")
+	os.Rename(objB, objA)
+	os.Rename(objA+".tmp", objB)
+	if _, err := fs.ReadFile(v, "a"); !errors.Is(err, ErrTampered) {
+		t.Fatalf("ReadFile of a swapped object: %v", err)
+	}
+	if err := v.Verify(); !errors.Is(err, ErrTampered) {
+		t.Fatalf("Verify with swapped objects: %v", err)
+	}
+	os.Rename(objA, objA+".tmp")
+	os.Rename(objB, objA)
+	os.Rename(objA+".tmp", objB)
+	if err := v.Verify(); err != nil {
+		t.Fatalf("Verify after restoring: %v", err)
+	}
+
+	// An object put back as it was before its last write is reported, even
+	// with the same size, and so is one the writer did not close.
+	f, err := v.Create("c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	objC, _ := v.objectPath(f.id)
+	f.WriteAt([]byte("old c"), 0)
+	f.Sync()
+	old, _ := os.ReadFile(objC)
+	f.WriteAt([]byte("new c"), 0)
+	if _, err := fs.ReadFile(v, "c"); !errors.Is(err, ErrTampered) {
+		t.Fatalf("ReadFile of a file still being written: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := fs.ReadFile(v, "c"); err != nil || string(got) != "new c" {
+		t.Fatalf("ReadFile after Close: %q, %v", got, err)
+	}
+	os.WriteFile(objC, old, 0o600)
+	if _, err := fs.ReadFile(v, "c"); !errors.Is(err, ErrTampered) {
+		t.Fatalf("ReadFile of a rolled-back object: %v", err)
+	}
+	if err := v.Verify(); !errors.Is(err, ErrTampered) {
+		t.Fatalf("Verify with a rolled-back object: %v", err)
+	}
+	if f, err = v.Create("d"); err != nil {
+		t.Fatal(err)
+	}
+	objD, _ := v.objectPath(f.id)
+	f.WriteAt([]byte("d"), 0)
+	f.Sync()
+	old, _ = os.ReadFile(objD)
+	f.WriteAt([]byte(" grew"), 1)
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(objD, old, 0o600)
+	if _, err := v.Stat("d"); !errors.Is(err, ErrTampered) {
+		t.Fatalf("Stat of a rolled-back object of another size: %v", err)
+	}
+	v.Remove("c")
+	v.Remove("d")
+	if err := v.Verify(); err != nil {
+		t.Fatalf("Verify after removing the rolled-back files: %v", err)
+	}
+
+	// A dropped object is reported, not treated as a missing file.
+	os.Remove(objB)
+	if _, err := v.Stat("b"); !errors.Is(err, ErrTampered) {
+		t.Fatalf("Stat of a dropped object: %v", err)
+	}
+	if _, err := v.ReadDir("."); !errors.Is(err, ErrTampered) {
+		t.Fatalf("ReadDir with a dropped object: %v", err)
+	}
+
+	// The manifest itself is authenticated.
+	manifest := filepath.Join(dir, manifestName)
+	data, _ := os.ReadFile(manifest)
+	data[len(data)-1] ^= 1
+	os.WriteFile(manifest, data, 0o600)
+	if _, err := Open(dir, key); err != raf.ErrAuth {
+		t.Fatalf("Open with a tampered manifest: %v", err)
+	}
+}
+
+func TestManifestEncoding(t *testing.T) {
+	id := bytes.Repeat([]byte{7}, idSize)
+	sum := bytes.Repeat([]byte{9}, sumSize)
+	entries := map[string]*entry{
+		"a":     {dir: true},
+		"a/b":   {id: id, size: 300, sum: sum},
+		"a-c":   {id: id, sum: sum},
+		"a/d":   {dir: true},
+		"a/d/e": {id: id, size: 1 << 40, sum: sum},
+	}
+	b := encodeManifest(entries)
+	got, err := decodeManifest(b)
+	if err != nil || len(got) != len(entries) {
+		t.Fatalf("decodeManifest: %v, %d entries", err, len(got))
+	}
+	for name, e := range entries {
+		if g := got[name]; g == nil || g.dir != e.dir || !bytes.Equal(g.id, e.id) || g.size != e.size || !bytes.Equal(g.sum, e.sum) {
+			t.Fatalf("entry %q: got %+v", name, g)
+		}
+	}
+
+	for _, bad := range []map[string]*entry{
+		{"x/y": {id: id, sum: sum}},
+		{"x": {id: id, sum: sum}, "x/y": {id: id, sum: sum}},
+		{"/x": {dir: true}},
+		{"x/../y": {dir: true}},
+	} {
+		if _, err := decodeManifest(encodeManifest(bad)); err != errBadManifest {
+			t.Errorf("decodeManifest of %v: got %v", bad, err)
+		}
+	}
+	for i := range b {
+		if _, err := decodeManifest(b[:i]); err != errBadManifest {
+			t.Errorf("decodeManifest of %d bytes: got %v", i, err)
+		}
+	}
+	if _, err := decodeManifest(append(b, 0)); err != errBadManifest {
+		t.Errorf("decodeManifest with trailing data: got %v", err)
+	}
+}